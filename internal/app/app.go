@@ -14,11 +14,16 @@ import (
 	backupsvc "nusantara/internal/backup"
 	"nusantara/internal/config"
 	dbsvc "nusantara/internal/db"
+	deploysvc "nusantara/internal/deploy"
+	"nusantara/internal/events"
 	"nusantara/internal/httpserver"
+	"nusantara/internal/idempotency"
 	"nusantara/internal/jobs"
 	"nusantara/internal/monitor"
+	"nusantara/internal/php"
 	"nusantara/internal/platform/oscheck"
 	"nusantara/internal/provision"
+	"nusantara/internal/scheduler"
 	authsvc "nusantara/internal/service/auth"
 	sitessvc "nusantara/internal/service/sites"
 	sslsvc "nusantara/internal/ssl"
@@ -72,21 +77,122 @@ func (a *App) Run() error {
 	}
 	a.logger.Printf("bootstrap admin ensured username=%s", a.cfg.BootstrapAdminUsername)
 
-	siteProvisioner := provision.NewNginxProvisioner(provision.NginxConfig{
-		Apply:         a.cfg.ProvisionApply,
-		AvailableDir:  a.cfg.NginxAvailableDir,
-		EnabledDir:    a.cfg.NginxEnabledDir,
-		TestCommand:   a.cfg.NginxTestCommand,
-		ReloadCommand: a.cfg.NginxReloadCommand,
+	fpmPools := provision.NewFPMPoolProvisioner(provision.FPMConfig{
+		RunDir:         a.cfg.PHPFPMRunDir,
+		PoolDir:        a.cfg.PHPFPMPoolDir,
+		TestCommand:    a.cfg.PHPFPMTestCommand,
+		ReloadCommand:  a.cfg.PHPFPMReloadCommand,
+		UserAddCommand: a.cfg.SiteUserAddCommand,
+		UserDelCommand: a.cfg.SiteUserDelCommand,
+		ChownCommand:   a.cfg.SiteChownCommand,
+		WebGroup:       a.cfg.WebGroup,
+		MaxChildren:    a.cfg.PHPFPMMaxChildren,
+		MemoryLimit:    a.cfg.PHPFPMMemoryLimit,
+		TmpDir:         a.cfg.PHPFPMTmpDir,
 	}, a.logger)
+	appUnits := provision.NewSystemdProvisioner(provision.SystemdConfig{
+		UnitDir:          a.cfg.SystemdUnitDir,
+		SystemctlCommand: a.cfg.SystemctlCommand,
+		UserAddCommand:   a.cfg.SiteUserAddCommand,
+		ChownCommand:     a.cfg.SiteChownCommand,
+		WebGroup:         a.cfg.WebGroup,
+	}, a.logger)
+
+	// Drift, import, basic auth users and the log viewer work on nginx
+	// vhosts only; with other web servers they report apply disabled.
+	var (
+		siteProvisioner jobs.SiteProvisioner
+		vhosts          sitessvc.VhostManager
+		appControl      sitessvc.AppController
+		reloadCommand   string
+		availableDir    string
+		enabledDir      string
+	)
+	switch a.cfg.WebServer {
+	case provision.WebServerApache:
+		apache := provision.NewApacheProvisioner(provision.ApacheConfig{
+			Apply:         a.cfg.ProvisionApply,
+			AvailableDir:  a.cfg.ApacheAvailableDir,
+			EnabledDir:    a.cfg.ApacheEnabledDir,
+			TestCommand:   a.cfg.ApacheTestCommand,
+			ReloadCommand: a.cfg.ApacheReloadCommand,
+			PHPFPMRunDir:  a.cfg.PHPFPMRunDir,
+			LogDir:        a.cfg.ApacheLogDir,
+		}, fpmPools, appUnits, a.logger)
+		siteProvisioner, appControl = apache, apache
+		reloadCommand, availableDir, enabledDir = a.cfg.ApacheReloadCommand, a.cfg.ApacheAvailableDir, a.cfg.ApacheEnabledDir
+	case provision.WebServerCaddy:
+		caddy := provision.NewCaddyProvisioner(provision.CaddyConfig{
+			Apply:         a.cfg.ProvisionApply,
+			AvailableDir:  a.cfg.CaddyAvailableDir,
+			EnabledDir:    a.cfg.CaddyEnabledDir,
+			TestCommand:   a.cfg.CaddyTestCommand,
+			ReloadCommand: a.cfg.CaddyReloadCommand,
+			PHPFPMRunDir:  a.cfg.PHPFPMRunDir,
+			LogDir:        a.cfg.CaddyLogDir,
+		}, fpmPools, appUnits, a.logger)
+		siteProvisioner, appControl = caddy, caddy
+		reloadCommand, availableDir, enabledDir = a.cfg.CaddyReloadCommand, a.cfg.CaddyAvailableDir, a.cfg.CaddyEnabledDir
+	default:
+		nginx := provision.NewNginxProvisioner(provision.NginxConfig{
+			Apply:          a.cfg.ProvisionApply,
+			AvailableDir:   a.cfg.NginxAvailableDir,
+			EnabledDir:     a.cfg.NginxEnabledDir,
+			TestCommand:    a.cfg.NginxTestCommand,
+			MainConfig:     a.cfg.NginxMainConfig,
+			ReloadCommand:  a.cfg.NginxReloadCommand,
+			PHPFPMRunDir:   a.cfg.PHPFPMRunDir,
+			TemplateDir:    a.cfg.NginxTemplateDir,
+			MaintenanceDir: a.cfg.NginxMaintenanceDir,
+			HtpasswdDir:    a.cfg.NginxHtpasswdDir,
+			WebGroup:       a.cfg.WebGroup,
+			CacheDir:       a.cfg.NginxCacheDir,
+			LogDir:         a.cfg.NginxLogDir,
+			LogrotatePath:  a.cfg.LogrotatePath,
+		}, fpmPools, appUnits, a.logger)
+		siteProvisioner, vhosts, appControl = nginx, nginx, nginx
+		reloadCommand, availableDir, enabledDir = a.cfg.NginxReloadCommand, a.cfg.NginxAvailableDir, a.cfg.NginxEnabledDir
+	}
 	a.logger.Printf(
-		"provision apply=%t available_dir=%s enabled_dir=%s",
+		"provision apply=%t web_server=%s available_dir=%s enabled_dir=%s",
 		a.cfg.ProvisionApply,
-		a.cfg.NginxAvailableDir,
-		a.cfg.NginxEnabledDir,
+		a.cfg.WebServer,
+		availableDir,
+		enabledDir,
 	)
 
-	jobService := jobs.NewService(repo, a.logger, siteProvisioner)
+	sslService := sslsvc.NewService(a.cfg.ProvisionApply, a.cfg.CertbotCommand, reloadCommand, 2*time.Minute, a.logger)
+	backupService := backupsvc.NewService(a.cfg.ProvisionApply, a.cfg.DBPath, a.cfg.BackupDir, a.logger)
+	dbService := dbsvc.NewService(a.cfg.ProvisionApply, a.cfg.MySQLCommand, 10*time.Second, a.logger)
+
+	eventBus := events.NewBus(0)
+	jobService := jobs.NewService(repo, a.logger, siteProvisioner, eventBus)
+	deployService := deploysvc.NewService(a.cfg.ProvisionApply, repo, jobService, siteProvisioner, deploysvc.Config{
+		GitCommand:     a.cfg.GitCommand,
+		KeyDir:         a.cfg.DeployKeyDir,
+		UserAddCommand: a.cfg.SiteUserAddCommand,
+		ChownCommand:   a.cfg.SiteChownCommand,
+		RunAsCommand:   a.cfg.SiteRunAsCommand,
+		WebGroup:       a.cfg.WebGroup,
+	}, a.logger)
+	siteService := sitessvc.NewService(repo, jobService, a.cfg.BackupDir, a.cfg.UploadDir, a.cfg.SiteRootBase, a.cfg.WebServer, a.cfg.ProvisionApply, sitessvc.PortRange{
+		Min: a.cfg.UpstreamPortMin,
+		Max: a.cfg.UpstreamPortMax,
+	}, php.NewDetector(a.cfg.PHPFPMRunDir), vhosts, appControl)
+	if err := siteService.BackfillSites(context.Background()); err != nil {
+		return fmt.Errorf("backfill sites: %w", err)
+	}
+	for _, register := range []func(jobs.Registry) error{
+		sslService.RegisterJobs,
+		backupService.RegisterJobs,
+		dbService.RegisterJobs,
+		deployService.RegisterJobs,
+		siteService.RegisterJobs,
+	} {
+		if err := register(jobService); err != nil {
+			return fmt.Errorf("register job handlers: %w", err)
+		}
+	}
 	jobService.Start(context.Background())
 	defer func() {
 		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -94,12 +200,16 @@ func (a *App) Run() error {
 		_ = jobService.Stop(stopCtx)
 	}()
 
-	siteService := sitessvc.NewService(repo, jobService, a.cfg.BackupDir, a.cfg.ProvisionApply)
-	auditService := audit.NewService(repo, a.logger)
+	auditService := audit.NewService(repo, a.logger, eventBus)
 	servicesMonitor := monitor.NewServicesMonitor(nil, 3*time.Second)
-	sslService := sslsvc.NewService(a.cfg.ProvisionApply, a.cfg.CertbotCommand, 2*time.Minute, a.logger)
-	dbService := dbsvc.NewService(a.cfg.ProvisionApply, a.cfg.MySQLCommand, 10*time.Second, a.logger)
-	backupService := backupsvc.NewService(a.cfg.ProvisionApply, a.cfg.DBPath, a.cfg.BackupDir, a.logger)
+
+	schedulerService := scheduler.NewService(repo, jobService, auditService, time.Duration(a.cfg.SchedulerIntervalSecs)*time.Second, a.logger)
+	schedulerService.Start(context.Background())
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = schedulerService.Stop(stopCtx)
+	}()
 	updaterService := updater.NewService(a.cfg.ProvisionApply, updater.Config{
 		RepoURL:   a.cfg.UpdateRepoURL,
 		Branch:    a.cfg.UpdateBranch,
@@ -107,8 +217,10 @@ func (a *App) Run() error {
 		UnitName:  a.cfg.UpdateUnitName,
 		LogLines:  a.cfg.UpdateLogLines,
 		Cooldown:  a.cfg.UpdateCooldown,
-	}, a.logger)
-	api := httpserver.NewAPI(authService, siteService, jobService, auditService, dbService, backupService, sslService, servicesMonitor, updaterService)
+	}, a.logger, eventBus)
+	idempotencyService := idempotency.NewService(repo, time.Duration(a.cfg.IdempotencyTTLHours)*time.Hour)
+
+	api := httpserver.NewAPI(authService, siteService, jobService, auditService, dbService, backupService, sslService, deployService, servicesMonitor, updaterService, eventBus, schedulerService, idempotencyService)
 
 	server := &http.Server{
 		Addr:         a.cfg.Address,
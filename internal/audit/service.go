@@ -6,18 +6,21 @@ import (
 	"log"
 	"time"
 
+	"nusantara/internal/events"
 	"nusantara/internal/store"
 )
 
 type Service struct {
 	repo   store.Repository
 	logger *log.Logger
+	events *events.Bus
 }
 
-func NewService(repo store.Repository, logger *log.Logger) *Service {
+func NewService(repo store.Repository, logger *log.Logger, bus *events.Bus) *Service {
 	return &Service{
 		repo:   repo,
 		logger: logger,
+		events: bus,
 	}
 }
 
@@ -34,9 +37,13 @@ func (s *Service) Record(ctx context.Context, actorUserID, action, targetType, t
 		Metadata:   string(body),
 		CreatedAt:  time.Now().UTC(),
 	}
-	if err := s.repo.CreateAuditLog(ctx, entry); err != nil && s.logger != nil {
-		s.logger.Printf("audit write failed action=%s target=%s err=%v", action, targetID, err)
+	if err := s.repo.CreateAuditLog(ctx, entry); err != nil {
+		if s.logger != nil {
+			s.logger.Printf("audit write failed action=%s target=%s err=%v", action, targetID, err)
+		}
+		return
 	}
+	s.events.Publish(events.TopicAudit, "audit.recorded", entry)
 }
 
 func (s *Service) List(ctx context.Context, limit int) ([]store.AuditLog, error) {
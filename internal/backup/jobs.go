@@ -0,0 +1,20 @@
+package backup
+
+import (
+	"context"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+// RegisterJobs exposes state backups as an async job.
+func (s *Service) RegisterJobs(r jobs.Registry) error {
+	return r.Register(store.JobTypeRunBackup, jobs.HandlerFunc(func(ctx context.Context, _ store.Job) error {
+		result, err := s.Run(ctx)
+		if err != nil {
+			return err
+		}
+		jobs.Logf(ctx, "backup written to %s", result.File)
+		return nil
+	}))
+}
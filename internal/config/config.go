@@ -14,7 +14,43 @@ const (
 	defaultNginxAvailableDir      = "/etc/nginx/sites-available"
 	defaultNginxEnabledDir        = "/etc/nginx/sites-enabled"
 	defaultNginxTestCommand       = "nginx -t"
+	defaultNginxMainConfig        = "/etc/nginx/nginx.conf"
 	defaultNginxReloadCommand     = "systemctl reload nginx"
+	defaultNginxTemplateDir       = "/etc/nusantara-panel/templates/nginx"
+	defaultNginxMaintenanceDir    = "/var/lib/nusantara-panel/maintenance"
+	defaultNginxHtpasswdDir       = "/etc/nusantara-panel/htpasswd"
+	defaultNginxCacheDir          = "/var/cache/nginx/nusantara"
+	defaultNginxLogDir            = "/var/log/nginx/nusantara"
+	defaultLogrotatePath          = "/etc/logrotate.d/nusantara-panel-sites"
+	defaultWebServer              = "nginx"
+	defaultApacheAvailableDir     = "/etc/apache2/sites-available"
+	defaultApacheEnabledDir       = "/etc/apache2/sites-enabled"
+	defaultApacheTestCommand      = "apache2ctl configtest"
+	defaultApacheReloadCommand    = "systemctl reload apache2"
+	defaultApacheLogDir           = "/var/log/apache2"
+	defaultCaddyAvailableDir      = "/etc/caddy/sites-available"
+	defaultCaddyEnabledDir        = "/etc/caddy/sites-enabled"
+	defaultCaddyTestCommand       = "caddy validate --config /etc/caddy/Caddyfile --adapter caddyfile"
+	defaultCaddyReloadCommand     = "systemctl reload caddy"
+	defaultCaddyLogDir            = "/var/log/caddy/nusantara"
+	defaultSystemdUnitDir         = "/etc/systemd/system"
+	defaultSystemctlCommand       = "systemctl"
+	defaultGitCommand             = "git"
+	defaultDeployKeyDir           = "/var/lib/nusantara-panel/deploy-keys"
+	defaultUploadDir              = "/var/lib/nusantara-panel/uploads"
+	defaultPHPFPMRunDir           = "/run/php"
+	defaultPHPFPMPoolDir          = "/etc/php/{version}/fpm/pool.d"
+	defaultPHPFPMTestCommand      = "php-fpm{version} -t"
+	defaultPHPFPMReloadCommand    = "systemctl reload php{version}-fpm"
+	defaultPHPFPMMaxChildren      = 5
+	defaultPHPFPMMemoryLimit      = "256M"
+	defaultPHPFPMTmpDir           = "/var/lib/nusantara-panel/php"
+	defaultSiteUserAddCommand     = "useradd --system --no-create-home --shell /usr/sbin/nologin"
+	defaultSiteUserDelCommand     = "userdel"
+	defaultSiteChownCommand       = "chown -R"
+	defaultSiteRunAsCommand       = "runuser -u"
+	defaultSiteRootBase           = "/var/www"
+	defaultWebGroup               = "www-data"
 	defaultCertbotCommand         = "certbot"
 	defaultMySQLCommand           = "mysql"
 	defaultBackupDir              = "/var/backups/nusantara-panel"
@@ -30,24 +66,48 @@ const (
 	defaultUpdateUnitName         = "nusantara-panel-updater"
 	defaultUpdateLogLines         = 80
 	defaultUpdateCooldownSecs     = 20
+	defaultSchedulerIntervalSecs  = 30
+	defaultIdempotencyTTLHours    = 24
+	defaultUpstreamPortMin        = 3000
+	defaultUpstreamPortMax        = 3999
 )
 
 type Config struct {
-	Address            string
-	DataDir            string
-	DBPath             string
-	ProvisionApply     bool
-	NginxAvailableDir  string
-	NginxEnabledDir    string
-	NginxTestCommand   string
-	NginxReloadCommand string
-	CertbotCommand     string
-	MySQLCommand       string
-	BackupDir          string
-	LogLevel           string
-	ShutdownSecs       int
-	TokenTTLHours      int
-	AllowNonUbuntu     bool
+	Address             string
+	DataDir             string
+	DBPath              string
+	ProvisionApply      bool
+	NginxAvailableDir   string
+	NginxEnabledDir     string
+	NginxTestCommand    string
+	NginxMainConfig     string
+	NginxReloadCommand  string
+	NginxTemplateDir    string
+	NginxMaintenanceDir string
+	NginxHtpasswdDir    string
+	NginxCacheDir       string
+	NginxLogDir         string
+	LogrotatePath       string
+	// WebServer selects the site backend: nginx, apache or caddy.
+	WebServer           string
+	ApacheAvailableDir  string
+	ApacheEnabledDir    string
+	ApacheTestCommand   string
+	ApacheReloadCommand string
+	ApacheLogDir        string
+	CaddyAvailableDir   string
+	CaddyEnabledDir     string
+	CaddyTestCommand    string
+	CaddyReloadCommand  string
+	CaddyLogDir         string
+	PHPFPMRunDir        string
+	CertbotCommand      string
+	MySQLCommand        string
+	BackupDir           string
+	LogLevel            string
+	ShutdownSecs        int
+	TokenTTLHours       int
+	AllowNonUbuntu      bool
 
 	BootstrapAdminUsername string
 	BootstrapAdminPassword string
@@ -58,24 +118,68 @@ type Config struct {
 	UpdateUnitName  string
 	UpdateLogLines  int
 	UpdateCooldown  int
+
+	SchedulerIntervalSecs int
+
+	IdempotencyTTLHours int
+
+	UpstreamPortMin int
+	UpstreamPortMax int
+
+	PHPFPMPoolDir       string
+	PHPFPMTestCommand   string
+	PHPFPMReloadCommand string
+	PHPFPMMaxChildren   int
+	PHPFPMMemoryLimit   string
+	PHPFPMTmpDir        string
+	SiteUserAddCommand  string
+	SiteUserDelCommand  string
+	SiteChownCommand    string
+	SiteRunAsCommand    string
+	SiteRootBase        string
+	WebGroup            string
+	SystemdUnitDir      string
+	SystemctlCommand    string
+	GitCommand          string
+	DeployKeyDir        string
+	UploadDir           string
 }
 
 func LoadFromEnv() (Config, error) {
 	cfg := Config{
-		Address:            getenv("NUSANTARA_ADDR", defaultAddress),
-		DataDir:            getenv("NUSANTARA_DATA_DIR", defaultDataDir),
-		ProvisionApply:     runtime.GOOS == "linux",
-		NginxAvailableDir:  getenv("NUSANTARA_NGINX_SITES_AVAILABLE_DIR", defaultNginxAvailableDir),
-		NginxEnabledDir:    getenv("NUSANTARA_NGINX_SITES_ENABLED_DIR", defaultNginxEnabledDir),
-		NginxTestCommand:   getenv("NUSANTARA_NGINX_TEST_COMMAND", defaultNginxTestCommand),
-		NginxReloadCommand: getenv("NUSANTARA_NGINX_RELOAD_COMMAND", defaultNginxReloadCommand),
-		CertbotCommand:     getenv("NUSANTARA_CERTBOT_COMMAND", defaultCertbotCommand),
-		MySQLCommand:       getenv("NUSANTARA_MYSQL_COMMAND", defaultMySQLCommand),
-		BackupDir:          getenv("NUSANTARA_BACKUP_DIR", defaultBackupDir),
-		LogLevel:           getenv("NUSANTARA_LOG_LEVEL", defaultLogLevel),
-		ShutdownSecs:       defaultShutdownSecs,
-		TokenTTLHours:      defaultTokenTTLHours,
-		AllowNonUbuntu:     defaultAllowNonLinux,
+		Address:             getenv("NUSANTARA_ADDR", defaultAddress),
+		DataDir:             getenv("NUSANTARA_DATA_DIR", defaultDataDir),
+		ProvisionApply:      runtime.GOOS == "linux",
+		NginxAvailableDir:   getenv("NUSANTARA_NGINX_SITES_AVAILABLE_DIR", defaultNginxAvailableDir),
+		NginxEnabledDir:     getenv("NUSANTARA_NGINX_SITES_ENABLED_DIR", defaultNginxEnabledDir),
+		NginxTestCommand:    getenv("NUSANTARA_NGINX_TEST_COMMAND", defaultNginxTestCommand),
+		NginxMainConfig:     getenv("NUSANTARA_NGINX_MAIN_CONFIG", defaultNginxMainConfig),
+		NginxReloadCommand:  getenv("NUSANTARA_NGINX_RELOAD_COMMAND", defaultNginxReloadCommand),
+		NginxTemplateDir:    getenv("NUSANTARA_NGINX_TEMPLATE_DIR", defaultNginxTemplateDir),
+		NginxMaintenanceDir: getenv("NUSANTARA_NGINX_MAINTENANCE_DIR", defaultNginxMaintenanceDir),
+		NginxHtpasswdDir:    getenv("NUSANTARA_NGINX_HTPASSWD_DIR", defaultNginxHtpasswdDir),
+		NginxCacheDir:       getenv("NUSANTARA_NGINX_CACHE_DIR", defaultNginxCacheDir),
+		NginxLogDir:         getenv("NUSANTARA_NGINX_LOG_DIR", defaultNginxLogDir),
+		LogrotatePath:       getenv("NUSANTARA_LOGROTATE_PATH", defaultLogrotatePath),
+		WebServer:           getenv("NUSANTARA_WEB_SERVER", defaultWebServer),
+		ApacheAvailableDir:  getenv("NUSANTARA_APACHE_SITES_AVAILABLE_DIR", defaultApacheAvailableDir),
+		ApacheEnabledDir:    getenv("NUSANTARA_APACHE_SITES_ENABLED_DIR", defaultApacheEnabledDir),
+		ApacheTestCommand:   getenv("NUSANTARA_APACHE_TEST_COMMAND", defaultApacheTestCommand),
+		ApacheReloadCommand: getenv("NUSANTARA_APACHE_RELOAD_COMMAND", defaultApacheReloadCommand),
+		ApacheLogDir:        getenv("NUSANTARA_APACHE_LOG_DIR", defaultApacheLogDir),
+		CaddyAvailableDir:   getenv("NUSANTARA_CADDY_SITES_AVAILABLE_DIR", defaultCaddyAvailableDir),
+		CaddyEnabledDir:     getenv("NUSANTARA_CADDY_SITES_ENABLED_DIR", defaultCaddyEnabledDir),
+		CaddyTestCommand:    getenv("NUSANTARA_CADDY_TEST_COMMAND", defaultCaddyTestCommand),
+		CaddyReloadCommand:  getenv("NUSANTARA_CADDY_RELOAD_COMMAND", defaultCaddyReloadCommand),
+		CaddyLogDir:         getenv("NUSANTARA_CADDY_LOG_DIR", defaultCaddyLogDir),
+		PHPFPMRunDir:        getenv("NUSANTARA_PHP_FPM_RUN_DIR", defaultPHPFPMRunDir),
+		CertbotCommand:      getenv("NUSANTARA_CERTBOT_COMMAND", defaultCertbotCommand),
+		MySQLCommand:        getenv("NUSANTARA_MYSQL_COMMAND", defaultMySQLCommand),
+		BackupDir:           getenv("NUSANTARA_BACKUP_DIR", defaultBackupDir),
+		LogLevel:            getenv("NUSANTARA_LOG_LEVEL", defaultLogLevel),
+		ShutdownSecs:        defaultShutdownSecs,
+		TokenTTLHours:       defaultTokenTTLHours,
+		AllowNonUbuntu:      defaultAllowNonLinux,
 
 		BootstrapAdminUsername: getenv("NUSANTARA_BOOTSTRAP_ADMIN_USERNAME", defaultBootstrapAdminUsername),
 		BootstrapAdminPassword: getenv("NUSANTARA_BOOTSTRAP_ADMIN_PASSWORD", defaultBootstrapAdminPassword),
@@ -85,6 +189,33 @@ func LoadFromEnv() (Config, error) {
 		UpdateUnitName:         getenv("NUSANTARA_UPDATE_UNIT_NAME", defaultUpdateUnitName),
 		UpdateLogLines:         defaultUpdateLogLines,
 		UpdateCooldown:         defaultUpdateCooldownSecs,
+		SchedulerIntervalSecs:  defaultSchedulerIntervalSecs,
+		IdempotencyTTLHours:    defaultIdempotencyTTLHours,
+		UpstreamPortMin:        defaultUpstreamPortMin,
+		UpstreamPortMax:        defaultUpstreamPortMax,
+		PHPFPMPoolDir:          getenv("NUSANTARA_PHP_FPM_POOL_DIR", defaultPHPFPMPoolDir),
+		PHPFPMTestCommand:      getenv("NUSANTARA_PHP_FPM_TEST_COMMAND", defaultPHPFPMTestCommand),
+		PHPFPMReloadCommand:    getenv("NUSANTARA_PHP_FPM_RELOAD_COMMAND", defaultPHPFPMReloadCommand),
+		PHPFPMMaxChildren:      defaultPHPFPMMaxChildren,
+		PHPFPMMemoryLimit:      getenv("NUSANTARA_PHP_FPM_MEMORY_LIMIT", defaultPHPFPMMemoryLimit),
+		PHPFPMTmpDir:           getenv("NUSANTARA_PHP_FPM_TMP_DIR", defaultPHPFPMTmpDir),
+		SiteUserAddCommand:     getenv("NUSANTARA_SITE_USERADD_COMMAND", defaultSiteUserAddCommand),
+		SiteUserDelCommand:     getenv("NUSANTARA_SITE_USERDEL_COMMAND", defaultSiteUserDelCommand),
+		SiteChownCommand:       getenv("NUSANTARA_SITE_CHOWN_COMMAND", defaultSiteChownCommand),
+		SiteRunAsCommand:       getenv("NUSANTARA_SITE_RUNAS_COMMAND", defaultSiteRunAsCommand),
+		SiteRootBase:           getenv("NUSANTARA_SITE_ROOT_BASE", defaultSiteRootBase),
+		WebGroup:               getenv("NUSANTARA_WEB_GROUP", defaultWebGroup),
+		SystemdUnitDir:         getenv("NUSANTARA_SYSTEMD_UNIT_DIR", defaultSystemdUnitDir),
+		SystemctlCommand:       getenv("NUSANTARA_SYSTEMCTL_COMMAND", defaultSystemctlCommand),
+		GitCommand:             getenv("NUSANTARA_GIT_COMMAND", defaultGitCommand),
+		DeployKeyDir:           getenv("NUSANTARA_DEPLOY_KEY_DIR", defaultDeployKeyDir),
+		UploadDir:              getenv("NUSANTARA_UPLOAD_DIR", defaultUploadDir),
+	}
+
+	switch cfg.WebServer {
+	case "nginx", "apache", "caddy":
+	default:
+		return Config{}, fmt.Errorf("invalid NUSANTARA_WEB_SERVER: %q", cfg.WebServer)
 	}
 
 	if v := os.Getenv("NUSANTARA_SHUTDOWN_SECS"); v != "" {
@@ -135,6 +266,48 @@ func LoadFromEnv() (Config, error) {
 		cfg.UpdateCooldown = secs
 	}
 
+	if v := os.Getenv("NUSANTARA_SCHEDULER_INTERVAL_SECS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 1 {
+			return Config{}, fmt.Errorf("invalid NUSANTARA_SCHEDULER_INTERVAL_SECS: %q", v)
+		}
+		cfg.SchedulerIntervalSecs = secs
+	}
+
+	if v := os.Getenv("NUSANTARA_IDEMPOTENCY_TTL_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours < 1 || hours > 24*30 {
+			return Config{}, fmt.Errorf("invalid NUSANTARA_IDEMPOTENCY_TTL_HOURS: %q", v)
+		}
+		cfg.IdempotencyTTLHours = hours
+	}
+
+	if v := os.Getenv("NUSANTARA_UPSTREAM_PORT_MIN"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return Config{}, fmt.Errorf("invalid NUSANTARA_UPSTREAM_PORT_MIN: %q", v)
+		}
+		cfg.UpstreamPortMin = port
+	}
+	if v := os.Getenv("NUSANTARA_UPSTREAM_PORT_MAX"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return Config{}, fmt.Errorf("invalid NUSANTARA_UPSTREAM_PORT_MAX: %q", v)
+		}
+		cfg.UpstreamPortMax = port
+	}
+	if cfg.UpstreamPortMax < cfg.UpstreamPortMin {
+		return Config{}, fmt.Errorf("NUSANTARA_UPSTREAM_PORT_MAX (%d) is below NUSANTARA_UPSTREAM_PORT_MIN (%d)", cfg.UpstreamPortMax, cfg.UpstreamPortMin)
+	}
+
+	if v := os.Getenv("NUSANTARA_PHP_FPM_MAX_CHILDREN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("invalid NUSANTARA_PHP_FPM_MAX_CHILDREN: %q", v)
+		}
+		cfg.PHPFPMMaxChildren = n
+	}
+
 	cfg.DBPath = getenv("NUSANTARA_DB_PATH", filepath.Join(cfg.DataDir, "nusantara_state.json"))
 
 	return cfg, nil
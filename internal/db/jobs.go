@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+type CreateDatabasePayload struct {
+	Name string `json:"name"`
+}
+
+func (p CreateDatabasePayload) Validate() error {
+	if !isValidIdentifier(strings.TrimSpace(p.Name)) {
+		return ErrInvalidDatabaseName
+	}
+	return nil
+}
+
+// RegisterJobs exposes database operations as async jobs.
+func (s *Service) RegisterJobs(r jobs.Registry) error {
+	if err := r.Register(store.JobTypeCreateDatabase, jobs.Typed(func(ctx context.Context, _ store.Job, p CreateDatabasePayload) error {
+		jobs.Logf(ctx, "creating database %s", p.Name)
+		return s.CreateDatabase(ctx, p.Name)
+	})); err != nil {
+		return err
+	}
+	return r.Register(store.JobTypeCreateDBUser, jobs.Typed(func(ctx context.Context, _ store.Job, p CreateUserInput) error {
+		jobs.Logf(ctx, "creating database user %s on %s", p.Username, p.Database)
+		return s.CreateUser(ctx, p)
+	}))
+}
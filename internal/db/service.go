@@ -42,10 +42,41 @@ type DatabaseInfo struct {
 }
 
 type CreateUserInput struct {
-	Database string
-	Username string
-	Password string
-	Host     string
+	Database string `json:"database"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host,omitempty"`
+}
+
+func (in CreateUserInput) normalized() CreateUserInput {
+	in.Database = strings.TrimSpace(in.Database)
+	in.Username = strings.TrimSpace(in.Username)
+	in.Host = strings.TrimSpace(in.Host)
+	if in.Host == "" {
+		in.Host = "localhost"
+	}
+	return in
+}
+
+func (in CreateUserInput) Validate() error {
+	in = in.normalized()
+	switch {
+	case !isValidIdentifier(in.Database):
+		return ErrInvalidDatabaseName
+	case !isValidIdentifier(in.Username):
+		return ErrInvalidUsername
+	case len(strings.TrimSpace(in.Password)) < 8:
+		return ErrInvalidPassword
+	case !hostPattern.MatchString(in.Host):
+		return ErrInvalidHost
+	}
+	return nil
+}
+
+// Redacted drops the password so it is not kept in the job history.
+func (in CreateUserInput) Redacted() CreateUserInput {
+	in.Password = ""
+	return in
 }
 
 func NewService(apply bool, mysqlCommand string, timeout time.Duration, logger *log.Logger) *Service {
@@ -101,24 +132,11 @@ func (s *Service) CreateDatabase(ctx context.Context, database string) error {
 }
 
 func (s *Service) CreateUser(ctx context.Context, input CreateUserInput) error {
-	database := strings.TrimSpace(input.Database)
-	username := strings.TrimSpace(input.Username)
-	password := input.Password
-	host := strings.TrimSpace(input.Host)
-	if host == "" {
-		host = "localhost"
-	}
-
-	switch {
-	case !isValidIdentifier(database):
-		return ErrInvalidDatabaseName
-	case !isValidIdentifier(username):
-		return ErrInvalidUsername
-	case len(strings.TrimSpace(password)) < 8:
-		return ErrInvalidPassword
-	case !hostPattern.MatchString(host):
-		return ErrInvalidHost
+	if err := input.Validate(); err != nil {
+		return err
 	}
+	input = input.normalized()
+	database, username, password, host := input.Database, input.Username, input.Password, input.Host
 
 	sql := strings.Join([]string{
 		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%s' IDENTIFIED BY '%s';", escapeSQLString(username), escapeSQLString(host), escapeSQLString(password)),
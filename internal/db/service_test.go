@@ -40,3 +40,13 @@ func TestCreateUserValidation(t *testing.T) {
 	}
 }
 
+func TestCreateUserInputRedacted(t *testing.T) {
+	input := CreateUserInput{Database: "app_db", Username: "app_user", Password: "StrongPass123"}
+	if err := input.Validate(); err != nil {
+		t.Fatalf("expected valid input: %v", err)
+	}
+	if got := input.Redacted(); got.Password != "" || got.Username != "app_user" {
+		t.Fatalf("unexpected redacted input %+v", got)
+	}
+}
+
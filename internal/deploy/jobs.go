@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+// DeployPayload deploys the branch head, or Commit when a webhook
+// recorded the pushed commit.
+type DeployPayload struct {
+	SiteID string `json:"site_id"`
+	Commit string `json:"commit,omitempty"`
+}
+
+func (p DeployPayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	if p.Commit != "" && !commitPattern.MatchString(p.Commit) {
+		return ErrInvalidCommit
+	}
+	return nil
+}
+
+// RollbackPayload names the release to make live again.
+type RollbackPayload struct {
+	SiteID  string `json:"site_id"`
+	Release string `json:"release"`
+}
+
+func (p RollbackPayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	if p.Release == "" {
+		return errors.New("missing release in payload")
+	}
+	return nil
+}
+
+// RegisterJobs exposes deploy and rollback as async jobs.
+func (s *Service) RegisterJobs(r jobs.Registry) error {
+	if err := r.Register(store.JobTypeDeploySite, jobs.Typed(func(ctx context.Context, _ store.Job, p DeployPayload) error {
+		_, err := s.Deploy(ctx, p.SiteID, p.Commit)
+		return err
+	})); err != nil {
+		return err
+	}
+	return r.Register(store.JobTypeRollbackSite, jobs.Typed(func(ctx context.Context, _ store.Job, p RollbackPayload) error {
+		jobs.Logf(ctx, "rolling back to release %s", p.Release)
+		return s.Rollback(ctx, p.SiteID, p.Release)
+	}))
+}
@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const keyType = "ssh-ed25519"
+
+// keyPath is the site's private deploy key. The public half is stored on
+// the site, so the key itself is only ever read by ssh.
+func (s *Service) keyPath(siteID string) string {
+	return filepath.Join(s.cfg.KeyDir, siteID)
+}
+
+// ensureKey returns the site's public deploy key in authorized_keys format,
+// generating a new ed25519 key unless the site already has one on disk.
+func (s *Service) ensureKey(siteID, current, comment string) (string, error) {
+	path := s.keyPath(siteID)
+	if current != "" {
+		if _, err := os.Stat(path); err == nil {
+			return current, nil
+		}
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("generate deploy key: %w", err)
+	}
+	if err := os.MkdirAll(s.cfg.KeyDir, 0o700); err != nil {
+		return "", fmt.Errorf("create key dir: %w", err)
+	}
+	block, err := marshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", err
+	}
+	if err := writePrivateFile(path, pem.EncodeToMemory(block)); err != nil {
+		return "", fmt.Errorf("write deploy key: %w", err)
+	}
+	return marshalAuthorizedKey(pub, comment), nil
+}
+
+// writePrivateFile replaces path with a file only the panel user can read.
+func writePrivateFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) removeKey(siteID string) error {
+	if err := os.Remove(s.keyPath(siteID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove deploy key: %w", err)
+	}
+	return nil
+}
+
+func marshalAuthorizedKey(pub ed25519.PublicKey, comment string) string {
+	return keyType + " " + base64.StdEncoding.EncodeToString(publicKeyBlob(pub)) + " " + comment
+}
+
+func publicKeyBlob(pub ed25519.PublicKey) []byte {
+	var b []byte
+	b = appendString(b, []byte(keyType))
+	return appendString(b, pub)
+}
+
+// marshalPrivateKey encodes key in the unencrypted openssh-key-v1 format
+// that ssh reads, see PROTOCOL.key in the OpenSSH sources.
+func marshalPrivateKey(key ed25519.PrivateKey, comment string) (*pem.Block, error) {
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return nil, fmt.Errorf("generate deploy key: %w", err)
+	}
+	var private []byte
+	private = append(private, check[:]...)
+	private = append(private, check[:]...)
+	private = appendString(private, []byte(keyType))
+	private = appendString(private, key.Public().(ed25519.PublicKey))
+	private = appendString(private, key)
+	private = appendString(private, []byte(comment))
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	out := []byte("openssh-key-v1\x00")
+	out = appendString(out, []byte("none"))
+	out = appendString(out, []byte("none"))
+	out = appendString(out, nil)
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = appendString(out, publicKeyBlob(key.Public().(ed25519.PublicKey)))
+	out = appendString(out, private)
+	return &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: out}, nil
+}
+
+func appendString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,684 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidRepo   = errors.New("invalid repo_url")
+	ErrInvalidBranch = errors.New("invalid branch")
+	ErrInvalidDeploy = errors.New("invalid deploy settings")
+	ErrNotLinked     = errors.New("site is not linked to a git repository")
+	ErrNoRelease     = errors.New("release not found")
+	ErrInvalidCommit = errors.New("invalid commit")
+)
+
+const (
+	defaultKeepReleases = 5
+	maxKeepReleases     = 50
+	maxCommandLength    = 4096
+	releaseTimeFormat   = "20060102150405"
+	hookPath            = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+var (
+	branchPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+	// scpRepoPattern matches git's scp-like syntax, e.g. git@github.com:org/app.git.
+	scpRepoPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[A-Za-z0-9._/~-]+$`)
+)
+
+type Config struct {
+	// GitCommand is the git binary; defaults to "git".
+	GitCommand string
+	// KeyDir holds the per-site private deploy keys.
+	KeyDir string
+	// UserAddCommand creates the site user, shared with the PHP-FPM pool,
+	// when the site has none yet. ChownCommand and WebGroup hand new
+	// releases to it.
+	UserAddCommand string
+	ChownCommand   string
+	WebGroup       string
+	// RunAsCommand runs build and post-deploy commands as the site user;
+	// it is given the user name, "--" and the command. Defaults to
+	// "runuser -u".
+	RunAsCommand string
+	// Timeout bounds one deploy, build and post-deploy commands included.
+	Timeout time.Duration
+}
+
+type Service struct {
+	apply       bool
+	repo        store.Repository
+	jobSvc      *jobs.Service
+	provisioner jobs.SiteProvisioner
+	cfg         Config
+	logger      *log.Logger
+}
+
+// NewService builds the git deployer. provisioner re-renders a site the
+// first time its root moves to the current release.
+func NewService(apply bool, repo store.Repository, jobSvc *jobs.Service, provisioner jobs.SiteProvisioner, cfg Config, logger *log.Logger) *Service {
+	if strings.TrimSpace(cfg.GitCommand) == "" {
+		cfg.GitCommand = "git"
+	}
+	if strings.TrimSpace(cfg.RunAsCommand) == "" {
+		cfg.RunAsCommand = "runuser -u"
+	}
+	if cfg.Timeout < time.Minute {
+		cfg.Timeout = 15 * time.Minute
+	}
+	return &Service{
+		apply:       apply,
+		repo:        repo,
+		jobSvc:      jobSvc,
+		provisioner: provisioner,
+		cfg:         cfg,
+		logger:      logger,
+	}
+}
+
+// LinkInput configures a site's git deployment. Path defaults to
+// /var/www/<domain> and KeepReleases to 5.
+type LinkInput struct {
+	RepoURL           string
+	Branch            string
+	Path              string
+	WebDir            string
+	KeepReleases      int
+	BuildCommand      string
+	PostDeployCommand string
+}
+
+// Link stores the site's git settings and creates its deploy key on first
+// use. Releases already deployed are kept.
+func (s *Service) Link(ctx context.Context, siteID string, input LinkInput) (store.Site, error) {
+	site, err := s.repo.GetSiteByID(ctx, siteID)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, provision.ErrSiteUnmanaged
+	}
+
+	deploy := &store.SiteDeploy{}
+	if site.Deploy != nil {
+		*deploy = *site.Deploy
+	}
+	deploy.RepoURL = strings.TrimSpace(input.RepoURL)
+	if !isValidRepoURL(deploy.RepoURL) {
+		return store.Site{}, ErrInvalidRepo
+	}
+	deploy.Branch = strings.TrimSpace(input.Branch)
+	if deploy.Branch == "" {
+		deploy.Branch = "main"
+	}
+	if !isValidBranch(deploy.Branch) {
+		return store.Site{}, ErrInvalidBranch
+	}
+	deploy.Path = strings.TrimSpace(input.Path)
+	if deploy.Path == "" {
+		deploy.Path = filepath.Join("/var/www", site.Domain)
+	}
+	if !isValidDir(deploy.Path) {
+		return store.Site{}, fmt.Errorf("%w: path must be an absolute directory", ErrInvalidDeploy)
+	}
+	if site.Deploy != nil && site.Deploy.Current != "" && deploy.Path != site.Deploy.Path {
+		return store.Site{}, fmt.Errorf("%w: unlink the site before moving its releases", ErrInvalidDeploy)
+	}
+	if site.RootPath != liveRoot(deploy.Path, site.Deploy) && isWithin(site.RootPath, deploy.Path) {
+		// Releases and the key-protected git cache must not be served.
+		return store.Site{}, fmt.Errorf("%w: path is inside the site root", ErrInvalidDeploy)
+	}
+	if err := s.checkPathFree(ctx, site.ID, deploy.Path); err != nil {
+		return store.Site{}, err
+	}
+	deploy.WebDir = strings.Trim(strings.TrimSpace(input.WebDir), "/")
+	if deploy.WebDir != "" && (filepath.Clean(deploy.WebDir) != deploy.WebDir || deploy.WebDir == ".." || strings.HasPrefix(deploy.WebDir, "../")) {
+		return store.Site{}, fmt.Errorf("%w: web_dir must stay inside the release", ErrInvalidDeploy)
+	}
+	deploy.KeepReleases = input.KeepReleases
+	if deploy.KeepReleases == 0 {
+		deploy.KeepReleases = defaultKeepReleases
+	}
+	if deploy.KeepReleases < 1 || deploy.KeepReleases > maxKeepReleases {
+		return store.Site{}, fmt.Errorf("%w: keep_releases must be between 1 and %d", ErrInvalidDeploy, maxKeepReleases)
+	}
+	deploy.BuildCommand = strings.TrimSpace(input.BuildCommand)
+	deploy.PostDeployCommand = strings.TrimSpace(input.PostDeployCommand)
+	if len(deploy.BuildCommand) > maxCommandLength || len(deploy.PostDeployCommand) > maxCommandLength {
+		return store.Site{}, fmt.Errorf("%w: commands are limited to %d bytes", ErrInvalidDeploy, maxCommandLength)
+	}
+
+	if deploy.PublicKey, err = s.ensureKey(site.ID, deploy.PublicKey, "nusantara-deploy@"+site.Domain); err != nil {
+		return store.Site{}, err
+	}
+	if err := s.repo.UpdateSiteDeploy(ctx, site.ID, deploy); err != nil {
+		return store.Site{}, err
+	}
+	return s.repo.GetSiteByID(ctx, site.ID)
+}
+
+// Unlink removes the git settings, the deploy key and the webhook secret.
+// Releases stay on disk and the site keeps serving the current one.
+func (s *Service) Unlink(ctx context.Context, siteID string) (store.Site, error) {
+	site, err := s.repo.GetSiteByID(ctx, siteID)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if site.Deploy == nil {
+		return store.Site{}, ErrNotLinked
+	}
+	if err := s.repo.UpdateSiteDeploy(ctx, site.ID, nil); err != nil {
+		return store.Site{}, err
+	}
+	if err := s.removeKey(site.ID); err != nil {
+		return store.Site{}, err
+	}
+	if err := s.removeWebhookSecret(site.ID); err != nil {
+		return store.Site{}, err
+	}
+	return s.repo.GetSiteByID(ctx, site.ID)
+}
+
+// QueueDeploy enqueues a deploy of the linked branch's head.
+func (s *Service) QueueDeploy(ctx context.Context, actorID, siteID string) (store.Site, store.Job, error) {
+	site, err := s.linkedSite(ctx, siteID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeDeploySite, DeployPayload{SiteID: site.ID})
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// QueueRollback enqueues a switch back to release, or to the release before
+// the current one when release is empty.
+func (s *Service) QueueRollback(ctx context.Context, actorID, siteID, release string) (store.Site, store.Job, error) {
+	site, err := s.linkedSite(ctx, siteID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	target, err := rollbackTarget(site.Deploy, release)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeRollbackSite, RollbackPayload{SiteID: site.ID, Release: target.ID})
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+func (s *Service) linkedSite(ctx context.Context, siteID string) (store.Site, error) {
+	site, err := s.repo.GetSiteByID(ctx, siteID)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if site.Deploy == nil {
+		return store.Site{}, ErrNotLinked
+	}
+	if site.Unmanaged {
+		return store.Site{}, provision.ErrSiteUnmanaged
+	}
+	return site, nil
+}
+
+// Deploy fetches the branch, checks its head out into a new release, builds
+// it and swaps the current link to it. A non-empty commit pins the release
+// to that commit, which must still be on the branch. A failed fetch,
+// checkout or build leaves the live release untouched. The post-deploy
+// command runs once the release is live, so its failure fails the job
+// without a rollback.
+func (s *Service) Deploy(ctx context.Context, siteID, commit string) (store.SiteRelease, error) {
+	site, err := s.linkedSite(ctx, siteID)
+	if err != nil {
+		return store.SiteRelease{}, err
+	}
+	if commit != "" && !commitPattern.MatchString(commit) {
+		return store.SiteRelease{}, ErrInvalidCommit
+	}
+	d := site.Deploy
+	if !s.apply {
+		s.logf("dry-run deploy site=%s repo=%s branch=%s commit=%s", site.ID, d.RepoURL, d.Branch, commit)
+		return store.SiteRelease{}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	releasesDir := filepath.Join(d.Path, "releases")
+	if err := os.MkdirAll(releasesDir, 0o755); err != nil {
+		return store.SiteRelease{}, fmt.Errorf("create releases dir: %w", err)
+	}
+	head, err := s.fetch(ctx, site)
+	if err != nil {
+		return store.SiteRelease{}, err
+	}
+	pushed := commit != ""
+	if commit == "" {
+		commit = head
+	} else if commit != head {
+		cache := filepath.Join(d.Path, "repo.git")
+		if _, err := s.git(ctx, site, "", "--git-dir", cache, "merge-base", "--is-ancestor", commit, head); err != nil {
+			return store.SiteRelease{}, fmt.Errorf("%w: %s is not on branch %s", ErrInvalidCommit, commit, d.Branch)
+		}
+		jobs.Logf(ctx, "deploying pushed commit %s (branch head is %s)", commit, head)
+	}
+	if pushed && s.supersededCommit(ctx, site, commit) {
+		jobs.Logf(ctx, "skipping pushed commit %s: release %s already includes it", commit, d.Current)
+		return store.SiteRelease{}, nil
+	}
+	if len(commit) < 12 {
+		return store.SiteRelease{}, fmt.Errorf("unexpected commit id %q", commit)
+	}
+	release := store.SiteRelease{
+		ID:        time.Now().UTC().Format(releaseTimeFormat) + "-" + commit[:12],
+		Commit:    commit,
+		CreatedAt: time.Now().UTC(),
+	}
+	releaseDir := filepath.Join(releasesDir, release.ID)
+	if _, err := os.Lstat(releaseDir); !errors.Is(err, os.ErrNotExist) {
+		return store.SiteRelease{}, fmt.Errorf("release %s already exists", release.ID)
+	}
+	jobs.Logf(ctx, "checking out %s into release %s", commit, release.ID)
+	if err := s.checkout(ctx, site, commit, releaseDir); err != nil {
+		_ = os.RemoveAll(releaseDir)
+		return store.SiteRelease{}, err
+	}
+	// The build runs as the site user inside a release it owns.
+	if err := s.chownRelease(ctx, site, releaseDir); err != nil {
+		_ = os.RemoveAll(releaseDir)
+		return store.SiteRelease{}, err
+	}
+	if d.BuildCommand != "" {
+		jobs.Logf(ctx, "running build command")
+		if err := s.runHook(ctx, site, releaseDir, d.BuildCommand); err != nil {
+			_ = os.RemoveAll(releaseDir)
+			return store.SiteRelease{}, fmt.Errorf("build command: %w", err)
+		}
+	}
+
+	previous, err := s.activate(ctx, site, release.ID)
+	if err != nil {
+		_ = os.RemoveAll(releaseDir)
+		return store.SiteRelease{}, err
+	}
+	if err := s.recordRelease(ctx, site.ID, release); err != nil {
+		return store.SiteRelease{}, err
+	}
+	jobs.Logf(ctx, "release %s is live (previous %s)", release.ID, previous)
+
+	if d.PostDeployCommand != "" {
+		jobs.Logf(ctx, "running post-deploy command")
+		if err := s.runHook(ctx, site, filepath.Join(d.Path, "current"), d.PostDeployCommand); err != nil {
+			return release, fmt.Errorf("post-deploy command: %w", err)
+		}
+	}
+	return release, nil
+}
+
+// supersededCommit reports whether commit is the live release's commit or
+// one of its ancestors, so a replayed push cannot roll the site back.
+func (s *Service) supersededCommit(ctx context.Context, site store.Site, commit string) bool {
+	d := site.Deploy
+	i := slices.IndexFunc(d.Releases, func(r store.SiteRelease) bool { return r.ID == d.Current })
+	if i < 0 {
+		return false
+	}
+	live := d.Releases[i].Commit
+	if live == commit {
+		return true
+	}
+	cache := filepath.Join(d.Path, "repo.git")
+	_, err := s.git(ctx, site, "", "--git-dir", cache, "merge-base", "--is-ancestor", commit, live)
+	return err == nil
+}
+
+// Rollback makes an earlier release live again. Build and post-deploy
+// commands are not re-run.
+func (s *Service) Rollback(ctx context.Context, siteID, releaseID string) error {
+	site, err := s.linkedSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	target, err := rollbackTarget(site.Deploy, releaseID)
+	if err != nil {
+		return err
+	}
+	if !s.apply {
+		s.logf("dry-run deploy rollback site=%s release=%s", site.ID, target.ID)
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(site.Deploy.Path, "releases", target.ID)); err != nil {
+		return fmt.Errorf("%w: %s is no longer on disk", ErrNoRelease, target.ID)
+	}
+	previous, err := s.activate(ctx, site, target.ID)
+	if err != nil {
+		return err
+	}
+	jobs.Logf(ctx, "release %s is live (previous %s)", target.ID, previous)
+	return s.updateDeploy(ctx, site.ID, func(d *store.SiteDeploy) {
+		d.Current = target.ID
+	})
+}
+
+func rollbackTarget(d *store.SiteDeploy, releaseID string) (store.SiteRelease, error) {
+	current := slices.IndexFunc(d.Releases, func(r store.SiteRelease) bool { return r.ID == d.Current })
+	if releaseID == "" {
+		if current < 1 {
+			return store.SiteRelease{}, fmt.Errorf("%w: no release before the current one", ErrNoRelease)
+		}
+		return d.Releases[current-1], nil
+	}
+	i := slices.IndexFunc(d.Releases, func(r store.SiteRelease) bool { return r.ID == releaseID })
+	if i < 0 {
+		return store.SiteRelease{}, fmt.Errorf("%w: %s", ErrNoRelease, releaseID)
+	}
+	return d.Releases[i], nil
+}
+
+// fetch updates the site's bare cache of the repository and returns the
+// branch head.
+func (s *Service) fetch(ctx context.Context, site store.Site) (string, error) {
+	d := site.Deploy
+	cache := filepath.Join(d.Path, "repo.git")
+	if _, err := os.Stat(cache); errors.Is(err, os.ErrNotExist) {
+		jobs.Logf(ctx, "cloning %s", d.RepoURL)
+		if _, err := s.git(ctx, site, "", "clone", "--bare", "--", d.RepoURL, cache); err != nil {
+			_ = os.RemoveAll(cache)
+			return "", err
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("stat git cache: %w", err)
+	} else if _, err := s.git(ctx, site, "", "--git-dir", cache, "remote", "set-url", "origin", "--", d.RepoURL); err != nil {
+		return "", err
+	}
+	jobs.Logf(ctx, "fetching branch %s", d.Branch)
+	ref := "refs/heads/" + d.Branch
+	if _, err := s.git(ctx, site, "", "--git-dir", cache, "fetch", "--prune", "origin", "+"+ref+":"+ref); err != nil {
+		return "", err
+	}
+	out, err := s.git(ctx, site, "", "--git-dir", cache, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// checkout writes commit's tree into dir without a .git directory. A
+// throwaway index keeps the shared cache untouched.
+func (s *Service) checkout(ctx context.Context, site store.Site, commit, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create release dir: %w", err)
+	}
+	index := dir + ".index"
+	defer os.Remove(index)
+	cache := filepath.Join(site.Deploy.Path, "repo.git")
+	_, err := s.git(ctx, site, index, "--git-dir", cache, "--work-tree", dir, "checkout", "-f", commit, "--", ".")
+	return err
+}
+
+// activate points the current link at release with an atomic rename and,
+// the first time, moves the site root there. It returns the release that
+// was live before.
+func (s *Service) activate(ctx context.Context, site store.Site, releaseID string) (string, error) {
+	d := site.Deploy
+	link := filepath.Join(d.Path, "current")
+	previousTarget, _ := os.Readlink(link)
+	swap := func(target string) error {
+		tmp := link + ".tmp"
+		_ = os.Remove(tmp)
+		if err := os.Symlink(target, tmp); err != nil {
+			return err
+		}
+		return os.Rename(tmp, link)
+	}
+	if err := swap(filepath.Join("releases", releaseID)); err != nil {
+		return "", fmt.Errorf("switch current release: %w", err)
+	}
+
+	root := liveRoot(d.Path, d)
+	if site.RootPath != root {
+		jobs.Logf(ctx, "moving site root from %s to %s", site.RootPath, root)
+		moved := site
+		moved.RootPath = root
+		err := s.provisioner.ProvisionSite(ctx, moved)
+		if err == nil {
+			err = s.repo.UpdateSite(ctx, moved)
+		}
+		if err != nil {
+			if previousTarget != "" {
+				_ = swap(previousTarget)
+			} else {
+				_ = os.Remove(link)
+			}
+			_ = s.provisioner.ProvisionSite(ctx, site)
+			return "", fmt.Errorf("move site root: %w", err)
+		}
+	}
+	return filepath.Base(previousTarget), nil
+}
+
+// recordRelease marks release live and forgets releases beyond the ones
+// kept for rollback, deleting their directories.
+func (s *Service) recordRelease(ctx context.Context, siteID string, release store.SiteRelease) error {
+	var pruned []store.SiteRelease
+	var path string
+	err := s.updateDeploy(ctx, siteID, func(d *store.SiteDeploy) {
+		d.Releases = append(d.Releases, release)
+		d.Current = release.ID
+		if extra := len(d.Releases) - d.KeepReleases - 1; extra > 0 {
+			pruned = slices.Clone(d.Releases[:extra])
+			d.Releases = slices.Clone(d.Releases[extra:])
+		}
+		path = d.Path
+	})
+	if err != nil {
+		return err
+	}
+	for _, old := range pruned {
+		jobs.Logf(ctx, "removing old release %s", old.ID)
+		if err := os.RemoveAll(filepath.Join(path, "releases", old.ID)); err != nil {
+			return fmt.Errorf("remove old release: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateDeploy applies fn to the stored deploy settings, re-read so a
+// change made while the job ran is not overwritten.
+func (s *Service) updateDeploy(ctx context.Context, siteID string, fn func(*store.SiteDeploy)) error {
+	site, err := s.repo.GetSiteByID(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if site.Deploy == nil {
+		return ErrNotLinked
+	}
+	d := *site.Deploy
+	d.Releases = slices.Clone(d.Releases)
+	fn(&d)
+	if err := s.repo.UpdateSiteDeploy(ctx, siteID, &d); err != nil {
+		return fmt.Errorf("update site deploy: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) chownRelease(ctx context.Context, site store.Site, dir string) error {
+	username, err := s.ensureSiteUser(ctx, site)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(s.cfg.ChownCommand) == "" {
+		return nil
+	}
+	parts := strings.Fields(s.cfg.ChownCommand)
+	args := append(parts[1:], username+":"+s.cfg.WebGroup, dir)
+	if out, err := exec.CommandContext(ctx, parts[0], args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("chown release: %w (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ensureSiteUser creates the site user the same way the PHP-FPM pool does
+// when the site has none yet, so hooks never fall back to root.
+func (s *Service) ensureSiteUser(ctx context.Context, site store.Site) (string, error) {
+	username := provision.PoolUser(site.ID)
+	if _, err := user.Lookup(username); err == nil {
+		return username, nil
+	}
+	if strings.TrimSpace(s.cfg.UserAddCommand) == "" {
+		return "", fmt.Errorf("site user %s does not exist", username)
+	}
+	parts := strings.Fields(s.cfg.UserAddCommand)
+	args := append(parts[1:], "--home-dir", site.RootPath, "--user-group", username)
+	if out, err := exec.CommandContext(ctx, parts[0], args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("create site user: %w (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return username, nil
+}
+
+// runHook runs an admin-provided shell command in dir as the site user and
+// copies its output to the job log. The command only sees a minimal
+// environment, never the panel's own.
+func (s *Service) runHook(ctx context.Context, site store.Site, dir, command string) error {
+	parts := strings.Fields(s.cfg.RunAsCommand)
+	args := append(parts[1:], provision.PoolUser(site.ID), "--", "/bin/sh", "-c", command)
+	cmd := exec.CommandContext(ctx, parts[0], args...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + hookPath,
+		"HOME=" + site.RootPath,
+		"NUSANTARA_SITE_ID=" + site.ID,
+		"NUSANTARA_SITE_DOMAIN=" + site.Domain,
+		"NUSANTARA_RELEASE_DIR=" + dir,
+	}
+	out, err := cmd.CombinedOutput()
+	logOutput(ctx, out)
+	return err
+}
+
+// git runs a git command with the site's deploy key. index, when set,
+// replaces the repository's index file.
+func (s *Service) git(ctx context.Context, site store.Site, index string, args ...string) (string, error) {
+	parts := strings.Fields(s.cfg.GitCommand)
+	cmd := exec.CommandContext(ctx, parts[0], append(parts[1:], args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if _, err := os.Stat(s.keyPath(site.ID)); err == nil {
+		knownHosts := filepath.Join(s.cfg.KeyDir, "known_hosts")
+		cmd.Env = append(cmd.Env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s",
+			s.keyPath(site.ID), knownHosts,
+		))
+	}
+	if index != "" {
+		cmd.Env = append(cmd.Env, "GIT_INDEX_FILE="+index)
+	}
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w (%s)", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func logOutput(ctx context.Context, out []byte) {
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			jobs.Logf(ctx, "| %s", line)
+		}
+	}
+}
+
+// liveRoot is the site root once releases are deployed.
+func liveRoot(path string, d *store.SiteDeploy) string {
+	webDir := ""
+	if d != nil {
+		webDir = d.WebDir
+	}
+	return filepath.Join(path, "current", webDir)
+}
+
+func isValidRepoURL(raw string) bool {
+	if raw == "" || strings.HasPrefix(raw, "-") || strings.ContainsAny(raw, " \t\r\n") {
+		return false
+	}
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://", "file://"} {
+		if strings.HasPrefix(raw, scheme) && len(raw) > len(scheme) {
+			return true
+		}
+	}
+	return scpRepoPattern.MatchString(raw) || (filepath.IsAbs(raw) && filepath.Clean(raw) == raw)
+}
+
+// isValidBranch accepts the branch names git check-ref-format allows,
+// minus the rarely used punctuation.
+func isValidBranch(branch string) bool {
+	if !branchPattern.MatchString(branch) || len(branch) > 255 {
+		return false
+	}
+	if strings.HasPrefix(branch, "-") || strings.HasPrefix(branch, "/") || strings.HasSuffix(branch, "/") ||
+		strings.HasSuffix(branch, ".lock") || strings.HasSuffix(branch, ".") {
+		return false
+	}
+	return !strings.Contains(branch, "..") && !strings.Contains(branch, "//") && !strings.Contains(branch, "/.")
+}
+
+// checkPathFree rejects a deploy path that overlaps another site's root or
+// deploy path: releases are handed to this site's user.
+func (s *Service) checkPathFree(ctx context.Context, siteID, path string) error {
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, other := range sites {
+		if other.ID == siteID {
+			continue
+		}
+		paths := []string{other.RootPath}
+		if other.Deploy != nil {
+			paths = append(paths, other.Deploy.Path)
+		}
+		for _, p := range paths {
+			if p != "" && (isWithin(p, path) || isWithin(path, p)) {
+				return fmt.Errorf("%w: path overlaps %s of site %s", ErrInvalidDeploy, p, other.Domain)
+			}
+		}
+	}
+	return nil
+}
+
+func isValidDir(path string) bool {
+	return filepath.IsAbs(path) && filepath.Clean(path) == path && path != "/"
+}
+
+func isWithin(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, "../"))
+}
+
+func (s *Service) logf(format string, args ...any) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,337 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+type fakeProvisioner struct {
+	err   error
+	sites []store.Site
+}
+
+func (f *fakeProvisioner) ProvisionSite(_ context.Context, site store.Site) error {
+	f.sites = append(f.sites, site)
+	return f.err
+}
+
+func (f *fakeProvisioner) DeprovisionSite(context.Context, store.Site) error { return nil }
+
+func (f *fakeProvisioner) SuspendSite(context.Context, store.Site) error { return nil }
+
+// gitRepo is a working repository pushed to a bare one, standing in for
+// the git host.
+type gitRepo struct {
+	t      *testing.T
+	work   string
+	remote string
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	r := &gitRepo{t: t, work: filepath.Join(dir, "work"), remote: filepath.Join(dir, "remote.git")}
+	r.run(dir, "init", "-q", "--bare", r.remote)
+	r.run(dir, "init", "-q", "-b", "main", r.work)
+	r.run(r.work, "remote", "add", "origin", r.remote)
+	return r
+}
+
+func (r *gitRepo) run(dir string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v (%s)", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files, commits and pushes them, and returns the commit.
+func (r *gitRepo) commit(files map[string]string) string {
+	r.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(r.work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.run(r.work, "add", "-A")
+	r.run(r.work, "commit", "-q", "-m", "update")
+	r.run(r.work, "push", "-q", "origin", "main")
+	return r.run(r.work, "rev-parse", "HEAD")
+}
+
+func newTestService(t *testing.T, provisioner *fakeProvisioner) (*Service, *filedb.Repository, store.Site) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := filedb.New(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	now := time.Now().UTC()
+	site := store.Site{
+		ID:        "site_abc123",
+		Domain:    "example.com",
+		RootPath:  filepath.Join(dir, "www", "public"),
+		Runtime:   "static",
+		Status:    store.SiteStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := repo.CreateSite(context.Background(), site); err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+	svc := NewService(true, repo, nil, provisioner, Config{
+		KeyDir:         filepath.Join(dir, "keys"),
+		UserAddCommand: "true",
+		RunAsCommand:   fakeRunAs(t, filepath.Join(dir, "RUNAS")),
+	}, nil)
+	return svc, repo, site
+}
+
+// fakeRunAs records the user a hook runs as and runs the hook as the
+// current user.
+func fakeRunAs(t *testing.T, record string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "runas")
+	script := "#!/bin/sh\necho \"$1\" > " + record + "\nshift 2\nexec \"$@\"\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake runas: %v", err)
+	}
+	return path
+}
+
+func TestDeployReleasesAndRollback(t *testing.T) {
+	remote := newGitRepo(t)
+	first := remote.commit(map[string]string{"public/index.html": "v1"})
+
+	t.Setenv("NUSANTARA_DEPLOY_TEST_SECRET", "-leaked")
+	provisioner := &fakeProvisioner{}
+	svc, repo, site := newTestService(t, provisioner)
+	ctx := context.Background()
+	base := filepath.Join(filepath.Dir(filepath.Dir(site.RootPath)), "deploy")
+
+	linked, err := svc.Link(ctx, site.ID, LinkInput{
+		RepoURL:           remote.remote,
+		Path:              base,
+		WebDir:            "public",
+		KeepReleases:      1,
+		BuildCommand:      "echo built$NUSANTARA_DEPLOY_TEST_SECRET > BUILD",
+		PostDeployCommand: "echo $NUSANTARA_SITE_DOMAIN > ../../POST",
+	})
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if linked.Deploy.Branch != "main" || !strings.HasPrefix(linked.Deploy.PublicKey, "ssh-ed25519 AAAA") {
+		t.Fatalf("link defaults = %+v", linked.Deploy)
+	}
+	if info, err := os.Stat(svc.keyPath(site.ID)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("deploy key not written privately: %v", err)
+	}
+
+	release, err := svc.Deploy(ctx, site.ID, "")
+	if err != nil {
+		t.Fatalf("first deploy: %v", err)
+	}
+	if release.Commit != first {
+		t.Fatalf("release commit = %s, want %s", release.Commit, first)
+	}
+	current := filepath.Join(base, "current")
+	if body, err := os.ReadFile(filepath.Join(current, "public", "index.html")); err != nil || string(body) != "v1" {
+		t.Fatalf("current release content = %q err=%v", body, err)
+	}
+	// Hooks run as the site user without the panel's environment.
+	if body, err := os.ReadFile(filepath.Join(current, "BUILD")); err != nil || strings.TrimSpace(string(body)) != "built" {
+		t.Fatalf("build command output = %q err=%v", body, err)
+	}
+	if body, _ := os.ReadFile(filepath.Join(filepath.Dir(base), "RUNAS")); strings.TrimSpace(string(body)) != "np_abc123" {
+		t.Fatalf("hook ran as %q", body)
+	}
+	if _, err := os.Stat(filepath.Join(current, ".git")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("release must not contain .git: %v", err)
+	}
+	if body, _ := os.ReadFile(filepath.Join(base, "POST")); strings.TrimSpace(string(body)) != "example.com" {
+		t.Fatalf("post-deploy command output = %q", body)
+	}
+	stored, err := repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if stored.RootPath != filepath.Join(current, "public") || len(provisioner.sites) != 1 {
+		t.Fatalf("site root not moved to the current release: %s provisions=%d", stored.RootPath, len(provisioner.sites))
+	}
+
+	remote.commit(map[string]string{"public/index.html": "v2"})
+	second, err := svc.Deploy(ctx, site.ID, "")
+	if err != nil {
+		t.Fatalf("second deploy: %v", err)
+	}
+	if body, _ := os.ReadFile(filepath.Join(current, "public", "index.html")); string(body) != "v2" {
+		t.Fatalf("current release content = %q", body)
+	}
+	if len(provisioner.sites) != 1 {
+		t.Fatalf("site must not be reprovisioned once its root is current")
+	}
+
+	if err := svc.Rollback(ctx, site.ID, ""); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if body, _ := os.ReadFile(filepath.Join(current, "public", "index.html")); string(body) != "v1" {
+		t.Fatalf("rolled back content = %q", body)
+	}
+	stored, _ = repo.GetSiteByID(ctx, site.ID)
+	if stored.Deploy.Current != release.ID {
+		t.Fatalf("current release = %s, want %s", stored.Deploy.Current, release.ID)
+	}
+
+	// With keep_releases 1, a third deploy drops the oldest release.
+	remote.commit(map[string]string{"public/index.html": "v3"})
+	if _, err := svc.Deploy(ctx, site.ID, ""); err != nil {
+		t.Fatalf("third deploy: %v", err)
+	}
+	stored, _ = repo.GetSiteByID(ctx, site.ID)
+	if len(stored.Deploy.Releases) != 2 || stored.Deploy.Releases[0].ID != second.ID {
+		t.Fatalf("releases after prune = %+v", stored.Deploy.Releases)
+	}
+	if _, err := os.Stat(filepath.Join(base, "releases", release.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("pruned release still on disk: %v", err)
+	}
+	if err := svc.Rollback(ctx, site.ID, release.ID); !errors.Is(err, ErrNoRelease) {
+		t.Fatalf("expected pruned release to be rejected, got %v", err)
+	}
+}
+
+func TestDeployFailedBuildKeepsLiveRelease(t *testing.T) {
+	remote := newGitRepo(t)
+	remote.commit(map[string]string{"index.html": "v1"})
+
+	provisioner := &fakeProvisioner{}
+	svc, repo, site := newTestService(t, provisioner)
+	ctx := context.Background()
+	base := filepath.Join(filepath.Dir(filepath.Dir(site.RootPath)), "deploy")
+	if _, err := svc.Link(ctx, site.ID, LinkInput{RepoURL: "file://" + remote.remote, Path: base}); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	live, err := svc.Deploy(ctx, site.ID, "")
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+
+	remote.commit(map[string]string{"index.html": "v2"})
+	if _, err := svc.Link(ctx, site.ID, LinkInput{RepoURL: "file://" + remote.remote, Path: base, BuildCommand: "exit 3"}); err != nil {
+		t.Fatalf("relink: %v", err)
+	}
+	if _, err := svc.Deploy(ctx, site.ID, ""); err == nil {
+		t.Fatal("expected failed build")
+	}
+	if body, _ := os.ReadFile(filepath.Join(base, "current", "index.html")); string(body) != "v1" {
+		t.Fatalf("live release changed after a failed build: %q", body)
+	}
+	entries, _ := os.ReadDir(filepath.Join(base, "releases"))
+	if len(entries) != 1 {
+		t.Fatalf("failed release left on disk: %d releases", len(entries))
+	}
+	stored, _ := repo.GetSiteByID(ctx, site.ID)
+	if stored.Deploy.Current != live.ID || len(stored.Deploy.Releases) != 1 {
+		t.Fatalf("deploy state changed after a failed build: %+v", stored.Deploy)
+	}
+
+	if _, err := svc.Unlink(ctx, site.ID); err != nil {
+		t.Fatalf("unlink: %v", err)
+	}
+	if _, err := os.Stat(svc.keyPath(site.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("deploy key not removed: %v", err)
+	}
+}
+
+func TestDeployRejectedRootMoveRestoresSite(t *testing.T) {
+	remote := newGitRepo(t)
+	remote.commit(map[string]string{"index.html": "v1"})
+
+	provisioner := &fakeProvisioner{err: errors.New("nginx test failed")}
+	svc, repo, site := newTestService(t, provisioner)
+	ctx := context.Background()
+	base := filepath.Join(filepath.Dir(filepath.Dir(site.RootPath)), "deploy")
+	if _, err := svc.Link(ctx, site.ID, LinkInput{RepoURL: remote.remote, Path: base}); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if _, err := svc.Deploy(ctx, site.ID, ""); err == nil {
+		t.Fatal("expected deploy to fail")
+	}
+	stored, _ := repo.GetSiteByID(ctx, site.ID)
+	if stored.RootPath != site.RootPath || stored.Deploy.Current != "" {
+		t.Fatalf("site changed after a rejected root move: root=%s current=%s", stored.RootPath, stored.Deploy.Current)
+	}
+	if len(provisioner.sites) != 2 || provisioner.sites[1].RootPath != site.RootPath {
+		t.Fatalf("previous root not provisioned again: %+v", provisioner.sites)
+	}
+	if _, err := os.Lstat(filepath.Join(base, "current")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("current link left behind: %v", err)
+	}
+}
+
+func TestLinkValidation(t *testing.T) {
+	svc, _, site := newTestService(t, &fakeProvisioner{})
+	ctx := context.Background()
+	cases := []struct {
+		input LinkInput
+		want  error
+	}{
+		{LinkInput{RepoURL: "--upload-pack=touch /tmp/x", Path: "/srv/app"}, ErrInvalidRepo},
+		{LinkInput{RepoURL: "https://example.com/app.git", Branch: "../main", Path: "/srv/app"}, ErrInvalidBranch},
+		{LinkInput{RepoURL: "git@github.com:org/app.git", Path: "relative"}, ErrInvalidDeploy},
+		{LinkInput{RepoURL: "git@github.com:org/app.git", Path: filepath.Join(site.RootPath, "deploy")}, ErrInvalidDeploy},
+		{LinkInput{RepoURL: "git@github.com:org/app.git", Path: "/srv/app", WebDir: "../etc"}, ErrInvalidDeploy},
+		{LinkInput{RepoURL: "git@github.com:org/app.git", Path: "/srv/app", KeepReleases: 100}, ErrInvalidDeploy},
+	}
+	for _, tc := range cases {
+		if _, err := svc.Link(ctx, site.ID, tc.input); !errors.Is(err, tc.want) {
+			t.Fatalf("Link(%+v) error = %v, want %v", tc.input, err, tc.want)
+		}
+	}
+	if _, err := svc.Deploy(ctx, site.ID, ""); !errors.Is(err, ErrNotLinked) {
+		t.Fatalf("expected unlinked site to be rejected, got %v", err)
+	}
+}
+
+func TestDeployKeyIsReadableBySSH(t *testing.T) {
+	keygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	svc, _, site := newTestService(t, &fakeProvisioner{})
+	pub, err := svc.ensureKey(site.ID, "", "test@example.com")
+	if err != nil {
+		t.Fatalf("ensure key: %v", err)
+	}
+	out, err := exec.Command(keygen, "-y", "-f", svc.keyPath(site.ID)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen -y: %v (%s)", err, out)
+	}
+	if !strings.HasPrefix(pub, strings.TrimSpace(string(out))) {
+		t.Fatalf("public key mismatch:\n%s\n%s", pub, out)
+	}
+	again, err := svc.ensureKey(site.ID, pub, "test@example.com")
+	if err != nil || again != pub {
+		t.Fatalf("existing key must be kept: %v", err)
+	}
+}
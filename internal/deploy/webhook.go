@@ -0,0 +1,247 @@
+package deploy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// Webhook providers, recognised by their event headers.
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+)
+
+// MaxWebhookBody bounds a push payload; hosts list every pushed commit.
+const MaxWebhookBody = 5 << 20
+
+var (
+	ErrWebhookDisabled  = errors.New("webhook is not enabled")
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookPayload   = errors.New("invalid webhook payload")
+)
+
+// WebhookEvent is a verified delivery. Ignored explains why it did not
+// queue a deploy.
+type WebhookEvent struct {
+	Provider string `json:"provider"`
+	Event    string `json:"event"`
+	Ref      string `json:"ref,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Pusher   string `json:"pusher,omitempty"`
+	Ignored  string `json:"ignored,omitempty"`
+}
+
+// pushPayload holds the push fields shared by GitHub, GitLab and Gitea.
+type pushPayload struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"`
+	Deleted     bool   `json:"deleted"`
+	Pusher      struct {
+		Name     string `json:"name"`
+		Login    string `json:"login"`
+		Username string `json:"username"`
+	} `json:"pusher"`
+	UserUsername string `json:"user_username"`
+}
+
+func (s *Service) webhookSecretPath(siteID string) string {
+	return filepath.Join(s.cfg.KeyDir, siteID+".webhook")
+}
+
+// EnableWebhook turns on push-to-deploy for a linked site and returns a new
+// secret, replacing any previous one. The secret is not shown again.
+func (s *Service) EnableWebhook(ctx context.Context, siteID string) (store.Site, string, error) {
+	site, err := s.linkedSite(ctx, siteID)
+	if err != nil {
+		return store.Site{}, "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return store.Site{}, "", fmt.Errorf("generate webhook secret: %w", err)
+	}
+	secret := hex.EncodeToString(raw)
+	if err := os.MkdirAll(s.cfg.KeyDir, 0o700); err != nil {
+		return store.Site{}, "", fmt.Errorf("create key dir: %w", err)
+	}
+	if err := writePrivateFile(s.webhookSecretPath(site.ID), []byte(secret)); err != nil {
+		return store.Site{}, "", fmt.Errorf("write webhook secret: %w", err)
+	}
+	if err := s.updateDeploy(ctx, site.ID, func(d *store.SiteDeploy) { d.Webhook = true }); err != nil {
+		return store.Site{}, "", err
+	}
+	site, err = s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, "", err
+	}
+	return site, secret, nil
+}
+
+// DisableWebhook turns push-to-deploy off and forgets the secret.
+func (s *Service) DisableWebhook(ctx context.Context, siteID string) (store.Site, error) {
+	site, err := s.repo.GetSiteByID(ctx, siteID)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if site.Deploy == nil {
+		return store.Site{}, ErrNotLinked
+	}
+	if err := s.updateDeploy(ctx, site.ID, func(d *store.SiteDeploy) { d.Webhook = false }); err != nil {
+		return store.Site{}, err
+	}
+	if err := s.removeWebhookSecret(site.ID); err != nil {
+		return store.Site{}, err
+	}
+	return s.repo.GetSiteByID(ctx, site.ID)
+}
+
+func (s *Service) removeWebhookSecret(siteID string) error {
+	if err := os.Remove(s.webhookSecretPath(siteID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove webhook secret: %w", err)
+	}
+	return nil
+}
+
+// HandleWebhook verifies a delivery from the git host and, for a push to
+// the linked branch, queues a deploy of the pushed commit. Sites without
+// an enabled webhook, including unknown ones, report ErrWebhookDisabled so
+// the endpoint does not reveal which sites exist.
+func (s *Service) HandleWebhook(ctx context.Context, siteID string, header http.Header, body []byte) (WebhookEvent, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, siteID)
+	if errors.Is(err, store.ErrNotFound) {
+		return WebhookEvent{}, store.Job{}, ErrWebhookDisabled
+	}
+	if err != nil {
+		return WebhookEvent{}, store.Job{}, err
+	}
+	if site.Deploy == nil || !site.Deploy.Webhook {
+		return WebhookEvent{}, store.Job{}, ErrWebhookDisabled
+	}
+	secret, err := os.ReadFile(s.webhookSecretPath(site.ID))
+	if errors.Is(err, os.ErrNotExist) {
+		return WebhookEvent{}, store.Job{}, ErrWebhookDisabled
+	}
+	if err != nil {
+		return WebhookEvent{}, store.Job{}, fmt.Errorf("read webhook secret: %w", err)
+	}
+
+	event, err := parseWebhook(header, body, string(secret), site.Deploy.Branch)
+	if err != nil || event.Ignored != "" {
+		return event, store.Job{}, err
+	}
+	if site.Unmanaged {
+		return event, store.Job{}, provision.ErrSiteUnmanaged
+	}
+	job, err := s.jobSvc.Enqueue(ctx, "webhook:"+event.Provider, store.JobTypeDeploySite, DeployPayload{
+		SiteID: site.ID,
+		Commit: event.Commit,
+	})
+	if err != nil {
+		return event, store.Job{}, err
+	}
+	return event, job, nil
+}
+
+// parseWebhook checks the delivery's signature before reading its body,
+// then reduces a push to the ref and commit it moved. Gitea is matched
+// before GitHub because it also sends GitHub's headers.
+func parseWebhook(header http.Header, body []byte, secret, branch string) (WebhookEvent, error) {
+	var event WebhookEvent
+	switch {
+	case header.Get("X-Gitlab-Event") != "":
+		event = WebhookEvent{Provider: ProviderGitLab, Event: header.Get("X-Gitlab-Event")}
+		// GitLab sends the secret itself rather than a signature.
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return event, ErrWebhookSignature
+		}
+	case header.Get("X-Gitea-Event") != "":
+		event = WebhookEvent{Provider: ProviderGitea, Event: header.Get("X-Gitea-Event")}
+		if !validSignature(header.Get("X-Gitea-Signature"), body, secret) {
+			return event, ErrWebhookSignature
+		}
+	case header.Get("X-GitHub-Event") != "":
+		event = WebhookEvent{Provider: ProviderGitHub, Event: header.Get("X-GitHub-Event")}
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !validSignature(signature, body, secret) {
+			return event, ErrWebhookSignature
+		}
+	default:
+		return event, fmt.Errorf("%w: unknown git host", ErrWebhookPayload)
+	}
+
+	switch event.Event {
+	case "push", "Push Hook":
+	case "ping":
+		event.Ignored = "ping"
+		return event, nil
+	default:
+		event.Ignored = "not a push event"
+		return event, nil
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return event, fmt.Errorf("%w: %v", ErrWebhookPayload, err)
+		}
+		body = []byte(form.Get("payload"))
+	}
+	var push pushPayload
+	if err := json.Unmarshal(body, &push); err != nil {
+		return event, fmt.Errorf("%w: %v", ErrWebhookPayload, err)
+	}
+	event.Ref = push.Ref
+	event.Pusher = firstNonEmpty(push.Pusher.Login, push.Pusher.Username, push.Pusher.Name, push.UserUsername)
+	event.Commit = firstNonEmpty(push.CheckoutSHA, push.After)
+
+	if push.Ref != "refs/heads/"+branch {
+		event.Ignored = "ref does not match branch " + branch
+		return event, nil
+	}
+	if push.Deleted || strings.Trim(push.After, "0") == "" {
+		event.Ignored = "branch was deleted"
+		return event, nil
+	}
+	if !commitPattern.MatchString(event.Commit) {
+		return event, fmt.Errorf("%w: bad commit %q", ErrWebhookPayload, event.Commit)
+	}
+	return event, nil
+}
+
+// validSignature compares a hex HMAC-SHA256 of body, as sent by GitHub and
+// Gitea.
+func validSignature(signature string, body []byte, secret string) bool {
+	got, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(got) != sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package deploy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	testSecret = "s3cret"
+	testCommit = "9fceb02d0ae598e95dc970b74767f19372d61af8"
+)
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestParseWebhookProviders(t *testing.T) {
+	push := `{"ref":"refs/heads/main","after":"` + testCommit + `","pusher":{"name":"octo","login":"octocat"},"user_username":"gl"}`
+	form := "payload=" + url.QueryEscape(push)
+
+	cases := []struct {
+		name     string
+		header   http.Header
+		body     string
+		provider string
+		pusher   string
+	}{
+		{
+			name:     "github",
+			header:   http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {"sha256=" + sign(push)}},
+			body:     push,
+			provider: ProviderGitHub,
+			pusher:   "octocat",
+		},
+		{
+			name: "github form",
+			header: http.Header{
+				"X-Github-Event":      {"push"},
+				"X-Hub-Signature-256": {"sha256=" + sign(form)},
+				"Content-Type":        {"application/x-www-form-urlencoded"},
+			},
+			body:     form,
+			provider: ProviderGitHub,
+			pusher:   "octocat",
+		},
+		{
+			name: "gitea",
+			header: http.Header{
+				"X-Gitea-Event":     {"push"},
+				"X-Github-Event":    {"push"},
+				"X-Gitea-Signature": {sign(push)},
+			},
+			body:     push,
+			provider: ProviderGitea,
+			pusher:   "octocat",
+		},
+		{
+			name:     "gitlab",
+			header:   http.Header{"X-Gitlab-Event": {"Push Hook"}, "X-Gitlab-Token": {testSecret}},
+			body:     `{"ref":"refs/heads/main","after":"` + testCommit + `","checkout_sha":"` + testCommit + `","user_username":"gl"}`,
+			provider: ProviderGitLab,
+			pusher:   "gl",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := parseWebhook(tc.header, []byte(tc.body), testSecret, "main")
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if event.Provider != tc.provider || event.Commit != testCommit || event.Pusher != tc.pusher || event.Ignored != "" {
+				t.Fatalf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestParseWebhookRejectsAndIgnores(t *testing.T) {
+	push := `{"ref":"refs/heads/main","after":"` + testCommit + `"}`
+
+	rejected := []http.Header{
+		{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {"sha256=" + sign(push+" ")}},
+		{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {sign(push)}},
+		{"X-Gitea-Event": {"push"}},
+		{"X-Gitlab-Event": {"Push Hook"}, "X-Gitlab-Token": {"wrong"}},
+	}
+	for i, header := range rejected {
+		if _, err := parseWebhook(header, []byte(push), testSecret, "main"); !errors.Is(err, ErrWebhookSignature) {
+			t.Fatalf("case %d: err = %v, want ErrWebhookSignature", i, err)
+		}
+	}
+	if _, err := parseWebhook(http.Header{}, []byte(push), testSecret, "main"); !errors.Is(err, ErrWebhookPayload) {
+		t.Fatalf("unknown host: err = %v", err)
+	}
+
+	ignored := map[string]string{
+		"ping":    "",
+		"other":   `{"ref":"refs/heads/develop","after":"` + testCommit + `"}`,
+		"tag":     `{"ref":"refs/tags/main","after":"` + testCommit + `"}`,
+		"deleted": `{"ref":"refs/heads/main","after":"0000000000000000000000000000000000000000","deleted":true}`,
+	}
+	for name, body := range ignored {
+		eventType := "push"
+		if name == "ping" {
+			eventType = "ping"
+		}
+		header := http.Header{"X-Github-Event": {eventType}, "X-Hub-Signature-256": {"sha256=" + sign(body)}}
+		event, err := parseWebhook(header, []byte(body), testSecret, "main")
+		if err != nil || event.Ignored == "" {
+			t.Fatalf("%s: event = %+v, err = %v", name, event, err)
+		}
+	}
+}
+
+func TestWebhookSecretLifecycle(t *testing.T) {
+	remote := newGitRepo(t)
+	remote.commit(map[string]string{"index.html": "v1"})
+	svc, _, site := newTestService(t, &fakeProvisioner{})
+	ctx := context.Background()
+
+	if _, _, err := svc.EnableWebhook(ctx, site.ID); !errors.Is(err, ErrNotLinked) {
+		t.Fatalf("enable before link: err = %v", err)
+	}
+	if _, err := svc.Link(ctx, site.ID, LinkInput{RepoURL: remote.remote, Path: t.TempDir()}); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	linked, secret, err := svc.EnableWebhook(ctx, site.ID)
+	if err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if !linked.Deploy.Webhook || len(secret) != 64 || strings.Contains(mustJSON(t, linked), secret) {
+		t.Fatalf("enable: webhook=%v secret=%q", linked.Deploy.Webhook, secret)
+	}
+	if info, err := os.Stat(svc.webhookSecretPath(site.ID)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("webhook secret not written privately: %v", err)
+	}
+
+	body := `{"ref":"refs/heads/main","after":"` + testCommit + `"}`
+	header := http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {"sha256=" + sign(body)}}
+	if _, _, err := svc.HandleWebhook(ctx, site.ID, header, []byte(body)); !errors.Is(err, ErrWebhookSignature) {
+		t.Fatalf("old secret accepted: err = %v", err)
+	}
+	if _, _, err := svc.HandleWebhook(ctx, "site_missing", header, []byte(body)); !errors.Is(err, ErrWebhookDisabled) {
+		t.Fatalf("unknown site: err = %v", err)
+	}
+
+	if _, err := svc.Unlink(ctx, site.ID); err != nil {
+		t.Fatalf("unlink: %v", err)
+	}
+	if _, err := os.Stat(svc.webhookSecretPath(site.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unlink kept webhook secret: %v", err)
+	}
+}
+
+func TestDeployPinnedCommit(t *testing.T) {
+	remote := newGitRepo(t)
+	first := remote.commit(map[string]string{"index.html": "v1"})
+	second := remote.commit(map[string]string{"index.html": "v2"})
+
+	svc, _, site := newTestService(t, &fakeProvisioner{})
+	ctx := context.Background()
+	if _, err := svc.Link(ctx, site.ID, LinkInput{RepoURL: remote.remote, Path: t.TempDir()}); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+
+	release, err := svc.Deploy(ctx, site.ID, first)
+	if err != nil {
+		t.Fatalf("deploy pinned commit: %v", err)
+	}
+	if release.Commit != first {
+		t.Fatalf("release commit = %s, want %s", release.Commit, first)
+	}
+	if release, err = svc.Deploy(ctx, site.ID, second); err != nil || release.Commit != second {
+		t.Fatalf("deploy newer commit: release=%+v err=%v", release, err)
+	}
+
+	// Replayed pushes of the live commit or an older one are skipped.
+	for _, commit := range []string{first, second} {
+		skipped, err := svc.Deploy(ctx, site.ID, commit)
+		if err != nil || skipped.ID != "" {
+			t.Fatalf("replayed push of %s: release=%+v err=%v", commit, skipped, err)
+		}
+	}
+	stored, err := svc.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if stored.Deploy.Current != release.ID {
+		t.Fatalf("current release = %s, want %s", stored.Deploy.Current, release.ID)
+	}
+	if _, err := svc.Deploy(ctx, site.ID, testCommit); !errors.Is(err, ErrInvalidCommit) {
+		t.Fatalf("commit off the branch: err = %v", err)
+	}
+}
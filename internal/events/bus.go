@@ -0,0 +1,129 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TopicJobs    = "jobs"
+	TopicAudit   = "audit"
+	TopicUpdater = "updater"
+
+	defaultHistorySize = 256
+	subscriberBuffer   = 64
+)
+
+type Event struct {
+	ID    uint64    `json:"id"`
+	Topic string    `json:"topic"`
+	Type  string    `json:"type"`
+	Data  any       `json:"data,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+type Bus struct {
+	mu          sync.Mutex
+	seq         uint64
+	history     []Event
+	historySize int
+	nextSubID   int
+	subscribers map[int]*subscription
+}
+
+type subscription struct {
+	topics map[string]struct{}
+	ch     chan Event
+}
+
+func NewBus(historySize int) *Bus {
+	if historySize <= 0 {
+		historySize = defaultHistorySize
+	}
+	return &Bus{
+		history:     make([]Event, 0, historySize),
+		historySize: historySize,
+		subscribers: make(map[int]*subscription),
+	}
+}
+
+// Publish is safe to call on a nil Bus so services can treat the bus as optional.
+func (b *Bus) Publish(topic, eventType string, data any) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	evt := Event{
+		ID:    b.seq,
+		Topic: topic,
+		Type:  eventType,
+		Data:  data,
+		Time:  time.Now().UTC(),
+	}
+	if len(b.history) == b.historySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:len(b.history)-1]
+	}
+	b.history = append(b.history, evt)
+
+	for _, sub := range b.subscribers {
+		if !sub.matches(topic) {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+			// Slow consumers lose events instead of blocking publishers;
+			// they can resync through Last-Event-ID.
+		}
+	}
+}
+
+// Subscribe returns a channel of events for the given topics (all topics when
+// empty). Events newer than afterID still held in history are replayed first.
+// The returned cancel func must be called to release the subscription.
+func (b *Bus) Subscribe(topics []string, afterID uint64) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscription{
+		topics: make(map[string]struct{}, len(topics)),
+		ch:     make(chan Event, subscriberBuffer+b.historySize),
+	}
+	for _, topic := range topics {
+		sub.topics[topic] = struct{}{}
+	}
+	if afterID > 0 {
+		for _, evt := range b.history {
+			if evt.ID > afterID && sub.matches(evt.Topic) {
+				sub.ch <- evt
+			}
+		}
+	}
+
+	id := b.nextSubID
+	b.nextSubID++
+	b.subscribers[id] = sub
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, id)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+	return sub.ch, cancel
+}
+
+func (s *subscription) matches(topic string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+	_, ok := s.topics[topic]
+	return ok
+}
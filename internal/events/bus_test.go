@@ -0,0 +1,61 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusDeliversMatchingTopics(t *testing.T) {
+	bus := NewBus(8)
+	stream, cancel := bus.Subscribe([]string{TopicJobs}, 0)
+	defer cancel()
+
+	bus.Publish(TopicAudit, "audit.recorded", nil)
+	bus.Publish(TopicJobs, "job.updated", map[string]string{"id": "job-1"})
+
+	select {
+	case evt := <-stream:
+		if evt.Topic != TopicJobs || evt.Type != "job.updated" {
+			t.Fatalf("unexpected event: %+v", evt)
+		}
+		if evt.ID != 2 {
+			t.Fatalf("expected event id 2, got %d", evt.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered")
+	}
+
+	select {
+	case evt := <-stream:
+		t.Fatalf("unexpected extra event: %+v", evt)
+	default:
+	}
+}
+
+func TestBusReplaysHistoryAfterID(t *testing.T) {
+	bus := NewBus(2)
+	bus.Publish(TopicJobs, "job.created", nil)
+	bus.Publish(TopicJobs, "job.updated", nil)
+	bus.Publish(TopicJobs, "job.log", nil)
+
+	stream, cancel := bus.Subscribe(nil, 1)
+	defer cancel()
+
+	var got []uint64
+	for len(got) < 2 {
+		select {
+		case evt := <-stream:
+			got = append(got, evt.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("replay incomplete: %v", got)
+		}
+	}
+	if got[0] != 2 || got[1] != 3 {
+		t.Fatalf("unexpected replay ids: %v", got)
+	}
+}
+
+func TestNilBusPublishIsNoop(t *testing.T) {
+	var bus *Bus
+	bus.Publish(TopicJobs, "job.created", nil)
+}
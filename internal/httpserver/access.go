@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/security/password"
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+type accessUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (a *API) handleSetSiteAccess(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req sitessvc.AccessRulesInput
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.SetAccessRules(r.Context(), user.ID, r.PathValue("siteID"), req)
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.access.update", "site", site.ID, map[string]any{
+		"allow":  len(req.Allow),
+		"deny":   len(req.Deny),
+		"paths":  len(req.Paths),
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleSetSiteAccessUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req accessUserRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.SetAccessUser(r.Context(), user.ID, r.PathValue("siteID"), req.Username, req.Password)
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.access.user.set", "site", site.ID, map[string]any{
+		"username": req.Username,
+		"job_id":   job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleDeleteSiteAccessUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	username := r.PathValue("username")
+	site, job, err := a.sites.RemoveAccessUser(r.Context(), user.ID, r.PathValue("siteID"), username)
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.access.user.remove", "site", site.ID, map[string]any{
+		"username": username,
+		"job_id":   job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func writeAccessError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	case errors.Is(err, sitessvc.ErrAccessUserNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, provision.ErrInvalidAccess), errors.Is(err, password.ErrWeakPassword):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, provision.ErrSiteUnmanaged), errors.Is(err, provision.ErrApplyDisabled):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"nusantara/internal/jobs"
+	sitessvc "nusantara/internal/service/sites"
+	sslsvc "nusantara/internal/ssl"
+	"nusantara/internal/store"
+)
+
+type addSiteAliasRequest struct {
+	Domain   string `json:"domain"`
+	SSLEmail string `json:"ssl_email"`
+}
+
+type setSiteRedirectRequest struct {
+	CanonicalRedirect string `json:"canonical_redirect"`
+	SSLEmail          string `json:"ssl_email"`
+}
+
+func (a *API) handleAddSiteAlias(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req addSiteAliasRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, workflow, err := a.sites.AddAlias(r.Context(), user.ID, r.PathValue("siteID"), req.Domain, req.SSLEmail)
+	if err != nil {
+		writeHostnameError(w, err)
+		return
+	}
+	a.auditHostnames(r, user, "site.alias.add", site, workflow, map[string]any{"alias": req.Domain})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site":     site,
+		"workflow": workflow,
+	})
+}
+
+func (a *API) handleRemoveSiteAlias(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	alias := r.PathValue("alias")
+	site, workflow, err := a.sites.RemoveAlias(r.Context(), user.ID, r.PathValue("siteID"), alias, r.URL.Query().Get("ssl_email"))
+	if err != nil {
+		writeHostnameError(w, err)
+		return
+	}
+	a.auditHostnames(r, user, "site.alias.remove", site, workflow, map[string]any{"alias": alias})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site":     site,
+		"workflow": workflow,
+	})
+}
+
+func (a *API) handleSetSiteRedirect(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req setSiteRedirectRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, workflow, err := a.sites.SetCanonicalRedirect(r.Context(), user.ID, r.PathValue("siteID"), req.CanonicalRedirect, req.SSLEmail)
+	if err != nil {
+		writeHostnameError(w, err)
+		return
+	}
+	a.auditHostnames(r, user, "site.redirect.update", site, workflow, map[string]any{"canonical_redirect": site.CanonicalRedirect})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site":     site,
+		"workflow": workflow,
+	})
+}
+
+func (a *API) auditHostnames(r *http.Request, user store.User, action string, site store.Site, workflow jobs.Workflow, details map[string]any) {
+	details["aliases"] = site.Aliases
+	details["workflow_id"] = workflow.Job.ID
+	a.audit.Record(r.Context(), user.ID, action, "site", site.ID, details)
+}
+
+func writeHostnameError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site or alias not found")
+	case errors.Is(err, sitessvc.ErrInvalidAlias), errors.Is(err, sitessvc.ErrInvalidRedirect),
+		errors.Is(err, sslsvc.ErrInvalidEmail), errors.Is(err, sslsvc.ErrInvalidDomain):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, store.ErrConflict):
+		writeError(w, http.StatusConflict, "domain already used by another site")
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
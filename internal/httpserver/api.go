@@ -20,8 +20,13 @@ import (
 	backupsvc "nusantara/internal/backup"
 	"nusantara/internal/buildinfo"
 	dbsvc "nusantara/internal/db"
+	deploysvc "nusantara/internal/deploy"
+	"nusantara/internal/events"
+	"nusantara/internal/idempotency"
 	"nusantara/internal/jobs"
 	"nusantara/internal/monitor"
+	"nusantara/internal/provision"
+	"nusantara/internal/scheduler"
 	"nusantara/internal/security/ratelimit"
 	authsvc "nusantara/internal/service/auth"
 	sitessvc "nusantara/internal/service/sites"
@@ -38,9 +43,13 @@ type API struct {
 	db              *dbsvc.Service
 	backup          *backupsvc.Service
 	ssl             *sslsvc.Service
+	deploy          *deploysvc.Service
 	servicesMonitor *monitor.ServicesMonitor
 	loginLimiter    *ratelimit.LoginLimiter
 	updater         *updater.Service
+	events          *events.Bus
+	scheduler       *scheduler.Service
+	idempotency     *idempotency.Service
 }
 
 type principalContextKey struct{}
@@ -54,8 +63,12 @@ func NewAPI(
 	db *dbsvc.Service,
 	backup *backupsvc.Service,
 	ssl *sslsvc.Service,
+	deploySvc *deploysvc.Service,
 	servicesMonitor *monitor.ServicesMonitor,
 	updaterSvc *updater.Service,
+	bus *events.Bus,
+	schedulerSvc *scheduler.Service,
+	idempotencySvc *idempotency.Service,
 ) *API {
 	return &API{
 		auth:            auth,
@@ -65,9 +78,13 @@ func NewAPI(
 		db:              db,
 		backup:          backup,
 		ssl:             ssl,
+		deploy:          deploySvc,
 		servicesMonitor: servicesMonitor,
 		loginLimiter:    ratelimit.NewLoginLimiter(5, 5*time.Minute),
 		updater:         updaterSvc,
+		events:          bus,
+		scheduler:       schedulerSvc,
+		idempotency:     idempotencySvc,
 	}
 }
 
@@ -79,20 +96,61 @@ func (a *API) RegisterRoutes(mux *http.ServeMux) {
 
 	mux.Handle("GET /v1/sites", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListSites)))
 	mux.Handle("POST /v1/sites", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleCreateSite)))
+	mux.Handle("GET /v1/sites/drift", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListDrift)))
+	mux.Handle("POST /v1/sites/drift/reconcile", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleReconcileDrift)))
+	mux.Handle("GET /v1/sites/import", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListImportCandidates)))
+	mux.Handle("POST /v1/sites/import", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleImportSites)))
 	mux.Handle("GET /v1/sites/{siteID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleGetSite)))
 	mux.Handle("GET /v1/sites/{siteID}/content", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleGetSiteContent)))
 	mux.Handle("PUT /v1/sites/{siteID}/content", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleUpdateSiteContent)))
 	mux.Handle("GET /v1/sites/{siteID}/files", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListSiteFiles)))
 	mux.Handle("GET /v1/sites/{siteID}/files/download", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDownloadSiteFile)))
 	mux.Handle("POST /v1/sites/{siteID}/files/upload", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleUploadSiteFile)))
+	mux.Handle("POST /v1/sites/{siteID}/files/archive", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleUploadSiteArchive)))
 	mux.Handle("DELETE /v1/sites/{siteID}/files", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeleteSiteFile)))
+	mux.Handle("POST /v1/sites/{siteID}/aliases", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleAddSiteAlias)))
+	mux.Handle("DELETE /v1/sites/{siteID}/aliases/{alias}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleRemoveSiteAlias)))
+	mux.Handle("PUT /v1/sites/{siteID}/tls", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleEnableSiteTLS)))
+	mux.Handle("DELETE /v1/sites/{siteID}/tls", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDisableSiteTLS)))
+	mux.Handle("PUT /v1/sites/{siteID}/directives", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSiteDirectives)))
+	mux.Handle("PUT /v1/sites/{siteID}/proxy", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSiteProxy)))
+	mux.Handle("PUT /v1/sites/{siteID}/policy", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSitePolicy)))
+	mux.Handle("GET /v1/sites/{siteID}/app", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSiteAppStatus)))
+	mux.Handle("PUT /v1/sites/{siteID}/app", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSiteApp)))
+	mux.Handle("DELETE /v1/sites/{siteID}/app", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeleteSiteApp)))
+	mux.Handle("POST /v1/sites/{siteID}/app/{action}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleControlSiteApp)))
+	mux.Handle("PUT /v1/sites/{siteID}/git", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleLinkSiteGit)))
+	mux.Handle("DELETE /v1/sites/{siteID}/git", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleUnlinkSiteGit)))
+	mux.Handle("POST /v1/sites/{siteID}/deploy", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeploySite)))
+	mux.Handle("POST /v1/sites/{siteID}/deploy/rollback", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleRollbackSite)))
+	mux.Handle("PUT /v1/sites/{siteID}/git/webhook", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleEnableSiteWebhook)))
+	mux.Handle("DELETE /v1/sites/{siteID}/git/webhook", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDisableSiteWebhook)))
+	mux.HandleFunc("POST /v1/webhooks/deploy/{siteID}", a.handleDeployWebhook)
+	mux.Handle("GET /v1/sites/{siteID}/logs", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSiteLogs)))
+	mux.Handle("PUT /v1/sites/{siteID}/access", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSiteAccess)))
+	mux.Handle("POST /v1/sites/{siteID}/access/users", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSiteAccessUser)))
+	mux.Handle("DELETE /v1/sites/{siteID}/access/users/{username}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeleteSiteAccessUser)))
+	mux.Handle("PUT /v1/sites/{siteID}/redirect", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSiteRedirect)))
+	mux.Handle("POST /v1/sites/{siteID}/suspend", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSuspendSite)))
+	mux.Handle("POST /v1/sites/{siteID}/resume", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleResumeSite)))
+	mux.Handle("PUT /v1/sites/{siteID}/maintenance", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleEnableSiteMaintenance)))
+	mux.Handle("DELETE /v1/sites/{siteID}/maintenance", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleResumeSite)))
+	mux.Handle("GET /v1/sites/{siteID}/drift", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleGetSiteDrift)))
+	mux.Handle("POST /v1/sites/{siteID}/drift/reconcile", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleReconcileDrift)))
+	mux.Handle("GET /v1/sites/{siteID}/nginx/preview", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handlePreviewSiteNginx)))
+	mux.Handle("POST /v1/sites/{siteID}/adopt", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleAdoptSite)))
 	mux.Handle("POST /v1/sites/{siteID}/dirs", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleCreateSiteDir)))
 	mux.Handle("DELETE /v1/sites/{siteID}/dirs", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeleteSiteDir)))
 	mux.Handle("POST /v1/sites/{siteID}/backup", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleBackupSiteContent)))
+	mux.Handle("PUT /v1/sites/{siteID}/php", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleSetSitePHPVersion)))
+	mux.Handle("GET /v1/php/versions", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListPHPVersions)))
+	mux.Handle("PATCH /v1/sites/{siteID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleUpdateSite)))
 	mux.Handle("DELETE /v1/sites/{siteID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeleteSite)))
 
 	mux.Handle("GET /v1/jobs", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListJobs)))
 	mux.Handle("GET /v1/jobs/{jobID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleGetJob)))
+	mux.Handle("POST /v1/workflows/site", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleCreateSiteWorkflow)))
+	mux.Handle("GET /v1/workflows/{workflowID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleGetWorkflow)))
 	mux.Handle("GET /v1/db/databases", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListDatabases)))
 	mux.Handle("POST /v1/db/databases", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleCreateDatabase)))
 	mux.Handle("POST /v1/db/users", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleCreateDatabaseUser)))
@@ -101,7 +159,15 @@ func (a *API) RegisterRoutes(mux *http.ServeMux) {
 	mux.Handle("POST /v1/ssl/issue", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleIssueSSL)))
 	mux.Handle("POST /v1/ssl/renew", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleRenewSSL)))
 
+	mux.Handle("GET /v1/schedules", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListSchedules)))
+	mux.Handle("POST /v1/schedules", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleCreateSchedule)))
+	mux.Handle("GET /v1/schedules/{scheduleID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleGetSchedule)))
+	mux.Handle("POST /v1/schedules/{scheduleID}/pause", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handlePauseSchedule)))
+	mux.Handle("POST /v1/schedules/{scheduleID}/resume", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleResumeSchedule)))
+	mux.Handle("DELETE /v1/schedules/{scheduleID}", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleDeleteSchedule)))
+
 	mux.Handle("GET /v1/audit/logs", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleListAuditLogs)))
+	mux.Handle("GET /v1/events", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleEvents)))
 
 	mux.Handle("GET /v1/monitor/host", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleMonitorHost)))
 	mux.Handle("GET /v1/monitor/services", a.requireRole(store.RoleAdmin, http.HandlerFunc(a.handleMonitorServices)))
@@ -210,9 +276,18 @@ func (a *API) handleMe(w http.ResponseWriter, r *http.Request) {
 }
 
 type createSiteRequest struct {
-	Domain   string `json:"domain"`
-	RootPath string `json:"root_path"`
-	Runtime  string `json:"runtime"`
+	Domain       string `json:"domain"`
+	RootPath     string `json:"root_path"`
+	Runtime      string `json:"runtime"`
+	UpstreamHost string `json:"upstream_host"`
+	UpstreamPort int    `json:"upstream_port"`
+	PHPVersion   string `json:"php_version"`
+
+	Aliases           []string          `json:"aliases"`
+	CanonicalRedirect string            `json:"canonical_redirect"`
+	Proxy             *store.SiteProxy  `json:"proxy"`
+	Policy            *store.SitePolicy `json:"policy"`
+	App               *store.SiteApp    `json:"app"`
 }
 
 func (a *API) handleCreateSite(w http.ResponseWriter, r *http.Request) {
@@ -229,14 +304,28 @@ func (a *API) handleCreateSite(w http.ResponseWriter, r *http.Request) {
 	}
 
 	site, job, err := a.sites.CreateSite(r.Context(), user.ID, sitessvc.CreateSiteInput{
-		Domain:   req.Domain,
-		RootPath: req.RootPath,
-		Runtime:  req.Runtime,
+		Domain:       req.Domain,
+		RootPath:     req.RootPath,
+		Runtime:      req.Runtime,
+		UpstreamHost: req.UpstreamHost,
+		UpstreamPort: req.UpstreamPort,
+		PHPVersion:   req.PHPVersion,
+
+		Aliases:           req.Aliases,
+		CanonicalRedirect: req.CanonicalRedirect,
+		Proxy:             req.Proxy,
+		Policy:            req.Policy,
+		App:               req.App,
 	})
 	if err != nil {
 		switch {
-		case errors.Is(err, sitessvc.ErrInvalidDomain), errors.Is(err, sitessvc.ErrInvalidRoot), errors.Is(err, sitessvc.ErrInvalidRuntime):
+		case errors.Is(err, sitessvc.ErrInvalidDomain), errors.Is(err, sitessvc.ErrInvalidRoot), errors.Is(err, sitessvc.ErrInvalidRuntime),
+			errors.Is(err, sitessvc.ErrInvalidUpstream), errors.Is(err, sitessvc.ErrInvalidPHP),
+			errors.Is(err, sitessvc.ErrInvalidAlias), errors.Is(err, sitessvc.ErrInvalidRedirect),
+			errors.Is(err, provision.ErrInvalidProxy), errors.Is(err, provision.ErrInvalidPolicy), errors.Is(err, provision.ErrInvalidApp):
 			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, store.ErrUpstreamInUse), errors.Is(err, sitessvc.ErrNoUpstreamPort):
+			writeError(w, http.StatusConflict, err.Error())
 		case errors.Is(err, store.ErrConflict):
 			writeError(w, http.StatusConflict, "domain already exists")
 		default:
@@ -691,7 +780,17 @@ func (a *API) handleCreateDatabase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.db.CreateDatabase(r.Context(), req.Name); err != nil {
+	var (
+		job store.Job
+		err error
+	)
+	async := wantsAsync(r)
+	if async {
+		job, err = a.jobs.Enqueue(r.Context(), user.ID, store.JobTypeCreateDatabase, dbsvc.CreateDatabasePayload{Name: req.Name})
+	} else {
+		err = a.db.CreateDatabase(r.Context(), req.Name)
+	}
+	if err != nil {
 		if errors.Is(err, dbsvc.ErrInvalidDatabaseName) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
@@ -699,6 +798,13 @@ func (a *API) handleCreateDatabase(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if async {
+		a.audit.Record(r.Context(), user.ID, "db.create_database.requested", "database", req.Name, map[string]any{
+			"job_id": job.ID,
+		})
+		writeQueuedJob(w, job)
+		return
+	}
 	a.audit.Record(r.Context(), user.ID, "db.create_database", "database", req.Name, nil)
 	writeJSON(w, http.StatusCreated, map[string]string{
 		"status":   "ok",
@@ -730,12 +836,23 @@ func (a *API) handleCreateDatabaseUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.db.CreateUser(r.Context(), dbsvc.CreateUserInput{
+	input := dbsvc.CreateUserInput{
 		Database: req.Database,
 		Username: req.Username,
 		Password: req.Password,
 		Host:     req.Host,
-	}); err != nil {
+	}
+	var (
+		job store.Job
+		err error
+	)
+	async := wantsAsync(r)
+	if async {
+		job, err = a.jobs.Enqueue(r.Context(), user.ID, store.JobTypeCreateDBUser, input)
+	} else {
+		err = a.db.CreateUser(r.Context(), input)
+	}
+	if err != nil {
 		switch {
 		case errors.Is(err, dbsvc.ErrInvalidDatabaseName),
 			errors.Is(err, dbsvc.ErrInvalidUsername),
@@ -752,6 +869,15 @@ func (a *API) handleCreateDatabaseUser(w http.ResponseWriter, r *http.Request) {
 	if strings.TrimSpace(host) == "" {
 		host = "localhost"
 	}
+	if async {
+		a.audit.Record(r.Context(), user.ID, "db.create_user.requested", "database", req.Database, map[string]any{
+			"username": req.Username,
+			"host":     host,
+			"job_id":   job.ID,
+		})
+		writeQueuedJob(w, job)
+		return
+	}
 	a.audit.Record(r.Context(), user.ID, "db.create_user", "database", req.Database, map[string]any{
 		"username": req.Username,
 		"host":     host,
@@ -775,6 +901,17 @@ func (a *API) handleRunBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wantsAsync(r) {
+		job, err := a.jobs.Enqueue(r.Context(), user.ID, store.JobTypeRunBackup, nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		a.audit.Record(r.Context(), user.ID, "backup.run.requested", "job", job.ID, nil)
+		writeQueuedJob(w, job)
+		return
+	}
+
 	result, err := a.backup.Run(r.Context())
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
@@ -833,6 +970,7 @@ func (a *API) handleListAuditLogs(w http.ResponseWriter, r *http.Request) {
 type issueSSLRequest struct {
 	Domain string `json:"domain"`
 	Email  string `json:"email"`
+	HSTS   bool   `json:"hsts"`
 }
 
 func (a *API) handleIssueSSL(w http.ResponseWriter, r *http.Request) {
@@ -851,9 +989,27 @@ func (a *API) handleIssueSSL(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if err := a.ssl.Issue(r.Context(), req.Domain, req.Email); err != nil {
+	// Certificates for panel sites also need the HTTPS server block, which
+	// the site TLS workflow renders.
+	site, found, err := a.sites.SiteByDomain(r.Context(), req.Domain)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if found {
+		a.enableSiteTLS(w, r, user, site.ID, req.Email, req.HSTS)
+		return
+	}
+	var job store.Job
+	async := wantsAsync(r)
+	if async {
+		job, err = a.jobs.Enqueue(r.Context(), user.ID, store.JobTypeIssueSSL, sslsvc.IssuePayload{Domain: req.Domain, Email: req.Email})
+	} else {
+		err = a.ssl.Issue(r.Context(), "", req.Domain, req.Email)
+	}
+	if err != nil {
 		switch {
-		case errors.Is(err, sslsvc.ErrInvalidDomain), errors.Is(err, sslsvc.ErrInvalidEmail):
+		case errors.Is(err, sslsvc.ErrInvalidDomain), errors.Is(err, sslsvc.ErrInvalidEmail), errors.Is(err, sslsvc.ErrNoWebroot):
 			writeError(w, http.StatusBadRequest, err.Error())
 		default:
 			writeError(w, http.StatusInternalServerError, err.Error())
@@ -861,6 +1017,14 @@ func (a *API) handleIssueSSL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if async {
+		a.audit.Record(r.Context(), user.ID, "ssl.issue.requested", "domain", req.Domain, map[string]any{
+			"email":  req.Email,
+			"job_id": job.ID,
+		})
+		writeQueuedJob(w, job)
+		return
+	}
 	a.audit.Record(r.Context(), user.ID, "ssl.issue", "domain", req.Domain, map[string]any{
 		"email": req.Email,
 	})
@@ -880,6 +1044,16 @@ func (a *API) handleRenewSSL(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "ssl service is not configured")
 		return
 	}
+	if wantsAsync(r) {
+		job, err := a.jobs.Enqueue(r.Context(), user.ID, store.JobTypeRenewSSL, nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		a.audit.Record(r.Context(), user.ID, "ssl.renew.requested", "job", job.ID, nil)
+		writeQueuedJob(w, job)
+		return
+	}
 	if err := a.ssl.Renew(r.Context()); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -1013,6 +1187,7 @@ func mergeErrorPayload(message string, extra map[string]any) map[string]any {
 }
 
 func (a *API) requireAuth(next http.Handler) http.Handler {
+	next = a.withIdempotency(next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, err := bearerToken(r.Header.Get("Authorization"))
 		if err != nil {
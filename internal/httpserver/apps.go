@@ -0,0 +1,107 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+func (a *API) handleSetSiteApp(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req store.SiteApp
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.SetApp(r.Context(), user.ID, r.PathValue("siteID"), &req)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.app.update", "site", site.ID, map[string]any{
+		"command": req.Command,
+		"user":    req.User,
+		"job_id":  job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleDeleteSiteApp(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, job, err := a.sites.SetApp(r.Context(), user.ID, r.PathValue("siteID"), nil)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.app.delete", "site", site.ID, map[string]any{
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleControlSiteApp(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	action := r.PathValue("action")
+	site, err := a.sites.ControlApp(r.Context(), r.PathValue("siteID"), action)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.app."+action, "site", site.ID, nil)
+	status, err := a.sites.AppStatus(r.Context(), site.ID)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *API) handleSiteAppStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := a.sites.AppStatus(r.Context(), r.PathValue("siteID"))
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func writeAppError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	case errors.Is(err, provision.ErrInvalidApp):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, provision.ErrAppNotConfigured), errors.Is(err, provision.ErrSiteUnmanaged),
+		errors.Is(err, provision.ErrApplyDisabled):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
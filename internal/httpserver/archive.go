@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+// archiveUploadTimeout replaces the server's read and write timeouts for
+// archive uploads, which are streamed as the raw request body.
+const archiveUploadTimeout = 30 * time.Minute
+
+func (a *API) handleUploadSiteArchive(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	siteID := r.PathValue("siteID")
+	wipe := false
+	if raw := strings.TrimSpace(r.URL.Query().Get("wipe")); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid wipe flag")
+			return
+		}
+		wipe = parsed
+	}
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(archiveUploadTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer r.Body.Close()
+
+	site, upload, job, err := a.sites.UploadSiteArchive(r.Context(), user.ID, siteID, r.URL.Query().Get("dir"), wipe, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "site not found")
+		case errors.Is(err, sitessvc.ErrInvalidPath), errors.Is(err, sitessvc.ErrInvalidArchive):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sitessvc.ErrArchiveTooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.files.archive", "site", site.ID, map[string]any{
+		"dir":    upload.Dir,
+		"format": upload.Format,
+		"size":   upload.Size,
+		"wipe":   upload.Wipe,
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site":    site,
+		"archive": upload,
+		"job":     job,
+	})
+}
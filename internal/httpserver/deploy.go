@@ -0,0 +1,260 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	deploysvc "nusantara/internal/deploy"
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+type linkSiteGitRequest struct {
+	RepoURL           string `json:"repo_url"`
+	Branch            string `json:"branch"`
+	Path              string `json:"path"`
+	WebDir            string `json:"web_dir"`
+	KeepReleases      int    `json:"keep_releases"`
+	BuildCommand      string `json:"build_command"`
+	PostDeployCommand string `json:"post_deploy_command"`
+}
+
+func (a *API) handleLinkSiteGit(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req linkSiteGitRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, err := a.deploy.Link(r.Context(), r.PathValue("siteID"), deploysvc.LinkInput{
+		RepoURL:           req.RepoURL,
+		Branch:            req.Branch,
+		Path:              req.Path,
+		WebDir:            req.WebDir,
+		KeepReleases:      req.KeepReleases,
+		BuildCommand:      req.BuildCommand,
+		PostDeployCommand: req.PostDeployCommand,
+	})
+	if err != nil {
+		writeDeployError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.git.link", "site", site.ID, map[string]any{
+		"repo_url": site.Deploy.RepoURL,
+		"branch":   site.Deploy.Branch,
+	})
+	writeJSON(w, http.StatusOK, site)
+}
+
+func (a *API) handleUnlinkSiteGit(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, err := a.deploy.Unlink(r.Context(), r.PathValue("siteID"))
+	if err != nil {
+		writeDeployError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.git.unlink", "site", site.ID, nil)
+	writeJSON(w, http.StatusOK, site)
+}
+
+func (a *API) handleDeploySite(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, job, err := a.deploy.QueueDeploy(r.Context(), user.ID, r.PathValue("siteID"))
+	if err != nil {
+		writeDeployError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.deploy", "site", site.ID, map[string]any{
+		"branch": site.Deploy.Branch,
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+type rollbackSiteRequest struct {
+	Release string `json:"release"`
+}
+
+func (a *API) handleRollbackSite(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req rollbackSiteRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	site, job, err := a.deploy.QueueRollback(r.Context(), user.ID, r.PathValue("siteID"), req.Release)
+	if err != nil {
+		writeDeployError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.deploy.rollback", "site", site.ID, map[string]any{
+		"release": req.Release,
+		"job_id":  job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleEnableSiteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, secret, err := a.deploy.EnableWebhook(r.Context(), r.PathValue("siteID"))
+	if err != nil {
+		writeDeployError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.git.webhook.enable", "site", site.ID, nil)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"site": site,
+		"webhook": map[string]string{
+			"url":    requestBaseURL(r) + "/v1/webhooks/deploy/" + site.ID,
+			"secret": secret,
+		},
+	})
+}
+
+func (a *API) handleDisableSiteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, err := a.deploy.DisableWebhook(r.Context(), r.PathValue("siteID"))
+	if err != nil {
+		writeDeployError(w, err)
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.git.webhook.disable", "site", site.ID, nil)
+	writeJSON(w, http.StatusOK, site)
+}
+
+// handleDeployWebhook is called by the git host, so it authenticates the
+// delivery by its signature instead of a bearer token.
+func (a *API) handleDeployWebhook(w http.ResponseWriter, r *http.Request) {
+	siteID := r.PathValue("siteID")
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, deploysvc.MaxWebhookBody+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if len(body) > deploysvc.MaxWebhookBody {
+		writeError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+
+	event, job, err := a.deploy.HandleWebhook(r.Context(), siteID, r.Header, body)
+	switch {
+	case err == nil:
+	case errors.Is(err, deploysvc.ErrWebhookDisabled):
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	case errors.Is(err, deploysvc.ErrWebhookSignature):
+		a.audit.Record(r.Context(), "", "site.deploy.webhook.rejected", "site", siteID, map[string]any{
+			"provider":    event.Provider,
+			"remote_addr": r.RemoteAddr,
+		})
+		writeError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	case errors.Is(err, deploysvc.ErrWebhookPayload):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, provision.ErrSiteUnmanaged):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if event.Ignored != "" {
+		writeJSON(w, http.StatusOK, map[string]any{"event": event})
+		return
+	}
+	a.audit.Record(r.Context(), "", "site.deploy.webhook", "site", siteID, map[string]any{
+		"provider": event.Provider,
+		"ref":      event.Ref,
+		"commit":   event.Commit,
+		"pusher":   event.Pusher,
+		"job_id":   job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"event": event,
+		"job":   job,
+	})
+}
+
+// requestBaseURL is the panel address as the client sees it, honouring a
+// reverse proxy's forwarded headers.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := strings.TrimSpace(r.Header.Get("X-Forwarded-Host")); forwarded != "" {
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return scheme + "://" + host
+}
+
+func writeDeployError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	case errors.Is(err, deploysvc.ErrInvalidRepo), errors.Is(err, deploysvc.ErrInvalidBranch),
+		errors.Is(err, deploysvc.ErrInvalidDeploy), errors.Is(err, deploysvc.ErrInvalidCommit):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, deploysvc.ErrNoRelease):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, deploysvc.ErrNotLinked), errors.Is(err, provision.ErrSiteUnmanaged):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
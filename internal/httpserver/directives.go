@@ -0,0 +1,53 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"nusantara/internal/provision"
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+type setSiteDirectivesRequest struct {
+	CustomDirectives string `json:"custom_directives"`
+}
+
+func (a *API) handleSetSiteDirectives(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req setSiteDirectivesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.SetCustomDirectives(r.Context(), user.ID, r.PathValue("siteID"), req.CustomDirectives)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "site not found")
+		case errors.Is(err, provision.ErrInvalidDirectives):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sitessvc.ErrSiteState), errors.Is(err, provision.ErrSiteUnmanaged):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.directives.update", "site", site.ID, map[string]any{
+		"bytes":  len(strings.TrimSpace(req.CustomDirectives)),
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+func (a *API) handleListDrift(w http.ResponseWriter, r *http.Request) {
+	reports, err := a.sites.Drift(r.Context())
+	if err != nil {
+		writeDriftError(w, err)
+		return
+	}
+	drifted := 0
+	for _, report := range reports {
+		if report.Drifted {
+			drifted++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":   reports,
+		"drifted": drifted,
+	})
+}
+
+func (a *API) handleGetSiteDrift(w http.ResponseWriter, r *http.Request) {
+	report, err := a.sites.SiteDrift(r.Context(), r.PathValue("siteID"))
+	if err != nil {
+		writeDriftError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleReconcileDrift serves both the global and the per-site reconcile
+// endpoint; the site path value is empty for the global one.
+func (a *API) handleReconcileDrift(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	siteID := r.PathValue("siteID")
+	reports, job, err := a.sites.Reconcile(r.Context(), user.ID, siteID)
+	if err != nil {
+		writeDriftError(w, err)
+		return
+	}
+	if job.ID == "" {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"items": reports,
+			"job":   nil,
+		})
+		return
+	}
+
+	var siteIDs []string
+	for _, report := range reports {
+		if report.Drifted {
+			siteIDs = append(siteIDs, report.SiteID)
+		}
+	}
+	targetID := siteID
+	if targetID == "" {
+		targetID = "*"
+	}
+	a.audit.Record(r.Context(), user.ID, "site.drift.reconcile", "site", targetID, map[string]any{
+		"site_ids": siteIDs,
+		"job_id":   job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"items": reports,
+		"job":   job,
+	})
+}
+
+func writeDriftError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	case errors.Is(err, provision.ErrSiteUnmanaged):
+		writeError(w, http.StatusConflict, "site was imported and is not managed by the panel yet; adopt it first")
+	case errors.Is(err, provision.ErrApplyDisabled):
+		writeError(w, http.StatusConflict, "nginx files are not managed: NUSANTARA_PROVISION_APPLY is false")
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
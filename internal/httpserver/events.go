@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nusantara/internal/events"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+var knownEventTopics = map[string]struct{}{
+	events.TopicJobs:    {},
+	events.TopicAudit:   {},
+	events.TopicUpdater: {},
+}
+
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if a.events == nil {
+		writeError(w, http.StatusInternalServerError, "event bus is not configured")
+		return
+	}
+
+	topics, err := parseEventTopics(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	afterID := parseLastEventID(r)
+
+	rc := http.NewResponseController(w)
+	// The server-wide write timeout would cut long-lived streams.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	stream, cancel := a.events.Subscribe(topics, afterID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	_ = rc.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case evt, ok := <-stream:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, evt); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, evt events.Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, body)
+	return err
+}
+
+func parseEventTopics(r *http.Request) ([]string, error) {
+	var topics []string
+	for _, raw := range r.URL.Query()["topic"] {
+		for _, topic := range strings.Split(raw, ",") {
+			topic = strings.ToLower(strings.TrimSpace(topic))
+			if topic == "" {
+				continue
+			}
+			if _, ok := knownEventTopics[topic]; !ok {
+				return nil, fmt.Errorf("unknown topic: %s", topic)
+			}
+			topics = append(topics, topic)
+		}
+	}
+	return topics, nil
+}
+
+func parseLastEventID(r *http.Request) uint64 {
+	raw := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.URL.Query().Get("last_event_id"))
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,147 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"nusantara/internal/idempotency"
+	"nusantara/internal/store"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// Bodies are buffered to hash them, and responses are stored in the state
+	// file, so both are bounded.
+	maxIdempotentRequestBytes  = 16 << 20
+	maxIdempotentResponseBytes = 1 << 20
+)
+
+// streamedBodyRoutes take raw uploads far larger than a buffered body may
+// be. Their body is left unread, so a key only matches method and URL.
+var streamedBodyRoutes = map[string]struct{}{
+	"POST /v1/sites/{siteID}/files/archive": {},
+}
+
+// secretBodyRoutes answer with secrets shown only once. Their response
+// body is not stored, so a replay repeats the status without it.
+var secretBodyRoutes = map[string]struct{}{
+	"PUT /v1/sites/{siteID}/git/webhook": {},
+	"POST /v1/workflows/site":            {},
+}
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.body.Len() <= maxIdempotentResponseBytes {
+		r.body.Write(p)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to extend
+// the deadlines of an archive upload.
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// withIdempotency replays the stored response when an authenticated client
+// repeats a mutating request with the same Idempotency-Key. Server errors are
+// not stored so the request can be retried.
+func (a *API) withIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || a.idempotency == nil || !isMutatingMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, ok := r.Context().Value(principalContextKey{}).(store.User)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		var body []byte
+		if _, streamed := streamedBodyRoutes[r.Pattern]; !streamed {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxIdempotentRequestBytes+1))
+			_ = r.Body.Close()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+			if len(body) > maxIdempotentRequestBytes {
+				writeError(w, http.StatusRequestEntityTooLarge, "request body too large for Idempotency-Key")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		record, replay, err := a.idempotency.Begin(r.Context(), user.ID, key, r.Method, r.URL.RequestURI(), body)
+		if err != nil {
+			switch {
+			case errors.Is(err, idempotency.ErrInvalidKey):
+				writeError(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, idempotency.ErrKeyReused):
+				writeError(w, http.StatusUnprocessableEntity, err.Error())
+			case errors.Is(err, idempotency.ErrKeyInProgress):
+				writeError(w, http.StatusConflict, err.Error())
+			default:
+				writeError(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		if replay {
+			if record.ContentType != "" {
+				w.Header().Set("Content-Type", record.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.StatusCode)
+			_, _ = io.WriteString(w, record.Body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// The client may already be gone (the reason it retries), so the
+		// result is stored regardless of the request context.
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= http.StatusInternalServerError || rec.body.Len() > maxIdempotentResponseBytes {
+			_ = a.idempotency.Release(ctx, record.ID)
+			return
+		}
+		contentType, stored := w.Header().Get("Content-Type"), rec.body.String()
+		if _, secret := secretBodyRoutes[r.Pattern]; secret {
+			contentType, stored = "", ""
+		}
+		_ = a.idempotency.Complete(ctx, record.ID, rec.status, contentType, stored)
+	})
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+type importSitesRequest struct {
+	Items []importSiteItem `json:"items"`
+}
+
+type importSiteItem struct {
+	File         string `json:"file"`
+	Domain       string `json:"domain"`
+	Runtime      string `json:"runtime"`
+	RootPath     string `json:"root_path"`
+	UpstreamHost string `json:"upstream_host"`
+	UpstreamPort int    `json:"upstream_port"`
+	PHPVersion   string `json:"php_version"`
+}
+
+func (a *API) handleListImportCandidates(w http.ResponseWriter, r *http.Request) {
+	candidates, err := a.sites.ImportCandidates(r.Context())
+	if err != nil {
+		writeDriftError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": candidates,
+	})
+}
+
+func (a *API) handleImportSites(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req importSitesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	inputs := make([]sitessvc.ImportInput, 0, len(req.Items))
+	for _, item := range req.Items {
+		inputs = append(inputs, sitessvc.ImportInput{
+			File:         item.File,
+			Domain:       item.Domain,
+			Runtime:      item.Runtime,
+			RootPath:     item.RootPath,
+			UpstreamHost: item.UpstreamHost,
+			UpstreamPort: item.UpstreamPort,
+			PHPVersion:   item.PHPVersion,
+		})
+	}
+
+	results, err := a.sites.ImportSites(r.Context(), user.ID, inputs)
+	if err != nil {
+		if errors.Is(err, sitessvc.ErrInvalidImport) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeDriftError(w, err)
+		return
+	}
+	for _, result := range results {
+		if result.Site == nil {
+			continue
+		}
+		a.audit.Record(r.Context(), user.ID, "site.import", "site", result.Site.ID, map[string]any{
+			"domain":  result.Site.Domain,
+			"file":    result.File,
+			"runtime": result.Site.Runtime,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": results,
+	})
+}
+
+func (a *API) handleAdoptSite(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, job, err := a.sites.Adopt(r.Context(), user.ID, r.PathValue("siteID"))
+	if err != nil {
+		writeSiteStateError(w, err)
+		return
+	}
+	a.audit.Record(r.Context(), user.ID, "site.adopt", "site", site.ID, map[string]any{
+		"domain":        site.Domain,
+		"imported_from": site.ImportedFrom,
+		"job_id":        job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
@@ -0,0 +1,23 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nusantara/internal/store"
+)
+
+// wantsAsync reports whether the caller opted into running the operation as a
+// background job via ?async=true.
+func wantsAsync(r *http.Request) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(r.URL.Query().Get("async")))
+	return err == nil && v
+}
+
+func writeQueuedJob(w http.ResponseWriter, job store.Job) {
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"status": "queued",
+		"job":    job,
+	})
+}
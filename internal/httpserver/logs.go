@@ -0,0 +1,118 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+func (a *API) handleSiteLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	logType := strings.TrimSpace(query.Get("type"))
+	if logType == "" {
+		logType = provision.LogTypeAccess
+	}
+	if logType != provision.LogTypeAccess && logType != provision.LogTypeError {
+		writeError(w, http.StatusBadRequest, provision.ErrInvalidLogType.Error())
+		return
+	}
+	lines := provision.DefaultLogTailLines
+	if raw := strings.TrimSpace(query.Get("lines")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > provision.MaxLogTailLines {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("lines must be between 1 and %d", provision.MaxLogTailLines))
+			return
+		}
+		lines = n
+	}
+	follow := false
+	if raw := query.Get("follow"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid follow")
+			return
+		}
+		follow = v
+	}
+
+	siteID := r.PathValue("siteID")
+	tail, err := a.sites.SiteLog(r.Context(), siteID, logType, lines)
+	if err != nil {
+		writeSiteLogError(w, err)
+		return
+	}
+	if !follow {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"site_id": siteID,
+			"type":    logType,
+			"path":    tail.Path,
+			"lines":   tail.Lines,
+			"offset":  tail.Offset,
+		})
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The server-wide write timeout would cut long-lived streams.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	lastWrite := time.Now()
+	emit := func(batch []string) error {
+		if len(batch) == 0 {
+			if time.Since(lastWrite) < sseHeartbeatInterval {
+				return nil
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+		} else if err := writeLogEvent(w, batch); err != nil {
+			return err
+		}
+		lastWrite = time.Now()
+		return rc.Flush()
+	}
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	_ = rc.Flush()
+	if err := emit(tail.Lines); err != nil {
+		return
+	}
+	// Errors end the stream; the client reconnects with a fresh tail.
+	_ = a.sites.FollowSiteLog(r.Context(), siteID, logType, tail.Offset, emit)
+}
+
+func writeLogEvent(w http.ResponseWriter, lines []string) error {
+	body, err := json.Marshal(map[string]any{"lines": lines})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: log\ndata: %s\n\n", body)
+	return err
+}
+
+func writeSiteLogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	case errors.Is(err, provision.ErrInvalidLogType):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, provision.ErrSiteUnmanaged), errors.Is(err, provision.ErrApplyDisabled):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
@@ -0,0 +1,65 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"nusantara/internal/php"
+	"nusantara/internal/provision"
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+type setSitePHPVersionRequest struct {
+	PHPVersion string `json:"php_version"`
+}
+
+func (a *API) handleListPHPVersions(w http.ResponseWriter, _ *http.Request) {
+	items, err := a.sites.PHPVersions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *API) handleSetSitePHPVersion(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req setSitePHPVersionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	siteID := r.PathValue("siteID")
+	site, job, err := a.sites.SetPHPVersion(r.Context(), user.ID, siteID, req.PHPVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "site not found")
+		case errors.Is(err, sitessvc.ErrInvalidPHP), errors.Is(err, php.ErrInvalidVersion),
+			errors.Is(err, php.ErrVersionNotFound), errors.Is(err, php.ErrNoVersionsFound):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sitessvc.ErrSiteState), errors.Is(err, provision.ErrSiteUnmanaged):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.php_version.update", "site", site.ID, map[string]any{
+		"php_version": strings.TrimSpace(req.PHPVersion),
+		"job_id":      job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
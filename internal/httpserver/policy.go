@@ -0,0 +1,49 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+func (a *API) handleSetSitePolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req store.SitePolicy
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.SetPolicy(r.Context(), user.ID, r.PathValue("siteID"), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "site not found")
+		case errors.Is(err, provision.ErrInvalidPolicy):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, provision.ErrSiteUnmanaged):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.policy.update", "site", site.ID, map[string]any{
+		"rate_limit":    req.RateLimit,
+		"conn_limit":    req.ConnLimit,
+		"fastcgi_cache": req.FastCGICache,
+		"job_id":        job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
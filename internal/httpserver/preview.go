@@ -0,0 +1,46 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+func (a *API) handlePreviewSiteNginx(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	validate := false
+	if raw := r.URL.Query().Get("validate"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid validate")
+			return
+		}
+		validate = v
+	}
+
+	preview, err := a.sites.PreviewConfig(r.Context(), r.PathValue("siteID"), validate)
+	if err != nil {
+		switch {
+		case errors.Is(err, provision.ErrInvalidDirectives):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeDriftError(w, err)
+		}
+		return
+	}
+	if preview.Validation != nil {
+		a.audit.Record(r.Context(), user.ID, "site.nginx.validate", "site", preview.SiteID, map[string]any{
+			"valid":   preview.Validation.Valid,
+			"changed": preview.Changed,
+		})
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
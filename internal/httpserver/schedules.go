@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nusantara/internal/scheduler"
+	"nusantara/internal/store"
+)
+
+type createScheduleRequest struct {
+	Name         string          `json:"name"`
+	Cron         string          `json:"cron"`
+	JobType      string          `json:"job_type"`
+	Payload      json.RawMessage `json:"payload"`
+	MissedPolicy string          `json:"missed_policy"`
+}
+
+func (a *API) handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	if a.scheduler == nil {
+		writeError(w, http.StatusInternalServerError, "scheduler is not configured")
+		return
+	}
+	items, err := a.scheduler.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *API) handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if a.scheduler == nil {
+		writeError(w, http.StatusInternalServerError, "scheduler is not configured")
+		return
+	}
+
+	var req createScheduleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	schedule, err := a.scheduler.Create(r.Context(), user.ID, scheduler.CreateScheduleInput{
+		Name:         req.Name,
+		CronExpr:     req.Cron,
+		JobType:      req.JobType,
+		Payload:      req.Payload,
+		MissedPolicy: req.MissedPolicy,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrInvalidName),
+			errors.Is(err, scheduler.ErrInvalidJobType),
+			errors.Is(err, scheduler.ErrInvalidMissedPolicy),
+			errors.Is(err, scheduler.ErrInvalidPayload),
+			errors.Is(err, scheduler.ErrInvalidCron):
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "schedule.create", "schedule", schedule.ID, map[string]any{
+		"name":     schedule.Name,
+		"cron":     schedule.CronExpr,
+		"job_type": schedule.JobType,
+	})
+	writeJSON(w, http.StatusCreated, schedule)
+}
+
+func (a *API) handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	if a.scheduler == nil {
+		writeError(w, http.StatusInternalServerError, "scheduler is not configured")
+		return
+	}
+	schedule, err := a.scheduler.Get(r.Context(), r.PathValue("scheduleID"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "schedule not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func (a *API) handlePauseSchedule(w http.ResponseWriter, r *http.Request) {
+	a.handleScheduleToggle(w, r, true)
+}
+
+func (a *API) handleResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	a.handleScheduleToggle(w, r, false)
+}
+
+func (a *API) handleScheduleToggle(w http.ResponseWriter, r *http.Request, pause bool) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if a.scheduler == nil {
+		writeError(w, http.StatusInternalServerError, "scheduler is not configured")
+		return
+	}
+
+	scheduleID := r.PathValue("scheduleID")
+	action := "schedule.resume"
+	var (
+		schedule store.Schedule
+		err      error
+	)
+	if pause {
+		action = "schedule.pause"
+		schedule, err = a.scheduler.Pause(r.Context(), scheduleID)
+	} else {
+		schedule, err = a.scheduler.Resume(r.Context(), scheduleID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "schedule not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, action, "schedule", schedule.ID, nil)
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func (a *API) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if a.scheduler == nil {
+		writeError(w, http.StatusInternalServerError, "scheduler is not configured")
+		return
+	}
+
+	scheduleID := r.PathValue("scheduleID")
+	if err := a.scheduler.Delete(r.Context(), scheduleID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "schedule not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.audit.Record(r.Context(), user.ID, "schedule.delete", "schedule", scheduleID, nil)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": "ok",
+		"id":     scheduleID,
+	})
+}
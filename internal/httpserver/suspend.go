@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+type siteMaintenanceRequest struct {
+	AllowIPs []string `json:"allow_ips"`
+	Page     string   `json:"page"`
+}
+
+func (a *API) handleSuspendSite(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, job, err := a.sites.Suspend(r.Context(), user.ID, r.PathValue("siteID"))
+	if err != nil {
+		writeSiteStateError(w, err)
+		return
+	}
+	a.audit.Record(r.Context(), user.ID, "site.suspend", "site", site.ID, map[string]any{
+		"domain": site.Domain,
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleResumeSite(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, job, err := a.sites.Resume(r.Context(), user.ID, r.PathValue("siteID"))
+	if err != nil {
+		writeSiteStateError(w, err)
+		return
+	}
+	a.audit.Record(r.Context(), user.ID, "site.resume", "site", site.ID, map[string]any{
+		"domain":      site.Domain,
+		"from_status": site.Status,
+		"job_id":      job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func (a *API) handleEnableSiteMaintenance(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req siteMaintenanceRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.EnableMaintenance(r.Context(), user.ID, r.PathValue("siteID"), req.AllowIPs, req.Page)
+	if err != nil {
+		writeSiteStateError(w, err)
+		return
+	}
+	a.audit.Record(r.Context(), user.ID, "site.maintenance", "site", site.ID, map[string]any{
+		"domain":      site.Domain,
+		"allow_ips":   req.AllowIPs,
+		"custom_page": req.Page != "",
+		"job_id":      job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
+
+func writeSiteStateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	case errors.Is(err, sitessvc.ErrInvalidMaintenance), errors.Is(err, sitessvc.ErrInvalidRoot):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, sitessvc.ErrSiteState):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
@@ -0,0 +1,82 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	sslsvc "nusantara/internal/ssl"
+	"nusantara/internal/store"
+)
+
+type enableSiteTLSRequest struct {
+	Email string `json:"email"`
+	HSTS  bool   `json:"hsts"`
+}
+
+func (a *API) handleEnableSiteTLS(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req enableSiteTLSRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.enableSiteTLS(w, r, user, r.PathValue("siteID"), req.Email, req.HSTS)
+}
+
+func (a *API) enableSiteTLS(w http.ResponseWriter, r *http.Request, user store.User, siteID, email string, hsts bool) {
+	site, workflow, err := a.sites.EnableTLS(r.Context(), user.ID, siteID, email, hsts)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "site not found")
+		case errors.Is(err, sslsvc.ErrInvalidDomain), errors.Is(err, sslsvc.ErrInvalidEmail):
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.tls.enable", "site", site.ID, map[string]any{
+		"domain":      site.Domain,
+		"email":       email,
+		"hsts":        hsts,
+		"workflow_id": workflow.Job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site":     site,
+		"workflow": workflow,
+	})
+}
+
+func (a *API) handleDisableSiteTLS(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	site, job, err := a.sites.DisableTLS(r.Context(), user.ID, r.PathValue("siteID"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "site not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.tls.disable", "site", site.ID, map[string]any{
+		"domain": site.Domain,
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
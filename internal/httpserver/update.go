@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"nusantara/internal/php"
+	"nusantara/internal/provision"
+	sitessvc "nusantara/internal/service/sites"
+	"nusantara/internal/store"
+)
+
+// updateSiteRequest leaves fields that are absent unchanged.
+type updateSiteRequest struct {
+	RootPath     *string           `json:"root_path"`
+	Runtime      *string           `json:"runtime"`
+	UpstreamHost *string           `json:"upstream_host"`
+	UpstreamPort *int              `json:"upstream_port"`
+	PHPVersion   *string           `json:"php_version"`
+	Proxy        *store.SiteProxy  `json:"proxy"`
+	Policy       *store.SitePolicy `json:"policy"`
+	App          *store.SiteApp    `json:"app"`
+}
+
+func (a *API) handleUpdateSite(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req updateSiteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	site, job, err := a.sites.UpdateSite(r.Context(), user.ID, r.PathValue("siteID"), sitessvc.UpdateSiteInput{
+		RootPath:     req.RootPath,
+		Runtime:      req.Runtime,
+		UpstreamHost: req.UpstreamHost,
+		UpstreamPort: req.UpstreamPort,
+		PHPVersion:   req.PHPVersion,
+		Proxy:        req.Proxy,
+		Policy:       req.Policy,
+		App:          req.App,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "site not found")
+		case errors.Is(err, sitessvc.ErrInvalidRoot), errors.Is(err, sitessvc.ErrInvalidRuntime),
+			errors.Is(err, sitessvc.ErrInvalidUpstream), errors.Is(err, sitessvc.ErrInvalidPHP),
+			errors.Is(err, php.ErrInvalidVersion), errors.Is(err, php.ErrVersionNotFound), errors.Is(err, php.ErrNoVersionsFound),
+			errors.Is(err, provision.ErrInvalidProxy), errors.Is(err, provision.ErrInvalidPolicy), errors.Is(err, provision.ErrInvalidApp):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, store.ErrUpstreamInUse), errors.Is(err, sitessvc.ErrNoUpstreamPort),
+			errors.Is(err, sitessvc.ErrSiteState), errors.Is(err, provision.ErrSiteUnmanaged):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.update", "site", site.ID, map[string]any{
+		"domain": site.Domain,
+		"job_id": job.ID,
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site": site,
+		"job":  job,
+	})
+}
@@ -0,0 +1,122 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	dbsvc "nusantara/internal/db"
+	"nusantara/internal/jobs"
+	"nusantara/internal/provision"
+	sitessvc "nusantara/internal/service/sites"
+	sslsvc "nusantara/internal/ssl"
+	"nusantara/internal/store"
+)
+
+type createSiteWorkflowRequest struct {
+	Domain            string                       `json:"domain"`
+	RootPath          string                       `json:"root_path"`
+	Runtime           string                       `json:"runtime"`
+	UpstreamHost      string                       `json:"upstream_host"`
+	UpstreamPort      int                          `json:"upstream_port"`
+	PHPVersion        string                       `json:"php_version"`
+	Aliases           []string                     `json:"aliases"`
+	CanonicalRedirect string                       `json:"canonical_redirect"`
+	SSLEmail          string                       `json:"ssl_email"`
+	HSTS              bool                         `json:"hsts"`
+	Database          *createSiteWorkflowDBRequest `json:"database"`
+	Proxy             *store.SiteProxy             `json:"proxy"`
+	Policy            *store.SitePolicy            `json:"policy"`
+	App               *store.SiteApp               `json:"app"`
+}
+
+type createSiteWorkflowDBRequest struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+}
+
+func (a *API) handleCreateSiteWorkflow(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(principalContextKey{}).(store.User)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req createSiteWorkflowRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	input := sitessvc.CreateSiteWorkflowInput{
+		Site: sitessvc.CreateSiteInput{
+			Domain:       req.Domain,
+			RootPath:     req.RootPath,
+			Runtime:      req.Runtime,
+			UpstreamHost: req.UpstreamHost,
+			UpstreamPort: req.UpstreamPort,
+			PHPVersion:   req.PHPVersion,
+
+			Aliases:           req.Aliases,
+			CanonicalRedirect: req.CanonicalRedirect,
+			Proxy:             req.Proxy,
+			Policy:            req.Policy,
+			App:               req.App,
+		},
+		SSLEmail: req.SSLEmail,
+		HSTS:     req.HSTS,
+	}
+	if req.Database != nil {
+		input.Database = &sitessvc.SiteDatabaseInput{
+			Name:     req.Database.Name,
+			Username: req.Database.Username,
+			Password: req.Database.Password,
+			Host:     req.Database.Host,
+		}
+	}
+
+	site, workflow, err := a.sites.CreateSiteWorkflow(r.Context(), user.ID, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, sitessvc.ErrInvalidDomain), errors.Is(err, sitessvc.ErrInvalidRoot), errors.Is(err, sitessvc.ErrInvalidRuntime),
+			errors.Is(err, sitessvc.ErrInvalidUpstream), errors.Is(err, sitessvc.ErrInvalidPHP),
+			errors.Is(err, sitessvc.ErrInvalidAlias), errors.Is(err, sitessvc.ErrInvalidRedirect),
+			errors.Is(err, provision.ErrInvalidProxy), errors.Is(err, provision.ErrInvalidPolicy), errors.Is(err, provision.ErrInvalidApp),
+			errors.Is(err, sslsvc.ErrInvalidEmail), errors.Is(err, sslsvc.ErrInvalidDomain),
+			errors.Is(err, dbsvc.ErrInvalidDatabaseName), errors.Is(err, dbsvc.ErrInvalidUsername),
+			errors.Is(err, dbsvc.ErrInvalidPassword), errors.Is(err, dbsvc.ErrInvalidHost):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, store.ErrUpstreamInUse), errors.Is(err, sitessvc.ErrNoUpstreamPort):
+			writeError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, store.ErrConflict):
+			writeError(w, http.StatusConflict, "domain already exists")
+		default:
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	a.audit.Record(r.Context(), user.ID, "site.create.workflow", "site", site.ID, map[string]any{
+		"domain":      site.Domain,
+		"workflow_id": workflow.Job.ID,
+		"steps":       len(workflow.Steps),
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"site":     site,
+		"workflow": workflow,
+	})
+}
+
+func (a *API) handleGetWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflow, err := a.jobs.GetWorkflow(r.Context(), r.PathValue("workflowID"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) || errors.Is(err, jobs.ErrNotWorkflow) {
+			writeError(w, http.StatusNotFound, "workflow not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, workflow)
+}
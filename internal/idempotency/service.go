@@ -0,0 +1,136 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidKey    = errors.New("invalid idempotency key")
+	ErrKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+	ErrKeyReused     = errors.New("idempotency key was already used for a different request")
+)
+
+const (
+	defaultTTL = 24 * time.Hour
+	maxKeyLen  = 255
+	// A reservation that never completed (e.g. the panel restarted mid-request)
+	// stops blocking retries after this long.
+	staleAfter = 15 * time.Minute
+	// Expired records are pruned by Begin at most this often, so they go
+	// even without a cleanup schedule.
+	pruneEvery = 10 * time.Minute
+)
+
+type Service struct {
+	repo store.Repository
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu       sync.Mutex
+	prunedAt time.Time
+}
+
+func NewService(repo store.Repository, ttl time.Duration) *Service {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &Service{
+		repo: repo,
+		ttl:  ttl,
+		now:  func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Begin reserves key for the request. When the key was already used for the
+// same request and completed, the stored record is returned with replay=true.
+func (s *Service) Begin(ctx context.Context, userID, key, method, path string, body []byte) (record store.IdempotencyRecord, replay bool, err error) {
+	key = strings.TrimSpace(key)
+	if key == "" || len(key) > maxKeyLen || strings.ContainsAny(key, "\r\n") {
+		return store.IdempotencyRecord{}, false, ErrInvalidKey
+	}
+
+	now := s.now()
+	s.pruneExpired(ctx, now)
+	record = store.IdempotencyRecord{
+		ID:          userID + ":" + key,
+		UserID:      userID,
+		Method:      method,
+		Path:        path,
+		RequestHash: requestHash(method, path, body),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		err = s.repo.CreateIdempotencyRecord(ctx, record)
+		if err == nil {
+			return record, false, nil
+		}
+		if !errors.Is(err, store.ErrConflict) {
+			return store.IdempotencyRecord{}, false, err
+		}
+
+		existing, err := s.repo.GetIdempotencyRecord(ctx, record.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			return store.IdempotencyRecord{}, false, err
+		}
+		if existing.RequestHash != record.RequestHash {
+			return store.IdempotencyRecord{}, false, ErrKeyReused
+		}
+		if existing.Completed {
+			return existing, true, nil
+		}
+		if now.Sub(existing.CreatedAt) < staleAfter {
+			return store.IdempotencyRecord{}, false, ErrKeyInProgress
+		}
+		if err := s.repo.DeleteIdempotencyRecord(ctx, existing.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return store.IdempotencyRecord{}, false, err
+		}
+	}
+	return store.IdempotencyRecord{}, false, ErrKeyInProgress
+}
+
+func (s *Service) Complete(ctx context.Context, id string, statusCode int, contentType, body string) error {
+	return s.repo.CompleteIdempotencyRecord(ctx, id, statusCode, contentType, body)
+}
+
+// Release drops a reservation so the request can be retried, used when the
+// first attempt failed with a server error.
+func (s *Service) Release(ctx context.Context, id string) error {
+	err := s.repo.DeleteIdempotencyRecord(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *Service) pruneExpired(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.prunedAt) < pruneEvery {
+		s.mu.Unlock()
+		return
+	}
+	s.prunedAt = now
+	s.mu.Unlock()
+	_, _ = s.repo.DeleteExpiredIdempotencyRecords(ctx, now)
+}
+
+func requestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(path))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return NewService(repo, time.Hour)
+}
+
+func TestBeginReplaysCompletedRequest(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+	body := []byte(`{"domain":"example.com"}`)
+
+	record, replay, err := svc.Begin(ctx, "usr-1", "key-1", "POST", "/v1/sites", body)
+	if err != nil || replay {
+		t.Fatalf("first begin: replay=%t err=%v", replay, err)
+	}
+	if _, _, err := svc.Begin(ctx, "usr-1", "key-1", "POST", "/v1/sites", body); !errors.Is(err, ErrKeyInProgress) {
+		t.Fatalf("expected in-progress error, got %v", err)
+	}
+	if err := svc.Complete(ctx, record.ID, 201, "application/json", `{"ok":true}`); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	got, replay, err := svc.Begin(ctx, "usr-1", "key-1", "POST", "/v1/sites", body)
+	if err != nil || !replay {
+		t.Fatalf("expected replay, replay=%t err=%v", replay, err)
+	}
+	if got.StatusCode != 201 || got.Body != `{"ok":true}` {
+		t.Fatalf("unexpected replayed record %+v", got)
+	}
+
+	if _, _, err := svc.Begin(ctx, "usr-1", "key-1", "POST", "/v1/sites", []byte(`{}`)); !errors.Is(err, ErrKeyReused) {
+		t.Fatalf("expected reused key error, got %v", err)
+	}
+	// Keys are scoped per user.
+	if _, replay, err := svc.Begin(ctx, "usr-2", "key-1", "POST", "/v1/sites", body); err != nil || replay {
+		t.Fatalf("expected fresh key for another user, replay=%t err=%v", replay, err)
+	}
+}
+
+func TestBeginAfterExpiryOrRelease(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return start }
+
+	record, _, err := svc.Begin(ctx, "usr-1", "key-1", "DELETE", "/v1/sites/site-1", nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := svc.Release(ctx, record.ID); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	record, replay, err := svc.Begin(ctx, "usr-1", "key-1", "DELETE", "/v1/sites/site-1", nil)
+	if err != nil || replay {
+		t.Fatalf("expected released key to be reusable, replay=%t err=%v", replay, err)
+	}
+	_ = svc.Complete(ctx, record.ID, 202, "application/json", "{}")
+
+	svc.now = func() time.Time { return start.Add(2 * time.Hour) }
+	if _, replay, err := svc.Begin(ctx, "usr-1", "key-1", "POST", "/v1/other", nil); err != nil || replay {
+		t.Fatalf("expected expired key to be reusable, replay=%t err=%v", replay, err)
+	}
+}
+
+func TestBeginRejectsInvalidKey(t *testing.T) {
+	svc := newTestService(t)
+	if _, _, err := svc.Begin(context.Background(), "usr-1", "  ", "POST", "/v1/sites", nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected invalid key error, got %v", err)
+	}
+}
+
+func TestBeginPrunesExpiredRecords(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	svc := NewService(repo, time.Hour)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return start }
+
+	record, _, err := svc.Begin(ctx, "usr-1", "old", "POST", "/v1/sites", nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	_ = svc.Complete(ctx, record.ID, 201, "application/json", `{"secret":"x"}`)
+
+	svc.now = func() time.Time { return start.Add(2 * time.Hour) }
+	if _, _, err := svc.Begin(ctx, "usr-1", "new", "POST", "/v1/sites", nil); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := repo.GetIdempotencyRecord(ctx, record.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expired record should be pruned, err=%v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"nusantara/internal/store"
+)
+
+var (
+	ErrUnknownJobType   = errors.New("unknown job type")
+	ErrDuplicateHandler = errors.New("job handler already registered")
+	ErrInvalidPayload   = errors.New("invalid job payload")
+	ErrSecretLost       = errors.New("job secret was lost when the panel restarted; create the job again")
+)
+
+// Handler runs one job type. Handlers are registered at startup by the
+// subsystem that owns the operation.
+type Handler interface {
+	Handle(ctx context.Context, job store.Job) error
+}
+
+type HandlerFunc func(ctx context.Context, job store.Job) error
+
+func (f HandlerFunc) Handle(ctx context.Context, job store.Job) error {
+	return f(ctx, job)
+}
+
+// Registry is the registration side of Service, so subsystems do not need the
+// whole job service to register their handlers.
+type Registry interface {
+	Register(jobType string, handler Handler) error
+}
+
+// Validator is implemented by payloads that can reject bad input before the
+// job is queued.
+type Validator interface {
+	Validate() error
+}
+
+// Redactor is implemented by payloads carrying secrets. Only the redacted
+// payload is stored and returned; the full one is held in memory until the
+// job runs, so a job still pending when the panel restarts fails with
+// ErrSecretLost.
+type Redactor[T any] interface {
+	Redacted() T
+}
+
+type payloadRedactor interface {
+	redactPayload(raw string) (string, bool)
+}
+
+type payloadValidator interface {
+	validatePayload(raw string) error
+}
+
+type typedHandler[T any] struct {
+	fn func(ctx context.Context, job store.Job, payload T) error
+}
+
+// Typed adapts fn into a Handler that decodes the job payload into T and runs
+// T's Validate method when present.
+func Typed[T any](fn func(ctx context.Context, job store.Job, payload T) error) Handler {
+	return typedHandler[T]{fn: fn}
+}
+
+func (h typedHandler[T]) Handle(ctx context.Context, job store.Job) error {
+	var payload T
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+	if v, ok := any(payload).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	return h.fn(ctx, job, payload)
+}
+
+// validatePayload checks a payload that arrives already encoded, such as a
+// schedule's, the same way Handle will.
+func (h typedHandler[T]) validatePayload(raw string) error {
+	var payload T
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if v, ok := any(payload).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+	}
+	return nil
+}
+
+func (h typedHandler[T]) redactPayload(raw string) (string, bool) {
+	var payload T
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return "", false
+	}
+	r, ok := any(payload).(Redactor[T])
+	if !ok {
+		return "", false
+	}
+	body, err := json.Marshal(r.Redacted())
+	if err != nil {
+		return "", false
+	}
+	return string(body), true
+}
+
+type jobLoggerKey struct{}
+
+// Logf writes a line to the running job's log stream. It is a no-op when ctx
+// does not belong to a job.
+func Logf(ctx context.Context, format string, args ...any) {
+	if logf, ok := ctx.Value(jobLoggerKey{}).(func(string)); ok {
+		logf(fmt.Sprintf(format, args...))
+	}
+}
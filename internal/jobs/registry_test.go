@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nusantara/internal/events"
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+type secretPayload struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func (p secretPayload) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func (p secretPayload) Redacted() secretPayload {
+	p.Password = ""
+	return p
+}
+
+func waitJob(t *testing.T, svc *Service, id string) store.Job {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := svc.Get(context.Background(), id)
+		if err == nil && (job.Status == store.JobStatusSuccess || job.Status == store.JobStatusFailed) {
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return store.Job{}
+}
+
+func TestRegistryRunsTypedHandlerAndRedactsPayload(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	bus := events.NewBus(0)
+	svc := NewService(repo, nil, nil, bus)
+	var got secretPayload
+	if err := svc.Register("secret", Typed(func(ctx context.Context, _ store.Job, p secretPayload) error {
+		got = p
+		Logf(ctx, "handled %s", p.Name)
+		return nil
+	})); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := svc.Register("secret", HandlerFunc(func(context.Context, store.Job) error { return nil })); !errors.Is(err, ErrDuplicateHandler) {
+		t.Fatalf("expected duplicate handler error, got %v", err)
+	}
+
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	if _, err := svc.Enqueue(ctx, "usr-1", "unknown", nil); !errors.Is(err, ErrUnknownJobType) {
+		t.Fatalf("expected unknown job type error, got %v", err)
+	}
+	if _, err := svc.Enqueue(ctx, "usr-1", "secret", secretPayload{}); err == nil {
+		t.Fatalf("expected validation error")
+	}
+
+	stream, unsubscribe := bus.Subscribe([]string{events.TopicJobs}, 0)
+	defer unsubscribe()
+	job, err := svc.Enqueue(ctx, "usr-1", "secret", secretPayload{Name: "app", Password: "hunter22"})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if strings.Contains(job.Payload, "hunter22") {
+		t.Fatalf("enqueued job must not carry the password, payload=%s", job.Payload)
+	}
+	final := waitJob(t, svc, job.ID)
+	if final.Status != store.JobStatusSuccess {
+		t.Fatalf("job status = %s err=%s", final.Status, final.Error)
+	}
+	if got.Name != "app" || got.Password != "hunter22" {
+		t.Fatalf("handler got %+v", got)
+	}
+	if strings.Contains(final.Payload, "hunter22") {
+		t.Fatalf("expected password redacted, payload=%s", final.Payload)
+	}
+	for {
+		select {
+		case evt := <-stream:
+			if job, ok := evt.Data.(store.Job); ok && strings.Contains(job.Payload, "hunter22") {
+				t.Fatalf("%s event carried the secret: %s", evt.Type, job.Payload)
+			}
+			if line, ok := evt.Data.(map[string]string); ok && evt.Type == "job.log" && line["line"] == "handled app" {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected handler log line on the event bus")
+		}
+	}
+}
+
+func TestJobFailsWhenSecretWasLost(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	svc := NewService(repo, nil, nil, events.NewBus(0))
+	ran := false
+	if err := svc.Register("secret", Typed(func(context.Context, store.Job, secretPayload) error {
+		ran = true
+		return nil
+	})); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	// Queued by a previous run of the panel: only the redacted payload is stored.
+	stranded := store.Job{ID: "job_stranded", Type: "secret", Status: store.JobStatusQueued, Payload: `{"name":"app","password":""}`, CreatedAt: time.Now().UTC()}
+	if err := repo.CreateJob(ctx, stranded); err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	svc.handleJob(ctx, stranded.ID)
+	final, err := svc.Get(ctx, stranded.ID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if ran || final.Status != store.JobStatusFailed || final.Error != ErrSecretLost.Error() {
+		t.Fatalf("stranded job ran=%t status=%s err=%s", ran, final.Status, final.Error)
+	}
+}
@@ -6,9 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"nusantara/internal/events"
 	"nusantara/internal/idgen"
 	"nusantara/internal/store"
 )
@@ -21,42 +25,259 @@ var (
 type SiteProvisioner interface {
 	ProvisionSite(ctx context.Context, site store.Site) error
 	DeprovisionSite(ctx context.Context, site store.Site) error
+	SuspendSite(ctx context.Context, site store.Site) error
 }
 
+type SitePayload struct {
+	SiteID   string `json:"site_id"`
+	Domain   string `json:"domain,omitempty"`
+	Runtime  string `json:"runtime,omitempty"`
+	RootPath string `json:"rootPath,omitempty"`
+}
+
+func (p SitePayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	return nil
+}
+
+// SiteTLSPayload switches a site to HTTPS with an issued certificate.
+type SiteTLSPayload struct {
+	SiteID   string `json:"site_id"`
+	Email    string `json:"email"`
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+	HSTS     bool   `json:"hsts,omitempty"`
+}
+
+func (p SiteTLSPayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	if p.CertPath == "" || p.KeyPath == "" {
+		return errors.New("missing certificate paths in payload")
+	}
+	return nil
+}
+
+// SiteMaintenancePayload puts a site in maintenance mode. AllowIPs are
+// addresses or CIDRs that still reach the site.
+type SiteMaintenancePayload struct {
+	SiteID   string   `json:"site_id"`
+	AllowIPs []string `json:"allow_ips,omitempty"`
+	Page     string   `json:"page,omitempty"`
+}
+
+func (p SiteMaintenancePayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	for _, raw := range p.AllowIPs {
+		if net.ParseIP(raw) == nil {
+			if _, _, err := net.ParseCIDR(raw); err != nil {
+				return fmt.Errorf("invalid allow_ips entry: %q", raw)
+			}
+		}
+	}
+	return nil
+}
+
+// SiteUpdatePayload carries a site's new settings. They are stored only
+// once the reprovisioned vhost is live.
+type SiteUpdatePayload struct {
+	SiteID       string            `json:"site_id"`
+	RootPath     string            `json:"root_path"`
+	Runtime      string            `json:"runtime"`
+	UpstreamHost string            `json:"upstream_host,omitempty"`
+	UpstreamPort int               `json:"upstream_port,omitempty"`
+	PHPVersion   string            `json:"php_version,omitempty"`
+	Proxy        *store.SiteProxy  `json:"proxy,omitempty"`
+	Policy       *store.SitePolicy `json:"policy,omitempty"`
+	App          *store.SiteApp    `json:"app,omitempty"`
+	// CustomDirectives is the site's nginx snippet, see
+	// provision.ValidateCustomDirectives.
+	CustomDirectives string `json:"custom_directives,omitempty"`
+}
+
+func (p SiteUpdatePayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	if p.RootPath == "" || p.Runtime == "" {
+		return errors.New("missing root_path or runtime in payload")
+	}
+	return nil
+}
+
+// ReconcilePayload lists the sites whose vhost is re-applied from the panel
+// state.
+type ReconcilePayload struct {
+	SiteIDs []string `json:"site_ids"`
+}
+
+func (p ReconcilePayload) Validate() error {
+	if len(p.SiteIDs) == 0 {
+		return errors.New("missing site_ids in payload")
+	}
+	return nil
+}
+
+type CleanupPayload struct {
+	JobRetentionDays int `json:"job_retention_days,omitempty"`
+}
+
+func (p CleanupPayload) Validate() error {
+	if p.JobRetentionDays < 0 {
+		return fmt.Errorf("invalid job_retention_days: %d", p.JobRetentionDays)
+	}
+	return nil
+}
+
+// UnmarshalJSON also accepts the quoted number stored by schedules created
+// before job_retention_days was an integer.
+func (p *CleanupPayload) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		JobRetentionDays json.RawMessage `json:"job_retention_days"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	value := strings.Trim(string(raw.JobRetentionDays), `"`)
+	if value == "" || value == "null" {
+		p.JobRetentionDays = 0
+		return nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid job_retention_days: %s", raw.JobRetentionDays)
+	}
+	p.JobRetentionDays = days
+	return nil
+}
+
+const defaultJobRetentionDays = 30
+
 type Service struct {
 	repo            store.Repository
 	logger          *log.Logger
 	siteProvisioner SiteProvisioner
+	handlers        map[string]Handler
+	events          *events.Bus
 	queue           chan string
-	started         bool
-	stopped         bool
-	mu              sync.RWMutex
-	wg              sync.WaitGroup
-	cancel          context.CancelFunc
+	// secrets holds the full payload of jobs whose stored payload is
+	// redacted, by job ID, until the job runs or is skipped.
+	secrets   map[string]string
+	secretsMu sync.Mutex
+	started   bool
+	stopped   bool
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+	// runCtx lives from Start to Stop; jobs queued for later are fed to
+	// the worker under it rather than under a request's context.
+	runCtx context.Context
+	cancel context.CancelFunc
 }
 
-func NewService(repo store.Repository, logger *log.Logger, siteProvisioner SiteProvisioner) *Service {
-	return &Service{
+func NewService(repo store.Repository, logger *log.Logger, siteProvisioner SiteProvisioner, bus *events.Bus) *Service {
+	s := &Service{
 		repo:            repo,
 		logger:          logger,
 		siteProvisioner: siteProvisioner,
+		handlers:        make(map[string]Handler),
+		events:          bus,
 		queue:           make(chan string, 256),
+		secrets:         make(map[string]string),
 	}
+	s.handlers[store.JobTypeProvisionSite] = Typed(s.runProvisionSite)
+	s.handlers[store.JobTypeDeprovisionSite] = Typed(s.runDeprovisionSite)
+	s.handlers[store.JobTypeCleanup] = Typed(s.runCleanup)
+	s.handlers[store.JobTypeEnableSiteTLS] = Typed(s.runEnableSiteTLS)
+	s.handlers[store.JobTypeSuspendSite] = Typed(s.runSuspendSite)
+	s.handlers[store.JobTypeResumeSite] = Typed(s.runResumeSite)
+	s.handlers[store.JobTypeMaintenanceSite] = Typed(s.runMaintenanceSite)
+	s.handlers[store.JobTypeReconcileSites] = Typed(s.runReconcileSites)
+	s.handlers[store.JobTypeReprovisionSite] = Typed(s.runReprovisionSite)
+	return s
 }
 
-func (s *Service) Start(parent context.Context) {
+func (s *Service) Register(jobType string, handler Handler) error {
+	if jobType == "" || handler == nil {
+		return errors.New("job type and handler are required")
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.started {
+	if _, exists := s.handlers[jobType]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicateHandler, jobType)
+	}
+	s.handlers[jobType] = handler
+	return nil
+}
+
+func (s *Service) handler(jobType string) (Handler, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.handlers[jobType]
+	return h, ok
+}
+
+// Start runs the worker. Jobs left pending by the previous run are picked
+// up first, see recoverJobs.
+func (s *Service) Start(parent context.Context) {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
 		return
 	}
 	ctx, cancel := context.WithCancel(parent)
-	s.cancel = cancel
+	s.runCtx, s.cancel = ctx, cancel
+	s.mu.Unlock()
+
+	// Enqueue is refused until recovery is done, so no new job is queued
+	// twice.
+	s.recoverJobs(ctx)
+	s.mu.Lock()
 	s.started = true
 	s.wg.Add(1)
+	s.mu.Unlock()
 	go s.worker(ctx)
 }
 
+// recoverJobs settles the jobs the previous run left behind: queued jobs
+// are queued again, jobs that were running are failed since their handler
+// stopped midway, and every open workflow is advanced so waiting steps are
+// queued or skipped and finished workflows are closed.
+func (s *Service) recoverJobs(ctx context.Context) {
+	items, err := s.repo.ListJobs(ctx, 0)
+	if err != nil {
+		s.logf("job recovery failed err=%v", err)
+		return
+	}
+	var workflows []string
+	for i := len(items) - 1; i >= 0; i-- {
+		job := items[i]
+		switch {
+		case job.Type == store.JobTypeWorkflow:
+			if job.Status == store.JobStatusRunning {
+				workflows = append(workflows, job.ID)
+			}
+		case job.Status == store.JobStatusQueued:
+			s.requeue(ctx, job.ID)
+		case job.Status == store.JobStatusRunning:
+			finishedAt := time.Now().UTC()
+			if err := s.repo.UpdateJob(ctx, job.ID, store.JobStatusFailed, "interrupted: the panel restarted while the job ran", job.StartedAt, &finishedAt); err != nil {
+				s.logf("job recovery failed id=%s err=%v", job.ID, err)
+				continue
+			}
+			s.redact(ctx, job)
+			s.publishJob(ctx, job.ID)
+		}
+	}
+	for _, id := range workflows {
+		s.advanceWorkflow(ctx, id)
+	}
+}
+
 func (s *Service) Stop(ctx context.Context) error {
 	s.mu.Lock()
 	if s.stopped {
@@ -85,19 +306,14 @@ func (s *Service) Stop(ctx context.Context) error {
 	}
 }
 
-func (s *Service) Enqueue(ctx context.Context, triggeredBy, jobType string, payload map[string]string) (store.Job, error) {
-	s.mu.RLock()
-	started := s.started
-	stopped := s.stopped
-	s.mu.RUnlock()
-	if !started {
-		return store.Job{}, ErrNotStarted
-	}
-	if stopped {
-		return store.Job{}, ErrStopped
+// Enqueue stores a job and hands it to the worker. The payload is encoded as
+// JSON; payloads implementing Validator are checked first and the validation
+// error is returned unchanged.
+func (s *Service) Enqueue(ctx context.Context, triggeredBy, jobType string, payload any) (store.Job, error) {
+	if err := s.checkRunning(); err != nil {
+		return store.Job{}, err
 	}
-
-	body, err := json.Marshal(payload)
+	body, err := s.encodePayload(jobType, payload)
 	if err != nil {
 		return store.Job{}, err
 	}
@@ -111,13 +327,15 @@ func (s *Service) Enqueue(ctx context.Context, triggeredBy, jobType string, payl
 		ID:          jobID,
 		Type:        jobType,
 		Status:      store.JobStatusQueued,
-		Payload:     string(body),
+		Payload:     s.holdSecret(jobID, jobType, body),
 		CreatedAt:   now,
 		TriggeredBy: triggeredBy,
 	}
 	if err := s.repo.CreateJob(ctx, job); err != nil {
+		s.dropSecret(jobID)
 		return store.Job{}, err
 	}
+	s.publishJobEvent("job.created", job)
 
 	select {
 	case s.queue <- job.ID:
@@ -127,12 +345,77 @@ func (s *Service) Enqueue(ctx context.Context, triggeredBy, jobType string, payl
 	return job, nil
 }
 
+func (s *Service) checkRunning() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.started {
+		return ErrNotStarted
+	}
+	if s.stopped {
+		return ErrStopped
+	}
+	return nil
+}
+
+func (s *Service) encodePayload(jobType string, payload any) (string, error) {
+	h, ok := s.handler(jobType)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+	v, isValidator := payload.(Validator)
+	if isValidator {
+		if err := v.Validate(); err != nil {
+			return "", err
+		}
+	}
+	if payload == nil {
+		payload = struct{}{}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	// Maps and raw JSON are checked against the handler's own payload type.
+	if pv, ok := h.(payloadValidator); ok && !isValidator {
+		if err := pv.validatePayload(string(body)); err != nil {
+			return "", err
+		}
+	}
+	return string(body), nil
+}
+
+// ValidatePayload checks an encoded payload for jobType without queueing
+// it, so callers that store payloads for later can reject them up front.
+func (s *Service) ValidatePayload(jobType string, raw []byte) error {
+	h, ok := s.handler(jobType)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+	if pv, ok := h.(payloadValidator); ok {
+		return pv.validatePayload(string(raw))
+	}
+	return nil
+}
+
+// List and Get return payloads redacted, which also covers jobs stored in
+// full before payloads were redacted on write.
 func (s *Service) List(ctx context.Context, limit int) ([]store.Job, error) {
-	return s.repo.ListJobs(ctx, limit)
+	items, err := s.repo.ListJobs(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i] = s.redactedJob(items[i])
+	}
+	return items, nil
 }
 
 func (s *Service) Get(ctx context.Context, id string) (store.Job, error) {
-	return s.repo.GetJobByID(ctx, id)
+	job, err := s.repo.GetJobByID(ctx, id)
+	if err != nil {
+		return store.Job{}, err
+	}
+	return s.redactedJob(job), nil
 }
 
 func (s *Service) worker(ctx context.Context) {
@@ -153,86 +436,409 @@ func (s *Service) handleJob(ctx context.Context, jobID string) {
 		s.logf("job load failed id=%s err=%v", jobID, err)
 		return
 	}
+	if job.Status != store.JobStatusQueued {
+		// Queued twice, e.g. by recovery and a finishing dependency.
+		return
+	}
 
 	startedAt := time.Now().UTC()
 	if err := s.repo.UpdateJob(ctx, job.ID, store.JobStatusRunning, "", &startedAt, nil); err != nil {
 		s.logf("job start failed id=%s err=%v", job.ID, err)
 		return
 	}
+	s.publishJob(ctx, job.ID)
+	if job.ParentID != "" {
+		defer s.advanceWorkflow(ctx, job.ParentID)
+	}
+	s.jobLogf(job, "job started type=%s", job.Type)
 
-	runErr := s.runByType(ctx, job)
+	payload, runErr := s.takeSecret(job)
+	if runErr == nil {
+		job.Payload = payload
+		runErr = s.run(ctx, job)
+	}
+	s.redact(ctx, job)
 	finishedAt := time.Now().UTC()
 	if runErr != nil {
 		_ = s.repo.UpdateJob(ctx, job.ID, store.JobStatusFailed, runErr.Error(), &startedAt, &finishedAt)
-		s.logf("job failed id=%s type=%s err=%v", job.ID, job.Type, runErr)
+		s.jobLogf(job, "job failed type=%s err=%v", job.Type, runErr)
+		s.publishJob(ctx, job.ID)
 		return
 	}
 	if err := s.repo.UpdateJob(ctx, job.ID, store.JobStatusSuccess, "", &startedAt, &finishedAt); err != nil {
 		s.logf("job finish write failed id=%s err=%v", job.ID, err)
+		return
 	}
+	s.jobLogf(job, "job finished type=%s", job.Type)
+	s.publishJob(ctx, job.ID)
 }
 
-func (s *Service) runByType(ctx context.Context, job store.Job) error {
-	switch job.Type {
-	case store.JobTypeProvisionSite:
-		return s.runProvisionSite(ctx, job)
-	case store.JobTypeDeprovisionSite:
-		return s.runDeprovisionSite(ctx, job)
-	default:
+// holdSecret keeps the full payload of a job whose type redacts secrets in
+// memory and returns the payload to store.
+func (s *Service) holdSecret(jobID, jobType, body string) string {
+	redacted, ok := s.redactedPayload(store.Job{Type: jobType, Payload: body})
+	if !ok {
+		return body
+	}
+	s.secretsMu.Lock()
+	s.secrets[jobID] = body
+	s.secretsMu.Unlock()
+	return redacted
+}
+
+// takeSecret returns the payload the job runs with. A redacted job without
+// a held payload was queued before a restart; one stored in full by an
+// older version still runs.
+func (s *Service) takeSecret(job store.Job) (string, error) {
+	s.secretsMu.Lock()
+	full, held := s.secrets[job.ID]
+	delete(s.secrets, job.ID)
+	s.secretsMu.Unlock()
+	if held {
+		return full, nil
+	}
+	if redacted, ok := s.redactedPayload(job); ok && redacted == job.Payload {
+		return "", ErrSecretLost
+	}
+	return job.Payload, nil
+}
+
+func (s *Service) dropSecret(jobID string) {
+	s.secretsMu.Lock()
+	delete(s.secrets, jobID)
+	s.secretsMu.Unlock()
+}
+
+func (s *Service) redactedJob(job store.Job) store.Job {
+	if redacted, ok := s.redactedPayload(job); ok {
+		job.Payload = redacted
+	}
+	return job
+}
+
+func (s *Service) publishJob(ctx context.Context, jobID string) {
+	if s.events == nil {
+		return
+	}
+	job, err := s.repo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return
+	}
+	s.publishJobEvent("job.updated", job)
+}
+
+// publishJobEvent puts job on the bus with its payload already redacted: the
+// stream and its replay history outlive the job's own secrets.
+func (s *Service) publishJobEvent(eventType string, job store.Job) {
+	s.events.Publish(events.TopicJobs, eventType, s.redactedJob(job))
+}
+
+func (s *Service) jobLogf(job store.Job, format string, args ...any) {
+	line := fmt.Sprintf(format, args...)
+	s.logf("job id=%s %s", job.ID, line)
+	s.events.Publish(events.TopicJobs, "job.log", map[string]string{
+		"job_id": job.ID,
+		"line":   line,
+	})
+}
+
+func (s *Service) run(ctx context.Context, job store.Job) error {
+	h, ok := s.handler(job.Type)
+	if !ok {
 		return fmt.Errorf("unsupported job type: %s", job.Type)
 	}
+	ctx = context.WithValue(ctx, jobLoggerKey{}, func(line string) {
+		s.jobLogf(job, "%s", line)
+	})
+	return h.Handle(ctx, job)
 }
 
-func (s *Service) runProvisionSite(ctx context.Context, job store.Job) error {
+func (s *Service) redact(ctx context.Context, job store.Job) {
+	redacted, ok := s.redactedPayload(job)
+	if !ok {
+		return
+	}
+	if err := s.repo.UpdateJobPayload(ctx, job.ID, redacted); err != nil {
+		s.logf("job payload redact failed id=%s err=%v", job.ID, err)
+	}
+}
+
+func (s *Service) redactedPayload(job store.Job) (string, bool) {
+	h, ok := s.handler(job.Type)
+	if !ok {
+		return "", false
+	}
+	r, ok := h.(payloadRedactor)
+	if !ok {
+		return "", false
+	}
+	return r.redactPayload(job.Payload)
+}
+
+func (s *Service) runProvisionSite(ctx context.Context, job store.Job, payload SitePayload) error {
 	if s.siteProvisioner == nil {
 		return errors.New("site provisioner is not configured")
 	}
 
-	var payload map[string]string
-	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
-		return fmt.Errorf("decode payload: %w", err)
+	site, err := s.repo.GetSiteByID(ctx, payload.SiteID)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+
+	if site.Unmanaged {
+		// Changes to an imported site are stored and rendered once it is
+		// adopted; its hand-written vhost keeps serving meanwhile.
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusActive)
+		return errors.New("site vhost is not managed by the panel; adopt it first")
 	}
 
-	siteID := payload["site_id"]
-	if siteID == "" {
-		return errors.New("missing site_id in payload")
+	s.jobLogf(job, "provisioning site domain=%s runtime=%s", site.Domain, site.Runtime)
+	if err := s.siteProvisioner.ProvisionSite(ctx, site); err != nil {
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
+		return err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, settledStatus(site)); err != nil {
+		return fmt.Errorf("update site status: %w", err)
+	}
+	return nil
+}
+
+// settledStatus is the status of a site whose vhost was provisioned: a
+// suspended site stays suspended and maintenance stays on until resumed.
+func settledStatus(site store.Site) string {
+	switch {
+	case site.Suspended:
+		return store.SiteStatusSuspended
+	case site.Maintenance != nil:
+		return store.SiteStatusMaintenance
+	default:
+		return store.SiteStatusActive
+	}
+}
+
+// runEnableSiteTLS reprovisions the vhost with its HTTPS block and stores the
+// TLS state only once that succeeded, so a failed nginx test leaves the site
+// on its previous config.
+func (s *Service) runEnableSiteTLS(ctx context.Context, job store.Job, payload SiteTLSPayload) error {
+	if s.siteProvisioner == nil {
+		return errors.New("site provisioner is not configured")
 	}
 
-	site, err := s.repo.GetSiteByID(ctx, siteID)
+	site, err := s.repo.GetSiteByID(ctx, payload.SiteID)
 	if err != nil {
 		return fmt.Errorf("load site: %w", err)
 	}
+	previous := site.TLS
+	site.TLS = &store.SiteTLS{
+		Email:     payload.Email,
+		CertPath:  payload.CertPath,
+		KeyPath:   payload.KeyPath,
+		HSTS:      payload.HSTS,
+		EnabledAt: time.Now().UTC(),
+	}
 
+	s.jobLogf(job, "enabling https domain=%s hsts=%t", site.Domain, payload.HSTS)
 	if err := s.siteProvisioner.ProvisionSite(ctx, site); err != nil {
-		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
 		return err
 	}
+	if err := s.repo.UpdateSiteTLS(ctx, site.ID, site.TLS); err != nil {
+		site.TLS = previous
+		_ = s.siteProvisioner.ProvisionSite(ctx, site)
+		return fmt.Errorf("update site tls: %w", err)
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, settledStatus(site)); err != nil {
+		return fmt.Errorf("update site status: %w", err)
+	}
+	return nil
+}
+
+// runSuspendSite takes the vhost offline but keeps its files and metadata.
+func (s *Service) runSuspendSite(ctx context.Context, job store.Job, payload SitePayload) error {
+	if s.siteProvisioner == nil {
+		return errors.New("site provisioner is not configured")
+	}
+
+	site, err := s.repo.GetSiteByID(ctx, payload.SiteID)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+
+	s.jobLogf(job, "suspending site domain=%s", site.Domain)
+	if err := s.siteProvisioner.SuspendSite(ctx, site); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateSiteSuspended(ctx, site.ID, true); err != nil {
+		return fmt.Errorf("update site suspended: %w", err)
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusSuspended); err != nil {
+		return fmt.Errorf("update site status: %w", err)
+	}
+	return nil
+}
 
+// runResumeSite brings a suspended site or one in maintenance back online.
+// Maintenance state is cleared only once the normal vhost is live.
+func (s *Service) runResumeSite(ctx context.Context, job store.Job, payload SitePayload) error {
+	if s.siteProvisioner == nil {
+		return errors.New("site provisioner is not configured")
+	}
+
+	site, err := s.repo.GetSiteByID(ctx, payload.SiteID)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	site.Maintenance = nil
+	site.Suspended = false
+
+	s.jobLogf(job, "resuming site domain=%s", site.Domain)
+	if err := s.siteProvisioner.ProvisionSite(ctx, site); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateSiteMaintenance(ctx, site.ID, nil); err != nil {
+		return fmt.Errorf("update site maintenance: %w", err)
+	}
+	if err := s.repo.UpdateSiteSuspended(ctx, site.ID, false); err != nil {
+		return fmt.Errorf("update site suspended: %w", err)
+	}
 	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusActive); err != nil {
 		return fmt.Errorf("update site status: %w", err)
 	}
 	return nil
 }
 
-func (s *Service) runDeprovisionSite(ctx context.Context, job store.Job) error {
+// runMaintenanceSite reprovisions the vhost with the maintenance page and
+// stores the maintenance state only once that succeeded.
+func (s *Service) runMaintenanceSite(ctx context.Context, job store.Job, payload SiteMaintenancePayload) error {
 	if s.siteProvisioner == nil {
 		return errors.New("site provisioner is not configured")
 	}
 
-	var payload map[string]string
-	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
-		return fmt.Errorf("decode payload: %w", err)
+	site, err := s.repo.GetSiteByID(ctx, payload.SiteID)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
 	}
-	siteID := payload["site_id"]
-	if siteID == "" {
-		return errors.New("missing site_id in payload")
+	site.Maintenance = &store.SiteMaintenance{
+		AllowIPs:  payload.AllowIPs,
+		Page:      payload.Page,
+		StartedAt: time.Now().UTC(),
+	}
+
+	s.jobLogf(job, "enabling maintenance domain=%s allow=%d", site.Domain, len(payload.AllowIPs))
+	if err := s.siteProvisioner.ProvisionSite(ctx, site); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateSiteMaintenance(ctx, site.ID, site.Maintenance); err != nil {
+		return fmt.Errorf("update site maintenance: %w", err)
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, settledStatus(site)); err != nil {
+		return fmt.Errorf("update site status: %w", err)
+	}
+	return nil
+}
+
+// runReprovisionSite reprovisions the site with its new settings and stores them
+// only once that succeeded. On failure the previous settings are provisioned
+// again, so a pool or app unit created for the new ones does not linger.
+func (s *Service) runReprovisionSite(ctx context.Context, job store.Job, payload SiteUpdatePayload) error {
+	if s.siteProvisioner == nil {
+		return errors.New("site provisioner is not configured")
+	}
+
+	previous, err := s.repo.GetSiteByID(ctx, payload.SiteID)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	if previous.Unmanaged {
+		return errors.New("site vhost is not managed by the panel; adopt it first")
+	}
+	site := previous
+	site.RootPath = payload.RootPath
+	site.Runtime = payload.Runtime
+	site.UpstreamHost = payload.UpstreamHost
+	site.UpstreamPort = payload.UpstreamPort
+	site.PHPVersion = payload.PHPVersion
+	site.Proxy = payload.Proxy
+	site.Policy = payload.Policy
+	site.App = payload.App
+	site.CustomDirectives = payload.CustomDirectives
+
+	s.jobLogf(job, "updating site domain=%s runtime=%s root=%s", site.Domain, site.Runtime, site.RootPath)
+	if err := s.siteProvisioner.ProvisionSite(ctx, site); err != nil {
+		s.restoreSite(ctx, job, previous)
+		return err
+	}
+	if err := s.repo.UpdateSite(ctx, site); err != nil {
+		s.restoreSite(ctx, job, previous)
+		return fmt.Errorf("update site: %w", err)
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, settledStatus(site)); err != nil {
+		return fmt.Errorf("update site status: %w", err)
+	}
+	return nil
+}
+
+// restoreSite provisions the stored settings again after a failed update.
+// The site is marked failed only if that does not work either.
+func (s *Service) restoreSite(ctx context.Context, job store.Job, site store.Site) {
+	if err := s.siteProvisioner.ProvisionSite(ctx, site); err != nil {
+		s.jobLogf(job, "restoring previous settings failed domain=%s err=%v", site.Domain, err)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
+		return
+	}
+	s.jobLogf(job, "previous settings restored domain=%s", site.Domain)
+}
+
+// runReconcileSites overwrites hand-edited or missing vhosts with the
+// rendered config. Suspended sites only get their enabled link removed. A
+// failing site does not stop the others.
+func (s *Service) runReconcileSites(ctx context.Context, job store.Job, payload ReconcilePayload) error {
+	if s.siteProvisioner == nil {
+		return errors.New("site provisioner is not configured")
+	}
+
+	var failed []string
+	for _, id := range payload.SiteIDs {
+		site, err := s.repo.GetSiteByID(ctx, id)
+		if err != nil {
+			s.jobLogf(job, "reconcile skipped site=%s err=%v", id, err)
+			failed = append(failed, id)
+			continue
+		}
+
+		s.jobLogf(job, "reconciling site domain=%s status=%s", site.Domain, site.Status)
+		if site.Suspended {
+			err = s.siteProvisioner.SuspendSite(ctx, site)
+		} else {
+			err = s.siteProvisioner.ProvisionSite(ctx, site)
+		}
+		if err != nil {
+			s.jobLogf(job, "reconcile failed domain=%s err=%v", site.Domain, err)
+			failed = append(failed, site.Domain)
+			continue
+		}
+		if site.Status == store.SiteStatusFailed {
+			if err := s.repo.UpdateSiteStatus(ctx, site.ID, settledStatus(site)); err != nil {
+				return fmt.Errorf("update site status: %w", err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("reconcile failed for %d of %d sites: %s", len(failed), len(payload.SiteIDs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (s *Service) runDeprovisionSite(ctx context.Context, job store.Job, payload SitePayload) error {
+	if s.siteProvisioner == nil {
+		return errors.New("site provisioner is not configured")
 	}
 
-	site, err := s.repo.GetSiteByID(ctx, siteID)
+	site, err := s.repo.GetSiteByID(ctx, payload.SiteID)
 	if err != nil {
 		return fmt.Errorf("load site: %w", err)
 	}
 
+	s.jobLogf(job, "deprovisioning site domain=%s", site.Domain)
 	if err := s.siteProvisioner.DeprovisionSite(ctx, site); err != nil {
 		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
 		return err
@@ -244,6 +850,29 @@ func (s *Service) runDeprovisionSite(ctx context.Context, job store.Job) error {
 	return nil
 }
 
+func (s *Service) runCleanup(ctx context.Context, job store.Job, payload CleanupPayload) error {
+	retentionDays := defaultJobRetentionDays
+	if payload.JobRetentionDays > 0 {
+		retentionDays = payload.JobRetentionDays
+	}
+
+	now := time.Now().UTC()
+	sessions, err := s.repo.DeleteExpiredSessions(ctx, now)
+	if err != nil {
+		return fmt.Errorf("prune sessions: %w", err)
+	}
+	jobsRemoved, err := s.repo.DeleteJobsFinishedBefore(ctx, now.AddDate(0, 0, -retentionDays))
+	if err != nil {
+		return fmt.Errorf("prune jobs: %w", err)
+	}
+	keys, err := s.repo.DeleteExpiredIdempotencyRecords(ctx, now)
+	if err != nil {
+		return fmt.Errorf("prune idempotency keys: %w", err)
+	}
+	s.jobLogf(job, "cleanup done sessions=%d jobs=%d idempotency_keys=%d retention_days=%d", sessions, jobsRemoved, keys, retentionDays)
+	return nil
+}
+
 func (s *Service) logf(format string, args ...any) {
 	if s.logger != nil {
 		s.logger.Printf(format, args...)
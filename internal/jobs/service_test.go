@@ -3,6 +3,7 @@
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"path/filepath"
 	"testing"
@@ -16,10 +17,13 @@ type fakeProvisioner struct {
 	err              error
 	provisionCount   int
 	deprovisionCount int
+	suspendCount     int
+	lastSite         store.Site
 }
 
-func (f *fakeProvisioner) ProvisionSite(_ context.Context, _ store.Site) error {
+func (f *fakeProvisioner) ProvisionSite(_ context.Context, site store.Site) error {
 	f.provisionCount++
+	f.lastSite = site
 	return f.err
 }
 
@@ -28,6 +32,11 @@ func (f *fakeProvisioner) DeprovisionSite(_ context.Context, _ store.Site) error
 	return f.err
 }
 
+func (f *fakeProvisioner) SuspendSite(_ context.Context, _ store.Site) error {
+	f.suspendCount++
+	return f.err
+}
+
 func TestServiceRunsProvisionJob(t *testing.T) {
 	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
 	if err != nil {
@@ -50,7 +59,7 @@ func TestServiceRunsProvisionJob(t *testing.T) {
 		t.Fatalf("create site: %v", err)
 	}
 
-	svc := NewService(repo, log.New(testWriter{t}, "", 0), &fakeProvisioner{})
+	svc := NewService(repo, log.New(testWriter{t}, "", 0), &fakeProvisioner{}, nil)
 	svc.Start(ctx)
 	defer func() {
 		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -111,7 +120,7 @@ func TestServiceRunsDeprovisionJob(t *testing.T) {
 	}
 
 	fake := &fakeProvisioner{}
-	svc := NewService(repo, nil, fake)
+	svc := NewService(repo, nil, fake, nil)
 	svc.Start(ctx)
 	defer func() {
 		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -172,7 +181,7 @@ func TestServiceHandlesBadPayload(t *testing.T) {
 		t.Fatalf("create job: %v", err)
 	}
 
-	svc := NewService(repo, nil, &fakeProvisioner{})
+	svc := NewService(repo, nil, &fakeProvisioner{}, nil)
 	svc.Start(context.Background())
 	defer func() {
 		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -199,3 +208,384 @@ func TestServiceHandlesBadPayload(t *testing.T) {
 	t.Fatalf("job did not transition to failed")
 }
 
+func TestServiceRunsCleanupJob(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -60)
+	if err := repo.CreateSession(ctx, store.Session{TokenHash: "expired", UserID: "usr-1", ExpiresAt: now.Add(-time.Hour), CreatedAt: old}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if err := repo.CreateJob(ctx, store.Job{ID: "job-old", Type: store.JobTypeProvisionSite, Status: store.JobStatusSuccess, Payload: "{}", CreatedAt: old, FinishedAt: &old}); err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+
+	svc := NewService(repo, nil, &fakeProvisioner{}, nil)
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	job, err := svc.Enqueue(ctx, "usr-1", store.JobTypeCleanup, CleanupPayload{JobRetentionDays: 30})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	var final store.Job
+	for time.Now().Before(deadline) {
+		final, _ = svc.Get(ctx, job.ID)
+		if final.Status == store.JobStatusSuccess || final.Status == store.JobStatusFailed {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if final.Status != store.JobStatusSuccess {
+		t.Fatalf("job status = %s err=%s", final.Status, final.Error)
+	}
+	if _, err := repo.GetSessionByTokenHash(ctx, "expired"); err == nil {
+		t.Fatalf("expected expired session pruned")
+	}
+	if _, err := repo.GetJobByID(ctx, "job-old"); err == nil {
+		t.Fatalf("expected old job pruned")
+	}
+}
+
+func TestServiceRunsEnableSiteTLSJob(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, id := range []string{"site-ok", "site-bad"} {
+		if err := repo.CreateSite(ctx, store.Site{
+			ID:        id,
+			Domain:    id + ".example.com",
+			RootPath:  "/var/www/" + id,
+			Runtime:   "static",
+			Status:    store.SiteStatusActive,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}); err != nil {
+			t.Fatalf("create site: %v", err)
+		}
+	}
+
+	provisioner := &fakeProvisioner{}
+	svc := NewService(repo, log.New(testWriter{t}, "", 0), provisioner, nil)
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	waitJob := func(id string) store.Job {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			job, err := svc.Get(ctx, id)
+			if err == nil && (job.Status == store.JobStatusSuccess || job.Status == store.JobStatusFailed) {
+				return job
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not finish", id)
+		return store.Job{}
+	}
+
+	job, err := svc.Enqueue(ctx, "usr-1", store.JobTypeEnableSiteTLS, SiteTLSPayload{
+		SiteID: "site-ok", Email: "admin@example.com", CertPath: "/certs/fullchain.pem", KeyPath: "/certs/privkey.pem", HSTS: true,
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if final := waitJob(job.ID); final.Status != store.JobStatusSuccess {
+		t.Fatalf("job status = %s err=%s", final.Status, final.Error)
+	}
+	site, err := repo.GetSiteByID(ctx, "site-ok")
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if site.TLS == nil || site.TLS.CertPath != "/certs/fullchain.pem" || !site.TLS.HSTS {
+		t.Fatalf("tls state not stored: %+v", site.TLS)
+	}
+
+	provisioner.err = errors.New("nginx test failed")
+	job, err = svc.Enqueue(ctx, "usr-1", store.JobTypeEnableSiteTLS, SiteTLSPayload{
+		SiteID: "site-bad", Email: "admin@example.com", CertPath: "/certs/fullchain.pem", KeyPath: "/certs/privkey.pem",
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if final := waitJob(job.ID); final.Status != store.JobStatusFailed {
+		t.Fatalf("expected failed job, got %s", final.Status)
+	}
+	site, err = repo.GetSiteByID(ctx, "site-bad")
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if site.TLS != nil {
+		t.Fatalf("tls state must not be stored after a failed provision: %+v", site.TLS)
+	}
+}
+
+func TestServiceRunsReprovisionJob(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, id := range []string{"site-ok", "site-bad"} {
+		if err := repo.CreateSite(ctx, store.Site{
+			ID:         id,
+			Domain:     id + ".example.com",
+			RootPath:   "/var/www/" + id,
+			Runtime:    "php",
+			PHPVersion: "8.2",
+			Status:     store.SiteStatusActive,
+			CreatedBy:  "usr-1",
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}); err != nil {
+			t.Fatalf("create site: %v", err)
+		}
+	}
+
+	provisioner := &fakeProvisioner{}
+	svc := NewService(repo, log.New(testWriter{t}, "", 0), provisioner, nil)
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	waitJob := func(id string) store.Job {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			job, err := svc.Get(ctx, id)
+			if err == nil && (job.Status == store.JobStatusSuccess || job.Status == store.JobStatusFailed) {
+				return job
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not finish", id)
+		return store.Job{}
+	}
+
+	job, err := svc.Enqueue(ctx, "usr-1", store.JobTypeReprovisionSite, SiteUpdatePayload{
+		SiteID: "site-ok", RootPath: "/srv/site-ok", Runtime: "node", UpstreamHost: "127.0.0.1", UpstreamPort: 3000,
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if final := waitJob(job.ID); final.Status != store.JobStatusSuccess {
+		t.Fatalf("job status = %s err=%s", final.Status, final.Error)
+	}
+	site, err := repo.GetSiteByID(ctx, "site-ok")
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if site.Runtime != "node" || site.RootPath != "/srv/site-ok" || site.UpstreamPort != 3000 || site.PHPVersion != "" {
+		t.Fatalf("settings not stored: %+v", site)
+	}
+	if site.Domain != "site-ok.example.com" || site.CreatedBy != "usr-1" || site.Status != store.SiteStatusActive {
+		t.Fatalf("unrelated fields changed: %+v", site)
+	}
+
+	provisioner.err = errors.New("nginx test failed")
+	provisioner.provisionCount = 0
+	job, err = svc.Enqueue(ctx, "usr-1", store.JobTypeReprovisionSite, SiteUpdatePayload{
+		SiteID: "site-bad", RootPath: "/srv/site-bad", Runtime: "static",
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if final := waitJob(job.ID); final.Status != store.JobStatusFailed {
+		t.Fatalf("expected failed job, got %s", final.Status)
+	}
+	if provisioner.provisionCount != 2 || provisioner.lastSite.Runtime != "php" || provisioner.lastSite.RootPath != "/var/www/site-bad" {
+		t.Fatalf("previous settings not provisioned again: count=%d site=%+v", provisioner.provisionCount, provisioner.lastSite)
+	}
+	site, err = repo.GetSiteByID(ctx, "site-bad")
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if site.Runtime != "php" || site.PHPVersion != "8.2" {
+		t.Fatalf("settings must not be stored after a failed provision: %+v", site)
+	}
+	if site.Status != store.SiteStatusFailed {
+		t.Fatalf("site status = %s, want failed when the restore fails too", site.Status)
+	}
+}
+
+func TestServiceRunsSuspendAndMaintenanceJobs(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := repo.CreateSite(ctx, store.Site{
+		ID:        "site-1",
+		Domain:    "example.com",
+		RootPath:  "/var/www/example.com",
+		Runtime:   "static",
+		Status:    store.SiteStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}); err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+
+	provisioner := &fakeProvisioner{}
+	svc := NewService(repo, log.New(testWriter{t}, "", 0), provisioner, nil)
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	run := func(jobType string, payload any, want string) store.Site {
+		t.Helper()
+		job, err := svc.Enqueue(ctx, "usr-1", jobType, payload)
+		if err != nil {
+			t.Fatalf("enqueue %s: %v", jobType, err)
+		}
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			job, err = svc.Get(ctx, job.ID)
+			if err == nil && (job.Status == store.JobStatusSuccess || job.Status == store.JobStatusFailed) {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if job.Status != want {
+			t.Fatalf("%s status = %s err=%s", jobType, job.Status, job.Error)
+		}
+		site, err := repo.GetSiteByID(ctx, "site-1")
+		if err != nil {
+			t.Fatalf("get site: %v", err)
+		}
+		return site
+	}
+
+	site := run(store.JobTypeMaintenanceSite, SiteMaintenancePayload{SiteID: "site-1", AllowIPs: []string{"10.0.0.0/8"}}, store.JobStatusSuccess)
+	if site.Status != store.SiteStatusMaintenance || site.Maintenance == nil || site.Maintenance.AllowIPs[0] != "10.0.0.0/8" {
+		t.Fatalf("maintenance not stored: status=%s %+v", site.Status, site.Maintenance)
+	}
+	if provisioner.lastSite.Maintenance == nil {
+		t.Fatalf("provisioner did not receive maintenance state")
+	}
+
+	provisioner.err = errors.New("nginx test failed")
+	site = run(store.JobTypeResumeSite, SitePayload{SiteID: "site-1"}, store.JobStatusFailed)
+	if site.Maintenance == nil {
+		t.Fatalf("maintenance must stay after a failed resume")
+	}
+
+	provisioner.err = nil
+	site = run(store.JobTypeSuspendSite, SitePayload{SiteID: "site-1"}, store.JobStatusSuccess)
+	if site.Status != store.SiteStatusSuspended || provisioner.suspendCount != 1 {
+		t.Fatalf("site not suspended: status=%s count=%d", site.Status, provisioner.suspendCount)
+	}
+	site = run(store.JobTypeProvisionSite, SitePayload{SiteID: "site-1", Domain: "site-1.example.com"}, store.JobStatusSuccess)
+	if site.Status != store.SiteStatusSuspended || !provisioner.lastSite.Suspended {
+		t.Fatalf("provisioning un-suspended the site: status=%s provisioned suspended=%t", site.Status, provisioner.lastSite.Suspended)
+	}
+
+	site = run(store.JobTypeResumeSite, SitePayload{SiteID: "site-1"}, store.JobStatusSuccess)
+	if site.Status != store.SiteStatusActive || site.Maintenance != nil {
+		t.Fatalf("site not resumed: status=%s %+v", site.Status, site.Maintenance)
+	}
+	if provisioner.lastSite.Maintenance != nil || provisioner.lastSite.Suspended || site.Suspended {
+		t.Fatalf("resume must provision without maintenance or suspension")
+	}
+
+	if _, err := svc.Enqueue(ctx, "usr-1", store.JobTypeMaintenanceSite, SiteMaintenancePayload{SiteID: "site-1", AllowIPs: []string{"nope"}}); err == nil {
+		t.Fatalf("expected invalid allow_ips to be rejected")
+	}
+}
+
+func TestServiceRunsReconcileJob(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for id, status := range map[string]string{
+		"site-failed":    store.SiteStatusFailed,
+		"site-suspended": store.SiteStatusSuspended,
+	} {
+		if err := repo.CreateSite(ctx, store.Site{
+			ID:        id,
+			Domain:    id + ".example.com",
+			RootPath:  "/var/www/" + id,
+			Runtime:   "static",
+			Status:    status,
+			Suspended: status == store.SiteStatusSuspended,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}); err != nil {
+			t.Fatalf("create site: %v", err)
+		}
+	}
+
+	provisioner := &fakeProvisioner{}
+	svc := NewService(repo, log.New(testWriter{t}, "", 0), provisioner, nil)
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	job, err := svc.Enqueue(ctx, "usr-1", store.JobTypeReconcileSites, ReconcilePayload{
+		SiteIDs: []string{"site-failed", "site-suspended"},
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err = svc.Get(ctx, job.ID)
+		if err == nil && (job.Status == store.JobStatusSuccess || job.Status == store.JobStatusFailed) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if job.Status != store.JobStatusSuccess {
+		t.Fatalf("job status = %s err=%s", job.Status, job.Error)
+	}
+	if provisioner.provisionCount != 1 || provisioner.suspendCount != 1 {
+		t.Fatalf("provision=%d suspend=%d, want 1 and 1", provisioner.provisionCount, provisioner.suspendCount)
+	}
+	site, err := repo.GetSiteByID(ctx, "site-failed")
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if site.Status != store.SiteStatusActive {
+		t.Fatalf("reconciled failed site status = %s", site.Status)
+	}
+}
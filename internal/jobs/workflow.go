@@ -0,0 +1,335 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"nusantara/internal/idgen"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidWorkflow = errors.New("invalid workflow")
+	ErrNotWorkflow     = errors.New("job is not a workflow")
+)
+
+// WorkflowStep is one job of a workflow. DependsOn lists names of earlier
+// steps that must succeed before this step is queued.
+type WorkflowStep struct {
+	Name      string
+	JobType   string
+	Payload   any
+	DependsOn []string
+}
+
+type WorkflowSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	Pending   int `json:"pending"`
+}
+
+type Workflow struct {
+	Job     store.Job       `json:"job"`
+	Steps   []store.Job     `json:"steps"`
+	Summary WorkflowSummary `json:"summary"`
+}
+
+type workflowPayload struct {
+	Name  string   `json:"name"`
+	Steps []string `json:"steps"`
+}
+
+// EnqueueWorkflow stores a parent job of type workflow plus one child job per
+// step. Steps without dependencies are queued right away; the rest wait until
+// their prerequisites finish and are skipped if any of them did not succeed.
+func (s *Service) EnqueueWorkflow(ctx context.Context, triggeredBy, name string, steps []WorkflowStep) (Workflow, error) {
+	if err := s.checkRunning(); err != nil {
+		return Workflow{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(steps) == 0 {
+		return Workflow{}, ErrInvalidWorkflow
+	}
+
+	bodies := make([]string, len(steps))
+	stepNames := make([]string, len(steps))
+	seen := make(map[string]struct{}, len(steps))
+	for i, step := range steps {
+		if step.Name == "" {
+			return Workflow{}, fmt.Errorf("%w: step %d has no name", ErrInvalidWorkflow, i)
+		}
+		if _, dup := seen[step.Name]; dup {
+			return Workflow{}, fmt.Errorf("%w: duplicate step %s", ErrInvalidWorkflow, step.Name)
+		}
+		// Dependencies must point at earlier steps, which also rules out cycles.
+		for _, dep := range step.DependsOn {
+			if _, ok := seen[dep]; !ok {
+				return Workflow{}, fmt.Errorf("%w: step %s depends on unknown step %s", ErrInvalidWorkflow, step.Name, dep)
+			}
+		}
+		body, err := s.encodePayload(step.JobType, step.Payload)
+		if err != nil {
+			return Workflow{}, err
+		}
+		seen[step.Name] = struct{}{}
+		bodies[i] = body
+		stepNames[i] = step.Name
+	}
+
+	parentBody, err := json.Marshal(workflowPayload{Name: name, Steps: stepNames})
+	if err != nil {
+		return Workflow{}, err
+	}
+	parentID, err := idgen.New("job")
+	if err != nil {
+		return Workflow{}, err
+	}
+	now := time.Now().UTC()
+	parent := store.Job{
+		ID:          parentID,
+		Type:        store.JobTypeWorkflow,
+		Status:      store.JobStatusRunning,
+		Payload:     string(parentBody),
+		StartedAt:   &now,
+		CreatedAt:   now,
+		TriggeredBy: triggeredBy,
+	}
+	if err := s.repo.CreateJob(ctx, parent); err != nil {
+		return Workflow{}, err
+	}
+	s.publishJobEvent("job.created", parent)
+
+	children := make([]store.Job, 0, len(steps))
+	idsByName := make(map[string]string, len(steps))
+	for i, step := range steps {
+		jobID, err := idgen.New("job")
+		if err != nil {
+			s.abortWorkflow(ctx, parent, children, err)
+			return Workflow{}, err
+		}
+		dependsOn := make([]string, 0, len(step.DependsOn))
+		for _, dep := range step.DependsOn {
+			dependsOn = append(dependsOn, idsByName[dep])
+		}
+		status := store.JobStatusQueued
+		if len(dependsOn) > 0 {
+			status = store.JobStatusWaiting
+		}
+		child := store.Job{
+			ID:          jobID,
+			Type:        step.JobType,
+			Status:      status,
+			Payload:     s.holdSecret(jobID, step.JobType, bodies[i]),
+			CreatedAt:   now,
+			TriggeredBy: triggeredBy,
+			ParentID:    parentID,
+			Step:        step.Name,
+			DependsOn:   dependsOn,
+		}
+		if err := s.repo.CreateJob(ctx, child); err != nil {
+			s.dropSecret(jobID)
+			s.abortWorkflow(ctx, parent, children, err)
+			return Workflow{}, err
+		}
+		s.publishJobEvent("job.created", child)
+		idsByName[step.Name] = jobID
+		children = append(children, child)
+	}
+
+	// Every row exists now, so the steps are handed to the worker under the
+	// service's context: a caller that goes away no longer strands them.
+	s.mu.RLock()
+	runCtx := s.runCtx
+	s.mu.RUnlock()
+	for _, child := range children {
+		if child.Status == store.JobStatusQueued {
+			s.requeue(runCtx, child.ID)
+		}
+	}
+	return s.GetWorkflow(ctx, parentID)
+}
+
+// abortWorkflow fails a workflow whose steps could not all be stored,
+// together with the steps that were. None of them was queued yet.
+func (s *Service) abortWorkflow(ctx context.Context, parent store.Job, children []store.Job, cause error) {
+	ctx = context.WithoutCancel(ctx)
+	finishedAt := time.Now().UTC()
+	msg := fmt.Sprintf("workflow not created: %v", cause)
+	for _, child := range children {
+		s.dropSecret(child.ID)
+		if err := s.repo.UpdateJob(ctx, child.ID, store.JobStatusFailed, msg, nil, &finishedAt); err != nil {
+			s.logf("workflow abort failed id=%s err=%v", child.ID, err)
+			continue
+		}
+		s.publishJob(ctx, child.ID)
+	}
+	if err := s.repo.UpdateJob(ctx, parent.ID, store.JobStatusFailed, msg, parent.StartedAt, &finishedAt); err != nil {
+		s.logf("workflow abort failed id=%s err=%v", parent.ID, err)
+		return
+	}
+	s.publishJob(ctx, parent.ID)
+}
+
+// GetWorkflow returns the parent job, its steps in definition order and a
+// per-status summary.
+func (s *Service) GetWorkflow(ctx context.Context, id string) (Workflow, error) {
+	parent, err := s.repo.GetJobByID(ctx, id)
+	if err != nil {
+		return Workflow{}, err
+	}
+	if parent.Type != store.JobTypeWorkflow {
+		return Workflow{}, ErrNotWorkflow
+	}
+	children, err := s.repo.ListJobsByParent(ctx, id)
+	if err != nil {
+		return Workflow{}, err
+	}
+
+	var payload workflowPayload
+	_ = json.Unmarshal([]byte(parent.Payload), &payload)
+	order := make(map[string]int, len(payload.Steps))
+	for i, name := range payload.Steps {
+		order[name] = i
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return order[children[i].Step] < order[children[j].Step]
+	})
+	for i := range children {
+		children[i] = s.redactedJob(children[i])
+	}
+	return Workflow{Job: parent, Steps: children, Summary: summarize(children)}, nil
+}
+
+// advanceWorkflow runs on the worker after a step finishes. It queues steps
+// whose dependencies all succeeded, skips steps behind a failed dependency and
+// closes the parent job once every step is terminal.
+func (s *Service) advanceWorkflow(ctx context.Context, parentID string) {
+	children, err := s.repo.ListJobsByParent(ctx, parentID)
+	if err != nil {
+		s.logf("workflow load failed id=%s err=%v", parentID, err)
+		return
+	}
+	byID := make(map[string]*store.Job, len(children))
+	for i := range children {
+		byID[children[i].ID] = &children[i]
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := range children {
+			child := &children[i]
+			if child.Status != store.JobStatusWaiting {
+				continue
+			}
+			ready, blockedBy := true, ""
+			for _, depID := range child.DependsOn {
+				dep, ok := byID[depID]
+				if !ok {
+					blockedBy = depID
+					break
+				}
+				switch dep.Status {
+				case store.JobStatusSuccess:
+				case store.JobStatusFailed, store.JobStatusSkipped:
+					blockedBy = dep.Step
+				default:
+					ready = false
+				}
+				if blockedBy != "" {
+					break
+				}
+			}
+			switch {
+			case blockedBy != "":
+				finishedAt := time.Now().UTC()
+				msg := fmt.Sprintf("skipped: dependency %s did not succeed", blockedBy)
+				if err := s.repo.UpdateJob(ctx, child.ID, store.JobStatusSkipped, msg, nil, &finishedAt); err != nil {
+					s.logf("workflow skip failed id=%s err=%v", child.ID, err)
+					return
+				}
+				child.Status = store.JobStatusSkipped
+				// A skipped step never runs, so its secrets go now.
+				s.dropSecret(child.ID)
+				s.redact(ctx, *child)
+				s.publishJob(ctx, child.ID)
+				changed = true
+			case ready:
+				if err := s.repo.UpdateJob(ctx, child.ID, store.JobStatusQueued, "", nil, nil); err != nil {
+					s.logf("workflow queue failed id=%s err=%v", child.ID, err)
+					return
+				}
+				child.Status = store.JobStatusQueued
+				s.publishJob(ctx, child.ID)
+				s.requeue(ctx, child.ID)
+			}
+		}
+	}
+
+	summary := summarize(children)
+	if summary.Pending > 0 {
+		return
+	}
+	parent, err := s.repo.GetJobByID(ctx, parentID)
+	if err != nil {
+		s.logf("workflow load failed id=%s err=%v", parentID, err)
+		return
+	}
+	status, msg := store.JobStatusSuccess, ""
+	if summary.Succeeded != summary.Total {
+		status = store.JobStatusFailed
+		failed := make([]string, 0, summary.Failed)
+		for _, child := range children {
+			if child.Status == store.JobStatusFailed {
+				failed = append(failed, child.Step)
+			}
+		}
+		msg = fmt.Sprintf("%d of %d steps succeeded; failed: %s; skipped: %d",
+			summary.Succeeded, summary.Total, strings.Join(failed, ","), summary.Skipped)
+	}
+	finishedAt := time.Now().UTC()
+	if err := s.repo.UpdateJob(ctx, parentID, status, msg, parent.StartedAt, &finishedAt); err != nil {
+		s.logf("workflow finish write failed id=%s err=%v", parentID, err)
+		return
+	}
+	s.jobLogf(parent, "workflow finished status=%s", status)
+	s.publishJob(ctx, parentID)
+}
+
+// requeue hands a job to the worker without blocking, since it also runs
+// on the worker goroutine itself; a full queue is fed in the background.
+func (s *Service) requeue(ctx context.Context, jobID string) {
+	select {
+	case s.queue <- jobID:
+	default:
+		go func() {
+			select {
+			case s.queue <- jobID:
+			case <-ctx.Done():
+			}
+		}()
+	}
+}
+
+func summarize(steps []store.Job) WorkflowSummary {
+	summary := WorkflowSummary{Total: len(steps)}
+	for _, step := range steps {
+		switch step.Status {
+		case store.JobStatusSuccess:
+			summary.Succeeded++
+		case store.JobStatusFailed:
+			summary.Failed++
+		case store.JobStatusSkipped:
+			summary.Skipped++
+		default:
+			summary.Pending++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func newWorkflowService(t *testing.T) *Service {
+	t.Helper()
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return NewService(repo, nil, nil, nil)
+}
+
+func waitWorkflow(t *testing.T, svc *Service, id string) Workflow {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		wf, err := svc.GetWorkflow(context.Background(), id)
+		if err == nil && wf.Job.Status != store.JobStatusRunning {
+			return wf
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("workflow %s did not finish", id)
+	return Workflow{}
+}
+
+func TestWorkflowRunsStepsInDependencyOrder(t *testing.T) {
+	svc := newWorkflowService(t)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := HandlerFunc(func(_ context.Context, job store.Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, job.Step)
+		return nil
+	})
+	if err := svc.Register("record", record); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	ctx := context.Background()
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	wf, err := svc.EnqueueWorkflow(ctx, "usr-1", "chain", []WorkflowStep{
+		{Name: "a", JobType: "record"},
+		{Name: "b", JobType: "record", DependsOn: []string{"a"}},
+		{Name: "c", JobType: "record", DependsOn: []string{"b"}},
+	})
+	if err != nil {
+		t.Fatalf("enqueue workflow: %v", err)
+	}
+	final := waitWorkflow(t, svc, wf.Job.ID)
+	if final.Job.Status != store.JobStatusSuccess || final.Summary.Succeeded != 3 {
+		t.Fatalf("unexpected workflow result %+v", final)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "c" {
+		t.Fatalf("unexpected run order %v", order)
+	}
+}
+
+func TestWorkflowSkipsStepsAfterFailure(t *testing.T) {
+	svc := newWorkflowService(t)
+	_ = svc.Register("ok", HandlerFunc(func(context.Context, store.Job) error { return nil }))
+	_ = svc.Register("fail", HandlerFunc(func(context.Context, store.Job) error { return errors.New("boom") }))
+	_ = svc.Register("secret", Typed(func(context.Context, store.Job, secretPayload) error { return nil }))
+	ctx := context.Background()
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	wf, err := svc.EnqueueWorkflow(ctx, "usr-1", "partial", []WorkflowStep{
+		{Name: "site", JobType: "fail"},
+		{Name: "ssl", JobType: "ok", DependsOn: []string{"site"}},
+		{Name: "ssl_check", JobType: "secret", Payload: secretPayload{Name: "db", Password: "hunter22"}, DependsOn: []string{"ssl"}},
+		{Name: "database", JobType: "ok"},
+	})
+	if err != nil {
+		t.Fatalf("enqueue workflow: %v", err)
+	}
+	if strings.Contains(wf.Steps[2].Payload, "hunter22") {
+		t.Fatalf("enqueued step returned its secret: %s", wf.Steps[2].Payload)
+	}
+	final := waitWorkflow(t, svc, wf.Job.ID)
+	if final.Job.Status != store.JobStatusFailed {
+		t.Fatalf("workflow status = %s", final.Job.Status)
+	}
+	want := WorkflowSummary{Total: 4, Succeeded: 1, Failed: 1, Skipped: 2}
+	if final.Summary != want {
+		t.Fatalf("summary = %+v, want %+v", final.Summary, want)
+	}
+	if final.Steps[0].Step != "site" || final.Steps[3].Step != "database" {
+		t.Fatalf("steps not in definition order: %+v", final.Steps)
+	}
+	if strings.Contains(final.Steps[2].Payload, "hunter22") {
+		t.Fatalf("skipped step kept its secret: %s", final.Steps[2].Payload)
+	}
+	svc.secretsMu.Lock()
+	held := len(svc.secrets)
+	svc.secretsMu.Unlock()
+	if held != 0 {
+		t.Fatalf("skipped step secret still held in memory")
+	}
+}
+
+func TestEnqueueWorkflowRejectsUnknownDependency(t *testing.T) {
+	svc := newWorkflowService(t)
+	svc.Start(context.Background())
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	_, err := svc.EnqueueWorkflow(context.Background(), "usr-1", "bad", []WorkflowStep{
+		{Name: "a", JobType: store.JobTypeCleanup, DependsOn: []string{"b"}},
+		{Name: "b", JobType: store.JobTypeCleanup},
+	})
+	if !errors.Is(err, ErrInvalidWorkflow) {
+		t.Fatalf("expected invalid workflow, got %v", err)
+	}
+}
+
+func TestStartRecoversJobsLeftByPreviousRun(t *testing.T) {
+	svc := newWorkflowService(t)
+	ran := make(chan string, 4)
+	_ = svc.Register("ok", HandlerFunc(func(_ context.Context, job store.Job) error {
+		ran <- job.ID
+		return nil
+	}))
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, job := range []store.Job{
+		{ID: "job_wf", Type: store.JobTypeWorkflow, Status: store.JobStatusRunning, Payload: `{"name":"wf","steps":["a","b"]}`, StartedAt: &now, CreatedAt: now},
+		{ID: "job_a", Type: "ok", Status: store.JobStatusSuccess, Payload: "{}", CreatedAt: now, ParentID: "job_wf", Step: "a"},
+		{ID: "job_b", Type: "ok", Status: store.JobStatusWaiting, Payload: "{}", CreatedAt: now, ParentID: "job_wf", Step: "b", DependsOn: []string{"job_a"}},
+		{ID: "job_queued", Type: "ok", Status: store.JobStatusQueued, Payload: "{}", CreatedAt: now},
+		{ID: "job_running", Type: "ok", Status: store.JobStatusRunning, Payload: "{}", StartedAt: &now, CreatedAt: now},
+	} {
+		if err := svc.repo.CreateJob(ctx, job); err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+	}
+
+	svc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = svc.Stop(stopCtx)
+	}()
+
+	if final := waitWorkflow(t, svc, "job_wf"); final.Job.Status != store.JobStatusSuccess {
+		t.Fatalf("stranded workflow = %+v", final)
+	}
+	if queued := waitJob(t, svc, "job_queued"); queued.Status != store.JobStatusSuccess {
+		t.Fatalf("queued job status = %s", queued.Status)
+	}
+	interrupted, err := svc.Get(ctx, "job_running")
+	if err != nil || interrupted.Status != store.JobStatusFailed {
+		t.Fatalf("interrupted job = %+v err=%v", interrupted, err)
+	}
+	if len(ran) != 2 {
+		t.Fatalf("expected only the waiting step and the queued job to run, ran %d", len(ran))
+	}
+}
@@ -0,0 +1,112 @@
+package php
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidVersion  = errors.New("invalid php version")
+	ErrVersionNotFound = errors.New("php-fpm version is not installed")
+	ErrNoVersionsFound = errors.New("no php-fpm installation found")
+	versionPattern     = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+	socketNamePattern  = regexp.MustCompile(`^php([0-9]+\.[0-9]+)-fpm\.sock$`)
+)
+
+const DefaultRunDir = "/run/php"
+
+type Version struct {
+	Version string `json:"version"`
+	Socket  string `json:"socket"`
+}
+
+// Detector finds PHP-FPM versions by their default pool sockets
+// (php<major.minor>-fpm.sock) in the FPM run directory.
+type Detector struct {
+	runDir string
+}
+
+func NewDetector(runDir string) *Detector {
+	if strings.TrimSpace(runDir) == "" {
+		runDir = DefaultRunDir
+	}
+	return &Detector{runDir: runDir}
+}
+
+func (d *Detector) RunDir() string {
+	return d.runDir
+}
+
+// Versions lists installed versions, newest first.
+func (d *Detector) Versions() ([]Version, error) {
+	entries, err := os.ReadDir(d.runDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Version{}, nil
+		}
+		return nil, fmt.Errorf("read php-fpm run dir: %w", err)
+	}
+	items := make([]Version, 0, len(entries))
+	for _, entry := range entries {
+		m := socketNamePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		items = append(items, Version{Version: m[1], Socket: filepath.Join(d.runDir, entry.Name())})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return compareVersions(items[i].Version, items[j].Version) > 0
+	})
+	return items, nil
+}
+
+func (d *Detector) SocketPath(version string) string {
+	return filepath.Join(d.runDir, "php"+version+"-fpm.sock")
+}
+
+// Resolve returns the installed version to use for a site. An empty version
+// selects the newest installed one.
+func (d *Detector) Resolve(version string) (Version, error) {
+	version = strings.TrimSpace(version)
+	if version != "" && !ValidVersion(version) {
+		return Version{}, ErrInvalidVersion
+	}
+	versions, err := d.Versions()
+	if err != nil {
+		return Version{}, err
+	}
+	if version == "" {
+		if len(versions) == 0 {
+			return Version{}, ErrNoVersionsFound
+		}
+		return versions[0], nil
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("%w: %s", ErrVersionNotFound, version)
+}
+
+func ValidVersion(version string) bool {
+	return versionPattern.MatchString(version)
+}
+
+func compareVersions(a, b string) int {
+	pa, pb := strings.SplitN(a, ".", 2), strings.SplitN(b, ".", 2)
+	for i := 0; i < 2; i++ {
+		na, _ := strconv.Atoi(pa[i])
+		nb, _ := strconv.Atoi(pb[i])
+		if na != nb {
+			return na - nb
+		}
+	}
+	return 0
+}
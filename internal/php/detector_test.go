@@ -0,0 +1,48 @@
+package php
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectorVersionsNewestFirst(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"php8.1-fpm.sock", "php8.3-fpm.sock", "php7.4-fpm.sock", "php-fpm.sock", "php8.3-fpm.pid"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	d := NewDetector(dir)
+	versions, err := d.Versions()
+	if err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	if len(versions) != 3 || versions[0].Version != "8.3" || versions[2].Version != "7.4" {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	latest, err := d.Resolve("")
+	if err != nil || latest.Version != "8.3" || latest.Socket != filepath.Join(dir, "php8.3-fpm.sock") {
+		t.Fatalf("resolve default = %+v err=%v", latest, err)
+	}
+	if _, err := d.Resolve("8.2"); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("expected missing version error, got %v", err)
+	}
+	if _, err := d.Resolve("8"); !errors.Is(err, ErrInvalidVersion) {
+		t.Fatalf("expected invalid version error, got %v", err)
+	}
+}
+
+func TestDetectorMissingRunDir(t *testing.T) {
+	d := NewDetector(filepath.Join(t.TempDir(), "missing"))
+	versions, err := d.Versions()
+	if err != nil || len(versions) != 0 {
+		t.Fatalf("expected no versions, got %+v err=%v", versions, err)
+	}
+	if _, err := d.Resolve(""); !errors.Is(err, ErrNoVersionsFound) {
+		t.Fatalf("expected no versions error, got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package provision
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nusantara/internal/store"
+)
+
+var ErrInvalidAccess = errors.New("invalid access rules")
+
+const (
+	defaultHtpasswdDir = "/etc/nusantara-panel/htpasswd"
+	defaultAccessRealm = "Restricted"
+	maxAccessUsers     = 100
+	maxAccessAddresses = 100
+	maxAccessPaths     = 20
+)
+
+var (
+	accessUserPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+	accessRealmPattern = regexp.MustCompile(`^[A-Za-z0-9 ._-]{1,64}$`)
+	accessPathPattern  = regexp.MustCompile(`^/[A-Za-z0-9._~/-]{0,199}$`)
+)
+
+// ValidateAccessUsername rejects names htpasswd cannot hold.
+func ValidateAccessUsername(name string) error {
+	if !accessUserPattern.MatchString(name) {
+		return fmt.Errorf("%w: username must be 1-32 letters, digits, '.', '_' or '-'", ErrInvalidAccess)
+	}
+	return nil
+}
+
+// ValidateAccess checks the rules before they are stored or rendered.
+// Addresses must already be in canonical form (see net.ParseCIDR).
+func ValidateAccess(access *store.SiteAccess) error {
+	if access == nil {
+		return nil
+	}
+	if len(access.Users) > maxAccessUsers {
+		return fmt.Errorf("%w: at most %d users", ErrInvalidAccess, maxAccessUsers)
+	}
+	for _, name := range access.Users {
+		if err := ValidateAccessUsername(name); err != nil {
+			return err
+		}
+	}
+	if access.Realm != "" && !accessRealmPattern.MatchString(access.Realm) {
+		return fmt.Errorf("%w: realm must be 1-64 letters, digits, spaces, '.', '_' or '-'", ErrInvalidAccess)
+	}
+	if len(access.Allow)+len(access.Deny) > maxAccessAddresses {
+		return fmt.Errorf("%w: at most %d allow and deny entries", ErrInvalidAccess, maxAccessAddresses)
+	}
+	allowed := make(map[string]struct{}, len(access.Allow))
+	for _, entry := range access.Allow {
+		if !isAddressOrCIDR(entry) {
+			return fmt.Errorf("%w: %q is not an IP or CIDR", ErrInvalidAccess, entry)
+		}
+		allowed[entry] = struct{}{}
+	}
+	for _, entry := range access.Deny {
+		if !isAddressOrCIDR(entry) {
+			return fmt.Errorf("%w: %q is not an IP or CIDR", ErrInvalidAccess, entry)
+		}
+		if _, ok := allowed[entry]; ok {
+			return fmt.Errorf("%w: %s is both allowed and denied", ErrInvalidAccess, entry)
+		}
+	}
+	if len(access.Paths) > maxAccessPaths {
+		return fmt.Errorf("%w: at most %d paths", ErrInvalidAccess, maxAccessPaths)
+	}
+	for _, path := range access.Paths {
+		if !accessPathPattern.MatchString(path) || strings.Contains(path, "..") {
+			return fmt.Errorf("%w: path %q must start with / and use only letters, digits, '.', '_', '~', '-' or '/'", ErrInvalidAccess, path)
+		}
+	}
+	return nil
+}
+
+func isAddressOrCIDR(entry string) bool {
+	if net.ParseIP(entry) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(entry)
+	return err == nil
+}
+
+func (p *NginxProvisioner) htpasswdDir() string {
+	if strings.TrimSpace(p.cfg.HtpasswdDir) == "" {
+		return defaultHtpasswdDir
+	}
+	return p.cfg.HtpasswdDir
+}
+
+// HtpasswdPath is the site's basic auth user file. It lives outside the site
+// root so it can never be served or overwritten by a deploy.
+func (p *NginxProvisioner) HtpasswdPath(site store.Site) string {
+	return filepath.Join(p.htpasswdDir(), sanitizeConfName(site.Domain)+".htpasswd")
+}
+
+// SetAccessUser adds or replaces a user in the site's htpasswd file. nginx
+// reads the file on every request, so no reload is needed.
+func (p *NginxProvisioner) SetAccessUser(site store.Site, username, hash string) error {
+	if err := ValidateAccessUsername(username); err != nil {
+		return err
+	}
+	if !p.cfg.Apply {
+		p.logf("dry-run set access user site=%s user=%s", site.ID, username)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+	path := p.HtpasswdPath(site)
+	entries, err := readHtpasswd(path)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range entries {
+		if entries[i].user == username {
+			entries[i].hash = hash
+			replaced = true
+		}
+	}
+	if !replaced {
+		entries = append(entries, htpasswdEntry{user: username, hash: hash})
+	}
+	return p.writeHtpasswd(path, entries)
+}
+
+// RemoveAccessUser drops a user from the site's htpasswd file. Removing a
+// user that is not there is not an error.
+func (p *NginxProvisioner) RemoveAccessUser(site store.Site, username string) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run remove access user site=%s user=%s", site.ID, username)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+	path := p.HtpasswdPath(site)
+	entries, err := readHtpasswd(path)
+	if err != nil {
+		return err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.user != username {
+			kept = append(kept, entry)
+		}
+	}
+	return p.writeHtpasswd(path, kept)
+}
+
+type htpasswdEntry struct {
+	user string
+	hash string
+}
+
+func readHtpasswd(path string) ([]htpasswdEntry, error) {
+	content, ok, err := readIfExists(path)
+	if err != nil {
+		return nil, fmt.Errorf("read htpasswd: %w", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	var entries []htpasswdEntry
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		entries = append(entries, htpasswdEntry{user: user, hash: hash})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read htpasswd: %w", err)
+	}
+	return entries, nil
+}
+
+// writeHtpasswd replaces the file as root:WebGroup 0640, so only nginx and
+// the panel can read the hashes. The mode and group are set before the
+// rename, so the file is never readable by others.
+func (p *NginxProvisioner) writeHtpasswd(path string, entries []htpasswdEntry) error {
+	group, err := user.LookupGroup(p.cfg.WebGroup)
+	if err != nil {
+		return fmt.Errorf("lookup web group: %w", err)
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return fmt.Errorf("lookup web group: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create htpasswd dir: %w", err)
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s:%s\n", entry.user, entry.hash)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(b.String()), 0o640); err != nil {
+		return fmt.Errorf("write htpasswd: %w", err)
+	}
+	if err := os.Chown(tmpPath, -1, gid); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("chown htpasswd: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0o640); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("chmod htpasswd: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("write htpasswd: %w", err)
+	}
+	return nil
+}
+
+func accessRestricted(access *store.SiteAccess) bool {
+	return access != nil && (len(access.Users) > 0 || len(access.Allow) > 0 || len(access.Deny) > 0)
+}
+
+func protectVariable(site store.Site) string {
+	return siteVariable("$nusantara_protect_", site)
+}
+
+func clientAllowedVariable(site store.Site) string {
+	return siteVariable("$nusantara_client_", site)
+}
+
+func forbiddenVariable(site store.Site) string {
+	return siteVariable("$nusantara_forbidden_", site)
+}
+
+func authRealmVariable(site store.Site) string {
+	return siteVariable("$nusantara_auth_", site)
+}
+
+// renderAccessMaps defines the http-level variables behind the site's
+// access rules. $nusantara_protect_* is 1 for URIs under protection (the
+// whole site when no paths are set, never the ACME challenge directory), so
+// both the IP rules and basic auth can be scoped to paths without repeating
+// the runtime's locations.
+func renderAccessMaps(site store.Site) string {
+	access := site.Access
+	var b strings.Builder
+	protectDefault := 1
+	if len(access.Paths) > 0 {
+		protectDefault = 0
+	}
+	fmt.Fprintf(&b, "map $uri %s {\n    default %d;\n    \"~^/\\.well-known/acme-challenge/\" 0;\n", protectVariable(site), protectDefault)
+	for _, path := range access.Paths {
+		fmt.Fprintf(&b, "    \"~^%s\" 1;\n", regexp.QuoteMeta(path))
+	}
+	b.WriteString("}\n\n")
+
+	if len(access.Allow) > 0 || len(access.Deny) > 0 {
+		clientDefault := 1
+		if len(access.Allow) > 0 {
+			clientDefault = 0
+		}
+		fmt.Fprintf(&b, "geo %s {\n    default %d;\n", clientAllowedVariable(site), clientDefault)
+		for _, entry := range access.Allow {
+			fmt.Fprintf(&b, "    %s 1;\n", entry)
+		}
+		for _, entry := range access.Deny {
+			fmt.Fprintf(&b, "    %s 0;\n", entry)
+		}
+		b.WriteString("}\n\n")
+		fmt.Fprintf(&b, "map \"%s%s\" %s {\n    default 0;\n    10 1;\n}\n\n",
+			protectVariable(site), clientAllowedVariable(site), forbiddenVariable(site))
+	}
+
+	if len(access.Users) > 0 {
+		realm := access.Realm
+		if realm == "" {
+			realm = defaultAccessRealm
+		}
+		fmt.Fprintf(&b, "map %s %s {\n    default off;\n    1 \"%s\";\n}\n\n",
+			protectVariable(site), authRealmVariable(site), realm)
+	}
+	return b.String()
+}
+
+// renderAccessDirectives applies the rules at server level. auth_basic
+// evaluating to "off" disables it, which keeps unprotected paths public.
+func renderAccessDirectives(site store.Site, htpasswdPath string) string {
+	access := site.Access
+	var b strings.Builder
+	if len(access.Allow) > 0 || len(access.Deny) > 0 {
+		fmt.Fprintf(&b, "\n    if (%s) {\n        return 403;\n    }\n", forbiddenVariable(site))
+	}
+	if len(access.Users) > 0 && htpasswdPath != "" {
+		fmt.Fprintf(&b, "\n    auth_basic %s;\n    auth_basic_user_file %s;\n", authRealmVariable(site), htpasswdPath)
+	}
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package provision
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func TestRenderNginxServerAccess(t *testing.T) {
+	site := store.Site{
+		ID:       "site_7a61c0",
+		Domain:   "staging.example.com",
+		RootPath: "/var/www/staging",
+		Runtime:  "static",
+		Access: &store.SiteAccess{
+			Users: []string{"qa"},
+			Realm: "Staging",
+			Allow: []string{"10.0.0.0/8"},
+			Deny:  []string{"10.9.9.9"},
+			Paths: []string{"/admin/", "/wp-login.php"},
+		},
+	}
+	conf := mustRenderNginxServer(t, site, vhostOptions{HtpasswdPath: "/etc/nusantara-panel/htpasswd/staging.example.com.htpasswd"})
+	for _, want := range []string{
+		"map $uri $nusantara_protect_7a61c0 {\n    default 0;\n    \"~^/\\.well-known/acme-challenge/\" 0;\n",
+		"    \"~^/admin/\" 1;\n",
+		"    \"~^/wp-login\\.php\" 1;\n",
+		"geo $nusantara_client_7a61c0 {\n    default 0;\n    10.0.0.0/8 1;\n    10.9.9.9 0;\n}",
+		"map \"$nusantara_protect_7a61c0$nusantara_client_7a61c0\" $nusantara_forbidden_7a61c0 {",
+		"map $nusantara_protect_7a61c0 $nusantara_auth_7a61c0 {\n    default off;\n    1 \"Staging\";\n}",
+		"    if ($nusantara_forbidden_7a61c0) {\n        return 403;\n    }",
+		"    auth_basic $nusantara_auth_7a61c0;\n    auth_basic_user_file /etc/nusantara-panel/htpasswd/staging.example.com.htpasswd;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+
+	site.Access = &store.SiteAccess{Allow: []string{"203.0.113.0/24"}}
+	conf = mustRenderNginxServer(t, site, vhostOptions{})
+	if !strings.Contains(conf, "map $uri $nusantara_protect_7a61c0 {\n    default 1;") {
+		t.Fatalf("site-wide rules must protect every uri:\n%s", conf)
+	}
+	if strings.Contains(conf, "auth_basic") {
+		t.Fatalf("basic auth rendered without users:\n%s", conf)
+	}
+
+	site.Access = &store.SiteAccess{Paths: []string{"/admin; deny all"}}
+	if _, err := renderNginxServer(site, vhostOptions{}); !errors.Is(err, ErrInvalidAccess) {
+		t.Fatalf("expected invalid path to be rejected, got %v", err)
+	}
+}
+
+func TestAccessUserHtpasswd(t *testing.T) {
+	dir := t.TempDir()
+	group, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Skipf("lookup own group: %v", err)
+	}
+	p := NewNginxProvisioner(NginxConfig{Apply: true, HtpasswdDir: dir, WebGroup: group.Name}, nil, nil, log.New(io.Discard, "", 0))
+	site := store.Site{Domain: "staging.example.com"}
+
+	if err := p.SetAccessUser(site, "qa", "$2y$05$first"); err != nil {
+		t.Fatalf("set qa: %v", err)
+	}
+	if err := p.SetAccessUser(site, "dev", "$2y$05$dev"); err != nil {
+		t.Fatalf("set dev: %v", err)
+	}
+	if err := p.SetAccessUser(site, "qa", "$2y$05$second"); err != nil {
+		t.Fatalf("update qa: %v", err)
+	}
+	path := filepath.Join(dir, "staging.example.com.htpasswd")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read htpasswd: %v", err)
+	}
+	if string(content) != "qa:$2y$05$second\ndev:$2y$05$dev\n" {
+		t.Fatalf("unexpected htpasswd:\n%s", content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat htpasswd: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("htpasswd mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	if err := p.RemoveAccessUser(site, "qa"); err != nil {
+		t.Fatalf("remove qa: %v", err)
+	}
+	if err := p.RemoveAccessUser(site, "missing"); err != nil {
+		t.Fatalf("remove missing user: %v", err)
+	}
+	content, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read htpasswd: %v", err)
+	}
+	if string(content) != "dev:$2y$05$dev\n" {
+		t.Fatalf("unexpected htpasswd after removal:\n%s", content)
+	}
+
+	if err := p.SetAccessUser(site, "bad:name", "x"); !errors.Is(err, ErrInvalidAccess) {
+		t.Fatalf("expected invalid username, got %v", err)
+	}
+}
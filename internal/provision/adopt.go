@@ -0,0 +1,119 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"nusantara/internal/store"
+)
+
+// ErrSiteUnmanaged is returned when a change needs the panel to render the
+// vhost of a site that was imported and not adopted yet.
+var ErrSiteUnmanaged = errors.New("site vhost is not managed by the panel")
+
+// ErrSharedVhost is returned when an imported vhost file also serves other
+// sites, which adopting would take offline with it.
+var ErrSharedVhost = errors.New("imported vhost file serves other sites")
+
+// importBackupSuffix marks the copy of a hand-written vhost kept when the
+// panel's config replaces it under the same name.
+const importBackupSuffix = ".nusantara-import"
+
+// CheckAdoptable reports whether every server block in the site's imported
+// file belongs to the site. Disabling the file on adopt would otherwise take
+// the other sites, or a default_server catch-all, offline too.
+func (p *NginxProvisioner) CheckAdoptable(site store.Site) error {
+	if site.ImportedFrom == "" {
+		return nil
+	}
+	raw, found, err := readIfExists(site.ImportedFrom)
+	if err != nil {
+		return fmt.Errorf("read imported vhost: %w", err)
+	}
+	if !found {
+		return nil
+	}
+	directives, err := parseNginxConfig(string(raw))
+	if err != nil {
+		return fmt.Errorf("parse imported vhost: %w", err)
+	}
+	var servers []nginxDirective
+	collectServers(directives, &servers)
+	own := make(map[string]struct{})
+	for _, name := range site.Hostnames() {
+		own[name] = struct{}{}
+	}
+	for _, server := range servers {
+		candidate := candidateFromServer(server)
+		if candidate.Domain == "" {
+			return fmt.Errorf("%w: %s has a server block without a host name of %s", ErrSharedVhost, site.ImportedFrom, site.Domain)
+		}
+		for _, name := range append([]string{candidate.Domain}, candidate.Aliases...) {
+			if _, ok := own[name]; !ok {
+				return fmt.Errorf("%w: %s also serves %s", ErrSharedVhost, site.ImportedFrom, name)
+			}
+		}
+	}
+	return nil
+}
+
+type enabledLink struct {
+	path   string
+	target string
+}
+
+// disableImportedVhost runs when an imported site is first rendered by the
+// panel. The original file stays in sites-available (with a backup when the
+// panel file takes its name); links enabling it under another name are
+// removed so nginx does not load the site twice. The returned func puts the
+// removed links back for a failed provision.
+func (p *NginxProvisioner) disableImportedVhost(site store.Site, confPath, linkPath string, previousConf []byte, hadPreviousConf bool) (func(), error) {
+	if site.ImportedFrom == "" {
+		return func() {}, nil
+	}
+	if err := p.CheckAdoptable(site); err != nil {
+		return nil, err
+	}
+	if filepath.Clean(site.ImportedFrom) == confPath && hadPreviousConf {
+		if err := writeFileIfNotExists(confPath+importBackupSuffix, previousConf); err != nil {
+			return nil, fmt.Errorf("back up imported vhost: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(p.cfg.EnabledDir)
+	if err != nil {
+		return nil, fmt.Errorf("read enabled dir: %w", err)
+	}
+	var removed []enabledLink
+	for _, entry := range entries {
+		path := filepath.Join(p.cfg.EnabledDir, entry.Name())
+		if path == linkPath {
+			continue
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			continue
+		}
+		resolved := target
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(p.cfg.EnabledDir, resolved)
+		}
+		if filepath.Clean(resolved) != filepath.Clean(site.ImportedFrom) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			restoreEnabledLinks(removed)
+			return nil, fmt.Errorf("disable imported vhost: %w", err)
+		}
+		removed = append(removed, enabledLink{path: path, target: target})
+	}
+	return func() { restoreEnabledLinks(removed) }, nil
+}
+
+func restoreEnabledLinks(links []enabledLink) {
+	for _, link := range links {
+		_ = os.Symlink(link.target, link.path)
+	}
+}
@@ -0,0 +1,382 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"nusantara/internal/php"
+	"nusantara/internal/store"
+)
+
+// defaultApacheLogDir is covered by the distro's apache2 logrotate config,
+// so the panel does not ship its own.
+const defaultApacheLogDir = "/var/log/apache2"
+
+type ApacheConfig struct {
+	Apply         bool
+	AvailableDir  string
+	EnabledDir    string
+	TestCommand   string
+	ReloadCommand string
+	PHPFPMRunDir  string
+	// LogDir holds the per-site access and error logs.
+	LogDir string
+}
+
+// ApacheProvisioner renders sites as Apache 2.4 virtual hosts. PHP goes
+// through PHP-FPM (mod_proxy_fcgi) with AllowOverride All, so apps relying
+// on .htaccess work unchanged.
+type ApacheProvisioner struct {
+	mu     sync.Mutex
+	cfg    ApacheConfig
+	host   siteHost
+	logger *log.Logger
+}
+
+func NewApacheProvisioner(cfg ApacheConfig, fpm *FPMPoolProvisioner, apps *SystemdProvisioner, logger *log.Logger) *ApacheProvisioner {
+	if strings.TrimSpace(cfg.LogDir) == "" {
+		cfg.LogDir = defaultApacheLogDir
+	}
+	return &ApacheProvisioner{
+		cfg:    cfg,
+		host:   siteHost{php: php.NewDetector(cfg.PHPFPMRunDir), fpm: fpm, apps: apps},
+		logger: logger,
+	}
+}
+
+func (p *ApacheProvisioner) files() vhostFiles {
+	return vhostFiles{
+		server:        WebServerApache,
+		availableDir:  p.cfg.AvailableDir,
+		enabledDir:    p.cfg.EnabledDir,
+		ext:           ".conf",
+		testCommand:   p.cfg.TestCommand,
+		reloadCommand: p.cfg.ReloadCommand,
+	}
+}
+
+func (p *ApacheProvisioner) logPath(site store.Site, logType string) string {
+	return filepath.Join(p.cfg.LogDir, sanitizeConfName(site.Domain)+"."+logType+".log")
+}
+
+func (p *ApacheProvisioner) ProvisionSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run provisioning site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Reject settings Apache cannot render before touching the host.
+	if _, err := renderApacheSite(site, "", "", ""); err != nil {
+		return fmt.Errorf("render apache conf: %w", err)
+	}
+	socket, version, undoHost, err := p.host.prepare(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.cfg.LogDir, 0o755); err != nil {
+		undoHost()
+		return fmt.Errorf("create log dir: %w", err)
+	}
+	conf, err := renderApacheSite(site, socket, p.logPath(site, LogTypeAccess), p.logPath(site, LogTypeError))
+	if err != nil {
+		undoHost()
+		return fmt.Errorf("render apache conf: %w", err)
+	}
+	confPath, err := p.files().install(ctx, site, conf)
+	if err != nil {
+		undoHost()
+		return err
+	}
+	if err := p.host.settle(ctx, site, version); err != nil {
+		return err
+	}
+
+	p.logf("site provisioned domain=%s conf=%s", site.Domain, confPath)
+	return nil
+}
+
+func (p *ApacheProvisioner) DeprovisionSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run deprovision site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	if site.Unmanaged {
+		p.logf("leaving unmanaged vhost in place domain=%s file=%s", site.Domain, site.ImportedFrom)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+
+	if err := p.files().remove(ctx, site); err != nil {
+		return err
+	}
+	if err := p.host.teardown(ctx, site); err != nil {
+		return err
+	}
+	for _, logType := range []string{LogTypeAccess, LogTypeError} {
+		matches, err := filepath.Glob(p.logPath(site, logType) + "*")
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove log: %w", err)
+			}
+		}
+	}
+
+	p.logf("site deprovisioned domain=%s", site.Domain)
+	return nil
+}
+
+// SuspendSite disables the site's vhost; resume is a normal provision.
+func (p *ApacheProvisioner) SuspendSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run suspend site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+	if site.Unmanaged {
+		return ErrSiteUnmanaged
+	}
+	suspended, err := p.files().suspend(ctx, site)
+	if err != nil {
+		return err
+	}
+	if !suspended {
+		p.logf("site already suspended domain=%s", site.Domain)
+		return nil
+	}
+	p.logf("site suspended domain=%s", site.Domain)
+	return nil
+}
+
+// ControlApp starts, stops or restarts the site's app service.
+func (p *ApacheProvisioner) ControlApp(ctx context.Context, site store.Site, action string) error {
+	if !p.cfg.Apply || p.host.apps == nil {
+		return ErrApplyDisabled
+	}
+	return p.host.apps.Control(ctx, site, action)
+}
+
+// AppStatus reports the site's app service state.
+func (p *ApacheProvisioner) AppStatus(ctx context.Context, site store.Site) (AppStatus, error) {
+	if !p.cfg.Apply || p.host.apps == nil {
+		return AppStatus{}, ErrApplyDisabled
+	}
+	return p.host.apps.Status(ctx, site)
+}
+
+func (p *ApacheProvisioner) logf(format string, args ...any) {
+	if p.logger != nil {
+		p.logger.Printf(format, args...)
+	}
+}
+
+// renderApacheSite renders the site's virtual hosts with the same layout as
+// the nginx backend: port 80 only, or port 80 redirecting to a 443 host
+// once TLS is on, plus a redirect host for the canonical redirect.
+func renderApacheSite(site store.Site, phpSocket, accessLog, errorLog string) (string, error) {
+	if err := checkPortable(site, WebServerApache); err != nil {
+		return "", err
+	}
+	if site.Runtime == "proxy" && site.Proxy.Balance == "ip_hash" {
+		return "", fmt.Errorf("%w: balance ip_hash is only available with nginx and caddy", ErrUnsupportedFeature)
+	}
+	if phpSocket == "" {
+		phpSocket = defaultPHPSocket
+	}
+	body := renderApacheBody(site, phpSocket, accessLog, errorLog)
+	names, redirectFrom, canonical := serverNames(site)
+
+	var b strings.Builder
+	b.WriteString("# Managed by Nusantara Panel. Manual changes are overwritten.\n")
+	if site.TLS == nil {
+		b.WriteString(renderApacheVirtualHost("*:80", names, site.RootPath, body))
+		if redirectFrom != "" {
+			b.WriteString("\n" + renderApacheVirtualHost("*:80", []string{redirectFrom}, site.RootPath, renderApacheRedirect("http://"+canonical)))
+		}
+		return b.String(), nil
+	}
+
+	tls := renderApacheTLS(site.TLS)
+	b.WriteString(renderApacheVirtualHost("*:80", site.Hostnames(), site.RootPath, renderApacheRedirect("https://%{HTTP_HOST}")))
+	b.WriteString("\n" + renderApacheVirtualHost("*:443", names, site.RootPath, tls+body))
+	if redirectFrom != "" {
+		b.WriteString("\n" + renderApacheVirtualHost("*:443", []string{redirectFrom}, site.RootPath, tls+renderApacheRedirect("https://"+canonical)))
+	}
+	return b.String(), nil
+}
+
+func renderApacheVirtualHost(addr string, names []string, root, body string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<VirtualHost %s>\n    ServerName %s\n", addr, names[0])
+	if len(names) > 1 {
+		fmt.Fprintf(&b, "    ServerAlias %s\n", strings.Join(names[1:], " "))
+	}
+	fmt.Fprintf(&b, "    DocumentRoot \"%s\"\n%s</VirtualHost>\n", root, body)
+	return b.String()
+}
+
+// renderApacheRedirect redirects everything except ACME challenges, which
+// certbot answers from the document root.
+func renderApacheRedirect(target string) string {
+	return fmt.Sprintf(`
+    RewriteEngine On
+    RewriteCond %%{REQUEST_URI} !^/\.well-known/acme-challenge/
+    RewriteRule ^ %s%%{REQUEST_URI} [R=301,L]
+`, target)
+}
+
+func renderApacheTLS(tls *store.SiteTLS) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+    SSLEngine on
+    SSLCertificateFile %s
+    SSLCertificateKeyFile %s
+    SSLProtocol -all +TLSv1.2 +TLSv1.3
+`, tls.CertPath, tls.KeyPath)
+	if tls.HSTS {
+		b.WriteString("    Header always set Strict-Transport-Security \"max-age=63072000\"\n")
+	}
+	return b.String()
+}
+
+func renderApacheBody(site store.Site, phpSocket, accessLog, errorLog string) string {
+	var b strings.Builder
+	if accessLog != "" && errorLog != "" {
+		fmt.Fprintf(&b, "\n    ErrorLog %s\n    CustomLog %s combined\n", errorLog, accessLog)
+	}
+	fmt.Fprintf(&b, `
+    <Directory "%s">
+        Options -Indexes +FollowSymLinks
+        AllowOverride All
+        Require all granted
+    </Directory>
+    DirectoryIndex index.php index.html index.htm
+`, site.RootPath)
+
+	switch site.Runtime {
+	case "static":
+	case "node", "python":
+		target := "http://" + upstreamAddress(site) + "/"
+		fmt.Fprintf(&b, `
+    ProxyPreserveHost On
+    RequestHeader set X-Forwarded-Proto expr=%%{REQUEST_SCHEME}
+    ProxyPass /.well-known/acme-challenge/ !
+    ProxyPass / %s upgrade=websocket
+    ProxyPassReverse / %s
+`, target, target)
+	case "proxy":
+		b.WriteString(renderApacheProxy(site))
+	default:
+		fmt.Fprintf(&b, `
+    <FilesMatch "\.php$">
+        SetHandler "proxy:unix:%s|fcgi://localhost"
+    </FilesMatch>
+`, phpSocket)
+	}
+
+	if policy := site.Policy; policy != nil {
+		if policy.Gzip {
+			fmt.Fprintf(&b, "\n    AddOutputFilterByType DEFLATE text/html %s\n", compressibleTypes)
+		}
+		if policy.Brotli {
+			fmt.Fprintf(&b, "\n    AddOutputFilterByType BROTLI_COMPRESS text/html %s\n", compressibleTypes)
+		}
+		if policy.StaticCache > 0 {
+			fmt.Fprintf(&b, `
+    <LocationMatch "\.(%s)$">
+        Header set Cache-Control "max-age=%d"
+    </LocationMatch>
+`, strings.Join(staticExtensions(policy), "|"), policy.StaticCache)
+		}
+	}
+	return b.String()
+}
+
+// apacheWorkerURL is the mod_proxy URL of a target; sockets use the
+// unix:path|http://host form.
+func apacheWorkerURL(target proxyTarget) string {
+	if path, ok := strings.CutPrefix(target.address, proxyUnixPrefix); ok {
+		return "unix:" + path + "|http://localhost/"
+	}
+	return target.scheme + "://" + target.address + "/"
+}
+
+// renderApacheProxy maps the proxy settings onto mod_proxy. A single target
+// is proxied directly; several become a mod_proxy_balancer group, with
+// backups as hot standbys. Apache has no max_fails: a failed member is
+// retried after fail_timeout.
+func renderApacheProxy(site store.Site) string {
+	proxy := site.Proxy
+	var params []string
+	if proxy.WebSocket {
+		params = append(params, "upgrade=websocket")
+	}
+	if proxy.ConnectTimeout > 0 {
+		params = append(params, fmt.Sprintf("connectiontimeout=%d", proxy.ConnectTimeout))
+	}
+	if timeout := max(proxy.ReadTimeout, proxy.SendTimeout); timeout > 0 {
+		params = append(params, fmt.Sprintf("timeout=%d", timeout))
+	}
+	if proxy.DisableBuffering {
+		params = append(params, "flushpackets=on")
+	}
+
+	var b strings.Builder
+	preserve := "Off"
+	if proxy.PreserveHost {
+		preserve = "On"
+	}
+	fmt.Fprintf(&b, "\n    ProxyPreserveHost %s\n    RequestHeader set X-Forwarded-Proto expr=%%{REQUEST_SCHEME}\n", preserve)
+	first, _ := parseProxyTarget(proxy.Targets[0].URL)
+	if first.scheme == "https" {
+		b.WriteString("    SSLProxyEngine on\n")
+	}
+	b.WriteString("    ProxyPass /.well-known/acme-challenge/ !\n")
+
+	if len(proxy.Targets) == 1 {
+		url := apacheWorkerURL(first)
+		fmt.Fprintf(&b, "    ProxyPass / %s\n    ProxyPassReverse / %s\n",
+			strings.TrimSpace(url+" "+strings.Join(params, " ")), url)
+		return b.String()
+	}
+
+	balancer := "balancer://" + upstreamName(site)
+	fmt.Fprintf(&b, "    <Proxy \"%s\">\n", balancer)
+	for _, t := range proxy.Targets {
+		target, _ := parseProxyTarget(t.URL)
+		member := append([]string{"\"" + apacheWorkerURL(target) + "\""}, params...)
+		if t.Weight > 0 {
+			member = append(member, fmt.Sprintf("loadfactor=%d", t.Weight))
+		}
+		if t.FailTimeout > 0 {
+			member = append(member, fmt.Sprintf("retry=%d", t.FailTimeout))
+		}
+		if t.Backup {
+			member = append(member, "status=+H")
+		}
+		fmt.Fprintf(&b, "        BalancerMember %s\n", strings.Join(member, " "))
+	}
+	if proxy.Balance == "least_conn" {
+		b.WriteString("        ProxySet lbmethod=bybusyness\n")
+	}
+	fmt.Fprintf(&b, "    </Proxy>\n    ProxyPass / %s/\n    ProxyPassReverse / %s/\n", balancer, balancer)
+	return b.String()
+}
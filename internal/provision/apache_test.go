@@ -0,0 +1,126 @@
+package provision
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func mustRenderApacheSite(t *testing.T, site store.Site, phpSocket string) string {
+	t.Helper()
+	conf, err := renderApacheSite(site, phpSocket, "/var/log/apache2/"+site.Domain+".access.log", "/var/log/apache2/"+site.Domain+".error.log")
+	if err != nil {
+		t.Fatalf("render apache site: %v", err)
+	}
+	return conf
+}
+
+func TestRenderApacheSitePHP(t *testing.T) {
+	conf := mustRenderApacheSite(t, store.Site{
+		Domain:   "example.com",
+		Aliases:  []string{"shop.example.com"},
+		RootPath: "/var/www/example",
+		Runtime:  "php",
+		Policy:   &store.SitePolicy{Gzip: true, StaticCache: 3600, StaticExtensions: []string{"css", "js"}},
+	}, "/run/php/np_abc-php8.3.sock")
+	for _, want := range []string{
+		"<VirtualHost *:80>",
+		"ServerName example.com",
+		"ServerAlias shop.example.com",
+		"AllowOverride All",
+		`SetHandler "proxy:unix:/run/php/np_abc-php8.3.sock|fcgi://localhost"`,
+		"CustomLog /var/log/apache2/example.com.access.log combined",
+		"AddOutputFilterByType DEFLATE text/html",
+		`<LocationMatch "\.(css|js)$">`,
+		`Header set Cache-Control "max-age=3600"`,
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("apache conf missing %q:\n%s", want, conf)
+		}
+	}
+}
+
+func TestRenderApacheSiteTLSAndRedirect(t *testing.T) {
+	conf := mustRenderApacheSite(t, store.Site{
+		Domain:            "example.com",
+		Aliases:           []string{"www.example.com"},
+		CanonicalRedirect: store.CanonicalApex,
+		RootPath:          "/var/www/example",
+		Runtime:           "node",
+		UpstreamPort:      3005,
+		TLS:               &store.SiteTLS{CertPath: "/etc/ssl/example.pem", KeyPath: "/etc/ssl/example.key", HSTS: true},
+	}, "")
+	for _, want := range []string{
+		"RewriteRule ^ https://%{HTTP_HOST}%{REQUEST_URI} [R=301,L]",
+		"<VirtualHost *:443>",
+		"SSLCertificateFile /etc/ssl/example.pem",
+		"Strict-Transport-Security",
+		"ProxyPass / http://127.0.0.1:3005/ upgrade=websocket",
+		"RewriteRule ^ https://example.com%{REQUEST_URI} [R=301,L]",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("apache conf missing %q:\n%s", want, conf)
+		}
+	}
+	if strings.Count(conf, "<VirtualHost *:443>") != 2 {
+		t.Fatalf("expected site and redirect hosts on 443:\n%s", conf)
+	}
+}
+
+func TestRenderApacheSiteProxyBalancer(t *testing.T) {
+	site := store.Site{
+		ID:       "site_a91b02",
+		Domain:   "api.example.com",
+		RootPath: "/var/www/api",
+		Runtime:  "proxy",
+		Proxy: &store.SiteProxy{
+			Targets: []store.ProxyTarget{
+				{URL: "http://10.0.0.5:8080", Weight: 3, FailTimeout: 30},
+				{URL: "http://10.0.0.6:8080", Backup: true},
+			},
+			Balance:     "least_conn",
+			WebSocket:   true,
+			ReadTimeout: 300,
+		},
+	}
+	conf := mustRenderApacheSite(t, site, "")
+	for _, want := range []string{
+		`<Proxy "balancer://nusantara_a91b02">`,
+		`BalancerMember "http://10.0.0.5:8080/" upgrade=websocket timeout=300 loadfactor=3 retry=30`,
+		`BalancerMember "http://10.0.0.6:8080/" upgrade=websocket timeout=300 status=+H`,
+		"ProxySet lbmethod=bybusyness",
+		"ProxyPreserveHost Off",
+		"ProxyPass /.well-known/acme-challenge/ !",
+		"ProxyPass / balancer://nusantara_a91b02/",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("apache conf missing %q:\n%s", want, conf)
+		}
+	}
+
+	site.Proxy.Balance = "ip_hash"
+	site.Proxy.Targets = site.Proxy.Targets[:1]
+	if _, err := renderApacheSite(site, "", "", ""); !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("expected ip_hash to be unsupported, got %v", err)
+	}
+}
+
+func TestRenderApacheSiteRejectsNginxOnlyPolicy(t *testing.T) {
+	site := store.Site{Domain: "example.com", RootPath: "/var/www/example", Runtime: "php", Policy: DefaultPolicy("php")}
+	if _, err := renderApacheSite(site, "", "", ""); !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("expected rate_limit to be unsupported, got %v", err)
+	}
+	portable, changed := PortablePolicy(WebServerApache, site.Policy)
+	if !changed || portable.RateLimit != 0 || portable.RateBurst != 0 || !portable.Gzip {
+		t.Fatalf("portable policy = %+v changed=%t", portable, changed)
+	}
+	site.Policy = portable
+	if _, err := renderApacheSite(site, "", "", ""); err != nil {
+		t.Fatalf("render portable policy: %v", err)
+	}
+	if _, changed := PortablePolicy(WebServerNginx, DefaultPolicy("php")); changed {
+		t.Fatalf("nginx keeps the whole policy")
+	}
+}
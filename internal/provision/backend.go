@@ -0,0 +1,339 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nusantara/internal/php"
+	"nusantara/internal/store"
+)
+
+// ErrUnsupportedFeature is returned by the Apache and Caddy backends for
+// site settings only the nginx backend can render.
+var ErrUnsupportedFeature = errors.New("site setting not supported by this web server")
+
+// Web servers the panel can provision sites for.
+const (
+	WebServerNginx  = "nginx"
+	WebServerApache = "apache"
+	WebServerCaddy  = "caddy"
+)
+
+// siteHost does the host-side work every backend needs around the web
+// server config: the site root, its PHP-FPM pool and its app unit.
+type siteHost struct {
+	php  *php.Detector
+	fpm  *FPMPoolProvisioner
+	apps *SystemdProvisioner
+}
+
+// prepare readies the host for a site and returns the PHP-FPM socket the
+// config must use, empty for runtimes other than php. The returned undo
+// puts the previous PHP-FPM pool back if the web server then rejects the
+// new config; it is never nil.
+func (h siteHost) prepare(ctx context.Context, site store.Site) (string, php.Version, func(), error) {
+	undo := func() {}
+	if site.Domain == "" {
+		return "", php.Version{}, undo, errors.New("site domain is empty")
+	}
+	if site.RootPath == "" {
+		return "", php.Version{}, undo, errors.New("site root_path is empty")
+	}
+	if site.Unmanaged {
+		return "", php.Version{}, undo, ErrSiteUnmanaged
+	}
+	_, statErr := os.Stat(site.RootPath)
+	if err := os.MkdirAll(site.RootPath, 0o755); err != nil {
+		return "", php.Version{}, undo, fmt.Errorf("create site root: %w", err)
+	}
+	// Only a root the panel created, or one already handed to the site
+	// user, is given to it; an existing directory keeps its owner.
+	claimRoot := errors.Is(statErr, os.ErrNotExist) || ownedBy(site.RootPath, PoolUser(site.ID))
+	if err := ensureRuntimeBootstrap(site); err != nil {
+		return "", php.Version{}, undo, fmt.Errorf("bootstrap site root: %w", err)
+	}
+	if site.TLS != nil {
+		for _, path := range []string{site.TLS.CertPath, site.TLS.KeyPath} {
+			if _, err := os.Stat(path); err != nil {
+				return "", php.Version{}, undo, fmt.Errorf("tls certificate: %w", err)
+			}
+		}
+	}
+
+	var socket string
+	var version php.Version
+	if site.Runtime == "php" {
+		resolved, err := h.php.Resolve(site.PHPVersion)
+		if err != nil {
+			return "", php.Version{}, undo, fmt.Errorf("resolve php-fpm: %w", err)
+		}
+		version = resolved
+		if _, err := os.Stat(version.Socket); err != nil {
+			return "", php.Version{}, undo, fmt.Errorf("php-fpm socket %s: %w", version.Socket, err)
+		}
+		socket = version.Socket
+		if h.fpm != nil {
+			if socket, undo, err = h.fpm.EnsurePool(ctx, site, version.Version, claimRoot); err != nil {
+				return "", php.Version{}, func() {}, fmt.Errorf("php-fpm pool: %w", err)
+			}
+		}
+	}
+
+	if h.apps != nil {
+		if site.App != nil {
+			if err := h.apps.EnsureUnit(ctx, site, claimRoot); err != nil {
+				undo()
+				return "", php.Version{}, func() {}, fmt.Errorf("app unit: %w", err)
+			}
+		} else if err := h.apps.RemoveUnit(ctx, site); err != nil {
+			undo()
+			return "", php.Version{}, func() {}, fmt.Errorf("app unit: %w", err)
+		}
+	}
+	return socket, version, undo, nil
+}
+
+// settle drops PHP-FPM pools of versions the site no longer uses once the
+// new config is live, all of them if it left the php runtime.
+func (h siteHost) settle(ctx context.Context, site store.Site, version php.Version) error {
+	if h.fpm != nil {
+		if err := h.fpm.PrunePools(ctx, site, version.Version); err != nil {
+			return fmt.Errorf("prune old php-fpm pools: %w", err)
+		}
+	}
+	return nil
+}
+
+// teardown removes the app unit before the pools, which delete the shared
+// site user.
+func (h siteHost) teardown(ctx context.Context, site store.Site) error {
+	if h.apps != nil {
+		if err := h.apps.RemoveUnit(ctx, site); err != nil {
+			return fmt.Errorf("app unit: %w", err)
+		}
+	}
+	if h.fpm != nil {
+		if err := h.fpm.RemovePools(ctx, site); err != nil {
+			return fmt.Errorf("remove php-fpm pools: %w", err)
+		}
+	}
+	return nil
+}
+
+// vhostFiles manages a web server's sites-available/sites-enabled pair.
+type vhostFiles struct {
+	server        string
+	availableDir  string
+	enabledDir    string
+	ext           string
+	testCommand   string
+	reloadCommand string
+	// retire, when set, runs just before conf is written and takes other
+	// files serving the site offline. The returned func puts them back if
+	// the install fails.
+	retire func(site store.Site, confPath, linkPath string, previousConf []byte, hadPreviousConf bool) (func(), error)
+}
+
+func (f vhostFiles) paths(site store.Site) (confPath, linkPath string) {
+	name := sanitizeConfName(site.Domain) + f.ext
+	return filepath.Join(f.availableDir, name), filepath.Join(f.enabledDir, name)
+}
+
+// install writes and enables conf, then tests and reloads the server. A
+// suspended site gets conf written but stays disabled. The previous file and
+// link are restored if either command fails.
+func (f vhostFiles) install(ctx context.Context, site store.Site, conf string) (string, error) {
+	if err := os.MkdirAll(f.availableDir, 0o755); err != nil {
+		return "", fmt.Errorf("create available dir: %w", err)
+	}
+	if err := os.MkdirAll(f.enabledDir, 0o755); err != nil {
+		return "", fmt.Errorf("create enabled dir: %w", err)
+	}
+	confPath, linkPath := f.paths(site)
+	previousConf, hadPreviousConf, err := readIfExists(confPath)
+	if err != nil {
+		return "", fmt.Errorf("read previous conf: %w", err)
+	}
+	previousLinkTarget, hadPreviousLink, err := readLinkIfExists(linkPath)
+	if err != nil {
+		return "", fmt.Errorf("read previous link: %w", err)
+	}
+	restoreRetired := func() {}
+	if f.retire != nil {
+		if restoreRetired, err = f.retire(site, confPath, linkPath, previousConf, hadPreviousConf); err != nil {
+			return "", err
+		}
+	}
+	undo := func() {
+		_ = rollback(confPath, linkPath, previousConf, hadPreviousConf, previousLinkTarget, hadPreviousLink)
+		restoreRetired()
+	}
+
+	if err := writeAtomic(confPath, []byte(conf)); err != nil {
+		restoreRetired()
+		return "", fmt.Errorf("write %s conf: %w", f.server, err)
+	}
+	if err := enableVhost(site, confPath, linkPath); err != nil {
+		undo()
+		return "", err
+	}
+	if err := runCommand(ctx, f.testCommand); err != nil {
+		undo()
+		return "", fmt.Errorf("%s test failed: %w", f.server, err)
+	}
+	if err := runCommand(ctx, f.reloadCommand); err != nil {
+		undo()
+		return "", fmt.Errorf("%s reload failed: %w", f.server, err)
+	}
+	return confPath, nil
+}
+
+// enableVhost links confPath into the enabled dir, or makes sure it is not
+// linked while the site is suspended.
+func enableVhost(site store.Site, confPath, linkPath string) error {
+	if site.Suspended {
+		if err := os.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove symlink: %w", err)
+		}
+		return nil
+	}
+	if err := upsertSymlink(confPath, linkPath); err != nil {
+		return fmt.Errorf("upsert symlink: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the site's config and reloads the server.
+func (f vhostFiles) remove(ctx context.Context, site store.Site) error {
+	confPath, linkPath := f.paths(site)
+	if err := os.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove symlink: %w", err)
+	}
+	if err := os.Remove(confPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove conf: %w", err)
+	}
+	if err := runCommand(ctx, f.testCommand); err != nil {
+		return fmt.Errorf("%s test failed: %w", f.server, err)
+	}
+	if err := runCommand(ctx, f.reloadCommand); err != nil {
+		return fmt.Errorf("%s reload failed: %w", f.server, err)
+	}
+	return nil
+}
+
+// suspend removes only the sites-enabled link, so resume is a normal
+// provision. It reports false when the site was already offline.
+func (f vhostFiles) suspend(ctx context.Context, site store.Site) (bool, error) {
+	_, linkPath := f.paths(site)
+	previousLinkTarget, hadPreviousLink, err := readLinkIfExists(linkPath)
+	if err != nil {
+		return false, fmt.Errorf("read previous link: %w", err)
+	}
+	if !hadPreviousLink {
+		return false, nil
+	}
+	restore := func() {
+		if previousLinkTarget != "" {
+			_ = os.Symlink(previousLinkTarget, linkPath)
+		}
+	}
+	if err := os.Remove(linkPath); err != nil {
+		return false, fmt.Errorf("remove symlink: %w", err)
+	}
+	if err := runCommand(ctx, f.testCommand); err != nil {
+		restore()
+		return false, fmt.Errorf("%s test failed: %w", f.server, err)
+	}
+	if err := runCommand(ctx, f.reloadCommand); err != nil {
+		restore()
+		return false, fmt.Errorf("%s reload failed: %w", f.server, err)
+	}
+	return true, nil
+}
+
+// checkPortable rejects settings written in nginx terms, which the Apache
+// and Caddy backends cannot translate faithfully.
+func checkPortable(site store.Site, server string) error {
+	feature := ""
+	switch {
+	case site.CustomDirectives != "":
+		feature = "custom_directives"
+	case site.Access != nil && accessConfigured(site.Access):
+		feature = "access"
+	case site.Maintenance != nil:
+		feature = "maintenance"
+	default:
+		if limits := nginxOnlyPolicy(site.Policy); len(limits) > 0 {
+			feature = strings.Join(limits, ", ")
+		}
+	}
+	if feature != "" {
+		return fmt.Errorf("%w: %s is only available with nginx, not %s", ErrUnsupportedFeature, feature, server)
+	}
+	if site.Runtime == "proxy" {
+		if err := ValidateProxy(site.Proxy); err != nil {
+			return err
+		}
+	}
+	if err := ValidateAccess(site.Access); err != nil {
+		return err
+	}
+	return ValidatePolicy(site.Runtime, site.Policy)
+}
+
+func accessConfigured(access *store.SiteAccess) bool {
+	return len(access.Users) > 0 || len(access.Allow) > 0 || len(access.Deny) > 0 || len(access.Paths) > 0 || access.Realm != ""
+}
+
+// nginxOnlyPolicy lists the policy limits and caches only nginx renders.
+// They protect the site, so other backends reject them rather than
+// serving it without them.
+func nginxOnlyPolicy(policy *store.SitePolicy) []string {
+	if policy == nil {
+		return nil
+	}
+	var limits []string
+	if policy.RateLimit > 0 {
+		limits = append(limits, "rate_limit")
+	}
+	if policy.ConnLimit > 0 {
+		limits = append(limits, "conn_limit")
+	}
+	if policy.FastCGICache > 0 {
+		limits = append(limits, "fastcgi_cache")
+	}
+	return limits
+}
+
+// PortablePolicy returns policy without the settings server cannot render,
+// and whether anything was dropped. Defaults and policies stored before a
+// switch to Apache or Caddy go through it.
+func PortablePolicy(server string, policy *store.SitePolicy) (*store.SitePolicy, bool) {
+	if policy == nil || server == "" || server == WebServerNginx || len(nginxOnlyPolicy(policy)) == 0 {
+		return policy, false
+	}
+	portable := *policy
+	portable.RateLimit, portable.RateBurst, portable.ConnLimit, portable.FastCGICache = 0, 0, 0, 0
+	return &portable, true
+}
+
+// unenforcedPolicy lists cosmetic policy settings Caddy cannot render, so
+// the provisioner can log them instead of failing the site.
+func unenforcedPolicy(policy *store.SitePolicy) []string {
+	if policy == nil || !policy.Brotli {
+		return nil
+	}
+	return []string{"brotli"}
+}
+
+// staticExtensions returns the extensions the policy's browser cache covers.
+func staticExtensions(policy *store.SitePolicy) []string {
+	if len(policy.StaticExtensions) > 0 {
+		return policy.StaticExtensions
+	}
+	return defaultStaticExtensions
+}
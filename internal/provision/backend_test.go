@@ -0,0 +1,160 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+// backendFixture is an Apache or Caddy provisioner working in temp dirs.
+type backendFixture struct {
+	name         string
+	ext          string
+	availableDir string
+	enabledDir   string
+	logDir       string
+	provisioner  interface {
+		ProvisionSite(ctx context.Context, site store.Site) error
+		DeprovisionSite(ctx context.Context, site store.Site) error
+		SuspendSite(ctx context.Context, site store.Site) error
+	}
+}
+
+func newBackendFixtures(t *testing.T, testCommand string) []backendFixture {
+	t.Helper()
+	logger := log.New(io.Discard, "", 0)
+	var fixtures []backendFixture
+	for _, name := range []string{WebServerApache, WebServerCaddy} {
+		base := t.TempDir()
+		f := backendFixture{
+			name:         name,
+			availableDir: filepath.Join(base, "available"),
+			enabledDir:   filepath.Join(base, "enabled"),
+			logDir:       filepath.Join(base, "logs"),
+		}
+		switch name {
+		case WebServerApache:
+			f.ext = ".conf"
+			f.provisioner = NewApacheProvisioner(ApacheConfig{
+				Apply:         true,
+				AvailableDir:  f.availableDir,
+				EnabledDir:    f.enabledDir,
+				TestCommand:   testCommand,
+				ReloadCommand: "true",
+				LogDir:        f.logDir,
+			}, nil, nil, logger)
+		case WebServerCaddy:
+			f.ext = ".caddy"
+			f.provisioner = NewCaddyProvisioner(CaddyConfig{
+				Apply:         true,
+				AvailableDir:  f.availableDir,
+				EnabledDir:    f.enabledDir,
+				TestCommand:   testCommand,
+				ReloadCommand: "true",
+				LogDir:        f.logDir,
+			}, nil, nil, logger)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures
+}
+
+func TestBackendLifecycle(t *testing.T) {
+	for _, f := range newBackendFixtures(t, "true") {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			site := store.Site{ID: "site-1", Domain: "example.com", RootPath: filepath.Join(t.TempDir(), "www"), Runtime: "static"}
+			confPath := filepath.Join(f.availableDir, "example.com"+f.ext)
+			linkPath := filepath.Join(f.enabledDir, "example.com"+f.ext)
+
+			if err := f.provisioner.ProvisionSite(ctx, site); err != nil {
+				t.Fatalf("provision: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(site.RootPath, "index.html")); err != nil {
+				t.Fatalf("static index should be bootstrapped: %v", err)
+			}
+			if target, err := os.Readlink(linkPath); err != nil || target != confPath {
+				t.Fatalf("site should be enabled, target=%q err=%v", target, err)
+			}
+
+			if err := f.provisioner.SuspendSite(ctx, site); err != nil {
+				t.Fatalf("suspend: %v", err)
+			}
+			if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+				t.Fatalf("suspend should remove the enabled link, err=%v", err)
+			}
+			if _, err := os.Stat(confPath); err != nil {
+				t.Fatalf("suspend should keep the config: %v", err)
+			}
+
+			suspended := site
+			suspended.Suspended = true
+			if err := f.provisioner.ProvisionSite(ctx, suspended); err != nil {
+				t.Fatalf("provision suspended: %v", err)
+			}
+			if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+				t.Fatalf("provisioning a suspended site must not enable it, err=%v", err)
+			}
+
+			if err := f.provisioner.DeprovisionSite(ctx, site); err != nil {
+				t.Fatalf("deprovision: %v", err)
+			}
+			if _, err := os.Stat(confPath); !os.IsNotExist(err) {
+				t.Fatalf("deprovision should remove the config, err=%v", err)
+			}
+		})
+	}
+}
+
+func TestBackendRestoresOnFailedTest(t *testing.T) {
+	for _, f := range newBackendFixtures(t, "false") {
+		t.Run(f.name, func(t *testing.T) {
+			confPath := filepath.Join(f.availableDir, "example.com"+f.ext)
+			if err := os.MkdirAll(f.availableDir, 0o755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+			if err := os.WriteFile(confPath, []byte("previous\n"), 0o644); err != nil {
+				t.Fatalf("seed conf: %v", err)
+			}
+			site := store.Site{ID: "site-1", Domain: "example.com", RootPath: t.TempDir(), Runtime: "static"}
+			if err := f.provisioner.ProvisionSite(context.Background(), site); err == nil || !strings.Contains(err.Error(), f.name+" test failed") {
+				t.Fatalf("expected %s test failure, got %v", f.name, err)
+			}
+			content, err := os.ReadFile(confPath)
+			if err != nil || string(content) != "previous\n" {
+				t.Fatalf("previous config should be restored, got %q err=%v", content, err)
+			}
+			if _, err := os.Lstat(filepath.Join(f.enabledDir, "example.com"+f.ext)); !os.IsNotExist(err) {
+				t.Fatalf("enabled link should be removed, err=%v", err)
+			}
+		})
+	}
+}
+
+func TestBackendRejectsNginxOnlySettings(t *testing.T) {
+	for _, f := range newBackendFixtures(t, "true") {
+		t.Run(f.name, func(t *testing.T) {
+			for name, site := range map[string]store.Site{
+				"directives":  {CustomDirectives: "client_max_body_size 64m;"},
+				"access":      {Access: &store.SiteAccess{Allow: []string{"10.0.0.0/8"}}},
+				"maintenance": {Maintenance: &store.SiteMaintenance{}},
+			} {
+				site.ID, site.Domain, site.Runtime = "site-1", "example.com", "static"
+				site.RootPath = filepath.Join(t.TempDir(), "www")
+				if err := f.provisioner.ProvisionSite(context.Background(), site); !errors.Is(err, ErrUnsupportedFeature) {
+					t.Fatalf("%s: expected ErrUnsupportedFeature, got %v", name, err)
+				}
+				if _, err := os.Stat(site.RootPath); !os.IsNotExist(err) {
+					t.Fatalf("%s: rejected site must not touch the host, err=%v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"nusantara/internal/php"
+	"nusantara/internal/store"
+)
+
+const defaultCaddyLogDir = "/var/log/caddy/nusantara"
+
+type CaddyConfig struct {
+	Apply bool
+	// AvailableDir and EnabledDir hold the per-site snippets; the main
+	// Caddyfile must import EnabledDir/*.caddy.
+	AvailableDir  string
+	EnabledDir    string
+	TestCommand   string
+	ReloadCommand string
+	PHPFPMRunDir  string
+	// LogDir holds the per-site access logs, which Caddy rolls itself.
+	LogDir string
+}
+
+// CaddyProvisioner renders sites as Caddyfile site blocks. Certificates
+// still come from the panel's certbot flow, so Caddy's automatic HTTPS only
+// serves the stored certificate and redirects port 80.
+type CaddyProvisioner struct {
+	mu     sync.Mutex
+	cfg    CaddyConfig
+	host   siteHost
+	logger *log.Logger
+}
+
+func NewCaddyProvisioner(cfg CaddyConfig, fpm *FPMPoolProvisioner, apps *SystemdProvisioner, logger *log.Logger) *CaddyProvisioner {
+	if strings.TrimSpace(cfg.LogDir) == "" {
+		cfg.LogDir = defaultCaddyLogDir
+	}
+	return &CaddyProvisioner{
+		cfg:    cfg,
+		host:   siteHost{php: php.NewDetector(cfg.PHPFPMRunDir), fpm: fpm, apps: apps},
+		logger: logger,
+	}
+}
+
+func (p *CaddyProvisioner) files() vhostFiles {
+	return vhostFiles{
+		server:        WebServerCaddy,
+		availableDir:  p.cfg.AvailableDir,
+		enabledDir:    p.cfg.EnabledDir,
+		ext:           ".caddy",
+		testCommand:   p.cfg.TestCommand,
+		reloadCommand: p.cfg.ReloadCommand,
+	}
+}
+
+func (p *CaddyProvisioner) logPath(site store.Site) string {
+	return filepath.Join(p.cfg.LogDir, sanitizeConfName(site.Domain)+".access.log")
+}
+
+func (p *CaddyProvisioner) ProvisionSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run provisioning site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Reject settings Caddy cannot render before touching the host.
+	if _, err := renderCaddySite(site, "", ""); err != nil {
+		return fmt.Errorf("render caddy conf: %w", err)
+	}
+	socket, version, undoHost, err := p.host.prepare(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.cfg.LogDir, 0o755); err != nil {
+		undoHost()
+		return fmt.Errorf("create log dir: %w", err)
+	}
+	conf, err := renderCaddySite(site, socket, p.logPath(site))
+	if err != nil {
+		undoHost()
+		return fmt.Errorf("render caddy conf: %w", err)
+	}
+	confPath, err := p.files().install(ctx, site, conf)
+	if err != nil {
+		undoHost()
+		return err
+	}
+	if err := p.host.settle(ctx, site, version); err != nil {
+		return err
+	}
+	if skipped := unenforcedPolicy(site.Policy); len(skipped) > 0 {
+		p.logf("caddy does not enforce policy settings site=%s settings=%s", site.ID, strings.Join(skipped, ","))
+	}
+
+	p.logf("site provisioned domain=%s conf=%s", site.Domain, confPath)
+	return nil
+}
+
+func (p *CaddyProvisioner) DeprovisionSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run deprovision site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	if site.Unmanaged {
+		p.logf("leaving unmanaged site block in place domain=%s file=%s", site.Domain, site.ImportedFrom)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+
+	if err := p.files().remove(ctx, site); err != nil {
+		return err
+	}
+	if err := p.host.teardown(ctx, site); err != nil {
+		return err
+	}
+	matches, err := filepath.Glob(strings.TrimSuffix(p.logPath(site), ".log") + "*")
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove log: %w", err)
+		}
+	}
+
+	p.logf("site deprovisioned domain=%s", site.Domain)
+	return nil
+}
+
+// SuspendSite disables the site's block; resume is a normal provision.
+func (p *CaddyProvisioner) SuspendSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run suspend site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+	if site.Unmanaged {
+		return ErrSiteUnmanaged
+	}
+	suspended, err := p.files().suspend(ctx, site)
+	if err != nil {
+		return err
+	}
+	if !suspended {
+		p.logf("site already suspended domain=%s", site.Domain)
+		return nil
+	}
+	p.logf("site suspended domain=%s", site.Domain)
+	return nil
+}
+
+// ControlApp starts, stops or restarts the site's app service.
+func (p *CaddyProvisioner) ControlApp(ctx context.Context, site store.Site, action string) error {
+	if !p.cfg.Apply || p.host.apps == nil {
+		return ErrApplyDisabled
+	}
+	return p.host.apps.Control(ctx, site, action)
+}
+
+// AppStatus reports the site's app service state.
+func (p *CaddyProvisioner) AppStatus(ctx context.Context, site store.Site) (AppStatus, error) {
+	if !p.cfg.Apply || p.host.apps == nil {
+		return AppStatus{}, ErrApplyDisabled
+	}
+	return p.host.apps.Status(ctx, site)
+}
+
+func (p *CaddyProvisioner) logf(format string, args ...any) {
+	if p.logger != nil {
+		p.logger.Printf(format, args...)
+	}
+}
+
+// renderCaddySite renders the site block and, with a canonical redirect,
+// a block redirecting the other host. Without TLS the addresses carry
+// http:// so Caddy does not try to obtain certificates on its own.
+func renderCaddySite(site store.Site, phpSocket, accessLog string) (string, error) {
+	if err := checkPortable(site, WebServerCaddy); err != nil {
+		return "", err
+	}
+	if site.Runtime == "proxy" {
+		for _, t := range site.Proxy.Targets {
+			if t.Backup {
+				return "", fmt.Errorf("%w: backup targets are only available with nginx and apache", ErrUnsupportedFeature)
+			}
+		}
+	}
+	if phpSocket == "" {
+		phpSocket = defaultPHPSocket
+	}
+	scheme, tls := "http://", ""
+	if site.TLS != nil {
+		scheme = ""
+		tls = fmt.Sprintf("\ttls %s %s\n", site.TLS.CertPath, site.TLS.KeyPath)
+		if site.TLS.HSTS {
+			tls += "\theader Strict-Transport-Security \"max-age=63072000\"\n"
+		}
+	}
+	names, redirectFrom, canonical := serverNames(site)
+
+	var b strings.Builder
+	b.WriteString("# Managed by Nusantara Panel. Manual changes are overwritten.\n")
+	fmt.Fprintf(&b, "%s {\n%s\troot * %s\n", caddyAddresses(scheme, names), tls, site.RootPath)
+	if accessLog != "" {
+		fmt.Fprintf(&b, "\tlog {\n\t\toutput file %s\n\t}\n", accessLog)
+	}
+	if policy := site.Policy; policy != nil {
+		if policy.Gzip {
+			b.WriteString("\tencode gzip\n")
+		}
+		if policy.StaticCache > 0 {
+			var patterns []string
+			for _, ext := range staticExtensions(policy) {
+				patterns = append(patterns, "*."+ext)
+			}
+			fmt.Fprintf(&b, "\t@static path %s\n\theader @static Cache-Control \"max-age=%d\"\n",
+				strings.Join(patterns, " "), policy.StaticCache)
+		}
+	}
+	fmt.Fprintf(&b, "\n\thandle /.well-known/acme-challenge/* {\n\t\tfile_server\n\t}\n\n\thandle {\n%s\t}\n}\n",
+		renderCaddyRuntime(site, phpSocket))
+
+	if redirectFrom != "" {
+		target := "https://" + canonical
+		if site.TLS == nil {
+			target = "http://" + canonical
+		}
+		fmt.Fprintf(&b, "\n%s {\n%s\troot * %s\n\n\thandle /.well-known/acme-challenge/* {\n\t\tfile_server\n\t}\n\n\thandle {\n\t\tredir %s{uri} permanent\n\t}\n}\n",
+			caddyAddresses(scheme, []string{redirectFrom}), tls, site.RootPath, target)
+	}
+	return b.String(), nil
+}
+
+func caddyAddresses(scheme string, names []string) string {
+	addresses := make([]string, len(names))
+	for i, name := range names {
+		addresses[i] = scheme + name
+	}
+	return strings.Join(addresses, ", ")
+}
+
+// caddyDial is the reverse_proxy address of a target; sockets use the
+// unix//path form.
+func caddyDial(target proxyTarget) string {
+	if path, ok := strings.CutPrefix(target.address, proxyUnixPrefix); ok {
+		return "unix/" + path
+	}
+	return target.scheme + "://" + target.address
+}
+
+// renderCaddyRuntime renders the handler of the site's runtime. Caddy
+// passes websocket upgrades and X-Forwarded-* headers on its own.
+func renderCaddyRuntime(site store.Site, phpSocket string) string {
+	switch site.Runtime {
+	case "static":
+		return "\t\tfile_server\n"
+	case "node", "python":
+		return fmt.Sprintf("\t\treverse_proxy %s\n", upstreamAddress(site))
+	case "proxy":
+		return renderCaddyProxy(site)
+	default:
+		return fmt.Sprintf("\t\tphp_fastcgi unix/%s\n\t\tfile_server\n", phpSocket)
+	}
+}
+
+// renderCaddyProxy maps the proxy settings onto reverse_proxy. Caddy keeps
+// failure counts per handler, so the highest max_fails and fail_timeout of
+// the targets apply to all of them.
+func renderCaddyProxy(site store.Site) string {
+	proxy := site.Proxy
+	var b strings.Builder
+	dials := make([]string, 0, len(proxy.Targets))
+	weights := make([]string, 0, len(proxy.Targets))
+	weighted := false
+	maxFails, failTimeout := 0, 0
+	for _, t := range proxy.Targets {
+		target, _ := parseProxyTarget(t.URL)
+		dials = append(dials, caddyDial(target))
+		weight := max(t.Weight, 1)
+		weighted = weighted || t.Weight > 0
+		weights = append(weights, fmt.Sprint(weight))
+		maxFails = max(maxFails, t.MaxFails)
+		failTimeout = max(failTimeout, t.FailTimeout)
+	}
+	fmt.Fprintf(&b, "\t\treverse_proxy {\n\t\t\tto %s\n", strings.Join(dials, " "))
+	switch {
+	case proxy.Balance != "":
+		fmt.Fprintf(&b, "\t\t\tlb_policy %s\n", proxy.Balance)
+	case weighted:
+		fmt.Fprintf(&b, "\t\t\tlb_policy weighted_round_robin %s\n", strings.Join(weights, " "))
+	}
+	if maxFails > 0 {
+		fmt.Fprintf(&b, "\t\t\tmax_fails %d\n", maxFails)
+	}
+	if failTimeout > 0 {
+		fmt.Fprintf(&b, "\t\t\tfail_duration %ds\n", failTimeout)
+	}
+	first, _ := parseProxyTarget(proxy.Targets[0].URL)
+	if !proxy.PreserveHost && first.host != "" {
+		b.WriteString("\t\t\theader_up Host {upstream_hostport}\n")
+	}
+	if proxy.DisableBuffering {
+		b.WriteString("\t\t\tflush_interval -1\n")
+	}
+	if proxy.ConnectTimeout > 0 || proxy.ReadTimeout > 0 || proxy.SendTimeout > 0 {
+		b.WriteString("\t\t\ttransport http {\n")
+		if proxy.ConnectTimeout > 0 {
+			fmt.Fprintf(&b, "\t\t\t\tdial_timeout %ds\n", proxy.ConnectTimeout)
+		}
+		if proxy.ReadTimeout > 0 {
+			fmt.Fprintf(&b, "\t\t\t\tread_timeout %ds\n", proxy.ReadTimeout)
+		}
+		if proxy.SendTimeout > 0 {
+			fmt.Fprintf(&b, "\t\t\t\twrite_timeout %ds\n", proxy.SendTimeout)
+		}
+		b.WriteString("\t\t\t}\n")
+	}
+	b.WriteString("\t\t}\n")
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package provision
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func mustRenderCaddySite(t *testing.T, site store.Site, phpSocket string) string {
+	t.Helper()
+	conf, err := renderCaddySite(site, phpSocket, "/var/log/caddy/nusantara/"+site.Domain+".access.log")
+	if err != nil {
+		t.Fatalf("render caddy site: %v", err)
+	}
+	return conf
+}
+
+func TestRenderCaddySitePHP(t *testing.T) {
+	conf := mustRenderCaddySite(t, store.Site{
+		Domain:   "example.com",
+		Aliases:  []string{"shop.example.com"},
+		RootPath: "/var/www/example",
+		Runtime:  "php",
+		Policy:   &store.SitePolicy{Gzip: true, StaticCache: 3600, StaticExtensions: []string{"css", "js"}},
+	}, "/run/php/np_abc-php8.3.sock")
+	for _, want := range []string{
+		"http://example.com, http://shop.example.com {",
+		"root * /var/www/example",
+		"output file /var/log/caddy/nusantara/example.com.access.log",
+		"encode gzip",
+		"@static path *.css *.js",
+		`header @static Cache-Control "max-age=3600"`,
+		"handle /.well-known/acme-challenge/* {",
+		"php_fastcgi unix//run/php/np_abc-php8.3.sock",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("caddy conf missing %q:\n%s", want, conf)
+		}
+	}
+}
+
+func TestRenderCaddySiteTLSAndRedirect(t *testing.T) {
+	conf := mustRenderCaddySite(t, store.Site{
+		Domain:            "example.com",
+		Aliases:           []string{"www.example.com"},
+		CanonicalRedirect: store.CanonicalWWW,
+		RootPath:          "/var/www/example",
+		Runtime:           "python",
+		UpstreamPort:      8001,
+		TLS:               &store.SiteTLS{CertPath: "/etc/ssl/example.pem", KeyPath: "/etc/ssl/example.key"},
+	}, "")
+	for _, want := range []string{
+		"www.example.com {",
+		"tls /etc/ssl/example.pem /etc/ssl/example.key",
+		"reverse_proxy 127.0.0.1:8001",
+		"example.com {",
+		"redir https://www.example.com{uri} permanent",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("caddy conf missing %q:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(conf, "http://") && !strings.Contains(conf, "redir https://") {
+		t.Fatalf("tls site must not use http:// addresses:\n%s", conf)
+	}
+}
+
+func TestRenderCaddySiteProxy(t *testing.T) {
+	site := store.Site{
+		Domain:   "api.example.com",
+		RootPath: "/var/www/api",
+		Runtime:  "proxy",
+		Proxy: &store.SiteProxy{
+			Targets: []store.ProxyTarget{
+				{URL: "http://10.0.0.5:8080", Weight: 3, MaxFails: 2, FailTimeout: 30},
+				{URL: "unix:/run/api.sock"},
+			},
+			ConnectTimeout:   5,
+			DisableBuffering: true,
+		},
+	}
+	conf := mustRenderCaddySite(t, site, "")
+	for _, want := range []string{
+		"to http://10.0.0.5:8080 unix//run/api.sock",
+		"lb_policy weighted_round_robin 3 1",
+		"max_fails 2",
+		"fail_duration 30s",
+		"header_up Host {upstream_hostport}",
+		"flush_interval -1",
+		"dial_timeout 5s",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("caddy conf missing %q:\n%s", want, conf)
+		}
+	}
+
+	site.Proxy.Targets[1].Backup = true
+	if _, err := renderCaddySite(site, "", ""); !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("expected backup targets to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidDirectives = errors.New("invalid custom directives")
+
+const MaxCustomDirectivesBytes = 8 * 1024
+
+// deniedDirectives could read or write files outside the site, load code or
+// change what the panel manages (listeners, names, certificates, root, logs).
+var deniedDirectives = map[string]struct{}{
+	"access_log":            {},
+	"error_log":             {},
+	"client_body_temp_path": {},
+	"proxy_temp_path":       {},
+	"fastcgi_temp_path":     {},
+	"uwsgi_temp_path":       {},
+	"scgi_temp_path":        {},
+	"proxy_cache_path":      {},
+	"fastcgi_cache_path":    {},
+	"include":               {},
+	"load_module":           {},
+	"root":                  {},
+	"alias":                 {},
+	"listen":                {},
+	"server_name":           {},
+	"server":                {},
+	"http":                  {},
+	"events":                {},
+	"stream":                {},
+	"upstream":              {},
+	"user":                  {},
+	"pid":                   {},
+	"ssl_certificate":       {},
+	"ssl_certificate_key":   {},
+	"ssl_password_file":     {},
+	"auth_basic_user_file":  {},
+	"perl":                  {},
+	"perl_modules":          {},
+	"perl_require":          {},
+	"js_import":             {},
+	"js_path":               {},
+}
+
+// ValidateCustomDirectives checks a per-site snippet that is placed inside
+// the site's server block. It only accepts complete statements with balanced
+// blocks and rejects directives on the deny list; nginx -t still has the
+// final word when the vhost is provisioned.
+func ValidateCustomDirectives(raw string) error {
+	if len(raw) > MaxCustomDirectivesBytes {
+		return fmt.Errorf("%w: larger than %d bytes", ErrInvalidDirectives, MaxCustomDirectivesBytes)
+	}
+	depth := 0
+	expectName := true
+	pending := false // a statement is open and needs ';' or '{'
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch {
+		case c == '#':
+			for i < len(raw) && raw[i] != '\n' {
+				i++
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == ';':
+			if !pending {
+				return fmt.Errorf("%w: empty statement", ErrInvalidDirectives)
+			}
+			pending, expectName = false, true
+			i++
+			continue
+		case c == '{':
+			if !pending {
+				return fmt.Errorf("%w: block without a directive", ErrInvalidDirectives)
+			}
+			depth++
+			pending, expectName = false, true
+			i++
+			continue
+		case c == '}':
+			if pending {
+				return fmt.Errorf("%w: missing ';' before '}'", ErrInvalidDirectives)
+			}
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("%w: unbalanced '}'", ErrInvalidDirectives)
+			}
+			i++
+			continue
+		}
+
+		start := i
+		if c == '"' || c == '\'' {
+			i++
+			for i < len(raw) && raw[i] != c {
+				if raw[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(raw) {
+				return fmt.Errorf("%w: unterminated quote", ErrInvalidDirectives)
+			}
+			i++
+		} else {
+			for i < len(raw) && !strings.ContainsRune(" \t\r\n;{}#", rune(raw[i])) {
+				i++
+			}
+		}
+		if expectName {
+			name := strings.ToLower(raw[start:i])
+			if _, denied := deniedDirectives[name]; denied || strings.Contains(name, "_by_lua") {
+				return fmt.Errorf("%w: directive %q is not allowed", ErrInvalidDirectives, name)
+			}
+			expectName = false
+		}
+		pending = true
+	}
+	if pending {
+		return fmt.Errorf("%w: last statement is missing ';'", ErrInvalidDirectives)
+	}
+	if depth != 0 {
+		return fmt.Errorf("%w: unbalanced '{'", ErrInvalidDirectives)
+	}
+	return nil
+}
+
+// renderCustomDirectives indents the stored snippet into the server block.
+func renderCustomDirectives(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n    # custom directives\n")
+	for _, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString("    " + line + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,98 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nusantara/internal/store"
+)
+
+// ErrApplyDisabled is returned by checks that need the managed files on disk.
+var ErrApplyDisabled = errors.New("provisioning apply is disabled")
+
+// Drift issues reported for a site.
+const (
+	DriftConfigMissing  = "config_missing"
+	DriftConfigModified = "config_modified"
+	DriftLinkMissing    = "link_missing"
+	DriftLinkTarget     = "link_target"
+	DriftLinkUnexpected = "link_unexpected"
+)
+
+// SiteDrift compares the vhost the panel would render with what is on disk.
+type SiteDrift struct {
+	SiteID     string    `json:"site_id"`
+	Domain     string    `json:"domain"`
+	Status     string    `json:"status"`
+	ConfigPath string    `json:"config_path"`
+	LinkPath   string    `json:"link_path"`
+	Drifted    bool      `json:"drifted"`
+	Issues     []string  `json:"issues,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// CheckDrift reports hand edits of the site config and a missing, retargeted
+// or unexpected sites-enabled link. Suspended sites are expected to have no
+// link. Nothing on disk is changed.
+func (p *NginxProvisioner) CheckDrift(_ context.Context, site store.Site) (SiteDrift, error) {
+	if !p.cfg.Apply {
+		return SiteDrift{}, ErrApplyDisabled
+	}
+	if site.Domain == "" {
+		return SiteDrift{}, errors.New("site domain is empty")
+	}
+	if site.Unmanaged {
+		return SiteDrift{}, ErrSiteUnmanaged
+	}
+
+	confPath, linkPath := p.files().paths(site)
+	report := SiteDrift{
+		SiteID:     site.ID,
+		Domain:     site.Domain,
+		Status:     site.Status,
+		ConfigPath: confPath,
+		LinkPath:   linkPath,
+		CheckedAt:  time.Now().UTC(),
+	}
+
+	opts, _, err := p.resolveVhostOptions(site)
+	if err != nil {
+		return SiteDrift{}, err
+	}
+	expected, err := renderNginxServer(site, opts)
+	if err != nil {
+		return SiteDrift{}, fmt.Errorf("render nginx conf: %w", err)
+	}
+	actual, hasConf, err := readIfExists(report.ConfigPath)
+	if err != nil {
+		return SiteDrift{}, fmt.Errorf("read conf: %w", err)
+	}
+	switch {
+	case !hasConf:
+		report.Issues = append(report.Issues, DriftConfigMissing)
+	case !bytes.Equal(actual, []byte(expected)):
+		report.Issues = append(report.Issues, DriftConfigModified)
+	}
+
+	target, hasLink, err := readLinkIfExists(report.LinkPath)
+	if err != nil {
+		return SiteDrift{}, fmt.Errorf("read link: %w", err)
+	}
+	switch {
+	case site.Suspended:
+		if hasLink {
+			report.Issues = append(report.Issues, DriftLinkUnexpected)
+		}
+	case !hasLink:
+		report.Issues = append(report.Issues, DriftLinkMissing)
+	case target != report.ConfigPath:
+		report.Issues = append(report.Issues, DriftLinkTarget)
+	}
+
+	report.Drifted = len(report.Issues) > 0
+	return report, nil
+}
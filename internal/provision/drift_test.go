@@ -0,0 +1,83 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func TestCheckDrift(t *testing.T) {
+	dir := t.TempDir()
+	cfg := NginxConfig{
+		Apply:         true,
+		AvailableDir:  filepath.Join(dir, "available"),
+		EnabledDir:    filepath.Join(dir, "enabled"),
+		LogDir:        filepath.Join(dir, "logs"),
+		LogrotatePath: filepath.Join(dir, "logrotate"),
+		TestCommand:   "true",
+		ReloadCommand: "true",
+	}
+	p := NewNginxProvisioner(cfg, nil, nil, log.New(io.Discard, "", 0))
+	site := store.Site{
+		ID:       "site-1",
+		Domain:   "example.com",
+		RootPath: filepath.Join(dir, "www"),
+		Runtime:  "static",
+		Status:   store.SiteStatusActive,
+	}
+	ctx := context.Background()
+
+	check := func(want ...string) {
+		t.Helper()
+		report, err := p.CheckDrift(ctx, site)
+		if err != nil {
+			t.Fatalf("check drift: %v", err)
+		}
+		if !reflect.DeepEqual(report.Issues, want) || report.Drifted != (len(want) > 0) {
+			t.Fatalf("issues = %v drifted=%t, want %v", report.Issues, report.Drifted, want)
+		}
+	}
+
+	check(DriftConfigMissing, DriftLinkMissing)
+
+	if err := p.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	check()
+
+	confPath := filepath.Join(cfg.AvailableDir, "example.com.conf")
+	linkPath := filepath.Join(cfg.EnabledDir, "example.com.conf")
+	if err := os.WriteFile(confPath, []byte("server { listen 80; }\n"), 0o644); err != nil {
+		t.Fatalf("edit conf: %v", err)
+	}
+	if err := os.Remove(linkPath); err != nil {
+		t.Fatalf("remove link: %v", err)
+	}
+	check(DriftConfigModified, DriftLinkMissing)
+
+	// A suspended site must not have an enabled link.
+	site.Status = store.SiteStatusSuspended
+	site.Suspended = true
+	check(DriftConfigModified)
+	if err := os.Symlink(confPath, linkPath); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	check(DriftConfigModified, DriftLinkUnexpected)
+
+	site.Status = store.SiteStatusActive
+	if err := p.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	check()
+
+	if _, err := NewNginxProvisioner(NginxConfig{}, nil, nil, nil).CheckDrift(ctx, site); !errors.Is(err, ErrApplyDisabled) {
+		t.Fatalf("expected ErrApplyDisabled in dry-run, got %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package provision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"nusantara/internal/php"
+	"nusantara/internal/store"
+)
+
+// versionPlaceholder is replaced with the PHP version (e.g. 8.3) in the pool
+// dir and in the FPM test/reload commands.
+const versionPlaceholder = "{version}"
+
+const (
+	defaultPoolMaxChildren = 5
+	defaultPoolMemoryLimit = "256M"
+	defaultWebGroup        = "www-data"
+	defaultPoolTmpDir      = "/var/lib/nusantara-panel/php"
+)
+
+type FPMConfig struct {
+	// RunDir holds the per-site pool sockets, next to the distro sockets the
+	// version detector looks for.
+	RunDir         string
+	PoolDir        string
+	TestCommand    string
+	ReloadCommand  string
+	UserAddCommand string
+	UserDelCommand string
+	ChownCommand   string
+	WebGroup       string
+	// TmpDir holds a private tmp and sessions dir per site user, outside
+	// the served root.
+	TmpDir      string
+	MaxChildren int
+	MemoryLimit string
+}
+
+// FPMPoolProvisioner gives every PHP site its own PHP-FPM pool running as a
+// dedicated system user, so sites cannot read each other's files.
+type FPMPoolProvisioner struct {
+	cfg    FPMConfig
+	php    *php.Detector
+	logger *log.Logger
+}
+
+func NewFPMPoolProvisioner(cfg FPMConfig, logger *log.Logger) *FPMPoolProvisioner {
+	if cfg.WebGroup == "" {
+		cfg.WebGroup = defaultWebGroup
+	}
+	if cfg.MaxChildren < 1 {
+		cfg.MaxChildren = defaultPoolMaxChildren
+	}
+	if cfg.MemoryLimit == "" {
+		cfg.MemoryLimit = defaultPoolMemoryLimit
+	}
+	if cfg.TmpDir == "" {
+		cfg.TmpDir = defaultPoolTmpDir
+	}
+	return &FPMPoolProvisioner{
+		cfg:    cfg,
+		php:    php.NewDetector(cfg.RunDir),
+		logger: logger,
+	}
+}
+
+// PoolUser derives the Linux user name for a site. Site IDs end in a random
+// hex suffix, which keeps the name short and stable.
+func PoolUser(siteID string) string {
+	suffix := siteID
+	if i := strings.LastIndex(siteID, "_"); i >= 0 {
+		suffix = siteID[i+1:]
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(suffix) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	clean := b.String()
+	if clean == "" || len(clean) > 24 {
+		sum := sha256.Sum256([]byte(siteID))
+		clean = hex.EncodeToString(sum[:6])
+	}
+	return "np_" + clean
+}
+
+func (f *FPMPoolProvisioner) PoolPath(site store.Site, version string) string {
+	dir := strings.ReplaceAll(f.cfg.PoolDir, versionPlaceholder, version)
+	return filepath.Join(dir, PoolUser(site.ID)+".conf")
+}
+
+// TmpPath is the site's private dir under TmpDir; uploads and temp files go
+// to its tmp subdir and sessions to its sessions subdir.
+func (f *FPMPoolProvisioner) TmpPath(site store.Site) string {
+	return filepath.Join(f.cfg.TmpDir, PoolUser(site.ID))
+}
+
+func (f *FPMPoolProvisioner) SocketPath(site store.Site, version string) string {
+	return filepath.Join(f.php.RunDir(), PoolUser(site.ID)+"-php"+version+".sock")
+}
+
+// EnsurePool creates the site user, hands the site root to it when
+// claimRoot is set and writes the pool for the given version. The previous pool file is restored when the
+// FPM config test or reload fails. It returns the pool socket and an undo
+// func that puts the previous pool back, for when the web server rejects
+// the config that uses it.
+func (f *FPMPoolProvisioner) EnsurePool(ctx context.Context, site store.Site, version string, claimRoot bool) (string, func(), error) {
+	if site.ID == "" {
+		return "", nil, errors.New("site id is empty")
+	}
+	if !php.ValidVersion(version) {
+		return "", nil, php.ErrInvalidVersion
+	}
+	base := basedir(site)
+	if strings.ContainsAny(base, "\n\r;:") {
+		return "", nil, fmt.Errorf("site path %q cannot be used in a pool config", base)
+	}
+
+	username := PoolUser(site.ID)
+	if err := f.ensureUser(ctx, username, site.RootPath); err != nil {
+		return "", nil, fmt.Errorf("create site user: %w", err)
+	}
+	if claimRoot {
+		if err := runCommand(ctx, f.cfg.ChownCommand, username+":"+f.cfg.WebGroup, site.RootPath); err != nil {
+			return "", nil, fmt.Errorf("chown site root: %w", err)
+		}
+		// Nginx reads static files through the web group; other site users get nothing.
+		if err := os.Chmod(site.RootPath, 0o750); err != nil {
+			return "", nil, fmt.Errorf("chmod site root: %w", err)
+		}
+	} else {
+		f.logf("fpm site root kept its owner site=%s root=%s", site.ID, site.RootPath)
+	}
+	if err := f.ensureTmpDirs(ctx, site, username); err != nil {
+		return "", nil, err
+	}
+
+	poolPath := f.PoolPath(site, version)
+	socket := f.SocketPath(site, version)
+	if err := os.MkdirAll(filepath.Dir(poolPath), 0o755); err != nil {
+		return "", nil, fmt.Errorf("create pool dir: %w", err)
+	}
+	previous, hadPrevious, err := readIfExists(poolPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("read previous pool: %w", err)
+	}
+	if err := writeAtomic(poolPath, []byte(f.renderPool(site, username, socket))); err != nil {
+		return "", nil, fmt.Errorf("write pool conf: %w", err)
+	}
+	restore := func() {
+		if hadPrevious {
+			_ = writeAtomic(poolPath, previous)
+		} else {
+			_ = os.Remove(poolPath)
+		}
+	}
+
+	if err := runCommand(ctx, f.command(f.cfg.TestCommand, version)); err != nil {
+		restore()
+		return "", nil, fmt.Errorf("php-fpm test failed: %w", err)
+	}
+	if err := runCommand(ctx, f.command(f.cfg.ReloadCommand, version)); err != nil {
+		restore()
+		return "", nil, fmt.Errorf("php-fpm reload failed: %w", err)
+	}
+	f.logf("fpm pool ready site=%s user=%s version=%s", site.ID, username, version)
+	undo := func() {
+		restore()
+		if err := runCommand(ctx, f.command(f.cfg.ReloadCommand, version)); err != nil {
+			f.logf("fpm pool restore reload failed site=%s version=%s err=%v", site.ID, version, err)
+		}
+	}
+	return socket, undo, nil
+}
+
+// PrunePools removes the site's pools for every installed version except
+// keep. It runs after the vhost points at the new pool, so a version switch
+// never leaves nginx without a socket.
+func (f *FPMPoolProvisioner) PrunePools(ctx context.Context, site store.Site, keep string) error {
+	versions, err := f.php.Versions()
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v.Version == keep {
+			continue
+		}
+		if err := f.removePool(ctx, site, v.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemovePools deletes every pool of the site, its tmp dir and then its
+// system user. The site files are left in place.
+func (f *FPMPoolProvisioner) RemovePools(ctx context.Context, site store.Site) error {
+	if site.ID == "" {
+		return errors.New("site id is empty")
+	}
+	if err := f.PrunePools(ctx, site, ""); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(f.TmpPath(site)); err != nil {
+		return fmt.Errorf("remove site tmp dir: %w", err)
+	}
+	username := PoolUser(site.ID)
+	if _, err := user.Lookup(username); err != nil {
+		return nil
+	}
+	if err := runCommand(ctx, f.cfg.UserDelCommand, username); err != nil {
+		return fmt.Errorf("remove site user: %w", err)
+	}
+	f.logf("fpm site user removed site=%s user=%s", site.ID, username)
+	return nil
+}
+
+func (f *FPMPoolProvisioner) removePool(ctx context.Context, site store.Site, version string) error {
+	poolPath := f.PoolPath(site, version)
+	if err := os.Remove(poolPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("remove pool conf: %w", err)
+	}
+	if err := runCommand(ctx, f.command(f.cfg.TestCommand, version)); err != nil {
+		return fmt.Errorf("php-fpm test failed: %w", err)
+	}
+	if err := runCommand(ctx, f.command(f.cfg.ReloadCommand, version)); err != nil {
+		return fmt.Errorf("php-fpm reload failed: %w", err)
+	}
+	f.logf("fpm pool removed site=%s version=%s", site.ID, version)
+	return nil
+}
+
+// ensureTmpDirs creates the site's tmp and sessions dirs, readable by the
+// site user only. Existing dirs are left alone.
+func (f *FPMPoolProvisioner) ensureTmpDirs(ctx context.Context, site store.Site, username string) error {
+	dir := f.TmpPath(site)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	for _, sub := range []string{"tmp", "sessions"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return fmt.Errorf("create site tmp dir: %w", err)
+		}
+	}
+	if err := runCommand(ctx, f.cfg.ChownCommand, username+":"+username, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return fmt.Errorf("chown site tmp dir: %w", err)
+	}
+	return nil
+}
+
+// basedir is what the site's PHP may open besides its tmp dir: the deploy
+// dir once the root is a release inside it, so code can reach vendor and
+// shared files next to web_dir, otherwise the root.
+func basedir(site store.Site) string {
+	root := filepath.Clean(site.RootPath)
+	if site.Deploy != nil && site.Deploy.Path != "" {
+		path := filepath.Clean(site.Deploy.Path)
+		if strings.HasPrefix(root, path+string(os.PathSeparator)) {
+			return path
+		}
+	}
+	return root
+}
+
+// ownedBy reports whether path belongs to the given system user.
+func ownedBy(path, username string) bool {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && strconv.FormatUint(uint64(st.Uid), 10) == u.Uid
+}
+
+func (f *FPMPoolProvisioner) ensureUser(ctx context.Context, username, home string) error {
+	if _, err := user.Lookup(username); err == nil {
+		return nil
+	}
+	return runCommand(ctx, f.cfg.UserAddCommand, "--home-dir", home, "--user-group", username)
+}
+
+func (f *FPMPoolProvisioner) command(raw, version string) string {
+	return strings.ReplaceAll(raw, versionPlaceholder, version)
+}
+
+func (f *FPMPoolProvisioner) renderPool(site store.Site, username, socket string) string {
+	dir := f.TmpPath(site)
+	tmp := filepath.Join(dir, "tmp")
+	return fmt.Sprintf(`; Managed by Nusantara Panel for %s. Manual changes are overwritten.
+[%s]
+user = %s
+group = %s
+listen = %s
+listen.owner = %s
+listen.group = %s
+listen.mode = 0660
+
+pm = ondemand
+pm.max_children = %d
+pm.process_idle_timeout = 10s
+pm.max_requests = 500
+request_terminate_timeout = 300s
+
+php_admin_value[open_basedir] = %s:%s
+php_admin_value[upload_tmp_dir] = %s
+php_admin_value[sys_temp_dir] = %s
+php_admin_value[session.save_path] = %s
+php_admin_value[memory_limit] = %s
+php_admin_value[disable_functions] = exec,passthru,shell_exec,system,proc_open,popen
+php_admin_flag[allow_url_include] = off
+`, site.Domain, username, username, username, socket, f.cfg.WebGroup, f.cfg.WebGroup,
+		f.cfg.MaxChildren, basedir(site), dir, tmp, tmp, filepath.Join(dir, "sessions"), f.cfg.MemoryLimit)
+}
+
+func (f *FPMPoolProvisioner) logf(format string, args ...any) {
+	if f.logger != nil {
+		f.logger.Printf(format, args...)
+	}
+}
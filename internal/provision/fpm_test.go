@@ -0,0 +1,216 @@
+package provision
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+type fpmFixture struct {
+	runDir  string
+	poolDir string
+	nginx   *NginxProvisioner
+	fpm     *FPMPoolProvisioner
+}
+
+func newFPMFixture(t *testing.T, fpmTestCommand string) fpmFixture {
+	t.Helper()
+	base := t.TempDir()
+	runDir := filepath.Join(base, "run")
+	if err := os.MkdirAll(runDir, 0o755); err != nil {
+		t.Fatalf("mkdir run dir: %v", err)
+	}
+	for _, v := range []string{"8.2", "8.3"} {
+		if err := os.WriteFile(filepath.Join(runDir, "php"+v+"-fpm.sock"), nil, 0o644); err != nil {
+			t.Fatalf("seed socket: %v", err)
+		}
+	}
+	logger := log.New(io.Discard, "", 0)
+	fpm := NewFPMPoolProvisioner(FPMConfig{
+		RunDir:         runDir,
+		PoolDir:        filepath.Join(base, "php", "{version}", "pool.d"),
+		TestCommand:    fpmTestCommand,
+		ReloadCommand:  "true",
+		UserAddCommand: "true",
+		UserDelCommand: "true",
+		ChownCommand:   "true",
+		TmpDir:         filepath.Join(base, "phptmp"),
+	}, logger)
+	nginx := NewNginxProvisioner(NginxConfig{
+		Apply:         true,
+		AvailableDir:  filepath.Join(base, "available"),
+		EnabledDir:    filepath.Join(base, "enabled"),
+		LogDir:        filepath.Join(base, "logs"),
+		LogrotatePath: filepath.Join(base, "logrotate"),
+		TestCommand:   "true",
+		ReloadCommand: "true",
+		PHPFPMRunDir:  runDir,
+	}, fpm, nil, logger)
+	return fpmFixture{runDir: runDir, poolDir: filepath.Join(base, "php"), nginx: nginx, fpm: fpm}
+}
+
+func TestPoolUser(t *testing.T) {
+	if got := PoolUser("site_1712345678901234567_a1b2c3d4e5f6"); got != "np_a1b2c3d4e5f6" {
+		t.Fatalf("unexpected pool user: %s", got)
+	}
+	long := PoolUser("site-" + strings.Repeat("x", 40))
+	if len(long) > 32 || !strings.HasPrefix(long, "np_") {
+		t.Fatalf("pool user must fit a linux user name: %s", long)
+	}
+}
+
+func TestProvisionSiteCreatesIsolatedPool(t *testing.T) {
+	f := newFPMFixture(t, "true")
+	site := store.Site{
+		ID:         "site_1_abc123",
+		Domain:     "php.example.test",
+		RootPath:   filepath.Join(t.TempDir(), "www"),
+		Runtime:    "php",
+		PHPVersion: "8.3",
+	}
+	ctx := context.Background()
+	if err := f.nginx.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+
+	pool, err := os.ReadFile(filepath.Join(f.poolDir, "8.3", "pool.d", "np_abc123.conf"))
+	if err != nil {
+		t.Fatalf("read pool: %v", err)
+	}
+	socket := filepath.Join(f.runDir, "np_abc123-php8.3.sock")
+	tmpDir := f.fpm.TmpPath(site)
+	for _, want := range []string{
+		"user = np_abc123",
+		"listen = " + socket,
+		"php_admin_value[open_basedir] = " + site.RootPath + ":" + tmpDir + "\n",
+		"php_admin_value[upload_tmp_dir] = " + filepath.Join(tmpDir, "tmp") + "\n",
+		"php_admin_value[sys_temp_dir] = " + filepath.Join(tmpDir, "tmp") + "\n",
+		"php_admin_value[session.save_path] = " + filepath.Join(tmpDir, "sessions") + "\n",
+	} {
+		if !strings.Contains(string(pool), want) {
+			t.Fatalf("pool missing %q:\n%s", want, pool)
+		}
+	}
+	vhost, err := os.ReadFile(filepath.Join(f.nginx.cfg.AvailableDir, "php.example.test.conf"))
+	if err != nil {
+		t.Fatalf("read vhost: %v", err)
+	}
+	if !strings.Contains(string(vhost), "fastcgi_pass unix:"+socket+";") {
+		t.Fatalf("vhost must use the site pool socket:\n%s", vhost)
+	}
+
+	site.PHPVersion = "8.2"
+	if err := f.nginx.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("switch version: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(f.poolDir, "8.3", "pool.d", "np_abc123.conf")); !os.IsNotExist(err) {
+		t.Fatalf("old version pool should be removed, stat err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(f.poolDir, "8.2", "pool.d", "np_abc123.conf")); err != nil {
+		t.Fatalf("new version pool missing: %v", err)
+	}
+
+	if err := f.nginx.DeprovisionSite(ctx, site); err != nil {
+		t.Fatalf("deprovision: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(f.poolDir, "8.2", "pool.d", "np_abc123.conf")); !os.IsNotExist(err) {
+		t.Fatalf("pool should be removed on deprovision, stat err=%v", err)
+	}
+	if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+		t.Fatalf("site tmp dir should be removed on deprovision, stat err=%v", err)
+	}
+}
+
+func TestProvisionSiteClaimsOnlyRootsItCreates(t *testing.T) {
+	f := newFPMFixture(t, "true")
+	site := store.Site{ID: "site_1_abc123", Domain: "php.example.test", RootPath: filepath.Join(t.TempDir(), "www"), Runtime: "php", PHPVersion: "8.3"}
+	ctx := context.Background()
+	if err := f.nginx.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision new root: %v", err)
+	}
+	if info, err := os.Stat(site.RootPath); err != nil || info.Mode().Perm() != 0o750 {
+		t.Fatalf("new root must be handed to the site user, stat=%v err=%v", info, err)
+	}
+
+	// An existing directory someone else owns is neither chowned nor chmodded.
+	f.fpm.cfg.ChownCommand = "false"
+	existing := t.TempDir()
+	if err := os.Chmod(existing, 0o755); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	site.RootPath = existing
+	if err := f.nginx.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision existing root: %v", err)
+	}
+	if info, err := os.Stat(existing); err != nil || info.Mode().Perm() != 0o755 {
+		t.Fatalf("existing root must keep its mode, stat=%v err=%v", info, err)
+	}
+}
+
+func TestPoolBasedirCoversDeployDir(t *testing.T) {
+	f := newFPMFixture(t, "true")
+	deployDir := t.TempDir()
+	site := store.Site{ID: "site_1_abc123", Domain: "php.example.test", RootPath: filepath.Join(deployDir, "current", "public"), Runtime: "php",
+		Deploy: &store.SiteDeploy{Path: deployDir}}
+	if err := os.MkdirAll(site.RootPath, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, _, err := f.fpm.EnsurePool(context.Background(), site, "8.3", true); err != nil {
+		t.Fatalf("ensure pool: %v", err)
+	}
+	pool, err := os.ReadFile(f.fpm.PoolPath(site, "8.3"))
+	if err != nil {
+		t.Fatalf("read pool: %v", err)
+	}
+	if want := "php_admin_value[open_basedir] = " + deployDir + ":"; !strings.Contains(string(pool), want) {
+		t.Fatalf("pool missing %q:\n%s", want, pool)
+	}
+}
+
+func TestEnsurePoolRestoresOnFailedTest(t *testing.T) {
+	f := newFPMFixture(t, "false")
+	site := store.Site{ID: "site_1_abc123", Domain: "php.example.test", RootPath: t.TempDir(), Runtime: "php"}
+	if _, _, err := f.fpm.EnsurePool(context.Background(), site, "8.3", true); err == nil {
+		t.Fatalf("expected php-fpm test failure")
+	}
+	if _, err := os.Stat(f.fpm.PoolPath(site, "8.3")); !os.IsNotExist(err) {
+		t.Fatalf("pool must not be left behind after a failed test, stat err=%v", err)
+	}
+}
+
+func TestProvisionSiteRestoresPoolWhenNginxRejectsConfig(t *testing.T) {
+	f := newFPMFixture(t, "true")
+	site := store.Site{ID: "site_1_abc123", Domain: "php.example.test", RootPath: t.TempDir(), Runtime: "php", PHPVersion: "8.3"}
+	ctx := context.Background()
+	if err := f.nginx.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	poolPath := f.fpm.PoolPath(site, "8.3")
+	before, err := os.ReadFile(poolPath)
+	if err != nil {
+		t.Fatalf("read pool: %v", err)
+	}
+
+	f.nginx.cfg.TestCommand = "false"
+	moved := site
+	moved.RootPath = t.TempDir()
+	if err := f.nginx.ProvisionSite(ctx, moved); err == nil {
+		t.Fatalf("expected nginx test failure")
+	}
+	if after, _ := os.ReadFile(poolPath); string(after) != string(before) {
+		t.Fatalf("pool not restored after nginx rejected the vhost:\n%s", after)
+	}
+	moved.PHPVersion = "8.2"
+	if err := f.nginx.ProvisionSite(ctx, moved); err == nil {
+		t.Fatalf("expected nginx test failure")
+	}
+	if _, err := os.Stat(f.fpm.PoolPath(site, "8.2")); !os.IsNotExist(err) {
+		t.Fatalf("new version pool left behind, stat err=%v", err)
+	}
+}
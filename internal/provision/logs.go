@@ -0,0 +1,252 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nusantara/internal/store"
+)
+
+var ErrInvalidLogType = errors.New("log type must be access or error")
+
+const (
+	LogTypeAccess = "access"
+	LogTypeError  = "error"
+)
+
+const (
+	defaultLogDir       = "/var/log/nginx/nusantara"
+	defaultLogrotate    = "/etc/logrotate.d/nusantara-panel-sites"
+	logRetentionDays    = 14
+	maxLogTailBytes     = 1 << 20
+	maxLogFollowBytes   = 1 << 20
+	logFollowInterval   = time.Second
+	DefaultLogTailLines = 100
+	MaxLogTailLines     = 1000
+)
+
+// SiteLog is the end of a site log. Offset is the file size the lines were
+// read up to, where a follow continues from.
+type SiteLog struct {
+	Path   string   `json:"path"`
+	Lines  []string `json:"lines"`
+	Offset int64    `json:"offset"`
+}
+
+func (p *NginxProvisioner) logDir() string {
+	if strings.TrimSpace(p.cfg.LogDir) == "" {
+		return defaultLogDir
+	}
+	return p.cfg.LogDir
+}
+
+// SiteLogPath returns the file the site's vhost logs to.
+func (p *NginxProvisioner) SiteLogPath(site store.Site, logType string) (string, error) {
+	if logType != LogTypeAccess && logType != LogTypeError {
+		return "", ErrInvalidLogType
+	}
+	return filepath.Join(p.logDir(), sanitizeConfName(site.Domain)+"."+logType+".log"), nil
+}
+
+func renderLogDirectives(accessLog, errorLog string) string {
+	return fmt.Sprintf("\n    access_log %s;\n    error_log %s warn;\n", accessLog, errorLog)
+}
+
+// renderLogrotate covers every site log with one glob, so adding a site
+// never needs a logrotate change.
+func renderLogrotate(logDir string) string {
+	return fmt.Sprintf(`# Managed by nusantara-panel; changes are overwritten.
+%s/*.log {
+    daily
+    rotate %d
+    missingok
+    notifempty
+    compress
+    delaycompress
+    sharedscripts
+    postrotate
+        [ ! -f /run/nginx.pid ] || kill -USR1 "$(cat /run/nginx.pid)"
+    endscript
+}
+`, logDir, logRetentionDays)
+}
+
+// ensureSiteLogs creates the log directory and keeps the logrotate config
+// current. nginx creates the files themselves when it opens them.
+func (p *NginxProvisioner) ensureSiteLogs() error {
+	if err := os.MkdirAll(p.logDir(), 0o755); err != nil {
+		return fmt.Errorf("create log dir: %w", err)
+	}
+	path := p.cfg.LogrotatePath
+	if strings.TrimSpace(path) == "" {
+		path = defaultLogrotate
+	}
+	want := renderLogrotate(p.logDir())
+	current, ok, err := readIfExists(path)
+	if err != nil {
+		return fmt.Errorf("read logrotate config: %w", err)
+	}
+	if ok && string(current) == want {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create logrotate dir: %w", err)
+	}
+	if err := writeAtomic(path, []byte(want)); err != nil {
+		return fmt.Errorf("write logrotate config: %w", err)
+	}
+	return nil
+}
+
+// removeSiteLogs deletes the site's logs including rotated copies.
+func (p *NginxProvisioner) removeSiteLogs(site store.Site) error {
+	for _, logType := range []string{LogTypeAccess, LogTypeError} {
+		path, err := p.SiteLogPath(site, logType)
+		if err != nil {
+			return err
+		}
+		matches, err := filepath.Glob(path + "*")
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove log: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// TailSiteLog returns the last lines of a site log. A log that does not
+// exist yet, because the site had no traffic, reads as empty.
+func (p *NginxProvisioner) TailSiteLog(site store.Site, logType string, lines int) (SiteLog, error) {
+	path, err := p.SiteLogPath(site, logType)
+	if err != nil {
+		return SiteLog{}, err
+	}
+	tail, offset, err := tailFile(path, lines)
+	if err != nil {
+		return SiteLog{}, err
+	}
+	return SiteLog{Path: path, Lines: tail, Offset: offset}, nil
+}
+
+// FollowSiteLog streams lines appended to a site log after offset until ctx
+// ends. emit is also called with no lines on idle polls so callers can
+// send keep-alives.
+func (p *NginxProvisioner) FollowSiteLog(ctx context.Context, site store.Site, logType string, offset int64, emit func(lines []string) error) error {
+	path, err := p.SiteLogPath(site, logType)
+	if err != nil {
+		return err
+	}
+	return followFile(ctx, path, offset, logFollowInterval, emit)
+}
+
+func tailFile(path string, lines int) ([]string, int64, error) {
+	if lines <= 0 {
+		lines = DefaultLogTailLines
+	}
+	lines = min(lines, MaxLogTailLines)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("stat log: %w", err)
+	}
+	size := info.Size()
+	start := max(size-maxLogTailBytes, 0)
+	buf := make([]byte, size-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, fmt.Errorf("read log: %w", err)
+	}
+	text := strings.TrimSuffix(string(buf), "\n")
+	if text == "" {
+		return []string{}, size, nil
+	}
+	out := strings.Split(text, "\n")
+	if start > 0 {
+		// The window starts mid-line.
+		out = out[1:]
+	}
+	if len(out) > lines {
+		out = out[len(out)-lines:]
+	}
+	return out, size, nil
+}
+
+// followFile polls path like tail -F: a file that shrinks or is replaced
+// by logrotate is read again from the start. A trailing partial line is
+// held back until its newline arrives.
+func followFile(ctx context.Context, path string, offset int64, interval time.Duration, emit func(lines []string) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var previous os.FileInfo
+	var partial string
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			previous, offset, partial = nil, 0, ""
+		case err != nil:
+			return fmt.Errorf("stat log: %w", err)
+		default:
+			if (previous != nil && !os.SameFile(previous, info)) || info.Size() < offset {
+				offset, partial = 0, ""
+			}
+			previous = info
+		}
+
+		var lines []string
+		if previous != nil && previous.Size() > offset {
+			chunk, err := readRange(path, offset, min(previous.Size()-offset, maxLogFollowBytes))
+			if err != nil {
+				return err
+			}
+			offset += int64(len(chunk))
+			text := partial + string(chunk)
+			cut := strings.LastIndexByte(text, '\n')
+			if cut >= 0 {
+				lines = strings.Split(text[:cut], "\n")
+				partial = text[cut+1:]
+			} else {
+				partial = text
+			}
+		}
+		if err := emit(lines); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func readRange(path string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read log: %w", err)
+	}
+	return buf[:n], nil
+}
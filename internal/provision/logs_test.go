@@ -0,0 +1,129 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"nusantara/internal/store"
+)
+
+func TestTailFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	lines, offset, err := tailFile(path, 10)
+	if err != nil || len(lines) != 0 || offset != 0 {
+		t.Fatalf("missing log: lines=%v offset=%d err=%v", lines, offset, err)
+	}
+
+	var b strings.Builder
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	lines, offset, err = tailFile(path, 2)
+	if err != nil {
+		t.Fatalf("tail: %v", err)
+	}
+	if !reflect.DeepEqual(lines, []string{"line 4", "line 5"}) || offset != int64(b.Len()) {
+		t.Fatalf("unexpected tail lines=%v offset=%d", lines, offset)
+	}
+}
+
+func TestFollowFileHandlesRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var got []string
+	polls := 0
+	err := followFile(ctx, path, 4, time.Millisecond, func(lines []string) error {
+		got = append(got, lines...)
+		polls++
+		switch polls {
+		case 1:
+			appendFile(t, path, "first\nsecond par")
+		case 2:
+			appendFile(t, path, "tial\n")
+		case 3:
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if err := os.WriteFile(path, []byte("rotated\n"), 0o644); err != nil {
+				t.Fatalf("write rotated log: %v", err)
+			}
+		}
+		if len(got) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+	if want := []string{"first", "second partial", "rotated"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("followed lines = %v, want %v", got, want)
+	}
+}
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("append log: %v", err)
+	}
+}
+
+func TestSiteLogsRenderedAndRotated(t *testing.T) {
+	dir := t.TempDir()
+	p := NewNginxProvisioner(NginxConfig{
+		Apply:         true,
+		LogDir:        filepath.Join(dir, "logs"),
+		LogrotatePath: filepath.Join(dir, "logrotate", "sites"),
+	}, nil, nil, log.New(io.Discard, "", 0))
+	site := store.Site{Domain: "example.com", RootPath: "/var/www/example", Runtime: "static"}
+
+	opts, _, err := p.resolveVhostOptions(site)
+	if err != nil {
+		t.Fatalf("resolve options: %v", err)
+	}
+	conf := mustRenderNginxServer(t, site, opts)
+	for _, want := range []string{
+		"    access_log " + filepath.Join(dir, "logs", "example.com.access.log") + ";",
+		"    error_log " + filepath.Join(dir, "logs", "example.com.error.log") + " warn;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+
+	if err := p.ensureSiteLogs(); err != nil {
+		t.Fatalf("ensure logs: %v", err)
+	}
+	rotate, err := os.ReadFile(filepath.Join(dir, "logrotate", "sites"))
+	if err != nil {
+		t.Fatalf("read logrotate: %v", err)
+	}
+	if !strings.HasPrefix(strings.SplitN(string(rotate), "\n", 3)[1], filepath.Join(dir, "logs")+"/*.log {") {
+		t.Fatalf("unexpected logrotate config:\n%s", rotate)
+	}
+
+	if _, err := p.TailSiteLog(site, "debug", 10); err != ErrInvalidLogType {
+		t.Fatalf("expected invalid log type, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nusantara/internal/store"
+)
+
+const defaultMaintenanceDir = "/var/lib/nusantara-panel/maintenance"
+
+func (p *NginxProvisioner) maintenanceDir() string {
+	if strings.TrimSpace(p.cfg.MaintenanceDir) == "" {
+		return defaultMaintenanceDir
+	}
+	return p.cfg.MaintenanceDir
+}
+
+func (p *NginxProvisioner) maintenancePagePath(site store.Site) string {
+	return filepath.Join(p.maintenanceDir(), sanitizeConfName(site.Domain)+".html")
+}
+
+// writeMaintenancePage stores the page served while the site is in
+// maintenance. It lives outside the site root so deploys cannot clobber it.
+func (p *NginxProvisioner) writeMaintenancePage(site store.Site) (string, error) {
+	path := p.maintenancePagePath(site)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("create maintenance dir: %w", err)
+	}
+	page := site.Maintenance.Page
+	if strings.TrimSpace(page) == "" {
+		page = defaultMaintenancePage(site.Domain)
+	}
+	if err := writeAtomic(path, []byte(page)); err != nil {
+		return "", fmt.Errorf("write maintenance page: %w", err)
+	}
+	return path, nil
+}
+
+// SuspendSite takes the site offline by removing its sites-enabled link. The
+// rendered config stays in sites-available so resume is a normal provision.
+func (p *NginxProvisioner) SuspendSite(ctx context.Context, site store.Site) error {
+	if !p.cfg.Apply {
+		p.logf("dry-run suspend site=%s domain=%s", site.ID, site.Domain)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if site.Domain == "" {
+		return errors.New("site domain is empty")
+	}
+	if site.Unmanaged {
+		return ErrSiteUnmanaged
+	}
+
+	suspended, err := p.files().suspend(ctx, site)
+	if err != nil {
+		return err
+	}
+	if !suspended {
+		p.logf("site already suspended domain=%s", site.Domain)
+		return nil
+	}
+	p.logf("site suspended domain=%s", site.Domain)
+	return nil
+}
+
+func maintenanceVariable(site store.Site) string {
+	return siteVariable("$nusantara_maintenance_", site)
+}
+
+func maintenanceClientVariable(site store.Site) string {
+	return siteVariable("$nusantara_maintenance_client_", site)
+}
+
+// renderMaintenanceGeo maps the client address to 1 unless it is on the
+// allowlist, then exempts ACME challenges like renderAccessMaps so
+// certificates still renew during maintenance. geo and map are only valid
+// in the http context, which is where vhost files are included.
+func renderMaintenanceGeo(site store.Site) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "geo %s {\n    default 1;\n", maintenanceClientVariable(site))
+	for _, allowed := range site.Maintenance.AllowIPs {
+		fmt.Fprintf(&b, "    %s 0;\n", allowed)
+	}
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "map \"%s:$uri\" %s {\n    default 0;\n    \"~^1:/\\.well-known/acme-challenge/\" 0;\n    \"~^1:\" 1;\n}\n\n",
+		maintenanceClientVariable(site), maintenanceVariable(site))
+	return b.String()
+}
+
+// renderMaintenanceDirectives answers 503 with the maintenance page for
+// everyone outside the allowlist. The named location is reached without
+// re-running the server-level if, so the page itself is not blocked.
+func renderMaintenanceDirectives(site store.Site, pagePath string) string {
+	return fmt.Sprintf(`
+    error_page 503 @nusantara_maintenance;
+    if (%s) {
+        return 503;
+    }
+
+    location @nusantara_maintenance {
+        root %s;
+        rewrite ^ /%s break;
+        add_header Retry-After 600 always;
+    }
+`, maintenanceVariable(site), filepath.Dir(pagePath), filepath.Base(pagePath))
+}
+
+func defaultMaintenancePage(domain string) string {
+	label := html.EscapeString(strings.TrimSpace(domain))
+	if label == "" {
+		label = "site"
+	}
+	return fmt.Sprintf(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>%s is under maintenance</title>
+</head>
+<body>
+  <h1>%s is under maintenance</h1>
+  <p>We will be back shortly.</p>
+</body>
+</html>
+`, label, label)
+}
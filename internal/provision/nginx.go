@@ -5,11 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
+	"nusantara/internal/php"
 	"nusantara/internal/store"
 )
 
@@ -19,78 +23,167 @@ type NginxConfig struct {
 	EnabledDir    string
 	TestCommand   string
 	ReloadCommand string
+	// MainConfig is the nginx.conf that includes EnabledDir. Dry
+	// validations test a copy of it, see PreviewSite.
+	MainConfig   string
+	PHPFPMRunDir string
+	// TemplateDir holds admin overrides of the runtime templates
+	// (php.tmpl, static.tmpl, proxy.tmpl, node.tmpl, python.tmpl).
+	TemplateDir string
+	// MaintenanceDir holds the maintenance pages served by sites in
+	// maintenance mode.
+	MaintenanceDir string
+	// HtpasswdDir holds the per-site basic auth user files. They are
+	// readable by WebGroup only, the group nginx workers run as.
+	HtpasswdDir string
+	WebGroup    string
+	// CacheDir holds the per-site fastcgi_cache directories.
+	CacheDir string
+	// LogDir holds the per-site access and error logs; LogrotatePath is
+	// the logrotate config generated for them.
+	LogDir        string
+	LogrotatePath string
 }
 
 type NginxProvisioner struct {
+	// mu serializes changes to the nginx config so a staged validation or a
+	// rollback never races another provisioning run.
+	mu     sync.Mutex
 	cfg    NginxConfig
+	host   siteHost
 	logger *log.Logger
 }
 
-func NewNginxProvisioner(cfg NginxConfig, logger *log.Logger) *NginxProvisioner {
+// vhostOptions carries host-specific values resolved at provision time.
+type vhostOptions struct {
+	PHPSocket       string
+	TemplateDir     string
+	MaintenancePage string
+	HtpasswdPath    string
+	CachePath       string
+	AccessLog       string
+	ErrorLog        string
+}
+
+// defaultPHPSocket is the distro-managed alias for the default PHP-FPM
+// version, used when no version was resolved.
+const defaultPHPSocket = "/run/php/php-fpm.sock"
+
+// NewNginxProvisioner builds the nginx provisioner. With a nil fpm, PHP sites
+// share the distro pool of their version; with nil apps, node and python
+// sites are never started by the panel.
+func NewNginxProvisioner(cfg NginxConfig, fpm *FPMPoolProvisioner, apps *SystemdProvisioner, logger *log.Logger) *NginxProvisioner {
+	if cfg.WebGroup == "" {
+		cfg.WebGroup = defaultWebGroup
+	}
 	return &NginxProvisioner{
 		cfg:    cfg,
+		host:   siteHost{php: php.NewDetector(cfg.PHPFPMRunDir), fpm: fpm, apps: apps},
 		logger: logger,
 	}
 }
 
+func (p *NginxProvisioner) files() vhostFiles {
+	return vhostFiles{
+		server:        WebServerNginx,
+		availableDir:  p.cfg.AvailableDir,
+		enabledDir:    p.cfg.EnabledDir,
+		ext:           ".conf",
+		testCommand:   p.cfg.TestCommand,
+		reloadCommand: p.cfg.ReloadCommand,
+		retire:        p.disableImportedVhost,
+	}
+}
+
 func (p *NginxProvisioner) ProvisionSite(ctx context.Context, site store.Site) error {
 	if !p.cfg.Apply {
 		p.logf("dry-run provisioning site=%s domain=%s", site.ID, site.Domain)
 		return nil
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	if site.Domain == "" {
-		return errors.New("site domain is empty")
+	// Reject settings nginx cannot render before touching the host.
+	if err := ValidateCustomDirectives(site.CustomDirectives); err != nil {
+		return err
+	}
+	opts, _, err := p.resolveVhostOptions(site)
+	if err != nil {
+		return err
 	}
-	if site.RootPath == "" {
-		return errors.New("site root_path is empty")
+	conf, err := renderNginxServer(site, opts)
+	if err != nil {
+		return fmt.Errorf("render nginx conf: %w", err)
 	}
-
-	if err := os.MkdirAll(p.cfg.AvailableDir, 0o755); err != nil {
-		return fmt.Errorf("create available dir: %w", err)
+	// The app starts before nginx proxies to it. Until nginx reloads with
+	// the new vhost, a failure puts the previous pool back so the live
+	// config keeps the pool it was tested with.
+	_, version, undoHost, err := p.host.prepare(ctx, site)
+	if err != nil {
+		return err
 	}
-	if err := os.MkdirAll(p.cfg.EnabledDir, 0o755); err != nil {
-		return fmt.Errorf("create enabled dir: %w", err)
+	if err := p.prepareSiteFiles(site, opts); err != nil {
+		undoHost()
+		return err
 	}
-	if err := os.MkdirAll(site.RootPath, 0o755); err != nil {
-		return fmt.Errorf("create site root: %w", err)
+	confPath, err := p.files().install(ctx, site, conf)
+	if err != nil {
+		undoHost()
+		return err
 	}
-	if err := ensureRuntimeBootstrap(site); err != nil {
-		return fmt.Errorf("bootstrap site root: %w", err)
+	if err := p.host.settle(ctx, site, version); err != nil {
+		return err
 	}
 
-	confName := sanitizeConfName(site.Domain) + ".conf"
-	confPath := filepath.Join(p.cfg.AvailableDir, confName)
-	linkPath := filepath.Join(p.cfg.EnabledDir, confName)
+	p.logf("site provisioned domain=%s conf=%s", site.Domain, confPath)
+	return nil
+}
 
-	previousConf, hadPreviousConf, err := readIfExists(confPath)
-	if err != nil {
-		return fmt.Errorf("read previous conf: %w", err)
+// prepareSiteFiles writes what the rendered vhost refers to outside the
+// site root.
+func (p *NginxProvisioner) prepareSiteFiles(site store.Site, opts vhostOptions) error {
+	if site.Maintenance != nil {
+		if _, err := p.writeMaintenancePage(site); err != nil {
+			return err
+		}
 	}
-	previousLinkTarget, hadPreviousLink, err := readLinkIfExists(linkPath)
-	if err != nil {
-		return fmt.Errorf("read previous link: %w", err)
+	if opts.CachePath != "" {
+		if err := p.ensureFastCGICache(site); err != nil {
+			return err
+		}
 	}
+	return p.ensureSiteLogs()
+}
 
-	if err := writeAtomic(confPath, []byte(renderNginxServer(site))); err != nil {
-		return fmt.Errorf("write nginx conf: %w", err)
+// resolveVhostOptions works out the host-specific render inputs without
+// touching the system, so drift checks render exactly what provisioning
+// would write.
+func (p *NginxProvisioner) resolveVhostOptions(site store.Site) (vhostOptions, php.Version, error) {
+	opts := vhostOptions{TemplateDir: p.cfg.TemplateDir}
+	opts.AccessLog, _ = p.SiteLogPath(site, LogTypeAccess)
+	opts.ErrorLog, _ = p.SiteLogPath(site, LogTypeError)
+	var version php.Version
+	if site.Runtime == "php" {
+		resolved, err := p.host.php.Resolve(site.PHPVersion)
+		if err != nil {
+			return vhostOptions{}, php.Version{}, fmt.Errorf("resolve php-fpm: %w", err)
+		}
+		version = resolved
+		opts.PHPSocket = version.Socket
+		if p.host.fpm != nil {
+			opts.PHPSocket = p.host.fpm.SocketPath(site, version.Version)
+		}
 	}
-	if err := upsertSymlink(confPath, linkPath); err != nil {
-		_ = rollback(confPath, linkPath, previousConf, hadPreviousConf, previousLinkTarget, hadPreviousLink)
-		return fmt.Errorf("upsert symlink: %w", err)
+	if site.Maintenance != nil {
+		opts.MaintenancePage = p.maintenancePagePath(site)
 	}
-
-	if err := runCommand(ctx, p.cfg.TestCommand); err != nil {
-		_ = rollback(confPath, linkPath, previousConf, hadPreviousConf, previousLinkTarget, hadPreviousLink)
-		return fmt.Errorf("nginx test failed: %w", err)
+	if site.Access != nil && len(site.Access.Users) > 0 {
+		opts.HtpasswdPath = p.HtpasswdPath(site)
 	}
-	if err := runCommand(ctx, p.cfg.ReloadCommand); err != nil {
-		_ = rollback(confPath, linkPath, previousConf, hadPreviousConf, previousLinkTarget, hadPreviousLink)
-		return fmt.Errorf("nginx reload failed: %w", err)
+	if site.Policy != nil && site.Policy.FastCGICache > 0 {
+		opts.CachePath = p.fastCGICachePath(site)
 	}
-
-	p.logf("site provisioned domain=%s conf=%s", site.Domain, confPath)
-	return nil
+	return opts, version, nil
 }
 
 func (p *NginxProvisioner) DeprovisionSite(ctx context.Context, site store.Site) error {
@@ -98,26 +191,30 @@ func (p *NginxProvisioner) DeprovisionSite(ctx context.Context, site store.Site)
 		p.logf("dry-run deprovision site=%s domain=%s", site.ID, site.Domain)
 		return nil
 	}
+	if site.Unmanaged {
+		p.logf("leaving unmanaged vhost in place domain=%s file=%s", site.Domain, site.ImportedFrom)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if site.Domain == "" {
 		return errors.New("site domain is empty")
 	}
 
-	confName := sanitizeConfName(site.Domain) + ".conf"
-	confPath := filepath.Join(p.cfg.AvailableDir, confName)
-	linkPath := filepath.Join(p.cfg.EnabledDir, confName)
-
-	if err := os.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("remove symlink: %w", err)
+	if err := p.files().remove(ctx, site); err != nil {
+		return err
 	}
-	if err := os.Remove(confPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("remove conf: %w", err)
+	if err := os.Remove(p.HtpasswdPath(site)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove htpasswd: %w", err)
 	}
-
-	if err := runCommand(ctx, p.cfg.TestCommand); err != nil {
-		return fmt.Errorf("nginx test failed: %w", err)
+	if err := os.RemoveAll(p.fastCGICachePath(site)); err != nil {
+		return fmt.Errorf("remove fastcgi cache: %w", err)
+	}
+	if err := p.host.teardown(ctx, site); err != nil {
+		return err
 	}
-	if err := runCommand(ctx, p.cfg.ReloadCommand); err != nil {
-		return fmt.Errorf("nginx reload failed: %w", err)
+	if err := p.removeSiteLogs(site); err != nil {
+		return err
 	}
 
 	p.logf("site deprovisioned domain=%s", site.Domain)
@@ -130,17 +227,92 @@ func sanitizeConfName(domain string) string {
 	return strings.ReplaceAll(clean, "/", "-")
 }
 
-func renderNginxServer(site store.Site) string {
-	serverCore := runtimeServerCore(site.Runtime, site.RootPath)
-	acmeChallenge := `    location ^~ /.well-known/acme-challenge/ {
+const acmeChallengeLocation = `    location ^~ /.well-known/acme-challenge/ {
         default_type text/plain;
         try_files $uri =404;
     }`
+
+const (
+	listenHTTP  = "    listen 80;\n    listen [::]:80;"
+	listenHTTPS = "    listen 443 ssl http2;\n    listen [::]:443 ssl http2;"
+)
+
+// renderNginxServer renders the whole vhost. Without TLS the site is served
+// on port 80. With TLS, port 80 only answers ACME challenges and redirects to
+// HTTPS, and the site is served from a 443 block using the stored
+// certificate.
+func renderNginxServer(site store.Site, opts vhostOptions) (string, error) {
+	if site.Runtime == "proxy" {
+		if err := ValidateProxy(site.Proxy); err != nil {
+			return "", err
+		}
+	}
+	if err := ValidateAccess(site.Access); err != nil {
+		return "", err
+	}
+	if err := ValidatePolicy(site.Runtime, site.Policy); err != nil {
+		return "", err
+	}
+	core, err := runtimeServerCore(site, opts)
+	if err != nil {
+		return "", err
+	}
+	core += renderCustomDirectives(site.CustomDirectives)
+
+	// prefix holds http-level blocks the vhost file may define before its
+	// server blocks.
+	var prefix, head string
+	if opts.AccessLog != "" && opts.ErrorLog != "" {
+		head = renderLogDirectives(opts.AccessLog, opts.ErrorLog)
+	}
+	switch site.Runtime {
+	case "proxy":
+		prefix += renderProxyUpstream(site)
+		if site.Proxy.WebSocket {
+			prefix += renderUpgradeMap(site)
+		}
+	case "node", "python":
+		prefix += renderUpgradeMap(site)
+	}
+	if site.Maintenance != nil && opts.MaintenancePage != "" {
+		prefix += renderMaintenanceGeo(site)
+		head += renderMaintenanceDirectives(site, opts.MaintenancePage)
+	}
+	if accessRestricted(site.Access) {
+		prefix += renderAccessMaps(site)
+		head += renderAccessDirectives(site, opts.HtpasswdPath)
+	}
+	if site.Policy != nil {
+		prefix += renderPolicyPrefix(site, opts.CachePath)
+		head += renderPolicyDirectives(site, opts.CachePath)
+	}
+
+	names, redirectFrom, canonical := serverNames(site)
+	if site.TLS == nil {
+		conf := prefix + renderSiteServer(site, core, listenHTTP, names, head)
+		if redirectFrom != "" {
+			conf += "\n" + renderRedirectServer(site, listenHTTP, redirectFrom, "$scheme://"+canonical, "")
+		}
+		return conf, nil
+	}
+
+	tls := renderTLSDirectives(site.TLS)
+	conf := prefix + renderRedirectServer(site, listenHTTP, strings.Join(site.Hostnames(), " "), "https://$host", "")
+	conf += "\n" + renderSiteServer(site, core, listenHTTPS, names, tls+head)
+	if redirectFrom != "" {
+		conf += "\n" + renderRedirectServer(site, listenHTTPS, redirectFrom, "https://"+canonical, tls)
+	}
+	return conf, nil
+}
+
+// renderSiteServer renders the block serving the site. head holds
+// server-level directives placed before root (TLS, logs, maintenance,
+// access, policy).
+func renderSiteServer(site store.Site, core, listen string, names []string, head string) string {
 	return fmt.Sprintf(`server {
-    listen 80;
-    listen [::]:80;
+%s
     server_name %s;
-
+%s
     root %s;
     index index.php index.html index.htm;
 
@@ -148,53 +320,76 @@ func renderNginxServer(site store.Site) string {
 
 %s
 }
-`, site.Domain, site.RootPath, acmeChallenge, serverCore)
+`, listen, strings.Join(names, " "), head, site.RootPath, acmeChallengeLocation, core)
 }
 
-func runtimeServerCore(runtimeName, rootPath string) string {
-	switch runtimeName {
-	case "node":
-		return `    location / {
-        proxy_pass http://127.0.0.1:3000;
-        proxy_http_version 1.1;
-        proxy_set_header Host $host;
-        proxy_set_header X-Real-IP $remote_addr;
-        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-        proxy_set_header X-Forwarded-Proto $scheme;
-    }`
-	case "python":
-		return `    location / {
-        proxy_pass http://127.0.0.1:8000;
-        proxy_http_version 1.1;
-        proxy_set_header Host $host;
-        proxy_set_header X-Real-IP $remote_addr;
-        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-        proxy_set_header X-Forwarded-Proto $scheme;
-    }`
-	case "static":
-		return `    location / {
-        try_files $uri $uri/ =404;
-    }`
-	default:
-		return fmt.Sprintf(`    location / {
-        try_files $uri $uri/ /index.php?$query_string;
+func renderRedirectServer(site store.Site, listen, names, target, tls string) string {
+	return fmt.Sprintf(`server {
+%s
+    server_name %s;
+%s
+    root %s;
+
+%s
+
+    location / {
+        return 301 %s$request_uri;
     }
+}
+`, listen, names, tls, site.RootPath, acmeChallengeLocation, target)
+}
+
+func renderTLSDirectives(tls *store.SiteTLS) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+    ssl_certificate %s;
+    ssl_certificate_key %s;
+    ssl_protocols TLSv1.2 TLSv1.3;
+    ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305;
+    ssl_prefer_server_ciphers off;
+    ssl_session_timeout 1d;
+    ssl_session_cache shared:NusantaraTLS:10m;
+    ssl_session_tickets off;
+`, tls.CertPath, tls.KeyPath)
+	if tls.HSTS {
+		b.WriteString("    add_header Strict-Transport-Security \"max-age=63072000\" always;\n")
+	}
+	return b.String()
+}
 
-    location ~ \.php$ {
-        include fastcgi_params;
-        fastcgi_param SCRIPT_FILENAME %s$fastcgi_script_name;
-        fastcgi_pass unix:/run/php/php8.1-fpm.sock;
-        fastcgi_index index.php;
-    }`, rootPath)
+// serverNames splits the site's host names into the names served by the main
+// server block and, when a canonical redirect is set, the single name that
+// is redirected to the canonical host.
+func serverNames(site store.Site) (names []string, redirectFrom, canonical string) {
+	www := "www." + site.Domain
+	switch site.CanonicalRedirect {
+	case store.CanonicalApex:
+		redirectFrom, canonical = www, site.Domain
+	case store.CanonicalWWW:
+		redirectFrom, canonical = site.Domain, www
+	default:
+		return site.Hostnames(), "", ""
+	}
+	names = append(names, canonical)
+	for _, name := range site.Hostnames() {
+		if name != redirectFrom && name != canonical {
+			names = append(names, name)
+		}
 	}
+	return names, redirectFrom, canonical
+}
+
+func upstreamAddress(site store.Site) string {
+	host, port, _ := site.Upstream()
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
-func runCommand(ctx context.Context, raw string) error {
+func runCommand(ctx context.Context, raw string, args ...string) error {
 	parts := strings.Fields(strings.TrimSpace(raw))
 	if len(parts) == 0 {
 		return errors.New("empty command")
 	}
-	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd := exec.CommandContext(ctx, parts[0], append(parts[1:], args...)...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("%s: %w (%s)", raw, err, strings.TrimSpace(string(out)))
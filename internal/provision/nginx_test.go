@@ -2,6 +2,7 @@ package provision
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"os"
@@ -15,7 +16,7 @@ import (
 func TestProvisionDryRun(t *testing.T) {
 	p := NewNginxProvisioner(NginxConfig{
 		Apply: false,
-	}, log.New(io.Discard, "", 0))
+	}, nil, nil, log.New(io.Discard, "", 0))
 
 	site := store.Site{
 		ID:       "site-1",
@@ -32,12 +33,21 @@ func TestProvisionDryRun(t *testing.T) {
 	}
 }
 
+func mustRenderNginxServer(t *testing.T, site store.Site, opts vhostOptions) string {
+	t.Helper()
+	conf, err := renderNginxServer(site, opts)
+	if err != nil {
+		t.Fatalf("render nginx server: %v", err)
+	}
+	return conf
+}
+
 func TestRenderNginxServer(t *testing.T) {
-	conf := renderNginxServer(store.Site{
+	conf := mustRenderNginxServer(t, store.Site{
 		Domain:   "example.com",
 		RootPath: "/var/www/example",
 		Runtime:  "static",
-	})
+	}, vhostOptions{})
 	if conf == "" {
 		t.Fatalf("empty config")
 	}
@@ -52,6 +62,40 @@ func TestRenderNginxServer(t *testing.T) {
 	}
 }
 
+func TestRenderNginxServerUpstream(t *testing.T) {
+	conf := mustRenderNginxServer(t, store.Site{
+		Domain:       "app.example.com",
+		RootPath:     "/var/www/app",
+		Runtime:      "node",
+		UpstreamHost: "127.0.0.1",
+		UpstreamPort: 3005,
+	}, vhostOptions{})
+	if !strings.Contains(conf, "proxy_pass http://127.0.0.1:3005;") {
+		t.Fatalf("missing configured upstream:\n%s", conf)
+	}
+
+	legacy := mustRenderNginxServer(t, store.Site{Domain: "py.example.com", RootPath: "/var/www/py", Runtime: "python"}, vhostOptions{})
+	if !strings.Contains(legacy, "proxy_pass http://127.0.0.1:8000;") {
+		t.Fatalf("expected legacy python upstream:\n%s", legacy)
+	}
+
+	v6 := mustRenderNginxServer(t, store.Site{Domain: "v6.example.com", RootPath: "/var/www/v6", Runtime: "node", UpstreamHost: "::1", UpstreamPort: 3100}, vhostOptions{})
+	if !strings.Contains(v6, "proxy_pass http://[::1]:3100;") {
+		t.Fatalf("expected bracketed IPv6 upstream:\n%s", v6)
+	}
+}
+
+func TestRenderNginxServerPHPSocket(t *testing.T) {
+	site := store.Site{Domain: "php.example.com", RootPath: "/var/www/php", Runtime: "php", PHPVersion: "8.3"}
+	conf := mustRenderNginxServer(t, site, vhostOptions{PHPSocket: "/run/php/php8.3-fpm.sock"})
+	if !strings.Contains(conf, "fastcgi_pass unix:/run/php/php8.3-fpm.sock;") {
+		t.Fatalf("missing resolved php socket:\n%s", conf)
+	}
+	if conf := mustRenderNginxServer(t, site, vhostOptions{}); !strings.Contains(conf, "fastcgi_pass unix:"+defaultPHPSocket+";") {
+		t.Fatalf("expected default php socket:\n%s", conf)
+	}
+}
+
 func TestEnsureRuntimeBootstrapStaticCreatesIndex(t *testing.T) {
 	root := t.TempDir()
 	site := store.Site{
@@ -98,3 +142,235 @@ func TestEnsureRuntimeBootstrapDoesNotOverwriteExistingIndex(t *testing.T) {
 		t.Fatalf("existing index must not be overwritten")
 	}
 }
+
+func TestRenderNginxServerAliasesAndRedirect(t *testing.T) {
+	site := store.Site{
+		Domain:   "example.com",
+		RootPath: "/var/www/example",
+		Runtime:  "static",
+		Aliases:  []string{"shop.example.com", "www.example.com"},
+	}
+	conf := mustRenderNginxServer(t, site, vhostOptions{})
+	if !strings.Contains(conf, "server_name example.com shop.example.com www.example.com;") {
+		t.Fatalf("expected all names on one server:\n%s", conf)
+	}
+	if strings.Contains(conf, "return 301") {
+		t.Fatalf("did not expect a redirect block:\n%s", conf)
+	}
+
+	site.CanonicalRedirect = store.CanonicalApex
+	conf = mustRenderNginxServer(t, site, vhostOptions{})
+	if !strings.Contains(conf, "server_name example.com shop.example.com;") ||
+		!strings.Contains(conf, "server_name www.example.com;") ||
+		!strings.Contains(conf, "return 301 $scheme://example.com$request_uri;") {
+		t.Fatalf("expected www to redirect to apex:\n%s", conf)
+	}
+
+	site.CanonicalRedirect = store.CanonicalWWW
+	conf = mustRenderNginxServer(t, site, vhostOptions{})
+	if !strings.Contains(conf, "server_name www.example.com shop.example.com;") ||
+		!strings.Contains(conf, "return 301 $scheme://www.example.com$request_uri;") {
+		t.Fatalf("expected apex to redirect to www:\n%s", conf)
+	}
+}
+
+func TestRenderNginxServerTLS(t *testing.T) {
+	site := store.Site{
+		Domain:            "example.com",
+		RootPath:          "/var/www/example",
+		Runtime:           "static",
+		Aliases:           []string{"www.example.com"},
+		CanonicalRedirect: store.CanonicalApex,
+		TLS: &store.SiteTLS{
+			CertPath: "/etc/letsencrypt/live/example.com/fullchain.pem",
+			KeyPath:  "/etc/letsencrypt/live/example.com/privkey.pem",
+		},
+	}
+	conf := mustRenderNginxServer(t, site, vhostOptions{})
+	for _, want := range []string{
+		"return 301 https://$host$request_uri;",
+		"listen 443 ssl http2;",
+		"ssl_certificate /etc/letsencrypt/live/example.com/fullchain.pem;",
+		"ssl_certificate_key /etc/letsencrypt/live/example.com/privkey.pem;",
+		"ssl_protocols TLSv1.2 TLSv1.3;",
+		"return 301 https://example.com$request_uri;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(conf, "Strict-Transport-Security") {
+		t.Fatalf("hsts must be opt-in:\n%s", conf)
+	}
+	if strings.Count(conf, "/.well-known/acme-challenge/") != 3 {
+		t.Fatalf("every server block must answer acme challenges:\n%s", conf)
+	}
+
+	site.TLS.HSTS = true
+	if conf := mustRenderNginxServer(t, site, vhostOptions{}); !strings.Contains(conf, `add_header Strict-Transport-Security "max-age=63072000" always;`) {
+		t.Fatalf("missing hsts header:\n%s", conf)
+	}
+}
+
+func TestRenderNginxServerCustomDirectives(t *testing.T) {
+	site := store.Site{
+		Domain:           "example.com",
+		RootPath:         "/var/www/example",
+		Runtime:          "static",
+		CustomDirectives: "client_max_body_size 64m;\nlocation /api/ {\n    add_header X-Api yes;\n}",
+	}
+	conf := mustRenderNginxServer(t, site, vhostOptions{})
+	for _, want := range []string{
+		"    # custom directives\n    client_max_body_size 64m;",
+		"    location /api/ {\n        add_header X-Api yes;\n    }",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q:\n%s", want, conf)
+		}
+	}
+}
+
+func TestRuntimeTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	override := "    location / {\n        proxy_pass http://{{.Upstream}};\n        proxy_read_timeout 300s;\n    }\n"
+	if err := os.WriteFile(filepath.Join(dir, "node.tmpl"), []byte(override), 0o644); err != nil {
+		t.Fatalf("write override: %v", err)
+	}
+	site := store.Site{Domain: "app.example.com", RootPath: "/var/www/app", Runtime: "node", UpstreamPort: 3001}
+	conf := mustRenderNginxServer(t, site, vhostOptions{TemplateDir: dir})
+	if !strings.Contains(conf, "proxy_read_timeout 300s;") || !strings.Contains(conf, "proxy_pass http://127.0.0.1:3001;") {
+		t.Fatalf("override not used:\n%s", conf)
+	}
+
+	// python has no override and keeps the built-in proxy template.
+	site.Runtime = "python"
+	if conf := mustRenderNginxServer(t, site, vhostOptions{TemplateDir: dir}); strings.Contains(conf, "proxy_read_timeout") {
+		t.Fatalf("node override leaked into python:\n%s", conf)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "static.tmpl"), []byte("{{.Nope}}"), 0o644); err != nil {
+		t.Fatalf("write override: %v", err)
+	}
+	if _, err := renderNginxServer(store.Site{Domain: "s.example.com", RootPath: "/var/www/s", Runtime: "static"}, vhostOptions{TemplateDir: dir}); err == nil {
+		t.Fatalf("expected broken override to fail rendering")
+	}
+}
+
+func TestValidateCustomDirectives(t *testing.T) {
+	valid := []string{
+		"",
+		"client_max_body_size 64m;",
+		"# comment only\n",
+		"location /api/ {\n    proxy_read_timeout 120s;\n}\nadd_header X-Frame-Options \"SAMEORIGIN\" always;",
+		"location ~ \\.(js|css)$ { expires 7d; }",
+	}
+	for _, raw := range valid {
+		if err := ValidateCustomDirectives(raw); err != nil {
+			t.Fatalf("expected %q to be valid: %v", raw, err)
+		}
+	}
+	invalid := []string{
+		"client_max_body_size 64m",
+		"location / {",
+		"}",
+		"include /etc/passwd;",
+		"location / { alias /etc/; }",
+		"access_log /etc/cron.d/x;",
+		"location / { error_log /root/.bashrc; }",
+		"client_body_temp_path /etc/nginx;",
+		"content_by_lua_block { ngx.say(1) }",
+		"add_header X \"unterminated;",
+		strings.Repeat("#", MaxCustomDirectivesBytes+1),
+	}
+	for _, raw := range invalid {
+		if err := ValidateCustomDirectives(raw); !errors.Is(err, ErrInvalidDirectives) {
+			t.Fatalf("expected %q to be rejected, got %v", raw, err)
+		}
+	}
+}
+
+func TestRenderNginxServerMaintenance(t *testing.T) {
+	conf := mustRenderNginxServer(t, store.Site{
+		ID:          "site_5e0d13",
+		Domain:      "shop.example.com",
+		RootPath:    "/var/www/shop",
+		Runtime:     "static",
+		Maintenance: &store.SiteMaintenance{AllowIPs: []string{"203.0.113.7", "10.0.0.0/8"}},
+	}, vhostOptions{MaintenancePage: "/var/lib/maint/shop.example.com.html"})
+
+	for _, want := range []string{
+		"geo $nusantara_maintenance_client_5e0d13 {",
+		"    203.0.113.7 0;",
+		"    10.0.0.0/8 0;",
+		"map \"$nusantara_maintenance_client_5e0d13:$uri\" $nusantara_maintenance_5e0d13 {",
+		"    \"~^1:/\\.well-known/acme-challenge/\" 0;\n    \"~^1:\" 1;",
+		"if ($nusantara_maintenance_5e0d13) {",
+		"error_page 503 @nusantara_maintenance;",
+		"root /var/lib/maint;",
+		"rewrite ^ /shop.example.com.html break;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+	if !strings.HasPrefix(conf, "geo ") {
+		t.Fatalf("geo block must be rendered outside the server block:\n%s", conf)
+	}
+}
+
+func TestSuspendSiteRemovesEnabledLink(t *testing.T) {
+	dir := t.TempDir()
+	cfg := NginxConfig{
+		Apply:          true,
+		AvailableDir:   filepath.Join(dir, "available"),
+		EnabledDir:     filepath.Join(dir, "enabled"),
+		LogDir:         filepath.Join(dir, "logs"),
+		LogrotatePath:  filepath.Join(dir, "logrotate"),
+		TestCommand:    "true",
+		ReloadCommand:  "true",
+		MaintenanceDir: filepath.Join(dir, "maintenance"),
+	}
+	for _, d := range []string{cfg.AvailableDir, cfg.EnabledDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	p := NewNginxProvisioner(cfg, nil, nil, log.New(io.Discard, "", 0))
+	site := store.Site{
+		ID:          "site-1",
+		Domain:      "example.com",
+		RootPath:    filepath.Join(dir, "www"),
+		Runtime:     "static",
+		Maintenance: &store.SiteMaintenance{},
+	}
+	ctx := context.Background()
+	if err := p.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	page, err := os.ReadFile(filepath.Join(cfg.MaintenanceDir, "example.com.html"))
+	if err != nil || !strings.Contains(string(page), "example.com is under maintenance") {
+		t.Fatalf("default maintenance page not written: %v", err)
+	}
+
+	linkPath := filepath.Join(cfg.EnabledDir, "example.com.conf")
+	if err := p.SuspendSite(ctx, site); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	if _, err := os.Lstat(linkPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("enabled link must be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.AvailableDir, "example.com.conf")); err != nil {
+		t.Fatalf("available config must be kept: %v", err)
+	}
+	if err := p.SuspendSite(ctx, site); err != nil {
+		t.Fatalf("suspending twice should be a no-op: %v", err)
+	}
+
+	site.Maintenance = nil
+	if err := p.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if _, err := os.Lstat(linkPath); err != nil {
+		t.Fatalf("enabled link must be restored: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"nusantara/internal/store"
+)
+
+var ErrInvalidPolicy = errors.New("invalid site policy")
+
+const (
+	defaultCacheDir       = "/var/cache/nginx/nusantara"
+	maxPolicyRate         = 10000
+	maxPolicyBurst        = 100000
+	maxPolicyConnections  = 10000
+	maxStaticCacheSeconds = 365 * 24 * 60 * 60
+	maxFastCGICacheSecs   = 24 * 60 * 60
+	maxStaticExtensions   = 40
+)
+
+var (
+	staticExtensionPattern = regexp.MustCompile(`^[a-z0-9]{1,10}$`)
+
+	defaultStaticExtensions = []string{
+		"css", "js", "mjs", "map", "png", "jpg", "jpeg", "gif", "webp", "avif", "svg", "ico",
+		"woff", "woff2", "ttf", "otf", "eot", "mp4", "webm", "mp3", "ogg",
+	}
+
+	// compressibleTypes leaves out text/html, which gzip always covers and
+	// nginx warns about when listed.
+	compressibleTypes = "text/plain text/css text/xml text/javascript application/javascript application/json application/xml application/rss+xml application/manifest+json image/svg+xml"
+)
+
+// DefaultPolicy is the policy new sites start with. Static sites are not
+// rate limited because every request is a cheap file read; dynamic runtimes
+// get a limit with enough burst for a page and its assets.
+func DefaultPolicy(runtime string) *store.SitePolicy {
+	policy := &store.SitePolicy{
+		Gzip:        true,
+		StaticCache: 7 * 24 * 60 * 60,
+	}
+	if runtime != "static" {
+		policy.RateLimit = 20
+		policy.RateBurst = 50
+	}
+	return policy
+}
+
+// ValidatePolicy checks a policy against the site's runtime before it is
+// stored or rendered.
+func ValidatePolicy(runtime string, policy *store.SitePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.RateLimit < 0 || policy.RateLimit > maxPolicyRate {
+		return fmt.Errorf("%w: rate_limit must be between 0 and %d", ErrInvalidPolicy, maxPolicyRate)
+	}
+	if policy.RateBurst < 0 || policy.RateBurst > maxPolicyBurst {
+		return fmt.Errorf("%w: rate_burst must be between 0 and %d", ErrInvalidPolicy, maxPolicyBurst)
+	}
+	if policy.RateBurst > 0 && policy.RateLimit == 0 {
+		return fmt.Errorf("%w: rate_burst needs rate_limit", ErrInvalidPolicy)
+	}
+	if policy.ConnLimit < 0 || policy.ConnLimit > maxPolicyConnections {
+		return fmt.Errorf("%w: conn_limit must be between 0 and %d", ErrInvalidPolicy, maxPolicyConnections)
+	}
+	if policy.StaticCache < 0 || policy.StaticCache > maxStaticCacheSeconds {
+		return fmt.Errorf("%w: static_cache must be between 0 and %d seconds", ErrInvalidPolicy, maxStaticCacheSeconds)
+	}
+	if len(policy.StaticExtensions) > maxStaticExtensions {
+		return fmt.Errorf("%w: at most %d static_extensions", ErrInvalidPolicy, maxStaticExtensions)
+	}
+	for _, ext := range policy.StaticExtensions {
+		if !staticExtensionPattern.MatchString(ext) {
+			return fmt.Errorf("%w: static extension %q must be 1-10 lowercase letters or digits", ErrInvalidPolicy, ext)
+		}
+	}
+	if policy.FastCGICache < 0 || policy.FastCGICache > maxFastCGICacheSecs {
+		return fmt.Errorf("%w: fastcgi_cache must be between 0 and %d seconds", ErrInvalidPolicy, maxFastCGICacheSecs)
+	}
+	if policy.FastCGICache > 0 && runtime != "php" {
+		return fmt.Errorf("%w: fastcgi_cache is only available for php sites", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+func (p *NginxProvisioner) cacheDir() string {
+	if strings.TrimSpace(p.cfg.CacheDir) == "" {
+		return defaultCacheDir
+	}
+	return p.cfg.CacheDir
+}
+
+func (p *NginxProvisioner) fastCGICachePath(site store.Site) string {
+	return filepath.Join(p.cacheDir(), sanitizeConfName(site.Domain))
+}
+
+// ensureFastCGICache creates the site's cache directory. nginx creates the
+// levels below it, but not missing parents.
+func (p *NginxProvisioner) ensureFastCGICache(site store.Site) error {
+	if err := os.MkdirAll(p.fastCGICachePath(site), 0o755); err != nil {
+		return fmt.Errorf("create fastcgi cache dir: %w", err)
+	}
+	return nil
+}
+
+func rateZoneName(site store.Site) string {
+	return siteVariable("nusantara_req_", site)
+}
+
+func connZoneName(site store.Site) string {
+	return siteVariable("nusantara_conn_", site)
+}
+
+func fastCGIZoneName(site store.Site) string {
+	return siteVariable("nusantara_fcgi_", site)
+}
+
+func expiresVariable(site store.Site) string {
+	return siteVariable("$nusantara_expires_", site)
+}
+
+func noCacheVariable(site store.Site) string {
+	return siteVariable("$nusantara_nocache_", site)
+}
+
+// renderPolicyPrefix defines the http-level zones and maps the policy
+// refers to. Browser caching goes through a map on the URI so it covers
+// every runtime without adding locations that would bypass the runtime's
+// own handling.
+func renderPolicyPrefix(site store.Site, cachePath string) string {
+	policy := site.Policy
+	var b strings.Builder
+	if policy.RateLimit > 0 {
+		fmt.Fprintf(&b, "limit_req_zone $binary_remote_addr zone=%s:10m rate=%dr/s;\n\n", rateZoneName(site), policy.RateLimit)
+	}
+	if policy.ConnLimit > 0 {
+		fmt.Fprintf(&b, "limit_conn_zone $binary_remote_addr zone=%s:10m;\n\n", connZoneName(site))
+	}
+	if policy.StaticCache > 0 {
+		extensions := policy.StaticExtensions
+		if len(extensions) == 0 {
+			extensions = defaultStaticExtensions
+		}
+		fmt.Fprintf(&b, "map $uri %s {\n    default off;\n    \"~*\\.(%s)$\" %ds;\n}\n\n",
+			expiresVariable(site), strings.Join(extensions, "|"), policy.StaticCache)
+	}
+	if policy.FastCGICache > 0 && cachePath != "" {
+		fmt.Fprintf(&b, "fastcgi_cache_path %s levels=1:2 keys_zone=%s:10m max_size=256m inactive=60m use_temp_path=off;\n\n",
+			cachePath, fastCGIZoneName(site))
+		// Writes and logged-in sessions must never be answered from cache.
+		fmt.Fprintf(&b, "map \"$request_method:$http_cookie\" %s {\n    default 0;\n    \"~^POST:\" 1;\n    \"~*(wordpress_logged_in|wp-postpass|comment_author|woocommerce_items_in_cart|PHPSESSID|laravel_session)\" 1;\n}\n\n",
+			noCacheVariable(site))
+	}
+	return b.String()
+}
+
+// renderPolicyDirectives applies the policy at server level so it covers
+// every location of the site.
+func renderPolicyDirectives(site store.Site, cachePath string) string {
+	policy := site.Policy
+	var b strings.Builder
+	if policy.RateLimit > 0 {
+		b.WriteString("\n")
+		if policy.RateBurst > 0 {
+			fmt.Fprintf(&b, "    limit_req zone=%s burst=%d nodelay;\n", rateZoneName(site), policy.RateBurst)
+		} else {
+			fmt.Fprintf(&b, "    limit_req zone=%s;\n", rateZoneName(site))
+		}
+		b.WriteString("    limit_req_status 429;\n")
+	}
+	if policy.ConnLimit > 0 {
+		fmt.Fprintf(&b, "\n    limit_conn %s %d;\n    limit_conn_status 429;\n", connZoneName(site), policy.ConnLimit)
+	}
+	if policy.Gzip {
+		fmt.Fprintf(&b, "\n    gzip on;\n    gzip_vary on;\n    gzip_proxied any;\n    gzip_comp_level 5;\n    gzip_min_length 256;\n    gzip_types %s;\n", compressibleTypes)
+	}
+	if policy.Brotli {
+		fmt.Fprintf(&b, "\n    brotli on;\n    brotli_comp_level 5;\n    brotli_types %s;\n", compressibleTypes)
+	}
+	if policy.StaticCache > 0 {
+		fmt.Fprintf(&b, "\n    expires %s;\n", expiresVariable(site))
+	}
+	if policy.FastCGICache > 0 && cachePath != "" {
+		fmt.Fprintf(&b, `
+    fastcgi_cache %s;
+    fastcgi_cache_key "$scheme$request_method$host$request_uri";
+    fastcgi_cache_valid 200 301 302 %ds;
+    fastcgi_cache_use_stale error timeout updating http_500 http_503;
+    fastcgi_cache_lock on;
+    fastcgi_cache_bypass %s;
+    fastcgi_no_cache %s;
+    add_header X-Cache-Status $upstream_cache_status;
+`, fastCGIZoneName(site), policy.FastCGICache, noCacheVariable(site), noCacheVariable(site))
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package provision
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func TestRenderNginxServerPolicy(t *testing.T) {
+	site := store.Site{
+		ID:       "site_5e0d13",
+		Domain:   "shop.example.com",
+		RootPath: "/var/www/shop",
+		Runtime:  "php",
+		Policy: &store.SitePolicy{
+			RateLimit:        10,
+			RateBurst:        30,
+			ConnLimit:        20,
+			Gzip:             true,
+			StaticCache:      86400,
+			StaticExtensions: []string{"css", "js"},
+			FastCGICache:     60,
+		},
+	}
+	conf := mustRenderNginxServer(t, site, vhostOptions{PHPSocket: "/run/php/php8.3-fpm.sock", CachePath: "/var/cache/nginx/nusantara/shop.example.com"})
+	for _, want := range []string{
+		"limit_req_zone $binary_remote_addr zone=nusantara_req_5e0d13:10m rate=10r/s;",
+		"limit_conn_zone $binary_remote_addr zone=nusantara_conn_5e0d13:10m;",
+		"map $uri $nusantara_expires_5e0d13 {\n    default off;\n    \"~*\\.(css|js)$\" 86400s;\n}",
+		"fastcgi_cache_path /var/cache/nginx/nusantara/shop.example.com levels=1:2 keys_zone=nusantara_fcgi_5e0d13:10m",
+		"map \"$request_method:$http_cookie\" $nusantara_nocache_5e0d13 {",
+		"    limit_req zone=nusantara_req_5e0d13 burst=30 nodelay;\n    limit_req_status 429;",
+		"    limit_conn nusantara_conn_5e0d13 20;",
+		"    gzip on;",
+		"    expires $nusantara_expires_5e0d13;",
+		"    fastcgi_cache nusantara_fcgi_5e0d13;",
+		"    fastcgi_cache_valid 200 301 302 60s;",
+		"    fastcgi_cache_bypass $nusantara_nocache_5e0d13;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(conf, "brotli") {
+		t.Fatalf("brotli rendered while disabled:\n%s", conf)
+	}
+
+	site.Policy = DefaultPolicy("static")
+	site.Runtime = "static"
+	conf = mustRenderNginxServer(t, site, vhostOptions{})
+	if strings.Contains(conf, "limit_req") || !strings.Contains(conf, "css|js|mjs") {
+		t.Fatalf("unexpected static default policy:\n%s", conf)
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	for _, runtime := range []string{"php", "static", "node", "python", "proxy"} {
+		if err := ValidatePolicy(runtime, DefaultPolicy(runtime)); err != nil {
+			t.Fatalf("default policy for %s rejected: %v", runtime, err)
+		}
+	}
+	cases := []store.SitePolicy{
+		{RateBurst: 10},
+		{RateLimit: -1},
+		{StaticCache: 60, StaticExtensions: []string{"css|html"}},
+		{FastCGICache: 60},
+	}
+	for _, policy := range cases {
+		if err := ValidatePolicy("static", &policy); !errors.Is(err, ErrInvalidPolicy) {
+			t.Fatalf("expected %+v to be rejected, got %v", policy, err)
+		}
+	}
+}
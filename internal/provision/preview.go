@@ -0,0 +1,186 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nusantara/internal/store"
+)
+
+// ConfigPreview is the vhost the panel would write for a site and how it
+// differs from the file on disk.
+type ConfigPreview struct {
+	SiteID     string `json:"site_id"`
+	Domain     string `json:"domain"`
+	ConfigPath string `json:"config_path"`
+	Config     string `json:"config"`
+	Diff       string `json:"diff"`
+	Changed    bool   `json:"changed"`
+	// Validation is set only when a dry validation was requested.
+	Validation *ConfigValidation `json:"validation,omitempty"`
+}
+
+const defaultNginxMainConfig = "/etc/nginx/nginx.conf"
+
+// ConfigValidation is the result of running the nginx test command against
+// the staged config.
+type ConfigValidation struct {
+	Valid     bool      `json:"valid"`
+	Output    string    `json:"output,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// PreviewSite renders the site's vhost and diffs it against the current
+// file. With validate, the rendered config is checked with the test command
+// in a staged copy of the nginx config; nothing live is written or reloaded.
+func (p *NginxProvisioner) PreviewSite(ctx context.Context, site store.Site, validate bool) (ConfigPreview, error) {
+	if site.Domain == "" {
+		return ConfigPreview{}, errors.New("site domain is empty")
+	}
+	if err := ValidateCustomDirectives(site.CustomDirectives); err != nil {
+		return ConfigPreview{}, err
+	}
+	opts, _, err := p.resolveVhostOptions(site)
+	if err != nil {
+		return ConfigPreview{}, err
+	}
+	conf, err := renderNginxServer(site, opts)
+	if err != nil {
+		return ConfigPreview{}, fmt.Errorf("render nginx conf: %w", err)
+	}
+
+	confName := sanitizeConfName(site.Domain) + ".conf"
+	confPath := filepath.Join(p.cfg.AvailableDir, confName)
+	// An imported site is compared with its hand-written file, which is
+	// what adopting it replaces.
+	currentPath := confPath
+	if site.Unmanaged && site.ImportedFrom != "" {
+		currentPath = site.ImportedFrom
+	}
+	current, _, err := readIfExists(currentPath)
+	if err != nil {
+		return ConfigPreview{}, fmt.Errorf("read conf: %w", err)
+	}
+	preview := ConfigPreview{
+		SiteID:     site.ID,
+		Domain:     site.Domain,
+		ConfigPath: confPath,
+		Config:     conf,
+		Diff:       unifiedDiff(currentPath, confPath+" (rendered)", string(current), conf),
+	}
+	preview.Changed = preview.Diff != ""
+
+	if !validate {
+		return preview, nil
+	}
+	if !p.cfg.Apply {
+		return ConfigPreview{}, ErrApplyDisabled
+	}
+	validation, err := p.validateStaged(ctx, confName, conf)
+	if err != nil {
+		return ConfigPreview{}, err
+	}
+	preview.Validation = &validation
+	return preview, nil
+}
+
+// validateStaged runs the test command against a copy of the nginx config
+// in a temp dir: nginx.conf is copied with its include of the enabled dir
+// pointed at a staged one holding the other enabled sites and conf, and
+// everything else next to nginx.conf is linked so relative includes still
+// resolve. The live files are never touched.
+func (p *NginxProvisioner) validateStaged(ctx context.Context, confName, conf string) (ConfigValidation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stage, err := os.MkdirTemp("", "nusantara-nginx-")
+	if err != nil {
+		return ConfigValidation{}, fmt.Errorf("create staging dir: %w", err)
+	}
+	defer os.RemoveAll(stage)
+
+	mainPath := filepath.Clean(p.mainConfig())
+	mainDir := filepath.Dir(mainPath)
+	enabledDir := filepath.Clean(p.cfg.EnabledDir)
+	stagedEnabled := filepath.Join(stage, "nusantara-enabled")
+	if rel, err := filepath.Rel(mainDir, enabledDir); err == nil && !strings.HasPrefix(rel, "..") && !strings.ContainsRune(rel, filepath.Separator) {
+		stagedEnabled = filepath.Join(stage, rel)
+	}
+
+	mainConf, err := os.ReadFile(mainPath)
+	if err != nil {
+		return ConfigValidation{}, fmt.Errorf("read nginx main config: %w", err)
+	}
+	entries, err := os.ReadDir(mainDir)
+	if err != nil {
+		return ConfigValidation{}, fmt.Errorf("read nginx config dir: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(mainDir, entry.Name())
+		if path == mainPath || filepath.Join(stage, entry.Name()) == stagedEnabled {
+			continue
+		}
+		if err := os.Symlink(path, filepath.Join(stage, entry.Name())); err != nil {
+			return ConfigValidation{}, fmt.Errorf("stage nginx config: %w", err)
+		}
+	}
+	if err := p.stageEnabledDir(stagedEnabled, confName, conf); err != nil {
+		return ConfigValidation{}, err
+	}
+	stagedMain := filepath.Join(stage, filepath.Base(mainPath))
+	rewritten := strings.ReplaceAll(string(mainConf), enabledDir+"/", stagedEnabled+"/")
+	if err := os.WriteFile(stagedMain, []byte(rewritten), 0o644); err != nil {
+		return ConfigValidation{}, fmt.Errorf("stage nginx main config: %w", err)
+	}
+
+	validation := ConfigValidation{Valid: true, CheckedAt: time.Now().UTC()}
+	if err := runCommand(ctx, p.cfg.TestCommand, "-c", stagedMain); err != nil {
+		validation.Valid = false
+		validation.Output = err.Error()
+	}
+	return validation, nil
+}
+
+// stageEnabledDir fills dir with links to what the live enabled dir loads,
+// with the site's own entry replaced by conf.
+func (p *NginxProvisioner) stageEnabledDir(dir, confName, conf string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create staged enabled dir: %w", err)
+	}
+	entries, err := os.ReadDir(p.cfg.EnabledDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read enabled dir: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == confName {
+			continue
+		}
+		path := filepath.Join(p.cfg.EnabledDir, entry.Name())
+		target, err := os.Readlink(path)
+		switch {
+		case err != nil:
+			target = path
+		case !filepath.IsAbs(target):
+			target = filepath.Join(p.cfg.EnabledDir, target)
+		}
+		if err := os.Symlink(target, filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("stage enabled site: %w", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, confName), []byte(conf), 0o644); err != nil {
+		return fmt.Errorf("stage nginx conf: %w", err)
+	}
+	return nil
+}
+
+func (p *NginxProvisioner) mainConfig() string {
+	if strings.TrimSpace(p.cfg.MainConfig) == "" {
+		return defaultNginxMainConfig
+	}
+	return p.cfg.MainConfig
+}
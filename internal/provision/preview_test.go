@@ -0,0 +1,126 @@
+package provision
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	want := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if got := unifiedDiff("old", "new", from, to); got != want {
+		t.Fatalf("diff mismatch:\n%s", got)
+	}
+	if got := unifiedDiff("old", "new", from, from); got != "" {
+		t.Fatalf("equal texts must give an empty diff, got:\n%s", got)
+	}
+	if got := unifiedDiff("old", "new", "", "x\n"); got != "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n" {
+		t.Fatalf("new file diff mismatch:\n%s", got)
+	}
+}
+
+func TestPreviewSiteValidatesWithoutApplying(t *testing.T) {
+	dir := t.TempDir()
+	cfg := NginxConfig{
+		Apply:         true,
+		AvailableDir:  filepath.Join(dir, "available"),
+		EnabledDir:    filepath.Join(dir, "enabled"),
+		LogDir:        filepath.Join(dir, "logs"),
+		LogrotatePath: filepath.Join(dir, "logrotate"),
+		TestCommand:   "true",
+		ReloadCommand: "true",
+	}
+	p := NewNginxProvisioner(cfg, nil, nil, log.New(io.Discard, "", 0))
+	site := store.Site{
+		ID:       "site-1",
+		Domain:   "example.com",
+		RootPath: filepath.Join(dir, "www"),
+		Runtime:  "static",
+	}
+	ctx := context.Background()
+	if err := p.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	confPath := filepath.Join(cfg.AvailableDir, "example.com.conf")
+	onDisk, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatalf("read conf: %v", err)
+	}
+
+	preview, err := p.PreviewSite(ctx, site, false)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if preview.Changed || preview.Diff != "" || preview.Validation != nil {
+		t.Fatalf("unchanged site must have no diff: %+v", preview)
+	}
+
+	site.CustomDirectives = "client_max_body_size 64m;"
+	// The test command gets a staged nginx.conf whose include points at a
+	// staged enabled dir holding the rendered config.
+	mainDir := filepath.Join(dir, "nginx")
+	if err := os.MkdirAll(mainDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p.cfg.MainConfig = filepath.Join(mainDir, "nginx.conf")
+	if err := os.WriteFile(p.cfg.MainConfig, []byte("http {\n    include "+cfg.EnabledDir+"/*;\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	check := filepath.Join(dir, "check.sh")
+	script := "#!/bin/sh\nset -e\n[ \"$1\" = -c ]\ngrep -q \"include $(dirname \"$2\")/\" \"$2\"\ngrep -rq client_max_body_size \"$(dirname \"$2\")\"\n"
+	if err := os.WriteFile(check, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p.cfg.TestCommand = check
+	preview, err = p.PreviewSite(ctx, site, true)
+	if err != nil {
+		t.Fatalf("preview with validation: %v", err)
+	}
+	if !preview.Changed || !strings.Contains(preview.Diff, "+    client_max_body_size 64m;") {
+		t.Fatalf("diff does not show the directive:\n%s", preview.Diff)
+	}
+	if preview.Validation == nil || !preview.Validation.Valid {
+		t.Fatalf("staged config should validate: %+v", preview.Validation)
+	}
+	after, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatalf("read conf: %v", err)
+	}
+	if string(after) != string(onDisk) {
+		t.Fatalf("validation must not touch the live config")
+	}
+	if target, err := os.Readlink(filepath.Join(cfg.EnabledDir, "example.com.conf")); err != nil || target != confPath {
+		t.Fatalf("validation must not touch the live link: target=%q err=%v", target, err)
+	}
+
+	p.cfg.TestCommand = "false"
+	preview, err = p.PreviewSite(ctx, site, true)
+	if err != nil {
+		t.Fatalf("preview with failing validation: %v", err)
+	}
+	if preview.Validation == nil || preview.Validation.Valid || preview.Validation.Output == "" {
+		t.Fatalf("expected failed validation with output: %+v", preview.Validation)
+	}
+}
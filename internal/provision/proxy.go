@@ -0,0 +1,206 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nusantara/internal/store"
+)
+
+var ErrInvalidProxy = errors.New("invalid proxy settings")
+
+const (
+	maxProxyTargets    = 16
+	maxProxyTimeout    = 3600
+	maxProxyWeight     = 100
+	maxProxyFails      = 100
+	upstreamKeepalive  = 16
+	proxyUnixPrefix    = "unix:"
+	proxyHostnameChars = `^[a-zA-Z0-9.-]+$`
+)
+
+var (
+	proxyHostnamePattern = regexp.MustCompile(proxyHostnameChars)
+	proxySocketPattern   = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+)
+
+// proxyTarget is a validated upstream server.
+type proxyTarget struct {
+	scheme  string // http or https; unix sockets are http
+	address string // host:port or unix:/path
+	host    string // host for the Host header and TLS SNI, empty for sockets
+}
+
+func parseProxyTarget(raw string) (proxyTarget, error) {
+	raw = strings.TrimSpace(raw)
+	if path, ok := strings.CutPrefix(raw, proxyUnixPrefix); ok {
+		if !proxySocketPattern.MatchString(path) || strings.Contains(path, "..") {
+			return proxyTarget{}, fmt.Errorf("%w: invalid socket path %q", ErrInvalidProxy, path)
+		}
+		return proxyTarget{scheme: "http", address: raw}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return proxyTarget{}, fmt.Errorf("%w: target %q must be http://host:port, https://host:port or unix:/path", ErrInvalidProxy, raw)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return proxyTarget{}, fmt.Errorf("%w: target %q must not have credentials, a path or a query", ErrInvalidProxy, raw)
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) == nil && !proxyHostnamePattern.MatchString(host) {
+		return proxyTarget{}, fmt.Errorf("%w: invalid target host %q", ErrInvalidProxy, host)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return proxyTarget{}, fmt.Errorf("%w: invalid target port %q", ErrInvalidProxy, port)
+	}
+	return proxyTarget{scheme: u.Scheme, address: net.JoinHostPort(host, port), host: host}, nil
+}
+
+// ValidateProxy checks the proxy runtime settings. All targets must share a
+// scheme because nginx proxies a whole upstream group with one protocol.
+func ValidateProxy(proxy *store.SiteProxy) error {
+	if proxy == nil || len(proxy.Targets) == 0 {
+		return fmt.Errorf("%w: at least one target is required", ErrInvalidProxy)
+	}
+	if len(proxy.Targets) > maxProxyTargets {
+		return fmt.Errorf("%w: at most %d targets", ErrInvalidProxy, maxProxyTargets)
+	}
+	switch proxy.Balance {
+	case "", "least_conn", "ip_hash":
+	default:
+		return fmt.Errorf("%w: balance must be least_conn or ip_hash", ErrInvalidProxy)
+	}
+	for _, timeout := range []int{proxy.ConnectTimeout, proxy.ReadTimeout, proxy.SendTimeout} {
+		if timeout < 0 || timeout > maxProxyTimeout {
+			return fmt.Errorf("%w: timeouts must be between 0 and %d seconds", ErrInvalidProxy, maxProxyTimeout)
+		}
+	}
+
+	scheme := ""
+	primaries := 0
+	for _, t := range proxy.Targets {
+		target, err := parseProxyTarget(t.URL)
+		if err != nil {
+			return err
+		}
+		if scheme != "" && target.scheme != scheme {
+			return fmt.Errorf("%w: targets mix http and https", ErrInvalidProxy)
+		}
+		scheme = target.scheme
+		if t.Weight < 0 || t.Weight > maxProxyWeight || t.MaxFails < 0 || t.MaxFails > maxProxyFails ||
+			t.FailTimeout < 0 || t.FailTimeout > maxProxyTimeout {
+			return fmt.Errorf("%w: target %q has out of range weight, max_fails or fail_timeout", ErrInvalidProxy, t.URL)
+		}
+		if t.Backup && proxy.Balance == "ip_hash" {
+			return fmt.Errorf("%w: backup targets cannot be used with ip_hash", ErrInvalidProxy)
+		}
+		if !t.Backup {
+			primaries++
+		}
+	}
+	if primaries == 0 {
+		return fmt.Errorf("%w: at least one target must not be a backup", ErrInvalidProxy)
+	}
+	return nil
+}
+
+// siteVariable names an nginx variable or upstream unique to the site. It
+// uses the site ID suffix like PoolUser: names built from the domain collide
+// (a-b.com and a.b.com) and change on rename.
+func siteVariable(prefix string, site store.Site) string {
+	return prefix + strings.TrimPrefix(PoolUser(site.ID), "np_")
+}
+
+func upstreamName(site store.Site) string {
+	return siteVariable("nusantara_", site)
+}
+
+func upgradeVariable(site store.Site) string {
+	return siteVariable("$nusantara_upgrade_", site)
+}
+
+// renderUpgradeMap maps the client's Upgrade header to the Connection header
+// sent upstream: "upgrade" for websocket handshakes and empty otherwise, so
+// upstream keepalive keeps working.
+func renderUpgradeMap(site store.Site) string {
+	return fmt.Sprintf("map $http_upgrade %s {\n    default upgrade;\n    '' '';\n}\n\n", upgradeVariable(site))
+}
+
+// renderProxyUpstream renders the upstream group of a proxy site.
+// ValidateProxy must have accepted the settings.
+func renderProxyUpstream(site store.Site) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "upstream %s {\n", upstreamName(site))
+	if site.Proxy.Balance != "" {
+		fmt.Fprintf(&b, "    %s;\n", site.Proxy.Balance)
+	}
+	for _, t := range site.Proxy.Targets {
+		target, _ := parseProxyTarget(t.URL)
+		b.WriteString("    server " + target.address)
+		if t.Weight > 0 {
+			fmt.Fprintf(&b, " weight=%d", t.Weight)
+		}
+		if t.MaxFails > 0 {
+			fmt.Fprintf(&b, " max_fails=%d", t.MaxFails)
+		}
+		if t.FailTimeout > 0 {
+			fmt.Fprintf(&b, " fail_timeout=%ds", t.FailTimeout)
+		}
+		if t.Backup {
+			b.WriteString(" backup")
+		}
+		b.WriteString(";\n")
+	}
+	fmt.Fprintf(&b, "    keepalive %d;\n}\n\n", upstreamKeepalive)
+	return b.String()
+}
+
+// proxyTemplateData fills the proxy fields of the runtime template data.
+func proxyTemplateData(site store.Site, data *coreTemplateData) {
+	data.HostHeader = "$host"
+	data.Buffering = true
+	switch site.Runtime {
+	case "proxy":
+		proxy := site.Proxy
+		var first proxyTarget
+		for _, t := range proxy.Targets {
+			if !t.Backup {
+				first, _ = parseProxyTarget(t.URL)
+				break
+			}
+		}
+		data.ProxyPass = first.scheme + "://" + upstreamName(site)
+		if !proxy.PreserveHost && first.host != "" {
+			data.HostHeader = first.host
+		}
+		if first.scheme == "https" {
+			data.ProxySSLName = first.host
+		}
+		data.WebSocket = proxy.WebSocket
+		data.Buffering = !proxy.DisableBuffering
+		data.ConnectTimeout = proxy.ConnectTimeout
+		data.ReadTimeout = proxy.ReadTimeout
+		data.SendTimeout = proxy.SendTimeout
+	default:
+		data.Upstream = upstreamAddress(site)
+		data.ProxyPass = "http://" + data.Upstream
+		data.WebSocket = true
+	}
+	data.ConnectionHeader = `""`
+	if data.WebSocket {
+		data.ConnectionHeader = upgradeVariable(site)
+	}
+}
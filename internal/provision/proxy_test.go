@@ -0,0 +1,129 @@
+package provision
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+func TestRenderNginxServerProxy(t *testing.T) {
+	conf := mustRenderNginxServer(t, store.Site{
+		ID:       "site_0a4f21",
+		Domain:   "app.example.com",
+		RootPath: "/var/www/app",
+		Runtime:  "proxy",
+		Proxy: &store.SiteProxy{
+			Targets: []store.ProxyTarget{
+				{URL: "https://backend.internal:8443", Weight: 3, MaxFails: 2, FailTimeout: 10},
+				{URL: "https://standby.internal", Backup: true},
+			},
+			Balance:          "least_conn",
+			WebSocket:        true,
+			DisableBuffering: true,
+			ReadTimeout:      300,
+		},
+	}, vhostOptions{})
+
+	for _, want := range []string{
+		"upstream nusantara_0a4f21 {\n    least_conn;\n",
+		"    server backend.internal:8443 weight=3 max_fails=2 fail_timeout=10s;\n",
+		"    server standby.internal:443 backup;\n",
+		"    keepalive 16;\n",
+		"map $http_upgrade $nusantara_upgrade_0a4f21 {",
+		"proxy_pass https://nusantara_0a4f21;",
+		"proxy_set_header Host backend.internal;",
+		"proxy_set_header Upgrade $http_upgrade;",
+		"proxy_set_header Connection $nusantara_upgrade_0a4f21;",
+		"proxy_ssl_name backend.internal;",
+		"proxy_read_timeout 300s;",
+		"proxy_buffering off;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(conf, "proxy_connect_timeout") {
+		t.Fatalf("unset timeouts must not be rendered:\n%s", conf)
+	}
+
+	conf = mustRenderNginxServer(t, store.Site{
+		ID:       "site_50c4e7",
+		Domain:   "sock.example.com",
+		RootPath: "/var/www/sock",
+		Runtime:  "proxy",
+		Proxy: &store.SiteProxy{
+			Targets:      []store.ProxyTarget{{URL: "unix:/run/app/app.sock"}},
+			PreserveHost: true,
+		},
+	}, vhostOptions{})
+	for _, want := range []string{
+		"server unix:/run/app/app.sock;",
+		"proxy_pass http://nusantara_50c4e7;",
+		"proxy_set_header Host $host;",
+		`proxy_set_header Connection "";`,
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(conf, "map $http_upgrade") {
+		t.Fatalf("websocket map must only be rendered when enabled:\n%s", conf)
+	}
+}
+
+func TestRenderNginxServerNodeWebSocket(t *testing.T) {
+	conf := mustRenderNginxServer(t, store.Site{
+		ID:           "site_40de99",
+		Domain:       "node.example.com",
+		RootPath:     "/var/www/node",
+		Runtime:      "node",
+		UpstreamPort: 3100,
+	}, vhostOptions{})
+	for _, want := range []string{
+		"map $http_upgrade $nusantara_upgrade_40de99 {",
+		"proxy_pass http://127.0.0.1:3100;",
+		"proxy_set_header Upgrade $http_upgrade;",
+		"proxy_set_header Connection $nusantara_upgrade_40de99;",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("missing %q in:\n%s", want, conf)
+		}
+	}
+}
+
+func TestValidateProxy(t *testing.T) {
+	valid := store.SiteProxy{Targets: []store.ProxyTarget{{URL: "http://10.0.0.5:8080"}, {URL: "unix:/run/app.sock"}}}
+	if err := ValidateProxy(&valid); err != nil {
+		t.Fatalf("valid proxy rejected: %v", err)
+	}
+
+	cases := map[string]store.SiteProxy{
+		"no targets":    {},
+		"mixed schemes": {Targets: []store.ProxyTarget{{URL: "http://a.internal"}, {URL: "https://b.internal"}}},
+		"path":          {Targets: []store.ProxyTarget{{URL: "http://a.internal/api"}}},
+		"injection":     {Targets: []store.ProxyTarget{{URL: "unix:/run/a.sock; include /etc/passwd"}}},
+		"bad scheme":    {Targets: []store.ProxyTarget{{URL: "ftp://a.internal"}}},
+		"only backup":   {Targets: []store.ProxyTarget{{URL: "http://a.internal", Backup: true}}},
+		"ip_hash":       {Balance: "ip_hash", Targets: []store.ProxyTarget{{URL: "http://a.internal"}, {URL: "http://b.internal", Backup: true}}},
+		"balance":       {Balance: "random", Targets: []store.ProxyTarget{{URL: "http://a.internal"}}},
+		"timeout":       {ReadTimeout: 7200, Targets: []store.ProxyTarget{{URL: "http://a.internal"}}},
+	}
+	for name, proxy := range cases {
+		if err := ValidateProxy(&proxy); !errors.Is(err, ErrInvalidProxy) {
+			t.Fatalf("%s: expected ErrInvalidProxy, got %v", name, err)
+		}
+	}
+}
+
+func TestSiteVariableUsesSiteID(t *testing.T) {
+	dashed := store.Site{ID: "site_1f2e3d", Domain: "a-b.com"}
+	dotted := store.Site{ID: "site_4c5b6a", Domain: "a.b.com"}
+	if upstreamName(dashed) == upstreamName(dotted) {
+		t.Fatalf("sites share upstream name %s", upstreamName(dashed))
+	}
+	if got := upgradeVariable(dashed); got != "$nusantara_upgrade_1f2e3d" {
+		t.Fatalf("upgrade variable = %s", got)
+	}
+}
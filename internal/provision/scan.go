@@ -0,0 +1,411 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxScannedVhostBytes = 1024 * 1024
+
+var phpSocketVersion = regexp.MustCompile(`php(\d+\.\d+)-fpm`)
+
+// VhostCandidate describes a hand-written vhost found in sites-available.
+// Runtime and upstream are guesses from the fastcgi_pass/proxy_pass seen in
+// the server block.
+type VhostCandidate struct {
+	File         string   `json:"file"`
+	Enabled      bool     `json:"enabled"`
+	Domain       string   `json:"domain"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Listen       []string `json:"listen"`
+	RootPath     string   `json:"root_path,omitempty"`
+	Runtime      string   `json:"runtime"`
+	PHPVersion   string   `json:"php_version,omitempty"`
+	FastCGIPass  string   `json:"fastcgi_pass,omitempty"`
+	ProxyPass    string   `json:"proxy_pass,omitempty"`
+	UpstreamHost string   `json:"upstream_host,omitempty"`
+	UpstreamPort int      `json:"upstream_port,omitempty"`
+	CertPath     string   `json:"cert_path,omitempty"`
+	KeyPath      string   `json:"key_path,omitempty"`
+	Warnings     []string `json:"warnings,omitempty"`
+}
+
+// nginxDirective is one parsed statement; Block is set for "name args { }".
+type nginxDirective struct {
+	Name  string
+	Args  []string
+	Block []nginxDirective
+}
+
+// ScanVhosts parses every file in sites-available and returns one candidate
+// per site found. Files the panel rendered itself are reported like any
+// other; callers match them against known sites. Includes are not followed.
+func (p *NginxProvisioner) ScanVhosts() ([]VhostCandidate, error) {
+	entries, err := os.ReadDir(p.cfg.AvailableDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []VhostCandidate{}, nil
+		}
+		return nil, fmt.Errorf("read available dir: %w", err)
+	}
+	enabled := p.enabledTargets()
+
+	candidates := []VhostCandidate{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+			strings.Contains(name, ".dpkg-") || strings.HasSuffix(name, ".bak") || strings.HasSuffix(name, importBackupSuffix) {
+			continue
+		}
+		path := filepath.Join(p.cfg.AvailableDir, name)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		if len(raw) > maxScannedVhostBytes {
+			continue
+		}
+		found, err := parseVhostCandidates(string(raw))
+		if err != nil {
+			candidates = append(candidates, VhostCandidate{File: path, Warnings: []string{err.Error()}})
+			continue
+		}
+		for _, candidate := range found {
+			candidate.File = path
+			_, candidate.Enabled = enabled[path]
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+// enabledTargets maps the absolute targets of sites-enabled links (or the
+// sites-available file with the same name for plain copies) to true.
+func (p *NginxProvisioner) enabledTargets() map[string]struct{} {
+	out := map[string]struct{}{}
+	entries, err := os.ReadDir(p.cfg.EnabledDir)
+	if err != nil {
+		return out
+	}
+	for _, entry := range entries {
+		linkPath := filepath.Join(p.cfg.EnabledDir, entry.Name())
+		target, err := os.Readlink(linkPath)
+		if err != nil {
+			out[filepath.Join(p.cfg.AvailableDir, entry.Name())] = struct{}{}
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(p.cfg.EnabledDir, target)
+		}
+		out[filepath.Clean(target)] = struct{}{}
+	}
+	return out
+}
+
+// parseVhostCandidates merges server blocks that share their first name,
+// which is how an HTTP redirect block and its HTTPS block usually appear.
+func parseVhostCandidates(raw string) ([]VhostCandidate, error) {
+	directives, err := parseNginxConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	var servers []nginxDirective
+	collectServers(directives, &servers)
+
+	var out []VhostCandidate
+	index := map[string]int{}
+	for _, server := range servers {
+		candidate := candidateFromServer(server)
+		if candidate.Domain == "" {
+			continue
+		}
+		i, seen := index[candidate.Domain]
+		if !seen {
+			index[candidate.Domain] = len(out)
+			out = append(out, candidate)
+			continue
+		}
+		out[i] = mergeCandidates(out[i], candidate)
+	}
+	for i := range out {
+		out[i] = guessRuntime(out[i])
+	}
+	return out, nil
+}
+
+func collectServers(directives []nginxDirective, servers *[]nginxDirective) {
+	for _, d := range directives {
+		switch d.Name {
+		case "server":
+			if d.Block != nil {
+				*servers = append(*servers, d)
+			}
+		case "http":
+			collectServers(d.Block, servers)
+		}
+	}
+}
+
+func candidateFromServer(server nginxDirective) VhostCandidate {
+	var candidate VhostCandidate
+	for _, d := range server.Block {
+		switch d.Name {
+		case "server_name":
+			for _, name := range d.Args {
+				name = strings.ToLower(strings.TrimSuffix(name, "."))
+				if !importableHostname(name) {
+					continue
+				}
+				if candidate.Domain == "" {
+					candidate.Domain = name
+				} else if name != candidate.Domain && !containsString(candidate.Aliases, name) {
+					candidate.Aliases = append(candidate.Aliases, name)
+				}
+			}
+		case "listen":
+			candidate.Listen = append(candidate.Listen, strings.Join(d.Args, " "))
+		case "root":
+			if len(d.Args) == 1 && candidate.RootPath == "" {
+				candidate.RootPath = d.Args[0]
+			}
+		case "ssl_certificate":
+			if len(d.Args) == 1 {
+				candidate.CertPath = d.Args[0]
+			}
+		case "ssl_certificate_key":
+			if len(d.Args) == 1 {
+				candidate.KeyPath = d.Args[0]
+			}
+		}
+	}
+	findPasses(server.Block, &candidate)
+	return candidate
+}
+
+// findPasses looks for the first fastcgi_pass and proxy_pass at any depth.
+func findPasses(directives []nginxDirective, candidate *VhostCandidate) {
+	for _, d := range directives {
+		switch {
+		case d.Name == "fastcgi_pass" && len(d.Args) == 1 && candidate.FastCGIPass == "":
+			candidate.FastCGIPass = d.Args[0]
+		case d.Name == "proxy_pass" && len(d.Args) == 1 && candidate.ProxyPass == "":
+			candidate.ProxyPass = d.Args[0]
+		case d.Name == "root" && len(d.Args) == 1 && candidate.RootPath == "":
+			candidate.RootPath = d.Args[0]
+		}
+		if d.Block != nil {
+			findPasses(d.Block, candidate)
+		}
+	}
+}
+
+func mergeCandidates(into, from VhostCandidate) VhostCandidate {
+	for _, alias := range from.Aliases {
+		if !containsString(into.Aliases, alias) {
+			into.Aliases = append(into.Aliases, alias)
+		}
+	}
+	into.Listen = append(into.Listen, from.Listen...)
+	if into.RootPath == "" {
+		into.RootPath = from.RootPath
+	}
+	if into.FastCGIPass == "" {
+		into.FastCGIPass = from.FastCGIPass
+	}
+	if into.ProxyPass == "" {
+		into.ProxyPass = from.ProxyPass
+	}
+	if into.CertPath == "" {
+		into.CertPath, into.KeyPath = from.CertPath, from.KeyPath
+	}
+	return into
+}
+
+func guessRuntime(candidate VhostCandidate) VhostCandidate {
+	switch {
+	case candidate.FastCGIPass != "":
+		candidate.Runtime = "php"
+		if m := phpSocketVersion.FindStringSubmatch(candidate.FastCGIPass); m != nil {
+			candidate.PHPVersion = m[1]
+		}
+	case candidate.ProxyPass != "":
+		// A local app server maps to the node runtime; anything else is
+		// kept as a generic proxy target.
+		host, port, ok := proxyUpstream(candidate.ProxyPass)
+		if ok && isLoopback(host) {
+			candidate.Runtime = "node"
+			candidate.UpstreamHost, candidate.UpstreamPort = host, port
+		} else {
+			candidate.Runtime = "proxy"
+			if _, err := parseProxyTarget(candidate.ProxyPass); err != nil || !ok {
+				candidate.Warnings = append(candidate.Warnings, "proxy_pass target is not host:port; set the proxy targets after importing")
+			}
+		}
+	default:
+		candidate.Runtime = "static"
+	}
+	if candidate.RootPath == "" {
+		candidate.Warnings = append(candidate.Warnings, "no root directive found")
+	}
+	if candidate.CertPath != "" && candidate.KeyPath == "" {
+		candidate.Warnings = append(candidate.Warnings, "ssl_certificate without ssl_certificate_key")
+	}
+	sort.Strings(candidate.Aliases)
+	return candidate
+}
+
+func proxyUpstream(raw string) (string, int, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || strings.Contains(raw, "$") {
+		return "", 0, false
+	}
+	host, portText, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, false
+	}
+	return host, port, true
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// importableHostname skips catch-all, wildcard and regex server names.
+func importableHostname(name string) bool {
+	if name == "" || name == "_" || name == "localhost" || strings.ContainsAny(name, "~*$") {
+		return false
+	}
+	return strings.Contains(name, ".")
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNginxConfig turns nginx config text into directives. It understands
+// comments, quoting and nested blocks, which is enough for vhost files.
+func parseNginxConfig(raw string) ([]nginxDirective, error) {
+	tokens, err := tokenizeNginx(raw)
+	if err != nil {
+		return nil, err
+	}
+	directives, rest, err := parseNginxBlock(tokens, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unbalanced '}'")
+	}
+	return directives, nil
+}
+
+func parseNginxBlock(tokens []string, nested bool) ([]nginxDirective, []string, error) {
+	var out []nginxDirective
+	var current []string
+	for len(tokens) > 0 {
+		tok := tokens[0]
+		tokens = tokens[1:]
+		switch tok {
+		case ";":
+			if len(current) == 0 {
+				continue
+			}
+			out = append(out, nginxDirective{Name: current[0], Args: current[1:]})
+			current = nil
+		case "{":
+			if len(current) == 0 {
+				return nil, nil, errors.New("block without a directive")
+			}
+			block, rest, err := parseNginxBlock(tokens, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			if block == nil {
+				block = []nginxDirective{}
+			}
+			out = append(out, nginxDirective{Name: current[0], Args: current[1:], Block: block})
+			current = nil
+			tokens = rest
+		case "}":
+			if !nested {
+				return nil, nil, errors.New("unbalanced '}'")
+			}
+			if len(current) != 0 {
+				return nil, nil, errors.New("missing ';' before '}'")
+			}
+			return out, tokens, nil
+		default:
+			current = append(current, tok)
+		}
+	}
+	if nested {
+		return nil, nil, errors.New("unbalanced '{'")
+	}
+	if len(current) != 0 {
+		return nil, nil, errors.New("last statement is missing ';'")
+	}
+	return out, nil, nil
+}
+
+// tokenizeNginx splits config text into words and the ; { } punctuation.
+// Quotes are removed from quoted words.
+func tokenizeNginx(raw string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch {
+		case c == '#':
+			for i < len(raw) && raw[i] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ';' || c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			i++
+			for i < len(raw) && raw[i] != c {
+				if raw[i] == '\\' && i+1 < len(raw) {
+					i++
+				}
+				b.WriteByte(raw[i])
+				i++
+			}
+			if i >= len(raw) {
+				return nil, errors.New("unterminated quote")
+			}
+			i++
+			tokens = append(tokens, b.String())
+		default:
+			start := i
+			for i < len(raw) && !strings.ContainsRune(" \t\r\n;{}#", rune(raw[i])) {
+				i++
+			}
+			tokens = append(tokens, raw[start:i])
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,178 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+const handWrittenVhost = `# legacy shop
+server {
+    listen 80;
+    server_name shop.example.com www.shop.example.com;
+    return 301 https://$host$request_uri;
+}
+
+server {
+    listen 443 ssl http2;
+    server_name shop.example.com www.shop.example.com;
+    ssl_certificate "/etc/letsencrypt/live/shop.example.com/fullchain.pem";
+    ssl_certificate_key /etc/letsencrypt/live/shop.example.com/privkey.pem;
+    root /srv/shop/public;
+
+    location ~ \.php$ {
+        include snippets/fastcgi-php.conf;
+        fastcgi_pass unix:/run/php/php8.1-fpm.sock;
+    }
+}
+
+server {
+    listen 80 default_server;
+    server_name _;
+    return 444;
+}
+
+server {
+    listen 80;
+    server_name api.example.com;
+    location / { proxy_pass http://127.0.0.1:4000; }
+}
+`
+
+func TestParseVhostCandidates(t *testing.T) {
+	got, err := parseVhostCandidates(handWrittenVhost)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 candidates, got %+v", got)
+	}
+
+	shop := got[0]
+	if shop.Domain != "shop.example.com" || !reflect.DeepEqual(shop.Aliases, []string{"www.shop.example.com"}) {
+		t.Fatalf("unexpected names: %+v", shop)
+	}
+	if shop.Runtime != "php" || shop.PHPVersion != "8.1" || shop.RootPath != "/srv/shop/public" {
+		t.Fatalf("unexpected runtime guess: %+v", shop)
+	}
+	if shop.CertPath != "/etc/letsencrypt/live/shop.example.com/fullchain.pem" || len(shop.Listen) != 2 {
+		t.Fatalf("unexpected tls/listen: %+v", shop)
+	}
+
+	api := got[1]
+	if api.Runtime != "node" || api.UpstreamHost != "127.0.0.1" || api.UpstreamPort != 4000 {
+		t.Fatalf("unexpected proxy guess: %+v", api)
+	}
+	if len(api.Warnings) != 1 {
+		t.Fatalf("expected a missing root warning: %v", api.Warnings)
+	}
+
+	if _, err := parseVhostCandidates("server { listen 80;"); err == nil {
+		t.Fatalf("expected unbalanced block to fail")
+	}
+}
+
+func TestAdoptImportedVhost(t *testing.T) {
+	dir := t.TempDir()
+	cfg := NginxConfig{
+		Apply:         true,
+		AvailableDir:  filepath.Join(dir, "available"),
+		EnabledDir:    filepath.Join(dir, "enabled"),
+		LogDir:        filepath.Join(dir, "logs"),
+		LogrotatePath: filepath.Join(dir, "logrotate"),
+		TestCommand:   "true",
+		ReloadCommand: "true",
+	}
+	for _, d := range []string{cfg.AvailableDir, cfg.EnabledDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	legacy := filepath.Join(cfg.AvailableDir, "shop")
+	if err := os.WriteFile(legacy, []byte("server {\n    listen 80;\n    server_name shop.example.com;\n    root /srv/shop;\n}\n"), 0o644); err != nil {
+		t.Fatalf("write legacy vhost: %v", err)
+	}
+	legacyLink := filepath.Join(cfg.EnabledDir, "shop")
+	if err := os.Symlink(legacy, legacyLink); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	p := NewNginxProvisioner(cfg, nil, nil, log.New(io.Discard, "", 0))
+	candidates, err := p.ScanVhosts()
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(candidates) != 1 || candidates[0].File != legacy || !candidates[0].Enabled || candidates[0].Runtime != "static" {
+		t.Fatalf("unexpected candidates: %+v", candidates)
+	}
+
+	site := store.Site{
+		ID:           "site-1",
+		Domain:       "shop.example.com",
+		RootPath:     filepath.Join(dir, "shop"),
+		Runtime:      "static",
+		Unmanaged:    true,
+		ImportedFrom: legacy,
+	}
+	ctx := context.Background()
+	if err := p.ProvisionSite(ctx, site); !errors.Is(err, ErrSiteUnmanaged) {
+		t.Fatalf("unmanaged site must not be rendered, got %v", err)
+	}
+	if err := p.DeprovisionSite(ctx, site); err != nil {
+		t.Fatalf("deprovision unmanaged: %v", err)
+	}
+	if _, err := os.Lstat(legacyLink); err != nil {
+		t.Fatalf("deprovision must leave the hand-written vhost enabled: %v", err)
+	}
+
+	// A failed nginx test keeps the original vhost enabled.
+	site.Unmanaged = false
+	p.cfg.TestCommand = "false"
+	if err := p.ProvisionSite(ctx, site); err == nil {
+		t.Fatalf("expected failing nginx test")
+	}
+	if _, err := os.Lstat(legacyLink); err != nil {
+		t.Fatalf("legacy link must be restored after a failed adopt: %v", err)
+	}
+
+	// A file that also serves another site or a catch-all is not adopted.
+	shared := filepath.Join(cfg.AvailableDir, "shared")
+	for _, extra := range []string{
+		"server {\n    listen 80;\n    server_name api.example.com;\n}\n",
+		"server {\n    listen 80 default_server;\n    server_name _;\n}\n",
+	} {
+		content := "server {\n    listen 80;\n    server_name shop.example.com;\n}\n" + extra
+		if err := os.WriteFile(shared, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sharedSite := site
+		sharedSite.ImportedFrom = shared
+		if err := p.CheckAdoptable(sharedSite); !errors.Is(err, ErrSharedVhost) {
+			t.Fatalf("expected shared vhost to be refused, got %v", err)
+		}
+	}
+
+	p.cfg.TestCommand = "true"
+	if err := p.CheckAdoptable(site); err != nil {
+		t.Fatalf("single-site vhost must be adoptable: %v", err)
+	}
+	if err := p.ProvisionSite(ctx, site); err != nil {
+		t.Fatalf("adopt: %v", err)
+	}
+	if _, err := os.Lstat(legacyLink); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("legacy link must be removed after adopt, got %v", err)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Fatalf("legacy file must be kept: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(cfg.EnabledDir, "shop.example.com.conf")); err != nil {
+		t.Fatalf("panel vhost not enabled: %v", err)
+	}
+}
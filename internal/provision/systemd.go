@@ -0,0 +1,380 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidApp       = errors.New("invalid app settings")
+	ErrAppNotConfigured = errors.New("site has no app process")
+)
+
+const (
+	AppActionStart   = "start"
+	AppActionStop    = "stop"
+	AppActionRestart = "restart"
+)
+
+const (
+	defaultUnitDir          = "/etc/systemd/system"
+	defaultSystemctlCommand = "systemctl"
+	maxAppCommandLen        = 1024
+)
+
+var appRestarts = map[string]struct{}{"": {}, "on-failure": {}, "always": {}, "no": {}}
+
+type SystemdConfig struct {
+	UnitDir          string
+	SystemctlCommand string
+	UserAddCommand   string
+	ChownCommand     string
+	WebGroup         string
+}
+
+// SystemdProvisioner runs node and python sites as systemd services. The
+// service runs as the same dedicated system user PHP-FPM pools use.
+type SystemdProvisioner struct {
+	cfg    SystemdConfig
+	logger *log.Logger
+}
+
+func NewSystemdProvisioner(cfg SystemdConfig, logger *log.Logger) *SystemdProvisioner {
+	if cfg.UnitDir == "" {
+		cfg.UnitDir = defaultUnitDir
+	}
+	if cfg.SystemctlCommand == "" {
+		cfg.SystemctlCommand = defaultSystemctlCommand
+	}
+	if cfg.WebGroup == "" {
+		cfg.WebGroup = defaultWebGroup
+	}
+	return &SystemdProvisioner{cfg: cfg, logger: logger}
+}
+
+// AppStatus is the subset of `systemctl show` the panel reports.
+type AppStatus struct {
+	Unit        string `json:"unit"`
+	LoadState   string `json:"load_state"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state"`
+	MainPID     int    `json:"main_pid"`
+	StartedAt   string `json:"started_at,omitempty"`
+	Restarts    int    `json:"restarts"`
+}
+
+// ValidateApp checks site.App before it is stored or rendered into a unit.
+// The env file is read by systemd as root, so it must live under the site
+// root or its deploy dir; the service always runs as the site's own user.
+func ValidateApp(site store.Site) error {
+	app := site.App
+	if app == nil {
+		return nil
+	}
+	if site.Runtime != "node" && site.Runtime != "python" {
+		return fmt.Errorf("%w: runtime %s does not run an app process", ErrInvalidApp, site.Runtime)
+	}
+	command := strings.TrimSpace(app.Command)
+	if command == "" || len(command) > maxAppCommandLen || strings.ContainsAny(command, "\r\n\x00") {
+		return fmt.Errorf("%w: command must be a single line of at most %d characters", ErrInvalidApp, maxAppCommandLen)
+	}
+	for name, path := range map[string]string{"working_dir": app.WorkingDir, "env_file": app.EnvFile} {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) || strings.ContainsAny(path, " \t\r\n\x00") || filepath.Clean(path) != path {
+			return fmt.Errorf("%w: %s must be a clean absolute path without spaces", ErrInvalidApp, name)
+		}
+	}
+	if app.EnvFile != "" && !appPathAllowed(site, app.EnvFile) {
+		return fmt.Errorf("%w: env_file must be inside the site root or deploy dir", ErrInvalidApp)
+	}
+	if app.User != "" && app.User != PoolUser(site.ID) {
+		return fmt.Errorf("%w: the app always runs as the site user %s", ErrInvalidApp, PoolUser(site.ID))
+	}
+	if _, ok := appRestarts[app.Restart]; !ok {
+		return fmt.Errorf("%w: restart must be on-failure, always or no", ErrInvalidApp)
+	}
+	return nil
+}
+
+func appPathAllowed(site store.Site, path string) bool {
+	roots := []string{site.RootPath}
+	if site.Deploy != nil {
+		roots = append(roots, site.Deploy.Path)
+	}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(filepath.Clean(root), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
+// UnitName is the systemd service of the site, keyed by site ID so it
+// survives domain changes.
+func UnitName(site store.Site) string {
+	return "nusantara-" + PoolUser(site.ID) + ".service"
+}
+
+func (s *SystemdProvisioner) unitPath(site store.Site) string {
+	return filepath.Join(s.cfg.UnitDir, UnitName(site))
+}
+
+// EnsureUnit writes the site's unit, enables it and restarts the service
+// when the unit changed. The previous unit is restored if systemd rejects
+// the new one. The site root is handed to the site user only with
+// claimRoot.
+func (s *SystemdProvisioner) EnsureUnit(ctx context.Context, site store.Site, claimRoot bool) error {
+	if site.ID == "" {
+		return errors.New("site id is empty")
+	}
+	if site.App == nil {
+		return ErrAppNotConfigured
+	}
+	// Units stored with another user run as the site user from now on.
+	app := *site.App
+	app.User = ""
+	site.App = &app
+	if err := ValidateApp(site); err != nil {
+		return err
+	}
+
+	username := PoolUser(site.ID)
+	if err := s.ensureSiteUser(ctx, username, site.RootPath, claimRoot); err != nil {
+		return err
+	}
+
+	path := s.unitPath(site)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create unit dir: %w", err)
+	}
+	previous, hadPrevious, err := readIfExists(path)
+	if err != nil {
+		return fmt.Errorf("read previous unit: %w", err)
+	}
+	unit := renderUnit(site, username)
+	changed := !hadPrevious || string(previous) != unit
+	if changed {
+		if err := writeAtomic(path, []byte(unit)); err != nil {
+			return fmt.Errorf("write unit: %w", err)
+		}
+	}
+	restore := func() {
+		if !changed {
+			return
+		}
+		if hadPrevious {
+			_ = writeAtomic(path, previous)
+		} else {
+			_ = os.Remove(path)
+		}
+		_ = runCommand(ctx, s.cfg.SystemctlCommand, "daemon-reload")
+		if hadPrevious {
+			_ = runCommand(ctx, s.cfg.SystemctlCommand, "restart", UnitName(site))
+		}
+	}
+
+	name := UnitName(site)
+	if err := runCommand(ctx, s.cfg.SystemctlCommand, "daemon-reload"); err != nil {
+		restore()
+		return fmt.Errorf("systemd reload failed: %w", err)
+	}
+	if err := runCommand(ctx, s.cfg.SystemctlCommand, "enable", name); err != nil {
+		restore()
+		return fmt.Errorf("enable %s: %w", name, err)
+	}
+	action := AppActionStart
+	if changed {
+		action = AppActionRestart
+	}
+	if err := runCommand(ctx, s.cfg.SystemctlCommand, action, name); err != nil {
+		restore()
+		return fmt.Errorf("%s %s: %w", action, name, err)
+	}
+	s.logf("app unit ready site=%s unit=%s changed=%t", site.ID, name, changed)
+	return nil
+}
+
+// RemoveUnit stops and deletes the site's unit. A site without a unit is
+// left alone.
+func (s *SystemdProvisioner) RemoveUnit(ctx context.Context, site store.Site) error {
+	if site.ID == "" {
+		return errors.New("site id is empty")
+	}
+	path := s.unitPath(site)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	name := UnitName(site)
+	if err := runCommand(ctx, s.cfg.SystemctlCommand, "disable", "--now", name); err != nil {
+		return fmt.Errorf("disable %s: %w", name, err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove unit: %w", err)
+	}
+	if err := runCommand(ctx, s.cfg.SystemctlCommand, "daemon-reload"); err != nil {
+		return fmt.Errorf("systemd reload failed: %w", err)
+	}
+	s.logf("app unit removed site=%s unit=%s", site.ID, name)
+	return nil
+}
+
+// Control starts, stops or restarts the site's service.
+func (s *SystemdProvisioner) Control(ctx context.Context, site store.Site, action string) error {
+	switch action {
+	case AppActionStart, AppActionStop, AppActionRestart:
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidApp, action)
+	}
+	if _, err := os.Stat(s.unitPath(site)); errors.Is(err, os.ErrNotExist) {
+		return ErrAppNotConfigured
+	}
+	if err := runCommand(ctx, s.cfg.SystemctlCommand, action, UnitName(site)); err != nil {
+		return fmt.Errorf("%s %s: %w", action, UnitName(site), err)
+	}
+	return nil
+}
+
+// Status reports the service state from systemd.
+func (s *SystemdProvisioner) Status(ctx context.Context, site store.Site) (AppStatus, error) {
+	if _, err := os.Stat(s.unitPath(site)); errors.Is(err, os.ErrNotExist) {
+		return AppStatus{}, ErrAppNotConfigured
+	}
+	name := UnitName(site)
+	out, err := commandOutput(ctx, s.cfg.SystemctlCommand, "show", name,
+		"--property=LoadState,ActiveState,SubState,MainPID,ExecMainStartTimestamp,NRestarts")
+	if err != nil {
+		return AppStatus{}, err
+	}
+	status := AppStatus{Unit: name}
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "LoadState":
+			status.LoadState = value
+		case "ActiveState":
+			status.ActiveState = value
+		case "SubState":
+			status.SubState = value
+		case "MainPID":
+			status.MainPID, _ = strconv.Atoi(value)
+		case "ExecMainStartTimestamp":
+			status.StartedAt = value
+		case "NRestarts":
+			status.Restarts, _ = strconv.Atoi(value)
+		}
+	}
+	return status, nil
+}
+
+func (s *SystemdProvisioner) ensureSiteUser(ctx context.Context, username, home string, claimRoot bool) error {
+	if _, err := user.Lookup(username); err != nil {
+		if err := runCommand(ctx, s.cfg.UserAddCommand, "--home-dir", home, "--user-group", username); err != nil {
+			return fmt.Errorf("create site user: %w", err)
+		}
+	}
+	if !claimRoot {
+		return nil
+	}
+	if err := runCommand(ctx, s.cfg.ChownCommand, username+":"+s.cfg.WebGroup, home); err != nil {
+		return fmt.Errorf("chown site root: %w", err)
+	}
+	return nil
+}
+
+// renderUnit writes the service. PORT and HOST carry the upstream address
+// the vhost proxies to; the env file may override anything else. '%' is
+// doubled because systemd treats it as a specifier.
+func renderUnit(site store.Site, username string) string {
+	app := site.App
+	workingDir := app.WorkingDir
+	if workingDir == "" {
+		workingDir = filepath.Clean(site.RootPath)
+	}
+	restart := app.Restart
+	if restart == "" {
+		restart = "on-failure"
+	}
+	var env strings.Builder
+	if site.UpstreamPort > 0 {
+		fmt.Fprintf(&env, "Environment=PORT=%d\n", site.UpstreamPort)
+	}
+	if site.UpstreamHost != "" {
+		fmt.Fprintf(&env, "Environment=HOST=%s\n", site.UpstreamHost)
+	}
+	if app.EnvFile != "" {
+		fmt.Fprintf(&env, "EnvironmentFile=-%s\n", app.EnvFile)
+	}
+	return fmt.Sprintf(`# Managed by Nusantara Panel for %s. Manual changes are overwritten.
+[Unit]
+Description=Nusantara Panel app for %s
+After=network.target
+
+[Service]
+Type=simple
+User=%s
+WorkingDirectory=%s
+%sExecStart=%s
+Restart=%s
+RestartSec=3
+NoNewPrivileges=true
+PrivateTmp=true
+
+[Install]
+WantedBy=multi-user.target
+`, site.Domain, site.Domain, username, workingDir, env.String(),
+		strings.ReplaceAll(strings.TrimSpace(app.Command), "%", "%%"), restart)
+}
+
+func commandOutput(ctx context.Context, raw string, args ...string) (string, error) {
+	parts := strings.Fields(strings.TrimSpace(raw))
+	if len(parts) == 0 {
+		return "", errors.New("empty command")
+	}
+	cmd := exec.CommandContext(ctx, parts[0], append(parts[1:], args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w (%s)", raw, err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func (s *SystemdProvisioner) logf(format string, args ...any) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// ControlApp starts, stops or restarts the site's app service.
+func (p *NginxProvisioner) ControlApp(ctx context.Context, site store.Site, action string) error {
+	if !p.cfg.Apply || p.host.apps == nil {
+		return ErrApplyDisabled
+	}
+	return p.host.apps.Control(ctx, site, action)
+}
+
+// AppStatus reports the site's app service state.
+func (p *NginxProvisioner) AppStatus(ctx context.Context, site store.Site) (AppStatus, error) {
+	if !p.cfg.Apply || p.host.apps == nil {
+		return AppStatus{}, ErrApplyDisabled
+	}
+	return p.host.apps.Status(ctx, site)
+}
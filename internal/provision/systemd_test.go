@@ -0,0 +1,177 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"nusantara/internal/store"
+)
+
+// fakeSystemctl records its arguments and answers `show` like systemd.
+func fakeSystemctl(t *testing.T, exitCode int) (command, calls string) {
+	t.Helper()
+	dir := t.TempDir()
+	calls = filepath.Join(dir, "calls")
+	command = filepath.Join(dir, "systemctl")
+	script := `#!/bin/sh
+echo "$@" >> ` + calls + `
+if [ "$1" = show ]; then
+  printf 'LoadState=loaded\nActiveState=active\nSubState=running\nMainPID=4242\nExecMainStartTimestamp=Sat 2026-10-17 10:00:00 UTC\nNRestarts=2\n'
+  exit 0
+fi
+if [ "$1" = restart ] || [ "$1" = start ]; then
+  exit ` + strconv.Itoa(exitCode) + `
+fi
+`
+	if err := os.WriteFile(command, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake systemctl: %v", err)
+	}
+	return command, calls
+}
+
+func readCalls(t *testing.T, path string) []string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("read calls: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func appSite() store.Site {
+	return store.Site{
+		ID:           "site_1_abc123",
+		Domain:       "node.example.test",
+		RootPath:     "/var/www/node",
+		Runtime:      "node",
+		UpstreamHost: "127.0.0.1",
+		UpstreamPort: 3000,
+		// A user stored before units always ran as the site user is ignored.
+		App: &store.SiteApp{Command: "/usr/bin/node server.js --title=100%", User: "deploy"},
+	}
+}
+
+func TestValidateApp(t *testing.T) {
+	site := store.Site{
+		ID:       "site_abc123",
+		RootPath: "/srv/app",
+		Runtime:  "node",
+		Deploy:   &store.SiteDeploy{Path: "/srv/deploy/app"},
+	}
+	for _, app := range []store.SiteApp{
+		{Command: "npm start", WorkingDir: "/srv/app", EnvFile: "/srv/app/.env", User: "np_abc123", Restart: "always"},
+		{Command: "npm start", EnvFile: "/srv/deploy/app/shared/.env"},
+	} {
+		site.App = &app
+		if err := ValidateApp(site); err != nil {
+			t.Fatalf("valid app rejected: %v", err)
+		}
+	}
+	if err := ValidateApp(store.Site{Runtime: "php"}); err != nil {
+		t.Fatalf("nil app must be valid: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		runtime string
+		app     store.SiteApp
+	}{
+		"runtime":   {"php", store.SiteApp{Command: "npm start"}},
+		"empty":     {"node", store.SiteApp{Command: "  "}},
+		"multiline": {"python", store.SiteApp{Command: "gunicorn app\nExecStartPre=/bin/sh"}},
+		"relative":  {"node", store.SiteApp{Command: "npm start", WorkingDir: "srv/app"}},
+		"unclean":   {"node", store.SiteApp{Command: "npm start", EnvFile: "/srv/../etc/shadow"}},
+		"outside":   {"node", store.SiteApp{Command: "npm start", EnvFile: "/etc/shadow"}},
+		"sibling":   {"node", store.SiteApp{Command: "npm start", EnvFile: "/srv/app2/.env"}},
+		"root":      {"node", store.SiteApp{Command: "npm start", User: "root"}},
+		"user":      {"node", store.SiteApp{Command: "npm start", User: "deploy"}},
+		"restart":   {"node", store.SiteApp{Command: "npm start", Restart: "sometimes"}},
+	} {
+		site.Runtime = tc.runtime
+		site.App = &tc.app
+		if err := ValidateApp(site); !errors.Is(err, ErrInvalidApp) {
+			t.Fatalf("%s: expected ErrInvalidApp, got %v", name, err)
+		}
+	}
+}
+
+func TestEnsureUnitWritesAndRestarts(t *testing.T) {
+	systemctl, calls := fakeSystemctl(t, 0)
+	unitDir := t.TempDir()
+	apps := NewSystemdProvisioner(SystemdConfig{UnitDir: unitDir, SystemctlCommand: systemctl, UserAddCommand: "true", ChownCommand: "true"}, log.New(io.Discard, "", 0))
+	site := appSite()
+	ctx := context.Background()
+
+	if err := apps.EnsureUnit(ctx, site, true); err != nil {
+		t.Fatalf("ensure unit: %v", err)
+	}
+	unit, err := os.ReadFile(filepath.Join(unitDir, "nusantara-np_abc123.service"))
+	if err != nil {
+		t.Fatalf("read unit: %v", err)
+	}
+	for _, want := range []string{
+		"User=np_abc123",
+		"WorkingDirectory=/var/www/node",
+		"Environment=PORT=3000",
+		"Environment=HOST=127.0.0.1",
+		"ExecStart=/usr/bin/node server.js --title=100%%",
+		"Restart=on-failure",
+	} {
+		if !strings.Contains(string(unit), want) {
+			t.Fatalf("unit missing %q:\n%s", want, unit)
+		}
+	}
+	want := []string{"daemon-reload", "enable nusantara-np_abc123.service", "restart nusantara-np_abc123.service"}
+	if got := readCalls(t, calls); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected systemctl calls: %q", got)
+	}
+
+	// An unchanged unit only makes sure the service is running.
+	if err := os.Remove(calls); err != nil {
+		t.Fatalf("reset calls: %v", err)
+	}
+	if err := apps.EnsureUnit(ctx, site, true); err != nil {
+		t.Fatalf("ensure unchanged unit: %v", err)
+	}
+	if got := readCalls(t, calls); got[len(got)-1] != "start nusantara-np_abc123.service" {
+		t.Fatalf("unchanged unit must start, not restart: %q", got)
+	}
+
+	status, err := apps.Status(ctx, site)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.ActiveState != "active" || status.SubState != "running" || status.MainPID != 4242 || status.Restarts != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	if err := apps.RemoveUnit(ctx, site); err != nil {
+		t.Fatalf("remove unit: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(unitDir, "nusantara-np_abc123.service")); !os.IsNotExist(err) {
+		t.Fatalf("unit should be removed, stat err=%v", err)
+	}
+	if err := apps.Control(ctx, site, AppActionRestart); !errors.Is(err, ErrAppNotConfigured) {
+		t.Fatalf("expected ErrAppNotConfigured after removal, got %v", err)
+	}
+}
+
+func TestEnsureUnitRestoresOnFailedStart(t *testing.T) {
+	systemctl, _ := fakeSystemctl(t, 1)
+	unitDir := t.TempDir()
+	apps := NewSystemdProvisioner(SystemdConfig{UnitDir: unitDir, SystemctlCommand: systemctl, UserAddCommand: "true", ChownCommand: "true"}, log.New(io.Discard, "", 0))
+	if err := apps.EnsureUnit(context.Background(), appSite(), true); err == nil {
+		t.Fatalf("expected start failure")
+	}
+	if _, err := os.Stat(filepath.Join(unitDir, "nusantara-np_abc123.service")); !os.IsNotExist(err) {
+		t.Fatalf("unit must not be left behind after a failed start, stat err=%v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package provision
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"nusantara/internal/store"
+)
+
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// coreTemplateData is the data passed to runtime templates. Admin overrides
+// may use any of these fields.
+type coreTemplateData struct {
+	Domain    string
+	RootPath  string
+	Runtime   string
+	Upstream  string
+	PHPSocket string
+
+	// Proxy fields, set for the node, python and proxy runtimes.
+	ProxyPass        string
+	HostHeader       string
+	ConnectionHeader string
+	ProxySSLName     string
+	WebSocket        bool
+	Buffering        bool
+	ConnectTimeout   int
+	ReadTimeout      int
+	SendTimeout      int
+}
+
+// templateNames lists the override file names for a runtime, most specific
+// first; the last one is also the built-in template.
+func templateNames(runtime string) []string {
+	switch runtime {
+	case "node", "python":
+		return []string{runtime + ".tmpl", "proxy.tmpl"}
+	case "proxy":
+		return []string{"proxy.tmpl"}
+	case "static":
+		return []string{"static.tmpl"}
+	default:
+		return []string{"php.tmpl"}
+	}
+}
+
+// loadCoreTemplate returns the runtime template, preferring an admin
+// override from dir over the built-in one.
+func loadCoreTemplate(dir, runtime string) (*template.Template, error) {
+	names := templateNames(runtime)
+	if strings.TrimSpace(dir) != "" {
+		for _, name := range names {
+			raw, err := os.ReadFile(filepath.Join(dir, name))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read template override %s: %w", name, err)
+			}
+			tmpl, err := template.New(name).Option("missingkey=error").Parse(string(raw))
+			if err != nil {
+				return nil, fmt.Errorf("parse template override %s: %w", name, err)
+			}
+			return tmpl, nil
+		}
+	}
+	name := names[len(names)-1]
+	return template.New(name).Option("missingkey=error").ParseFS(builtinTemplates, "templates/"+name)
+}
+
+func runtimeServerCore(site store.Site, opts vhostOptions) (string, error) {
+	tmpl, err := loadCoreTemplate(opts.TemplateDir, site.Runtime)
+	if err != nil {
+		return "", err
+	}
+	data := coreTemplateData{
+		Domain:   site.Domain,
+		RootPath: site.RootPath,
+		Runtime:  site.Runtime,
+	}
+	switch site.Runtime {
+	case "node", "python", "proxy":
+		proxyTemplateData(site, &data)
+	case "static":
+	default:
+		data.PHPSocket = opts.PHPSocket
+		if data.PHPSocket == "" {
+			data.PHPSocket = defaultPHPSocket
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render %s template: %w", site.Runtime, err)
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
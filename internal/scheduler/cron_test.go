@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+	for _, expr := range cases {
+		if _, err := ParseCron(expr); err == nil {
+			t.Fatalf("expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2026, 3, 2, 10, 7, 30, 0, time.UTC) // Monday
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "*/15 * * * *", want: time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)},
+		{expr: "0 3 * * *", want: time.Date(2026, 3, 3, 3, 0, 0, 0, time.UTC)},
+		{expr: "@hourly", want: time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC)},
+		{expr: "30 2 * * 0", want: time.Date(2026, 3, 8, 2, 30, 0, 0, time.UTC)},
+		{expr: "0 0 1 */3 *", want: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 12 15 * 5", want: time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		cron, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.expr, err)
+		}
+		got, err := cron.Next(base)
+		if err != nil {
+			t.Fatalf("next %q: %v", tc.expr, err)
+		}
+		if !got.Equal(tc.want) {
+			t.Fatalf("next %q = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestCronNextImpossibleDate(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := cron.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatalf("expected no matching time")
+	}
+}
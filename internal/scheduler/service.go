@@ -0,0 +1,316 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"nusantara/internal/audit"
+	"nusantara/internal/idgen"
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidName         = errors.New("invalid schedule name")
+	ErrInvalidJobType      = errors.New("job type cannot be scheduled")
+	ErrInvalidMissedPolicy = errors.New("invalid missed_policy")
+	ErrInvalidPayload      = errors.New("invalid schedule payload")
+)
+
+var schedulableJobTypes = map[string]struct{}{
+	store.JobTypeRenewSSL:  {},
+	store.JobTypeRunBackup: {},
+	store.JobTypeCleanup:   {},
+}
+
+const defaultTickInterval = 30 * time.Second
+
+type Service struct {
+	repo     store.Repository
+	jobs     *jobs.Service
+	audit    *audit.Service
+	logger   *log.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	started bool
+	stopped bool
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+}
+
+type CreateScheduleInput struct {
+	Name         string
+	CronExpr     string
+	JobType      string
+	Payload      json.RawMessage
+	MissedPolicy string
+}
+
+func NewService(repo store.Repository, jobSvc *jobs.Service, auditSvc *audit.Service, interval time.Duration, logger *log.Logger) *Service {
+	if interval < time.Second {
+		interval = defaultTickInterval
+	}
+	return &Service{
+		repo:     repo,
+		jobs:     jobSvc,
+		audit:    auditSvc,
+		logger:   logger,
+		interval: interval,
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+func (s *Service) Start(parent context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	ctx, cancel := context.WithCancel(parent)
+	s.cancel = cancel
+	s.started = true
+	s.wg.Add(1)
+	go s.loop(ctx)
+}
+
+func (s *Service) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) Create(ctx context.Context, actorID string, input CreateScheduleInput) (store.Schedule, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len(name) > 120 {
+		return store.Schedule{}, ErrInvalidName
+	}
+	jobType := strings.TrimSpace(input.JobType)
+	if _, ok := schedulableJobTypes[jobType]; !ok {
+		return store.Schedule{}, ErrInvalidJobType
+	}
+	missedPolicy := strings.TrimSpace(input.MissedPolicy)
+	if missedPolicy == "" {
+		missedPolicy = store.ScheduleMissedRunOnce
+	}
+	if missedPolicy != store.ScheduleMissedRunOnce && missedPolicy != store.ScheduleMissedSkip {
+		return store.Schedule{}, ErrInvalidMissedPolicy
+	}
+	cronExpr := strings.Join(strings.Fields(input.CronExpr), " ")
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return store.Schedule{}, err
+	}
+
+	body := []byte(strings.TrimSpace(string(input.Payload)))
+	if len(body) == 0 || string(body) == "null" {
+		body = []byte("{}")
+	}
+	// The job validator runs now rather than at the first tick, when nobody
+	// is watching the response.
+	if err := s.jobs.ValidatePayload(jobType, body); err != nil {
+		return store.Schedule{}, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	now := s.now()
+	nextRunAt, err := cron.Next(now)
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	id, err := idgen.New("sch")
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	schedule := store.Schedule{
+		ID:           id,
+		Name:         name,
+		CronExpr:     cronExpr,
+		JobType:      jobType,
+		Payload:      string(body),
+		MissedPolicy: missedPolicy,
+		NextRunAt:    nextRunAt,
+		CreatedBy:    actorID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.repo.CreateSchedule(ctx, schedule); err != nil {
+		return store.Schedule{}, err
+	}
+	return schedule, nil
+}
+
+func (s *Service) List(ctx context.Context) ([]store.Schedule, error) {
+	return s.repo.ListSchedules(ctx)
+}
+
+func (s *Service) Get(ctx context.Context, id string) (store.Schedule, error) {
+	return s.repo.GetScheduleByID(ctx, id)
+}
+
+func (s *Service) Pause(ctx context.Context, id string) (store.Schedule, error) {
+	schedule, err := s.repo.GetScheduleByID(ctx, id)
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	if err := s.repo.UpdateScheduleState(ctx, id, true, schedule.NextRunAt); err != nil {
+		return store.Schedule{}, err
+	}
+	return s.repo.GetScheduleByID(ctx, id)
+}
+
+// Resume re-arms a paused schedule from the current time, so runs that were
+// due while paused are not replayed.
+func (s *Service) Resume(ctx context.Context, id string) (store.Schedule, error) {
+	schedule, err := s.repo.GetScheduleByID(ctx, id)
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	cron, err := ParseCron(schedule.CronExpr)
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	nextRunAt, err := cron.Next(s.now())
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	if err := s.repo.UpdateScheduleState(ctx, id, false, nextRunAt); err != nil {
+		return store.Schedule{}, err
+	}
+	return s.repo.GetScheduleByID(ctx, id)
+}
+
+func (s *Service) Delete(ctx context.Context, id string) error {
+	return s.repo.DeleteSchedule(ctx, id)
+}
+
+func (s *Service) loop(ctx context.Context) {
+	defer s.wg.Done()
+	// An immediate tick picks up runs missed while the panel was down.
+	s.tick(ctx)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.tick(ctx)
+		}
+	}
+}
+
+func (s *Service) tick(ctx context.Context) {
+	schedules, err := s.repo.ListSchedules(ctx)
+	if err != nil {
+		s.logf("scheduler list failed err=%v", err)
+		return
+	}
+	now := s.now()
+	for _, schedule := range schedules {
+		if schedule.Paused || schedule.NextRunAt.After(now) {
+			continue
+		}
+		if err := s.fire(ctx, schedule, now); err != nil {
+			s.logf("scheduler run failed schedule=%s err=%v", schedule.ID, err)
+		}
+	}
+}
+
+func (s *Service) fire(ctx context.Context, schedule store.Schedule, now time.Time) error {
+	cron, err := ParseCron(schedule.CronExpr)
+	if err != nil {
+		return err
+	}
+	nextRunAt, err := cron.Next(now)
+	if err != nil {
+		return err
+	}
+
+	missed := countMissed(cron, schedule.NextRunAt, now, s.interval)
+	if missed > 0 && schedule.MissedPolicy == store.ScheduleMissedSkip {
+		if err := s.repo.UpdateScheduleState(ctx, schedule.ID, false, nextRunAt); err != nil {
+			return err
+		}
+		s.audit.Record(ctx, "", "schedule.run.skipped", "schedule", schedule.ID, map[string]any{
+			"job_type":     schedule.JobType,
+			"missed_runs":  missed,
+			"scheduled_at": schedule.NextRunAt,
+		})
+		return nil
+	}
+
+	// Advance first: if recording the run fails after the job is queued, the
+	// next tick must not queue it again.
+	if err := s.repo.UpdateScheduleState(ctx, schedule.ID, false, nextRunAt); err != nil {
+		return err
+	}
+	job, err := s.jobs.Enqueue(ctx, "schedule:"+schedule.ID, schedule.JobType, json.RawMessage(schedule.Payload))
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdateScheduleRun(ctx, schedule.ID, job.ID, now, nextRunAt); err != nil {
+		s.logf("scheduler run record failed schedule=%s job=%s err=%v", schedule.ID, job.ID, err)
+	}
+	s.audit.Record(ctx, "", "schedule.run", "schedule", schedule.ID, map[string]any{
+		"job_id":       job.ID,
+		"job_type":     schedule.JobType,
+		"missed_runs":  missed,
+		"scheduled_at": schedule.NextRunAt,
+	})
+	return nil
+}
+
+// countMissed reports how many occurrences were due but not run, treating
+// anything later than two tick intervals as missed (e.g. panel downtime).
+func countMissed(cron CronSchedule, due, now time.Time, interval time.Duration) int {
+	if now.Sub(due) <= 2*interval {
+		return 0
+	}
+	count := 1
+	next := due
+	for count < 1000 {
+		var err error
+		next, err = cron.Next(next)
+		if err != nil || next.After(now) {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+func (s *Service) logf(format string, args ...any) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nusantara/internal/audit"
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func newTestScheduler(t *testing.T, now time.Time) (*Service, *filedb.Repository) {
+	t.Helper()
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	jobSvc := jobs.NewService(repo, nil, nil, nil)
+	jobSvc.Start(context.Background())
+	t.Cleanup(func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = jobSvc.Stop(stopCtx)
+	})
+
+	svc := NewService(repo, jobSvc, audit.NewService(repo, nil, nil), time.Minute, nil)
+	svc.now = func() time.Time { return now }
+	return svc, repo
+}
+
+func TestCreateValidatesInput(t *testing.T) {
+	svc, _ := newTestScheduler(t, time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+
+	if _, err := svc.Create(ctx, "usr-1", CreateScheduleInput{Name: "x", CronExpr: "@daily", JobType: store.JobTypeProvisionSite}); err == nil {
+		t.Fatalf("expected provision_site to be rejected")
+	}
+	if _, err := svc.Create(ctx, "usr-1", CreateScheduleInput{Name: "x", CronExpr: "bad", JobType: store.JobTypeCleanup}); err == nil {
+		t.Fatalf("expected invalid cron to be rejected")
+	}
+	for _, payload := range []string{`{"job_retention_days":-1}`, `{"job_retention_days":"soon"}`, `[]`} {
+		_, err := svc.Create(ctx, "usr-1", CreateScheduleInput{Name: "x", CronExpr: "@daily", JobType: store.JobTypeCleanup, Payload: json.RawMessage(payload)})
+		if !errors.Is(err, ErrInvalidPayload) {
+			t.Fatalf("payload %s: err = %v, want ErrInvalidPayload", payload, err)
+		}
+	}
+	schedule, err := svc.Create(ctx, "usr-1", CreateScheduleInput{
+		Name:     "nightly",
+		CronExpr: "0 2 * * *",
+		JobType:  store.JobTypeCleanup,
+		Payload:  json.RawMessage(`{"job_retention_days":7}`),
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if want := time.Date(2026, 3, 3, 2, 0, 0, 0, time.UTC); !schedule.NextRunAt.Equal(want) {
+		t.Fatalf("next_run_at = %s, want %s", schedule.NextRunAt, want)
+	}
+	if schedule.MissedPolicy != store.ScheduleMissedRunOnce {
+		t.Fatalf("unexpected default missed policy %q", schedule.MissedPolicy)
+	}
+}
+
+func TestTickRunsMissedScheduleOnce(t *testing.T) {
+	created := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	svc, repo := newTestScheduler(t, created)
+	ctx := context.Background()
+
+	schedule, err := svc.Create(ctx, "usr-1", CreateScheduleInput{Name: "hourly", CronExpr: "@hourly", JobType: store.JobTypeCleanup})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Simulate the panel coming back after three hours of downtime.
+	restart := created.Add(3*time.Hour + 10*time.Minute)
+	svc.now = func() time.Time { return restart }
+	svc.tick(ctx)
+
+	got, err := repo.GetScheduleByID(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if got.LastJobID == "" || got.LastRunAt == nil {
+		t.Fatalf("expected schedule to record a run")
+	}
+	if want := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC); !got.NextRunAt.Equal(want) {
+		t.Fatalf("next_run_at = %s, want %s", got.NextRunAt, want)
+	}
+	jobsList, err := repo.ListJobs(ctx, 0)
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	if len(jobsList) != 1 || jobsList[0].Type != store.JobTypeCleanup {
+		t.Fatalf("expected exactly one cleanup job, got %+v", jobsList)
+	}
+	logs, _ := repo.ListAuditLogs(ctx, 1)
+	if len(logs) != 1 || logs[0].Action != "schedule.run" {
+		t.Fatalf("expected schedule.run audit entry, got %+v", logs)
+	}
+}
+
+func TestTickSkipsMissedRunsWithSkipPolicy(t *testing.T) {
+	created := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	svc, repo := newTestScheduler(t, created)
+	ctx := context.Background()
+
+	schedule, err := svc.Create(ctx, "usr-1", CreateScheduleInput{
+		Name:         "hourly",
+		CronExpr:     "@hourly",
+		JobType:      store.JobTypeCleanup,
+		MissedPolicy: store.ScheduleMissedSkip,
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	svc.now = func() time.Time { return created.Add(5 * time.Hour) }
+	svc.tick(ctx)
+
+	got, _ := repo.GetScheduleByID(ctx, schedule.ID)
+	if got.LastJobID != "" {
+		t.Fatalf("expected no job for skipped run")
+	}
+	if want := time.Date(2026, 3, 2, 16, 0, 0, 0, time.UTC); !got.NextRunAt.Equal(want) {
+		t.Fatalf("next_run_at = %s, want %s", got.NextRunAt, want)
+	}
+	jobsList, _ := repo.ListJobs(ctx, 0)
+	if len(jobsList) != 0 {
+		t.Fatalf("expected no jobs, got %d", len(jobsList))
+	}
+}
+
+func TestPausedScheduleDoesNotRun(t *testing.T) {
+	created := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	svc, repo := newTestScheduler(t, created)
+	ctx := context.Background()
+
+	schedule, err := svc.Create(ctx, "usr-1", CreateScheduleInput{Name: "hourly", CronExpr: "@hourly", JobType: store.JobTypeCleanup})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := svc.Pause(ctx, schedule.ID); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+
+	svc.now = func() time.Time { return created.Add(2 * time.Hour) }
+	svc.tick(ctx)
+
+	jobsList, _ := repo.ListJobs(ctx, 0)
+	if len(jobsList) != 0 {
+		t.Fatalf("paused schedule must not enqueue jobs")
+	}
+
+	resumed, err := svc.Resume(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if resumed.Paused || !resumed.NextRunAt.After(created.Add(2*time.Hour)) {
+		t.Fatalf("resume must re-arm from now, got %+v", resumed)
+	}
+}
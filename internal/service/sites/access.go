@@ -0,0 +1,176 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/security/password"
+	"nusantara/internal/store"
+)
+
+var ErrAccessUserNotFound = errors.New("access user not found")
+
+// htpasswdCost is bcrypt's default. The file is readable by the web group,
+// so the hashes must hold up to offline cracking.
+const htpasswdCost = bcrypt.DefaultCost
+
+// AccessRulesInput replaces a site's realm, IP rules and protected paths.
+// Basic auth users are managed separately.
+type AccessRulesInput struct {
+	Realm string   `json:"realm"`
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	Paths []string `json:"paths"`
+}
+
+// SetAccessRules stores the site's IP rules and protected paths and
+// reprovisions it. Empty lists lift the corresponding restriction.
+func (s *Service) SetAccessRules(ctx context.Context, actorID, id string, input AccessRulesInput) (store.Site, store.Job, error) {
+	allow, err := normalizeAddresses(input.Allow, provision.ErrInvalidAccess)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	deny, err := normalizeAddresses(input.Deny, provision.ErrInvalidAccess)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	paths := make([]string, 0, len(input.Paths))
+	for _, path := range input.Paths {
+		path = strings.TrimSpace(path)
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, store.Job{}, provision.ErrSiteUnmanaged
+	}
+
+	access := &store.SiteAccess{
+		Realm: strings.TrimSpace(input.Realm),
+		Allow: allow,
+		Deny:  deny,
+		Paths: paths,
+	}
+	if site.Access != nil {
+		access.Users = site.Access.Users
+	}
+	if err := provision.ValidateAccess(access); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return s.applyAccess(ctx, actorID, site, access)
+}
+
+// SetAccessUser adds a basic auth user, or changes an existing user's
+// password, and reprovisions the site so the vhost asks for credentials.
+func (s *Service) SetAccessUser(ctx context.Context, actorID, id, username, plain string) (store.Site, store.Job, error) {
+	username = strings.TrimSpace(username)
+	if err := provision.ValidateAccessUsername(username); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if len(plain) < 8 {
+		return store.Site{}, store.Job{}, password.ErrWeakPassword
+	}
+	if s.vhosts == nil {
+		return store.Site{}, store.Job{}, provision.ErrApplyDisabled
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, store.Job{}, provision.ErrSiteUnmanaged
+	}
+
+	access := &store.SiteAccess{}
+	if site.Access != nil {
+		copied := *site.Access
+		access = &copied
+	}
+	existing := slices.Contains(access.Users, username)
+	if !existing {
+		access.Users = append(slices.Clone(access.Users), username)
+	}
+	if err := provision.ValidateAccess(access); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), htpasswdCost)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.vhosts.SetAccessUser(site, username, string(hash)); err != nil {
+		return store.Site{}, store.Job{}, fmt.Errorf("write htpasswd: %w", err)
+	}
+	updated, job, err := s.applyAccess(ctx, actorID, site, access)
+	if err != nil && !existing {
+		_ = s.vhosts.RemoveAccessUser(site, username)
+	}
+	return updated, job, err
+}
+
+// RemoveAccessUser deletes a basic auth user. Removing the last user turns
+// basic auth off for the site.
+func (s *Service) RemoveAccessUser(ctx context.Context, actorID, id, username string) (store.Site, store.Job, error) {
+	if s.vhosts == nil {
+		return store.Site{}, store.Job{}, provision.ErrApplyDisabled
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Access == nil || !slices.Contains(site.Access.Users, username) {
+		return store.Site{}, store.Job{}, ErrAccessUserNotFound
+	}
+
+	access := *site.Access
+	access.Users = slices.DeleteFunc(slices.Clone(access.Users), func(name string) bool {
+		return name == username
+	})
+	// The user loses access as soon as the line is gone. After the last
+	// user the site refuses everyone until the job drops basic auth from
+	// the vhost, which fails closed.
+	if err := s.vhosts.RemoveAccessUser(site, username); err != nil {
+		return store.Site{}, store.Job{}, fmt.Errorf("write htpasswd: %w", err)
+	}
+	return s.applyAccess(ctx, actorID, site, &access)
+}
+
+// applyAccess stores the new rules and enqueues the reprovision, restoring
+// the previous rules if the job cannot be queued.
+func (s *Service) applyAccess(ctx context.Context, actorID string, site store.Site, access *store.SiteAccess) (store.Site, store.Job, error) {
+	if !accessConfigured(access) {
+		access = nil
+	}
+	if err := s.repo.UpdateSiteAccess(ctx, site.ID, access); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusProvisioning); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, provisionPayload(site))
+	if err != nil {
+		_ = s.repo.UpdateSiteAccess(ctx, site.ID, site.Access)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, site.Status)
+		return store.Site{}, store.Job{}, err
+	}
+	site, err = s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+func accessConfigured(access *store.SiteAccess) bool {
+	return access != nil && (len(access.Users) > 0 || len(access.Allow) > 0 || len(access.Deny) > 0 ||
+		len(access.Paths) > 0 || access.Realm != "")
+}
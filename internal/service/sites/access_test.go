@@ -0,0 +1,68 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/provision"
+	"nusantara/internal/security/password"
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func TestAccessUsersAndRules(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := repo.CreateSite(ctx, store.Site{
+		ID: "site-1", Domain: "staging.example.com", RootPath: "/srv/staging", Runtime: "static",
+		Status: store.SiteStatusActive, CreatedAt: now, UpdatedAt: now,
+	}); err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+	vhosts := fakeVhosts{users: map[string]string{}}
+	// The job service is never started, so every enqueue fails and the
+	// changes must be rolled back.
+	svc := NewService(repo, jobs.NewService(repo, nil, nil, nil), "", "", "", "", true, PortRange{}, nil, vhosts, nil)
+
+	if _, _, err := svc.SetAccessUser(ctx, "usr-1", "site-1", "qa:team", "secret-pass"); !errors.Is(err, provision.ErrInvalidAccess) {
+		t.Fatalf("expected invalid username, got %v", err)
+	}
+	if _, _, err := svc.SetAccessUser(ctx, "usr-1", "site-1", "qa", "short"); !errors.Is(err, password.ErrWeakPassword) {
+		t.Fatalf("expected weak password, got %v", err)
+	}
+	if _, _, err := svc.SetAccessUser(ctx, "usr-1", "site-1", "qa", "secret-pass"); err == nil {
+		t.Fatalf("expected enqueue failure")
+	}
+	if _, ok := vhosts.users["qa"]; ok {
+		t.Fatalf("htpasswd entry not removed after failed enqueue")
+	}
+	site, err := repo.GetSiteByID(ctx, "site-1")
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	if site.Access != nil || site.Status != store.SiteStatusActive {
+		t.Fatalf("site not restored: %+v", site)
+	}
+
+	if _, _, err := svc.SetAccessRules(ctx, "usr-1", "site-1", AccessRulesInput{Allow: []string{"10.0.0.0/8; allow all"}}); !errors.Is(err, provision.ErrInvalidAccess) {
+		t.Fatalf("expected invalid address, got %v", err)
+	}
+	if _, _, err := svc.SetAccessRules(ctx, "usr-1", "site-1", AccessRulesInput{Paths: []string{"admin"}}); !errors.Is(err, provision.ErrInvalidAccess) {
+		t.Fatalf("expected invalid path, got %v", err)
+	}
+	if _, _, err := svc.SetAccessRules(ctx, "usr-1", "site-1", AccessRulesInput{Allow: []string{"10.1.2.3/8"}, Deny: []string{"10.0.0.0/8"}}); !errors.Is(err, provision.ErrInvalidAccess) {
+		t.Fatalf("expected conflicting rules to be rejected, got %v", err)
+	}
+	if _, _, err := svc.RemoveAccessUser(ctx, "usr-1", "site-1", "qa"); !errors.Is(err, ErrAccessUserNotFound) {
+		t.Fatalf("expected unknown user, got %v", err)
+	}
+}
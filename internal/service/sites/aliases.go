@@ -0,0 +1,148 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidAlias    = errors.New("invalid alias")
+	ErrInvalidRedirect = errors.New("invalid canonical_redirect")
+)
+
+const maxSiteAliases = 20
+
+// normalizeHostnames validates aliases against the site domain and the
+// canonical redirect. A redirect needs the www name, so it is added as an
+// alias when missing.
+func normalizeHostnames(domain string, aliases []string, redirect string) ([]string, string, error) {
+	redirect = strings.ToLower(strings.TrimSpace(redirect))
+	switch redirect {
+	case "", store.CanonicalApex, store.CanonicalWWW:
+	default:
+		return nil, "", ErrInvalidRedirect
+	}
+
+	www := "www." + domain
+	out := make([]string, 0, len(aliases)+1)
+	seen := map[string]struct{}{domain: {}}
+	for _, raw := range aliases {
+		alias := normalizeDomain(raw)
+		if !isValidDomain(alias) {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidAlias, raw)
+		}
+		if _, dup := seen[alias]; dup {
+			return nil, "", fmt.Errorf("%w: %s is listed twice", ErrInvalidAlias, alias)
+		}
+		seen[alias] = struct{}{}
+		out = append(out, alias)
+	}
+	if redirect != "" {
+		if strings.HasPrefix(domain, "www.") {
+			return nil, "", fmt.Errorf("%w: site domain must be the apex name", ErrInvalidRedirect)
+		}
+		if _, ok := seen[www]; !ok {
+			out = append(out, www)
+		}
+	}
+	if len(out) > maxSiteAliases {
+		return nil, "", fmt.Errorf("%w: at most %d aliases", ErrInvalidAlias, maxSiteAliases)
+	}
+	return out, redirect, nil
+}
+
+// AddAlias adds a host name to the site and reprovisions it. The certificate
+// is re-issued for the new name list when sslEmail is set or the site already
+// serves HTTPS.
+func (s *Service) AddAlias(ctx context.Context, actorID, id, alias, sslEmail string) (store.Site, jobs.Workflow, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	aliases := append(append([]string(nil), site.Aliases...), alias)
+	return s.updateHostnames(ctx, actorID, site, aliases, site.CanonicalRedirect, sslEmail)
+}
+
+// RemoveAlias drops a host name from the site. The www alias cannot be
+// removed while a canonical redirect depends on it.
+func (s *Service) RemoveAlias(ctx context.Context, actorID, id, alias, sslEmail string) (store.Site, jobs.Workflow, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	alias = normalizeDomain(alias)
+	if site.CanonicalRedirect != "" && alias == "www."+site.Domain {
+		return store.Site{}, jobs.Workflow{}, fmt.Errorf("%w: %s is required by the canonical redirect", ErrInvalidAlias, alias)
+	}
+	aliases := make([]string, 0, len(site.Aliases))
+	for _, existing := range site.Aliases {
+		if existing != alias {
+			aliases = append(aliases, existing)
+		}
+	}
+	if len(aliases) == len(site.Aliases) {
+		return store.Site{}, jobs.Workflow{}, store.ErrNotFound
+	}
+	return s.updateHostnames(ctx, actorID, site, aliases, site.CanonicalRedirect, sslEmail)
+}
+
+// SetCanonicalRedirect switches the www/apex redirect of the site.
+func (s *Service) SetCanonicalRedirect(ctx context.Context, actorID, id, redirect, sslEmail string) (store.Site, jobs.Workflow, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	return s.updateHostnames(ctx, actorID, site, site.Aliases, redirect, sslEmail)
+}
+
+func (s *Service) updateHostnames(ctx context.Context, actorID string, site store.Site, aliases []string, redirect, sslEmail string) (store.Site, jobs.Workflow, error) {
+	aliases, redirect, err := normalizeHostnames(site.Domain, aliases, redirect)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+
+	steps := []jobs.WorkflowStep{{
+		Name:    stepProvisionSite,
+		JobType: store.JobTypeProvisionSite,
+		Payload: provisionPayload(site),
+	}}
+	hsts := false
+	if site.TLS != nil {
+		hsts = site.TLS.HSTS
+		if sslEmail == "" {
+			sslEmail = site.TLS.Email
+		}
+	}
+	if sslEmail != "" {
+		renamed := site
+		renamed.Aliases = aliases
+		tls, err := tlsSteps(renamed, sslEmail, hsts, stepProvisionSite)
+		if err != nil {
+			return store.Site{}, jobs.Workflow{}, err
+		}
+		steps = append(steps, tls...)
+	}
+
+	if err := s.repo.UpdateSiteDomains(ctx, site.ID, aliases, redirect); err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusProvisioning); err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	workflow, err := s.jobSvc.EnqueueWorkflow(ctx, actorID, "site_hostnames:"+site.Domain, steps)
+	if err != nil {
+		_ = s.repo.UpdateSiteDomains(ctx, site.ID, site.Aliases, site.CanonicalRedirect)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, site.Status)
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	updated, err := s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	return updated, workflow, nil
+}
@@ -0,0 +1,77 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func TestNormalizeHostnames(t *testing.T) {
+	aliases, redirect, err := normalizeHostnames("example.com", []string{"Shop.Example.com."}, "APEX")
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if redirect != store.CanonicalApex || !reflect.DeepEqual(aliases, []string{"shop.example.com", "www.example.com"}) {
+		t.Fatalf("unexpected result aliases=%v redirect=%q", aliases, redirect)
+	}
+
+	if _, _, err := normalizeHostnames("example.com", []string{"example.com"}, ""); !errors.Is(err, ErrInvalidAlias) {
+		t.Fatalf("expected domain repeated as alias to be rejected, got %v", err)
+	}
+	if _, _, err := normalizeHostnames("example.com", []string{"bad host"}, ""); !errors.Is(err, ErrInvalidAlias) {
+		t.Fatalf("expected invalid alias, got %v", err)
+	}
+	if _, _, err := normalizeHostnames("www.example.com", nil, store.CanonicalWWW); !errors.Is(err, ErrInvalidRedirect) {
+		t.Fatalf("expected redirect on www domain to be rejected, got %v", err)
+	}
+	if _, _, err := normalizeHostnames("example.com", nil, "sideways"); !errors.Is(err, ErrInvalidRedirect) {
+		t.Fatalf("expected unknown redirect to be rejected, got %v", err)
+	}
+}
+
+func TestAliasesAreUniqueAcrossSites(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	svc := NewService(repo, nil, "", "", "", "", false, PortRange{}, nil, nil, nil)
+	ctx := context.Background()
+
+	first, err := newSite("usr-1", CreateSiteInput{Domain: "example.com", RootPath: "/var/www/example", Runtime: "static", Aliases: []string{"shop.example.com"}})
+	if err != nil {
+		t.Fatalf("new site: %v", err)
+	}
+	if _, err := svc.createSiteRecord(ctx, first); err != nil {
+		t.Fatalf("create first: %v", err)
+	}
+
+	second, err := newSite("usr-1", CreateSiteInput{Domain: "shop.example.com", RootPath: "/var/www/shop", Runtime: "static"})
+	if err != nil {
+		t.Fatalf("new site: %v", err)
+	}
+	if _, err := svc.createSiteRecord(ctx, second); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("expected alias conflict on create, got %v", err)
+	}
+
+	second.Domain = "other.example.com"
+	if _, err := svc.createSiteRecord(ctx, second); err != nil {
+		t.Fatalf("create second: %v", err)
+	}
+	if err := repo.UpdateSiteDomains(ctx, second.ID, []string{"shop.example.com"}, ""); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("expected alias conflict on update, got %v", err)
+	}
+
+	// Releasing the alias on the first site frees it for the second.
+	if err := repo.UpdateSiteDomains(ctx, first.ID, nil, ""); err != nil {
+		t.Fatalf("clear aliases: %v", err)
+	}
+	if err := repo.UpdateSiteDomains(ctx, second.ID, []string{"shop.example.com"}, ""); err != nil {
+		t.Fatalf("move alias: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package sites
+
+import (
+	"context"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// AppController starts, stops and inspects the systemd services of node
+// and python sites.
+type AppController interface {
+	ControlApp(ctx context.Context, site store.Site, action string) error
+	AppStatus(ctx context.Context, site store.Site) (provision.AppStatus, error)
+}
+
+// SetApp stores the site's app process settings and reprovisions it, which
+// writes and restarts the unit. A nil app stops and removes the unit.
+func (s *Service) SetApp(ctx context.Context, actorID, id string, app *store.SiteApp) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, store.Job{}, provision.ErrSiteUnmanaged
+	}
+	candidate := site
+	candidate.App = app
+	if err := provision.ValidateApp(candidate); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+
+	if err := s.repo.UpdateSiteApp(ctx, site.ID, app); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusProvisioning); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, provisionPayload(site))
+	if err != nil {
+		_ = s.repo.UpdateSiteApp(ctx, site.ID, site.App)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, site.Status)
+		return store.Site{}, store.Job{}, err
+	}
+	site, err = s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// ControlApp runs start, stop or restart on the site's service.
+func (s *Service) ControlApp(ctx context.Context, id, action string) (store.Site, error) {
+	site, err := s.appSite(ctx, id)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if err := s.apps.ControlApp(ctx, site, action); err != nil {
+		return store.Site{}, err
+	}
+	return site, nil
+}
+
+// AppStatus reports the state of the site's service.
+func (s *Service) AppStatus(ctx context.Context, id string) (provision.AppStatus, error) {
+	site, err := s.appSite(ctx, id)
+	if err != nil {
+		return provision.AppStatus{}, err
+	}
+	return s.apps.AppStatus(ctx, site)
+}
+
+func (s *Service) appSite(ctx context.Context, id string) (store.Site, error) {
+	if s.apps == nil {
+		return store.Site{}, provision.ErrApplyDisabled
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if site.App == nil {
+		return store.Site{}, provision.ErrAppNotConfigured
+	}
+	return site, nil
+}
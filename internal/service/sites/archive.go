@@ -0,0 +1,580 @@
+package sites
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidArchive  = errors.New("invalid archive")
+	ErrArchiveTooLarge = errors.New("archive too large")
+)
+
+const (
+	ArchiveFormatZip   = "zip"
+	ArchiveFormatTarGz = "tar.gz"
+)
+
+const (
+	maxArchiveUploadBytes  = 1 << 30 // 1 GiB compressed
+	maxArchiveExtractBytes = 4 << 30 // 4 GiB extracted
+	maxArchiveEntries      = 100000
+)
+
+// SiteArchiveUpload describes a staged archive waiting for extraction.
+type SiteArchiveUpload struct {
+	Dir    string `json:"dir"`
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+	Wipe   bool   `json:"wipe"`
+}
+
+// ExtractArchivePayload extracts a staged upload into Dir, relative to the
+// site root. The job deletes Archive when it finishes.
+type ExtractArchivePayload struct {
+	SiteID  string `json:"site_id"`
+	Archive string `json:"archive"`
+	Format  string `json:"format"`
+	Dir     string `json:"dir,omitempty"`
+	Wipe    bool   `json:"wipe,omitempty"`
+}
+
+func (p ExtractArchivePayload) Validate() error {
+	if p.SiteID == "" {
+		return errors.New("missing site_id in payload")
+	}
+	if p.Archive == "" {
+		return errors.New("missing archive in payload")
+	}
+	if p.Format != ArchiveFormatZip && p.Format != ArchiveFormatTarGz {
+		return fmt.Errorf("unsupported archive format %q", p.Format)
+	}
+	return nil
+}
+
+// RegisterJobs exposes archive extraction as an async job.
+func (s *Service) RegisterJobs(r jobs.Registry) error {
+	return r.Register(store.JobTypeExtractArchive, jobs.Typed(func(ctx context.Context, _ store.Job, p ExtractArchivePayload) error {
+		return s.extractArchive(ctx, p)
+	}))
+}
+
+// UploadSiteArchive stages a zip or tar.gz upload and queues its extraction
+// into dir. With wipe, the contents of dir are removed first, but only once
+// the whole archive has been checked.
+func (s *Service) UploadSiteArchive(ctx context.Context, actorID, id, dir string, wipe bool, body io.Reader) (store.Site, SiteArchiveUpload, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, err
+	}
+	relDir, err := normalizeRelativePath(dir, true)
+	if err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, err
+	}
+	if !isWithinRoot(site.RootPath, filepath.Join(site.RootPath, filepath.FromSlash(relDir))) {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, ErrInvalidPath
+	}
+	if strings.TrimSpace(s.uploadDir) == "" {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, errors.New("upload dir is empty")
+	}
+
+	if err := os.MkdirAll(s.uploadDir, 0o700); err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, fmt.Errorf("create upload dir: %w", err)
+	}
+	staged, err := os.CreateTemp(s.uploadDir, "archive-*")
+	if err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, fmt.Errorf("stage upload: %w", err)
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			_ = os.Remove(staged.Name())
+		}
+	}()
+	size, err := io.Copy(staged, io.LimitReader(body, maxArchiveUploadBytes+1))
+	if closeErr := staged.Close(); err == nil && closeErr != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, fmt.Errorf("stage upload: %w", closeErr)
+	}
+	if err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, fmt.Errorf("%w: read upload: %v", ErrInvalidArchive, err)
+	}
+	if size > maxArchiveUploadBytes {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, ErrArchiveTooLarge
+	}
+	format, err := sniffArchive(staged.Name())
+	if err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, err
+	}
+
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeExtractArchive, ExtractArchivePayload{
+		SiteID:  site.ID,
+		Archive: staged.Name(),
+		Format:  format,
+		Dir:     relDir,
+		Wipe:    wipe,
+	})
+	if err != nil {
+		return store.Site{}, SiteArchiveUpload{}, store.Job{}, err
+	}
+	queued = true
+	return site, SiteArchiveUpload{Dir: relDir, Format: format, Size: size, Wipe: wipe}, job, nil
+}
+
+func sniffArchive(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("open upload: %w", err)
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return ArchiveFormatZip, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return ArchiveFormatTarGz, nil
+	default:
+		return "", fmt.Errorf("%w: only zip and tar.gz are supported", ErrInvalidArchive)
+	}
+}
+
+type archiveEntryKind int
+
+const (
+	archiveFile archiveEntryKind = iota
+	archiveDir
+	archiveLink
+	archiveOther
+)
+
+type archiveEntry struct {
+	name string
+	kind archiveEntryKind
+	mode fs.FileMode
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// extractArchive checks every entry before touching the target, so a
+// rejected archive never wipes it. Writes go through os.Root, which refuses
+// to follow symlinks already in the site out of the target. With wipe the
+// archive is extracted next to the target and swapped in once complete, so
+// a failure leaves the old content in place.
+func (s *Service) extractArchive(ctx context.Context, p ExtractArchivePayload) error {
+	if filepath.Dir(filepath.Clean(p.Archive)) != filepath.Clean(s.uploadDir) {
+		return fmt.Errorf("archive %s is not a staged upload", p.Archive)
+	}
+	defer os.Remove(p.Archive)
+
+	site, err := s.repo.GetSiteByID(ctx, p.SiteID)
+	if err != nil {
+		return err
+	}
+	relDir, err := normalizeRelativePath(p.Dir, true)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(site.RootPath, filepath.FromSlash(relDir))
+	if !isWithinRoot(site.RootPath, target) {
+		return ErrInvalidPath
+	}
+	if !s.apply {
+		jobs.Logf(ctx, "dry-run: would extract %s archive into %s (wipe=%t)", p.Format, target, p.Wipe)
+		return nil
+	}
+
+	var files, dirs, skipped int
+	var total int64
+	err = walkArchive(p.Archive, p.Format, func(e archiveEntry) error {
+		if _, err := archiveEntryPath(target, e.name); err != nil {
+			return err
+		}
+		switch e.kind {
+		case archiveFile:
+			files++
+			total += e.size
+		case archiveDir:
+			dirs++
+		default:
+			skipped++
+		}
+		if files+dirs > maxArchiveEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, maxArchiveEntries)
+		}
+		if total > maxArchiveExtractBytes {
+			return fmt.Errorf("%w: more than %d bytes uncompressed", ErrArchiveTooLarge, int64(maxArchiveExtractBytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	jobs.Logf(ctx, "archive holds %d files and %d directories (%d bytes); skipping %d links and special files", files, dirs, total, skipped)
+
+	if err := os.MkdirAll(site.RootPath, 0o755); err != nil {
+		return fmt.Errorf("ensure site root: %w", err)
+	}
+	siteRoot, err := os.OpenRoot(site.RootPath)
+	if err != nil {
+		return fmt.Errorf("open site root: %w", err)
+	}
+	defer siteRoot.Close()
+	owner, err := archiveOwner(site)
+	if err != nil {
+		return err
+	}
+	if err := mkdirAllIn(siteRoot, relDir, owner); err != nil {
+		return err
+	}
+	root := siteRoot
+	if relDir != "" {
+		if root, err = siteRoot.OpenRoot(filepath.FromSlash(relDir)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPath, err)
+		}
+		defer root.Close()
+	}
+	var realTarget, staging string
+	if p.Wipe {
+		if realTarget, err = resolveTarget(site.RootPath, target); err != nil {
+			return err
+		}
+		staging, err = os.MkdirTemp(filepath.Dir(realTarget), "."+filepath.Base(realTarget)+".extract-")
+		if err != nil {
+			return fmt.Errorf("create staging dir: %w", err)
+		}
+		defer os.RemoveAll(staging)
+		if root, err = os.OpenRoot(staging); err != nil {
+			return fmt.Errorf("open staging dir: %w", err)
+		}
+		defer root.Close()
+	}
+
+	var done int
+	var written int64
+	nextReport := 10
+	err = walkArchive(p.Archive, p.Format, func(e archiveEntry) error {
+		rel, err := archiveEntryPath(target, e.name)
+		if err != nil || rel == "" {
+			return err
+		}
+		switch e.kind {
+		case archiveDir:
+			return mkdirAllIn(root, rel, owner)
+		case archiveFile:
+		default:
+			return nil
+		}
+		if err := mkdirAllIn(root, path.Dir(rel), owner); err != nil {
+			return err
+		}
+		n, err := extractArchiveFile(root, rel, e, maxArchiveExtractBytes-written, owner)
+		if err != nil {
+			return err
+		}
+		written += n
+		done++
+		if pct := done * 100 / files; pct >= nextReport || done == files {
+			jobs.Logf(ctx, "extracted %d/%d files (%d%%)", done, files, pct)
+			for nextReport <= pct {
+				nextReport += 10
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if !p.Wipe && done > 0 {
+			jobs.Logf(ctx, "extraction stopped after %d/%d files; %s now holds a partial update", done, files, target)
+		}
+		return err
+	}
+	if p.Wipe {
+		jobs.Logf(ctx, "replacing the content of %s", target)
+		return swapDir(realTarget, staging)
+	}
+	return nil
+}
+
+// fileOwner is who extracted files and directories are handed to.
+type fileOwner struct {
+	uid, gid int
+}
+
+// archiveOwner returns the site's own system user, shared with its PHP-FPM
+// pool and app unit. Sites without one keep root-owned files, like a
+// deploy release does.
+func archiveOwner(site store.Site) (*fileOwner, error) {
+	u, err := user.Lookup(provision.PoolUser(site.ID))
+	if err != nil {
+		return nil, nil
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("site user uid %q: %w", u.Uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("site user gid %q: %w", u.Gid, err)
+	}
+	return &fileOwner{uid: uid, gid: gid}, nil
+}
+
+// chown hands an open file to owner. Going through the descriptor means a
+// symlink swapped in meanwhile cannot redirect it.
+func (o *fileOwner) chown(f *os.File) error {
+	if o == nil {
+		return nil
+	}
+	if err := f.Chown(o.uid, o.gid); err != nil {
+		return fmt.Errorf("chown %s: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// archiveEntryPath cleans an entry name to a path relative to target and
+// rejects names that would land outside it (zip-slip). The archive's own
+// root entry maps to "".
+func archiveEntryPath(target, name string) (string, error) {
+	name = strings.TrimSuffix(strings.ReplaceAll(name, "\\", "/"), "/")
+	for strings.HasPrefix(name, "./") {
+		name = strings.TrimPrefix(name, "./")
+	}
+	if name == "" || name == "." {
+		return "", nil
+	}
+	rel, err := normalizeRelativePath(name, false)
+	if err != nil || !isWithinRoot(target, filepath.Join(target, filepath.FromSlash(rel))) {
+		return "", fmt.Errorf("%w: entry %q escapes the target directory", ErrInvalidArchive, name)
+	}
+	return rel, nil
+}
+
+func extractArchiveFile(root *os.Root, rel string, e archiveEntry, budget int64, owner *fileOwner) (int64, error) {
+	name := filepath.FromSlash(rel)
+	if info, err := root.Lstat(name); err == nil {
+		if info.IsDir() {
+			return 0, fmt.Errorf("%w: %s is a directory", ErrInvalidArchive, rel)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			// Replace the link rather than write through it.
+			if err := root.Remove(name); err != nil {
+				return 0, fmt.Errorf("replace %s: %w", rel, err)
+			}
+		}
+	}
+	src, err := e.open()
+	if err != nil {
+		return 0, fmt.Errorf("%w: open %s: %v", ErrInvalidArchive, rel, err)
+	}
+	defer src.Close()
+	perm := fs.FileMode(0o644)
+	if e.mode&0o111 != 0 {
+		perm = 0o755
+	}
+	dst, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, fmt.Errorf("create %s: %w", rel, err)
+	}
+	// Declared sizes were checked against the limits, so a longer stream
+	// means a forged header.
+	limit := min(e.size, budget)
+	n, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	if err == nil {
+		err = owner.chown(dst)
+	}
+	if closeErr := dst.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("extract %s: %w", rel, err)
+	}
+	if n > limit {
+		return n, fmt.Errorf("%w: %s is larger than declared", ErrInvalidArchive, rel)
+	}
+	return n, nil
+}
+
+// mkdirAllIn creates rel and its parents inside root. Directories it
+// creates are handed to owner; existing ones are left alone.
+func mkdirAllIn(root *os.Root, rel string, owner *fileOwner) error {
+	if rel == "" || rel == "." {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(rel, "/") {
+		current = path.Join(current, part)
+		name := filepath.FromSlash(current)
+		err := root.Mkdir(name, 0o755)
+		if err == nil {
+			if err := chownIn(root, name, owner); err != nil {
+				return err
+			}
+			continue
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("create directory %s: %w", current, err)
+		}
+		info, err := root.Stat(name)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidPath, current, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %s is not a directory", ErrInvalidArchive, current)
+		}
+	}
+	return nil
+}
+
+func chownIn(root *os.Root, name string, owner *fileOwner) error {
+	if owner == nil {
+		return nil
+	}
+	f, err := root.Open(name)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer f.Close()
+	return owner.chown(f)
+}
+
+func fileOwnerIDs(info fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// resolveTarget returns the real path of target, which must resolve inside
+// rootPath even when reached through a symlink.
+func resolveTarget(rootPath, target string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return "", fmt.Errorf("resolve site root: %w", err)
+	}
+	realTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("resolve target: %w", err)
+	}
+	if !isWithinRoot(realRoot, realTarget) {
+		return "", ErrInvalidPath
+	}
+	return realTarget, nil
+}
+
+// swapDir replaces target with staging, which takes over target's mode and
+// ownership, then removes the old content. target is put back if staging
+// cannot take its place.
+func swapDir(target, staging string) error {
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("stat target: %w", err)
+	}
+	if err := os.Chmod(staging, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("chmod staging dir: %w", err)
+	}
+	if uid, gid, ok := fileOwnerIDs(info); ok {
+		if err := os.Lchown(staging, uid, gid); err != nil {
+			return fmt.Errorf("chown staging dir: %w", err)
+		}
+	}
+	old := staging + ".old"
+	if err := os.Rename(target, old); err != nil {
+		return fmt.Errorf("move old content aside: %w", err)
+	}
+	if err := os.Rename(staging, target); err != nil {
+		if restoreErr := os.Rename(old, target); restoreErr != nil {
+			return fmt.Errorf("swap in new content: %w (old content left in %s: %v)", err, old, restoreErr)
+		}
+		return fmt.Errorf("swap in new content: %w", err)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("remove old content: %w", err)
+	}
+	return nil
+}
+
+func walkArchive(name, format string, fn func(archiveEntry) error) error {
+	switch format {
+	case ArchiveFormatZip:
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			e := archiveEntry{name: f.Name, mode: f.Mode(), size: int64(f.UncompressedSize64), open: f.Open}
+			switch {
+			case e.mode.IsDir():
+				e.kind = archiveDir
+			case e.mode.IsRegular():
+				e.kind = archiveFile
+			case e.mode&fs.ModeSymlink != 0:
+				e.kind = archiveLink
+			default:
+				e.kind = archiveOther
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ArchiveFormatTarGz:
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("open archive: %w", err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+			}
+			e := archiveEntry{name: hdr.Name, mode: hdr.FileInfo().Mode(), size: hdr.Size, open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			}}
+			switch hdr.Typeflag {
+			case tar.TypeReg:
+				e.kind = archiveFile
+			case tar.TypeDir:
+				e.kind = archiveDir
+			case tar.TypeSymlink, tar.TypeLink:
+				e.kind = archiveLink
+			default:
+				e.kind = archiveOther
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported format %q", ErrInvalidArchive, format)
+	}
+}
@@ -0,0 +1,298 @@
+package sites
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+type testArchiveEntry struct {
+	name    string
+	content string
+	link    string
+}
+
+func zipArchive(t *testing.T, files []testArchiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		header := &zip.FileHeader{Name: f.name, Method: zip.Deflate}
+		content := f.content
+		if f.link != "" {
+			header.SetMode(os.ModeSymlink | 0o777)
+			content = f.link
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files []testArchiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0o755, 0
+		}
+		if f.link != "" {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, f.link, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(f.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newArchiveTestService(t *testing.T) (*Service, store.Site) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := filedb.New(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	now := time.Now().UTC()
+	site := store.Site{
+		ID:        "site_archive",
+		Domain:    "example.com",
+		RootPath:  filepath.Join(dir, "www"),
+		Runtime:   "static",
+		Status:    store.SiteStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := repo.CreateSite(context.Background(), site); err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+	return NewService(repo, nil, "", filepath.Join(dir, "uploads"), "", "", true, PortRange{}, nil, nil, nil), site
+}
+
+// stageArchive writes data where UploadSiteArchive would have left it.
+func stageArchive(t *testing.T, s *Service, data []byte) string {
+	t.Helper()
+	if err := os.MkdirAll(s.uploadDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.CreateTemp(s.uploadDir, "archive-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestExtractArchiveFormats(t *testing.T) {
+	files := []testArchiveEntry{
+		{name: "./wordpress/"},
+		{name: "wordpress/index.php", content: "<?php echo 1;"},
+		{name: "wordpress/wp-content/themes/style.css", content: "body{}"},
+		{name: "wordpress/link", link: "/etc/passwd"},
+	}
+	for format, data := range map[string][]byte{
+		ArchiveFormatZip:   zipArchive(t, files),
+		ArchiveFormatTarGz: tarGzArchive(t, files),
+	} {
+		t.Run(format, func(t *testing.T) {
+			svc, site := newArchiveTestService(t)
+			ctx := context.Background()
+			if err := os.MkdirAll(filepath.Join(site.RootPath, "public"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(site.RootPath, "public", "old.html"), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			staged := stageArchive(t, svc, data)
+			if got, err := sniffArchive(staged); err != nil || got != format {
+				t.Fatalf("sniff = %q, %v", got, err)
+			}
+			err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: staged, Format: format, Dir: "public", Wipe: true})
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if got := readFile(t, filepath.Join(site.RootPath, "public", "wordpress", "wp-content", "themes", "style.css")); got != "body{}" {
+				t.Fatalf("style.css = %q", got)
+			}
+			if _, err := os.Stat(filepath.Join(site.RootPath, "public", "old.html")); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("wipe kept old file: %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(site.RootPath, "public", "wordpress", "link")); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("symlink entry was extracted: %v", err)
+			}
+			if _, err := os.Stat(staged); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("staged archive kept: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejectsZipSlipBeforeWipe(t *testing.T) {
+	svc, site := newArchiveTestService(t)
+	ctx := context.Background()
+	if err := os.MkdirAll(site.RootPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	keep := filepath.Join(site.RootPath, "index.html")
+	if err := os.WriteFile(keep, []byte("live"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../evil.txt", "/etc/evil.txt", "a/../../evil.txt", "..\\evil.txt"} {
+		staged := stageArchive(t, svc, zipArchive(t, []testArchiveEntry{{name: "ok.txt", content: "ok"}, {name: name, content: "x"}}))
+		err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: staged, Format: ArchiveFormatZip, Wipe: true})
+		if !errors.Is(err, ErrInvalidArchive) {
+			t.Fatalf("%s: err = %v, want ErrInvalidArchive", name, err)
+		}
+	}
+	if got := readFile(t, keep); got != "live" {
+		t.Fatalf("rejected archive touched the site: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(site.RootPath), "evil.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("zip-slip wrote outside the root: %v", err)
+	}
+}
+
+func TestExtractArchiveFailureKeepsWipedContent(t *testing.T) {
+	svc, site := newArchiveTestService(t)
+	ctx := context.Background()
+	public := filepath.Join(site.RootPath, "public")
+	if err := os.MkdirAll(public, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	keep := filepath.Join(public, "index.html")
+	if err := os.WriteFile(keep, []byte("live"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// "a" is extracted as a file, so "a/b.txt" fails halfway through.
+	staged := stageArchive(t, svc, zipArchive(t, []testArchiveEntry{{name: "a", content: "x"}, {name: "a/b.txt", content: "y"}}))
+	err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: staged, Format: ArchiveFormatZip, Dir: "public", Wipe: true})
+	if !errors.Is(err, ErrInvalidArchive) {
+		t.Fatalf("err = %v, want ErrInvalidArchive", err)
+	}
+	if got := readFile(t, keep); got != "live" {
+		t.Fatalf("failed extraction touched the target: %q", got)
+	}
+	entries, err := os.ReadDir(site.RootPath)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("staging dir left behind: %v %v", entries, err)
+	}
+
+	staged = stageArchive(t, svc, zipArchive(t, []testArchiveEntry{{name: "new.html", content: "new"}}))
+	if err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: staged, Format: ArchiveFormatZip, Dir: "public", Wipe: true}); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	info, err := os.Stat(public)
+	if err != nil || info.Mode().Perm() != 0o750 {
+		t.Fatalf("swapped target lost its mode: %v %v", info, err)
+	}
+	if entries, err := os.ReadDir(public); err != nil || len(entries) != 1 || entries[0].Name() != "new.html" {
+		t.Fatalf("swapped target = %v %v", entries, err)
+	}
+	if entries, err := os.ReadDir(site.RootPath); err != nil || len(entries) != 1 {
+		t.Fatalf("old content left behind: %v %v", entries, err)
+	}
+}
+
+func TestExtractArchiveDoesNotFollowSymlinksOutOfRoot(t *testing.T) {
+	svc, site := newArchiveTestService(t)
+	ctx := context.Background()
+	outside := t.TempDir()
+	if err := os.MkdirAll(site.RootPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(site.RootPath, "uploads")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(site.RootPath, "config.php")); err != nil {
+		t.Fatal(err)
+	}
+
+	staged := stageArchive(t, svc, tarGzArchive(t, []testArchiveEntry{{name: "uploads/pwn.txt", content: "x"}}))
+	if err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: staged, Format: ArchiveFormatTarGz}); err == nil {
+		t.Fatal("expected extraction through an escaping symlink to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwn.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file written outside the root: %v", err)
+	}
+	staged = stageArchive(t, svc, tarGzArchive(t, []testArchiveEntry{{name: "config.php", content: "new"}}))
+	if err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: staged, Format: ArchiveFormatTarGz}); err != nil {
+		t.Fatalf("replace symlinked file: %v", err)
+	}
+	if got := readFile(t, filepath.Join(outside, "secret")); got != "keep" {
+		t.Fatalf("write followed symlink: %q", got)
+	}
+	if got := readFile(t, filepath.Join(site.RootPath, "config.php")); got != "new" {
+		t.Fatalf("config.php = %q", got)
+	}
+
+	if err := svc.extractArchive(ctx, ExtractArchivePayload{SiteID: site.ID, Archive: "/tmp/other.zip", Format: ArchiveFormatZip}); err == nil {
+		t.Fatal("expected archive outside the upload dir to be refused")
+	}
+}
+
+func TestUploadSiteArchiveRejectsUnknownFormat(t *testing.T) {
+	svc, site := newArchiveTestService(t)
+	_, _, _, err := svc.UploadSiteArchive(context.Background(), "usr-1", site.ID, "", false, strings.NewReader("plain text"))
+	if !errors.Is(err, ErrInvalidArchive) {
+		t.Fatalf("err = %v, want ErrInvalidArchive", err)
+	}
+	if _, _, _, err := svc.UploadSiteArchive(context.Background(), "usr-1", site.ID, "../up", false, strings.NewReader("")); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("err = %v, want ErrInvalidPath", err)
+	}
+	entries, _ := os.ReadDir(svc.uploadDir)
+	if len(entries) != 0 {
+		t.Fatalf("rejected upload left %d staged files", len(entries))
+	}
+}
@@ -0,0 +1,14 @@
+package sites
+
+import (
+	"context"
+
+	"nusantara/internal/store"
+)
+
+// SetCustomDirectives validates an nginx snippet for the site and queues a
+// reprovision. The snippet is stored only once nginx -t accepted the vhost,
+// so a rejected snippet never reaches the next provision.
+func (s *Service) SetCustomDirectives(ctx context.Context, actorID, id, directives string) (store.Site, store.Job, error) {
+	return s.UpdateSite(ctx, actorID, id, UpdateSiteInput{CustomDirectives: &directives})
+}
@@ -0,0 +1,126 @@
+package sites
+
+import (
+	"context"
+	"errors"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// VhostManager compares a site's rendered vhost with the files on disk,
+// finds hand-written vhosts to import and maintains or reads the files a
+// vhost references outside the provisioning job.
+type VhostManager interface {
+	CheckDrift(ctx context.Context, site store.Site) (provision.SiteDrift, error)
+	PreviewSite(ctx context.Context, site store.Site, validate bool) (provision.ConfigPreview, error)
+	ScanVhosts() ([]provision.VhostCandidate, error)
+	CheckAdoptable(site store.Site) error
+	SetAccessUser(site store.Site, username, hash string) error
+	RemoveAccessUser(site store.Site, username string) error
+	TailSiteLog(site store.Site, logType string, lines int) (provision.SiteLog, error)
+	FollowSiteLog(ctx context.Context, site store.Site, logType string, offset int64, emit func(lines []string) error) error
+}
+
+// driftCheckable reports whether the panel owns the site's files in its
+// current state. Sites that are being provisioned or deleted, and imported
+// sites not adopted yet, are skipped.
+func driftCheckable(site store.Site) bool {
+	if site.Unmanaged {
+		return false
+	}
+	switch site.Status {
+	case store.SiteStatusActive, store.SiteStatusMaintenance, store.SiteStatusSuspended, store.SiteStatusFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// SiteDrift checks one site.
+func (s *Service) SiteDrift(ctx context.Context, id string) (provision.SiteDrift, error) {
+	if s.vhosts == nil {
+		return provision.SiteDrift{}, provision.ErrApplyDisabled
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return provision.SiteDrift{}, err
+	}
+	return s.vhosts.CheckDrift(ctx, site)
+}
+
+// Drift checks every site the panel manages. A site that cannot be checked
+// is reported with its error instead of failing the whole report.
+func (s *Service) Drift(ctx context.Context) ([]provision.SiteDrift, error) {
+	if s.vhosts == nil {
+		return nil, provision.ErrApplyDisabled
+	}
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]provision.SiteDrift, 0, len(sites))
+	for _, site := range sites {
+		if !driftCheckable(site) {
+			continue
+		}
+		report, err := s.vhosts.CheckDrift(ctx, site)
+		if errors.Is(err, provision.ErrApplyDisabled) {
+			return nil, err
+		}
+		if err != nil {
+			report = provision.SiteDrift{SiteID: site.ID, Domain: site.Domain, Status: site.Status, Error: err.Error()}
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// PreviewConfig renders the site's vhost and diffs it against the file on
+// disk, optionally validating it with the nginx test command.
+func (s *Service) PreviewConfig(ctx context.Context, id string, validate bool) (provision.ConfigPreview, error) {
+	if s.vhosts == nil {
+		return provision.ConfigPreview{}, provision.ErrApplyDisabled
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return provision.ConfigPreview{}, err
+	}
+	return s.vhosts.PreviewSite(ctx, site, validate)
+}
+
+// Reconcile enqueues a sites_reconcile job for the drifted sites, or for the
+// given site only when id is set. The returned job is empty when nothing
+// drifted.
+func (s *Service) Reconcile(ctx context.Context, actorID, id string) ([]provision.SiteDrift, store.Job, error) {
+	var reports []provision.SiteDrift
+	if id != "" {
+		report, err := s.SiteDrift(ctx, id)
+		if err != nil {
+			return nil, store.Job{}, err
+		}
+		reports = []provision.SiteDrift{report}
+	} else {
+		all, err := s.Drift(ctx)
+		if err != nil {
+			return nil, store.Job{}, err
+		}
+		reports = all
+	}
+
+	var ids []string
+	for _, report := range reports {
+		if report.Drifted {
+			ids = append(ids, report.SiteID)
+		}
+	}
+	if len(ids) == 0 {
+		return reports, store.Job{}, nil
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeReconcileSites, jobs.ReconcilePayload{SiteIDs: ids})
+	if err != nil {
+		return nil, store.Job{}, err
+	}
+	return reports, job, nil
+}
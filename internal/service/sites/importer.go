@@ -0,0 +1,227 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidImport  = errors.New("invalid import request")
+	ErrImportNotFound = errors.New("vhost not found in sites-available")
+)
+
+const maxImportItems = 100
+
+// ImportCandidate is a scanned vhost; SiteID is set when one of its names
+// already belongs to a site.
+type ImportCandidate struct {
+	provision.VhostCandidate
+	SiteID string `json:"site_id,omitempty"`
+}
+
+// ImportInput selects a scanned vhost by file and domain. The other fields
+// override the scanner's guesses.
+type ImportInput struct {
+	File         string
+	Domain       string
+	Runtime      string
+	RootPath     string
+	UpstreamHost string
+	UpstreamPort int
+	PHPVersion   string
+}
+
+type ImportResult struct {
+	File   string      `json:"file"`
+	Domain string      `json:"domain"`
+	Site   *store.Site `json:"site,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// ImportCandidates scans sites-available for vhosts that could be imported.
+func (s *Service) ImportCandidates(ctx context.Context) ([]ImportCandidate, error) {
+	if s.vhosts == nil {
+		return nil, provision.ErrApplyDisabled
+	}
+	scanned, err := s.vhosts.ScanVhosts()
+	if err != nil {
+		return nil, err
+	}
+	owners, err := s.hostnameOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ImportCandidate, 0, len(scanned))
+	for _, candidate := range scanned {
+		item := ImportCandidate{VhostCandidate: candidate}
+		for _, name := range append([]string{candidate.Domain}, candidate.Aliases...) {
+			if id, ok := owners[name]; ok {
+				item.SiteID = id
+				break
+			}
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+// ImportSites records the selected vhosts as unmanaged sites. Their files
+// are not touched; the panel only renders them after Adopt. Each item is
+// imported on its own, so one bad item does not block the rest.
+func (s *Service) ImportSites(ctx context.Context, actorID string, inputs []ImportInput) ([]ImportResult, error) {
+	if len(inputs) == 0 || len(inputs) > maxImportItems {
+		return nil, fmt.Errorf("%w: between 1 and %d items", ErrInvalidImport, maxImportItems)
+	}
+	if s.vhosts == nil {
+		return nil, provision.ErrApplyDisabled
+	}
+	scanned, err := s.vhosts.ScanVhosts()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ImportResult, 0, len(inputs))
+	for _, input := range inputs {
+		result := ImportResult{File: input.File, Domain: normalizeDomain(input.Domain)}
+		site, err := s.importSite(ctx, actorID, scanned, input)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Site = &site
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *Service) importSite(ctx context.Context, actorID string, scanned []provision.VhostCandidate, input ImportInput) (store.Site, error) {
+	domain := normalizeDomain(input.Domain)
+	var candidate *provision.VhostCandidate
+	for i := range scanned {
+		if scanned[i].File == input.File && scanned[i].Domain == domain {
+			candidate = &scanned[i]
+			break
+		}
+	}
+	if candidate == nil {
+		return store.Site{}, ErrImportNotFound
+	}
+
+	create := CreateSiteInput{
+		Domain:       candidate.Domain,
+		RootPath:     firstNonEmpty(input.RootPath, candidate.RootPath),
+		Runtime:      firstNonEmpty(input.Runtime, candidate.Runtime),
+		UpstreamHost: firstNonEmpty(input.UpstreamHost, candidate.UpstreamHost),
+		UpstreamPort: candidate.UpstreamPort,
+		PHPVersion:   firstNonEmpty(input.PHPVersion, candidate.PHPVersion),
+		Aliases:      candidate.Aliases,
+	}
+	if input.UpstreamPort != 0 {
+		create.UpstreamPort = input.UpstreamPort
+	}
+	runtime := strings.ToLower(strings.TrimSpace(create.Runtime))
+	if _, proxied := upstreamRuntimes[runtime]; proxied && create.UpstreamPort == 0 {
+		// The app already listens somewhere; allocating a new port would
+		// point the vhost at nothing once it is adopted.
+		return store.Site{}, fmt.Errorf("%w: upstream_port is required for imported %s sites", ErrInvalidUpstream, runtime)
+	}
+	if _, proxied := upstreamRuntimes[runtime]; !proxied {
+		create.UpstreamHost = ""
+	}
+	if runtime != "php" {
+		create.PHPVersion = ""
+	}
+	if runtime == "proxy" {
+		create.Proxy = &store.SiteProxy{
+			Targets:      []store.ProxyTarget{{URL: candidate.ProxyPass}},
+			PreserveHost: true,
+		}
+	}
+
+	site, err := newSite(actorID, create)
+	if err != nil {
+		return store.Site{}, err
+	}
+	site.Unmanaged = true
+	site.ImportedFrom = candidate.File
+	site.Status = store.SiteStatusActive
+	if candidate.CertPath != "" && candidate.KeyPath != "" {
+		site.TLS = &store.SiteTLS{
+			CertPath:  candidate.CertPath,
+			KeyPath:   candidate.KeyPath,
+			EnabledAt: time.Now().UTC(),
+		}
+	}
+	return s.createSiteRecord(ctx, site)
+}
+
+// Adopt hands an imported site over to panel-managed rendering. The
+// provision job replaces the hand-written vhost and disables the original
+// file; if nginx rejects the result the original stays in use. A file that
+// also serves other sites is refused.
+func (s *Service) Adopt(ctx context.Context, actorID, id string) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if !site.Unmanaged {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: site is already managed", ErrSiteState)
+	}
+	// Adopting hands the root to the site user, so it must be one a new
+	// site could use.
+	if err := s.checkRootPath(ctx, site.ID, site.RootPath); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if s.vhosts != nil {
+		if err := s.vhosts.CheckAdoptable(site); err != nil {
+			return store.Site{}, store.Job{}, fmt.Errorf("%w: %w", ErrSiteState, err)
+		}
+	}
+
+	if err := s.repo.UpdateSiteUnmanaged(ctx, site.ID, false); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusProvisioning); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, provisionPayload(site))
+	if err != nil {
+		_ = s.repo.UpdateSiteUnmanaged(ctx, site.ID, true)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, site.Status)
+		return store.Site{}, store.Job{}, err
+	}
+	site, err = s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+func (s *Service) hostnameOwners(ctx context.Context) (map[string]string, error) {
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string, len(sites))
+	for _, site := range sites {
+		for _, name := range site.Hostnames() {
+			owners[name] = site.ID
+		}
+	}
+	return owners, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
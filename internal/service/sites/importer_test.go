@@ -0,0 +1,103 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+type fakeVhosts struct {
+	candidates []provision.VhostCandidate
+	// users records htpasswd writes by user name.
+	users map[string]string
+}
+
+func (f fakeVhosts) CheckDrift(context.Context, store.Site) (provision.SiteDrift, error) {
+	return provision.SiteDrift{}, nil
+}
+
+func (f fakeVhosts) PreviewSite(context.Context, store.Site, bool) (provision.ConfigPreview, error) {
+	return provision.ConfigPreview{}, nil
+}
+
+func (f fakeVhosts) ScanVhosts() ([]provision.VhostCandidate, error) {
+	return f.candidates, nil
+}
+
+func (f fakeVhosts) CheckAdoptable(store.Site) error {
+	return nil
+}
+
+func (f fakeVhosts) SetAccessUser(_ store.Site, username, hash string) error {
+	f.users[username] = hash
+	return nil
+}
+
+func (f fakeVhosts) RemoveAccessUser(_ store.Site, username string) error {
+	delete(f.users, username)
+	return nil
+}
+
+func (f fakeVhosts) TailSiteLog(store.Site, string, int) (provision.SiteLog, error) {
+	return provision.SiteLog{}, nil
+}
+
+func (f fakeVhosts) FollowSiteLog(context.Context, store.Site, string, int64, func([]string) error) error {
+	return nil
+}
+
+func TestImportSites(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	svc := NewService(repo, nil, "", "", "", "", false, PortRange{Min: 3000, Max: 3001}, nil, fakeVhosts{candidates: []provision.VhostCandidate{
+		{
+			File: "/etc/nginx/sites-available/shop", Domain: "shop.example.com", Aliases: []string{"www.shop.example.com"},
+			RootPath: "/srv/shop", Runtime: "php", PHPVersion: "8.1",
+			CertPath: "/certs/fullchain.pem", KeyPath: "/certs/privkey.pem",
+		},
+		{File: "/etc/nginx/sites-available/api", Domain: "api.example.com", RootPath: "/srv/api", Runtime: "node"},
+	}}, nil)
+	ctx := context.Background()
+
+	results, err := svc.ImportSites(ctx, "usr-1", []ImportInput{
+		{File: "/etc/nginx/sites-available/shop", Domain: "shop.example.com"},
+		{File: "/etc/nginx/sites-available/api", Domain: "api.example.com"},
+		{File: "/etc/nginx/sites-available/missing", Domain: "missing.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	shop := results[0].Site
+	if shop == nil || !shop.Unmanaged || shop.ImportedFrom != "/etc/nginx/sites-available/shop" || shop.Status != store.SiteStatusActive {
+		t.Fatalf("unexpected imported site: %+v (%s)", shop, results[0].Error)
+	}
+	if shop.PHPVersion != "8.1" || shop.TLS == nil || len(shop.Aliases) != 1 {
+		t.Fatalf("scanned settings not kept: %+v", shop)
+	}
+	if results[1].Site != nil || results[1].Error == "" {
+		t.Fatalf("node import without upstream port must fail: %+v", results[1])
+	}
+	if results[2].Error != ErrImportNotFound.Error() {
+		t.Fatalf("expected not found, got %+v", results[2])
+	}
+
+	candidates, err := svc.ImportCandidates(ctx)
+	if err != nil {
+		t.Fatalf("candidates: %v", err)
+	}
+	if candidates[0].SiteID != shop.ID || candidates[1].SiteID != "" {
+		t.Fatalf("imported vhost must be linked to its site: %+v", candidates)
+	}
+
+	if _, err := svc.ImportSites(ctx, "usr-1", nil); !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("expected empty import to be rejected, got %v", err)
+	}
+}
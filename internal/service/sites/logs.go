@@ -0,0 +1,43 @@
+package sites
+
+import (
+	"context"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// SiteLog returns the last lines of the site's access or error log.
+func (s *Service) SiteLog(ctx context.Context, id, logType string, lines int) (provision.SiteLog, error) {
+	site, err := s.logSite(ctx, id)
+	if err != nil {
+		return provision.SiteLog{}, err
+	}
+	return s.vhosts.TailSiteLog(site, logType, lines)
+}
+
+// FollowSiteLog streams lines appended to the site's log after offset
+// until ctx is done.
+func (s *Service) FollowSiteLog(ctx context.Context, id, logType string, offset int64, emit func(lines []string) error) error {
+	site, err := s.logSite(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.vhosts.FollowSiteLog(ctx, site, logType, offset, emit)
+}
+
+// logSite loads a site whose logs the panel configured. Imported sites log
+// wherever their own vhost says until they are adopted.
+func (s *Service) logSite(ctx context.Context, id string) (store.Site, error) {
+	if s.vhosts == nil {
+		return store.Site{}, provision.ErrApplyDisabled
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, provision.ErrSiteUnmanaged
+	}
+	return site, nil
+}
@@ -0,0 +1,41 @@
+package sites
+
+import (
+	"context"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// SetPolicy replaces the site's rate limits and caching settings and
+// reprovisions it. A nil policy removes them from the vhost.
+func (s *Service) SetPolicy(ctx context.Context, actorID, id string, policy *store.SitePolicy) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, store.Job{}, provision.ErrSiteUnmanaged
+	}
+	if err := provision.ValidatePolicy(site.Runtime, policy); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+
+	if err := s.repo.UpdateSitePolicy(ctx, site.ID, policy); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusProvisioning); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, provisionPayload(site))
+	if err != nil {
+		_ = s.repo.UpdateSitePolicy(ctx, site.ID, site.Policy)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, site.Status)
+		return store.Site{}, store.Job{}, err
+	}
+	site, err = s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
@@ -0,0 +1,25 @@
+package sites
+
+import (
+	"context"
+	"fmt"
+
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// SetProxy replaces the upstream settings of a proxy site and queues a
+// reprovision. The settings are stored once the new vhost is live.
+func (s *Service) SetProxy(ctx context.Context, actorID, id string, proxy store.SiteProxy) (store.Site, store.Job, error) {
+	if err := provision.ValidateProxy(&proxy); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Runtime != "proxy" {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: site runtime is %s", provision.ErrInvalidProxy, site.Runtime)
+	}
+	return s.UpdateSite(ctx, actorID, site.ID, UpdateSiteInput{Proxy: &proxy})
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -17,20 +18,26 @@ import (
 
 	"nusantara/internal/idgen"
 	"nusantara/internal/jobs"
+	"nusantara/internal/php"
+	"nusantara/internal/provision"
+	sslsvc "nusantara/internal/ssl"
 	"nusantara/internal/store"
 )
 
 var (
-	ErrInvalidDomain  = errors.New("invalid domain")
-	ErrInvalidRoot    = errors.New("invalid root_path")
-	ErrInvalidRuntime = errors.New("invalid runtime")
-	ErrInvalidFile    = errors.New("invalid file")
-	ErrContentTooLong = errors.New("content too long")
-	ErrInvalidPath    = errors.New("invalid path")
-	ErrInvalidBase64  = errors.New("invalid base64 content")
-	ErrFileTooLarge   = errors.New("file too large")
-	ErrNotDirectory   = errors.New("path is not a directory")
-	ErrDirNotEmpty    = errors.New("directory is not empty")
+	ErrInvalidDomain   = errors.New("invalid domain")
+	ErrInvalidRoot     = errors.New("invalid root_path")
+	ErrInvalidRuntime  = errors.New("invalid runtime")
+	ErrInvalidFile     = errors.New("invalid file")
+	ErrContentTooLong  = errors.New("content too long")
+	ErrInvalidPath     = errors.New("invalid path")
+	ErrInvalidBase64   = errors.New("invalid base64 content")
+	ErrFileTooLarge    = errors.New("file too large")
+	ErrNotDirectory    = errors.New("path is not a directory")
+	ErrDirNotEmpty     = errors.New("directory is not empty")
+	ErrInvalidUpstream = errors.New("invalid upstream")
+	ErrNoUpstreamPort  = errors.New("no free upstream port in range")
+	ErrInvalidPHP      = errors.New("invalid php_version")
 )
 
 var domainRegex = regexp.MustCompile(`^[a-zA-Z0-9.-]+$`)
@@ -40,8 +47,17 @@ var allowedRuntime = map[string]struct{}{
 	"node":   {},
 	"python": {},
 	"static": {},
+	"proxy":  {},
 }
 
+// upstreamRuntimes are proxied to a local app server and need an upstream.
+var upstreamRuntimes = map[string]struct{}{
+	"node":   {},
+	"python": {},
+}
+
+const defaultUpstreamHost = "127.0.0.1"
+
 var editableFiles = map[string]struct{}{
 	"index.html": {},
 	"index.htm":  {},
@@ -60,74 +76,341 @@ type SiteFileEntry struct {
 }
 
 type Service struct {
-	repo      store.Repository
-	jobSvc    *jobs.Service
-	backupDir string
-	apply     bool
+	repo          store.Repository
+	jobSvc        *jobs.Service
+	backupDir     string
+	uploadDir     string
+	rootBase      string
+	webServer     string
+	apply         bool
+	upstreamPorts PortRange
+	php           *php.Detector
+	vhosts        VhostManager
+	apps          AppController
+}
+
+// PortRange bounds auto-allocated upstream ports for node and python sites.
+type PortRange struct {
+	Min int
+	Max int
 }
 
 type CreateSiteInput struct {
 	Domain   string
 	RootPath string
 	Runtime  string
+	// UpstreamHost and UpstreamPort are optional for node and python; the
+	// port is allocated from the configured range when omitted.
+	UpstreamHost string
+	UpstreamPort int
+	// PHPVersion is optional for php sites, e.g. "8.3".
+	PHPVersion string
+	// Aliases are extra host names; CanonicalRedirect is "", "apex" or "www".
+	Aliases           []string
+	CanonicalRedirect string
+	// Proxy is required for the proxy runtime and rejected otherwise.
+	Proxy *store.SiteProxy
+	// Policy overrides the runtime's default rate limits and caching.
+	Policy *store.SitePolicy
+	// App optionally runs a node or python site as a systemd service.
+	App *store.SiteApp
 }
 
-func NewService(repo store.Repository, jobSvc *jobs.Service, backupDir string, apply bool) *Service {
+func NewService(repo store.Repository, jobSvc *jobs.Service, backupDir, uploadDir, rootBase, webServer string, apply bool, upstreamPorts PortRange, phpDetector *php.Detector, vhosts VhostManager, apps AppController) *Service {
 	return &Service{
-		repo:      repo,
-		jobSvc:    jobSvc,
-		backupDir: backupDir,
-		apply:     apply,
+		repo:          repo,
+		jobSvc:        jobSvc,
+		backupDir:     backupDir,
+		uploadDir:     uploadDir,
+		rootBase:      rootBase,
+		webServer:     webServer,
+		apply:         apply,
+		upstreamPorts: upstreamPorts,
+		php:           phpDetector,
+		vhosts:        vhosts,
+		apps:          apps,
 	}
 }
 
 func (s *Service) CreateSite(ctx context.Context, actorID string, input CreateSiteInput) (store.Site, store.Job, error) {
+	if input.Policy == nil {
+		input.Policy = s.defaultPolicy(input.Runtime)
+	}
+	site, err := newSite(actorID, input)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+
+	if err := s.checkRootPath(ctx, "", site.RootPath); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	site, err = s.createSiteRecord(ctx, site)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, provisionPayload(site))
+	if err != nil {
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// defaultPolicy is the runtime's default policy without the limits the
+// configured web server cannot enforce.
+func (s *Service) defaultPolicy(runtime string) *store.SitePolicy {
+	policy, _ := provision.PortablePolicy(s.webServer, provision.DefaultPolicy(strings.ToLower(strings.TrimSpace(runtime))))
+	return policy
+}
+
+func newSite(actorID string, input CreateSiteInput) (store.Site, error) {
 	domain := normalizeDomain(input.Domain)
 	if !isValidDomain(domain) {
-		return store.Site{}, store.Job{}, ErrInvalidDomain
+		return store.Site{}, ErrInvalidDomain
+	}
+
+	if input.Policy == nil {
+		input.Policy = provision.DefaultPolicy(strings.ToLower(strings.TrimSpace(input.Runtime)))
+	}
+	settings, err := siteSettings(store.Site{}, input)
+	if err != nil {
+		return store.Site{}, err
 	}
 
+	settings.Aliases, settings.CanonicalRedirect, err = normalizeHostnames(domain, input.Aliases, input.CanonicalRedirect)
+	if err != nil {
+		return store.Site{}, err
+	}
+
+	siteID, err := idgen.New("site")
+	if err != nil {
+		return store.Site{}, err
+	}
+	now := time.Now().UTC()
+	settings.ID = siteID
+	settings.Domain = domain
+	settings.Status = store.SiteStatusProvisioning
+	settings.CreatedBy = actorID
+	settings.CreatedAt = now
+	settings.UpdatedAt = now
+	return settings, nil
+}
+
+// siteSettings validates the settings CreateSite and UpdateSite share and
+// returns them as a site with only those fields set. A nil policy stays nil.
+// current is the stored site on update, whose id and deploy dir bound the
+// app settings.
+func siteSettings(current store.Site, input CreateSiteInput) (store.Site, error) {
 	rootPath := strings.TrimSpace(input.RootPath)
 	if !isValidRootPath(rootPath) {
-		return store.Site{}, store.Job{}, ErrInvalidRoot
+		return store.Site{}, ErrInvalidRoot
 	}
 
 	runtime := strings.ToLower(strings.TrimSpace(input.Runtime))
 	if _, ok := allowedRuntime[runtime]; !ok {
-		return store.Site{}, store.Job{}, ErrInvalidRuntime
+		return store.Site{}, ErrInvalidRuntime
 	}
 
-	siteID, err := idgen.New("site")
+	upstreamHost := strings.ToLower(strings.TrimSpace(input.UpstreamHost))
+	upstreamPort := input.UpstreamPort
+	if _, ok := upstreamRuntimes[runtime]; ok {
+		if upstreamHost == "" {
+			upstreamHost = defaultUpstreamHost
+		}
+		if !isValidUpstreamHost(upstreamHost) || upstreamPort < 0 || upstreamPort > 65535 {
+			return store.Site{}, ErrInvalidUpstream
+		}
+	} else if upstreamHost != "" || upstreamPort != 0 {
+		return store.Site{}, fmt.Errorf("%w: runtime %s does not use an upstream", ErrInvalidUpstream, runtime)
+	}
+
+	if runtime == "proxy" {
+		if err := provision.ValidateProxy(input.Proxy); err != nil {
+			return store.Site{}, err
+		}
+	} else if input.Proxy != nil {
+		return store.Site{}, fmt.Errorf("%w: runtime %s does not use proxy settings", provision.ErrInvalidProxy, runtime)
+	}
+
+	if err := provision.ValidateApp(store.Site{
+		ID:       current.ID,
+		RootPath: rootPath,
+		Runtime:  runtime,
+		Deploy:   current.Deploy,
+		App:      input.App,
+	}); err != nil {
+		return store.Site{}, err
+	}
+
+	if err := provision.ValidatePolicy(runtime, input.Policy); err != nil {
+		return store.Site{}, err
+	}
+
+	phpVersion := strings.TrimSpace(input.PHPVersion)
+	if phpVersion != "" && (runtime != "php" || !php.ValidVersion(phpVersion)) {
+		return store.Site{}, ErrInvalidPHP
+	}
+
+	return store.Site{
+		RootPath:     rootPath,
+		Runtime:      runtime,
+		UpstreamHost: upstreamHost,
+		UpstreamPort: upstreamPort,
+		PHPVersion:   phpVersion,
+		Proxy:        input.Proxy,
+		Policy:       input.Policy,
+		App:          input.App,
+	}, nil
+}
+
+// createSiteRecord stores site, allocating an upstream port first when the
+// runtime needs one and none was requested. Allocation is retried if another
+// request took the same port in the meantime. A php site without a version
+// is pinned to the newest one installed.
+func (s *Service) createSiteRecord(ctx context.Context, site store.Site) (store.Site, error) {
+	site.PHPVersion = s.pinnedPHPVersion(site)
+	_, proxied := upstreamRuntimes[site.Runtime]
+	auto := proxied && site.UpstreamPort == 0
+	for attempt := 0; ; attempt++ {
+		if auto {
+			port, err := s.allocateUpstreamPort(ctx, site.ID, site.Runtime, site.UpstreamHost)
+			if err != nil {
+				return store.Site{}, err
+			}
+			site.UpstreamPort = port
+		}
+		err := s.repo.CreateSite(ctx, site)
+		if auto && errors.Is(err, store.ErrUpstreamInUse) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return store.Site{}, err
+		}
+		return site, nil
+	}
+}
+
+// allocateUpstreamPort returns the first port in the range that no other
+// site proxies to, counting the implied defaults of legacy sites.
+func (s *Service) allocateUpstreamPort(ctx context.Context, siteID, runtime, host string) (int, error) {
+	if s.upstreamPorts.Min < 1 || s.upstreamPorts.Max < s.upstreamPorts.Min {
+		return 0, ErrNoUpstreamPort
+	}
+	sites, err := s.repo.ListSites(ctx, 0)
 	if err != nil {
-		return store.Site{}, store.Job{}, err
+		return 0, err
 	}
-	now := time.Now().UTC()
-	site := store.Site{
-		ID:        siteID,
-		Domain:    domain,
-		RootPath:  rootPath,
-		Runtime:   runtime,
-		Status:    store.SiteStatusProvisioning,
-		CreatedBy: actorID,
-		CreatedAt: now,
-		UpdatedAt: now,
+	candidate := store.Site{Runtime: runtime, UpstreamHost: host}
+	for port := s.upstreamPorts.Min; port <= s.upstreamPorts.Max; port++ {
+		candidate.UpstreamPort = port
+		taken := false
+		for _, site := range sites {
+			if site.ID != siteID && store.UpstreamConflict(candidate, site) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return port, nil
+		}
 	}
+	return 0, ErrNoUpstreamPort
+}
 
-	if err := s.repo.CreateSite(ctx, site); err != nil {
-		return store.Site{}, store.Job{}, err
+func provisionPayload(site store.Site) jobs.SitePayload {
+	return jobs.SitePayload{
+		SiteID:   site.ID,
+		Domain:   site.Domain,
+		Runtime:  site.Runtime,
+		RootPath: site.RootPath,
+	}
+}
+
+// PHPVersions lists the PHP-FPM versions installed on the host.
+func (s *Service) PHPVersions() ([]php.Version, error) {
+	if s.php == nil {
+		return []php.Version{}, nil
 	}
+	return s.php.Versions()
+}
 
-	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, map[string]string{
-		"site_id":  site.ID,
-		"domain":   site.Domain,
-		"runtime":  site.Runtime,
-		"rootPath": site.RootPath,
-	})
+// SetPHPVersion switches a php site to another installed PHP-FPM version.
+// Like UpdateSite, the version is stored only once the reprovisioned vhost
+// is live, so the returned site still shows the current one.
+func (s *Service) SetPHPVersion(ctx context.Context, actorID, id, version string) (store.Site, store.Job, error) {
+	version = strings.TrimSpace(version)
+	if !php.ValidVersion(version) {
+		return store.Site{}, store.Job{}, ErrInvalidPHP
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
 	if err != nil {
-		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
 		return store.Site{}, store.Job{}, err
 	}
-	return site, job, nil
+	if site.Runtime != "php" {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: site runtime is %s", ErrInvalidPHP, site.Runtime)
+	}
+	return s.UpdateSite(ctx, actorID, site.ID, UpdateSiteInput{PHPVersion: &version})
+}
+
+// BackfillSites fills in settings that older sites left to be worked out on
+// every provision. It runs once at startup.
+func (s *Service) BackfillSites(ctx context.Context) error {
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, site := range sites {
+		if version := s.pinnedPHPVersion(site); version != site.PHPVersion {
+			if err := s.repo.UpdateSitePHPVersion(ctx, site.ID, version); err != nil {
+				return fmt.Errorf("pin php version for %s: %w", site.Domain, err)
+			}
+		}
+		// Suspension used to live in the status only.
+		if site.Status == store.SiteStatusSuspended && !site.Suspended {
+			if err := s.repo.UpdateSiteSuspended(ctx, site.ID, true); err != nil {
+				return fmt.Errorf("record suspension for %s: %w", site.Domain, err)
+			}
+		}
+		// Apache and Caddy used to skip the nginx-only limits; they now
+		// reject them, so policies stored before are cut to what applies.
+		if policy, changed := provision.PortablePolicy(s.webServer, site.Policy); changed {
+			if err := s.repo.UpdateSitePolicy(ctx, site.ID, policy); err != nil {
+				return fmt.Errorf("drop unsupported policy for %s: %w", site.Domain, err)
+			}
+		}
+		if tls := s.installedTLS(site); tls != nil {
+			if err := s.repo.UpdateSiteTLS(ctx, site.ID, tls); err != nil {
+				return fmt.Errorf("record tls for %s: %w", site.Domain, err)
+			}
+		}
+	}
+	return nil
+}
+
+// installedTLS finds the certificate of a site that got HTTPS from
+// `certbot install` before the panel rendered the HTTPS block itself. Without
+// it the next provision would drop HTTPS.
+func (s *Service) installedTLS(site store.Site) *store.SiteTLS {
+	if site.TLS != nil || site.Unmanaged || !s.apply || !sslsvc.CertificateExists(site.Domain) {
+		return nil
+	}
+	certPath, keyPath := sslsvc.CertificatePaths(site.Domain)
+	return &store.SiteTLS{CertPath: certPath, KeyPath: keyPath, EnabledAt: time.Now().UTC()}
+}
+
+// pinnedPHPVersion returns the version a php site should be stored with. An
+// empty version is pinned to the newest one installed, so a later PHP
+// install does not silently upgrade the site.
+func (s *Service) pinnedPHPVersion(site store.Site) string {
+	if site.Runtime != "php" || site.PHPVersion != "" || !s.apply || s.php == nil {
+		return site.PHPVersion
+	}
+	resolved, err := s.php.Resolve("")
+	if err != nil {
+		return ""
+	}
+	return resolved.Version
 }
 
 func (s *Service) ListSites(ctx context.Context, limit int) ([]store.Site, error) {
@@ -146,8 +429,8 @@ func (s *Service) DeleteSite(ctx context.Context, actorID, id string) (store.Job
 	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusDeleting); err != nil {
 		return store.Job{}, err
 	}
-	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeDeprovisionSite, map[string]string{
-		"site_id": site.ID,
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeDeprovisionSite, jobs.SitePayload{
+		SiteID: site.ID,
 	})
 	if err != nil {
 		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
@@ -492,6 +775,16 @@ func normalizeDomain(in string) string {
 	return strings.ToLower(strings.TrimSpace(strings.TrimSuffix(in, ".")))
 }
 
+func isValidUpstreamHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "localhost" {
+		return true
+	}
+	return isValidDomain(host)
+}
+
 func isValidDomain(domain string) bool {
 	if len(domain) < 3 || len(domain) > 253 {
 		return false
@@ -591,6 +884,37 @@ func normalizeRelativePath(raw string, allowEmpty bool) (string, error) {
 	return clean, nil
 }
 
+// checkRootPath keeps a managed root inside the root base and apart from
+// every other site's root and deploy dir, because provisioning hands the
+// whole tree to the site's own user. siteID is the site being changed.
+func (s *Service) checkRootPath(ctx context.Context, siteID, rootPath string) error {
+	if s.rootBase != "" {
+		base := filepath.Clean(s.rootBase)
+		if filepath.Clean(rootPath) == base || !isWithinRoot(base, rootPath) {
+			return fmt.Errorf("%w: must be inside %s", ErrInvalidRoot, base)
+		}
+	}
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, other := range sites {
+		if other.ID == siteID {
+			continue
+		}
+		paths := []string{other.RootPath}
+		if other.Deploy != nil {
+			paths = append(paths, other.Deploy.Path)
+		}
+		for _, p := range paths {
+			if p != "" && (isWithinRoot(p, rootPath) || isWithinRoot(rootPath, p)) {
+				return fmt.Errorf("%w: overlaps %s of site %s", ErrInvalidRoot, p, other.Domain)
+			}
+		}
+	}
+	return nil
+}
+
 func isWithinRoot(rootPath, targetPath string) bool {
 	root := filepath.Clean(rootPath)
 	target := filepath.Clean(targetPath)
@@ -1,11 +1,14 @@
 package sites
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
 )
 
 func TestIsValidDomain(t *testing.T) {
@@ -110,3 +113,42 @@ func TestIsWithinRoot(t *testing.T) {
 		t.Fatalf("expected path outside root to be rejected")
 	}
 }
+
+func TestCheckRootPath(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	svc := NewService(repo, nil, "", "", "/var/www", "", false, PortRange{}, nil, nil, nil)
+	ctx := context.Background()
+
+	deployed := store.Site{ID: "site_app", Domain: "app.example.com", RootPath: "/var/www/app/current/public", Runtime: "php",
+		Deploy: &store.SiteDeploy{Path: "/var/www/app"}}
+	if err := repo.CreateSite(ctx, deployed); err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+
+	for _, root := range []string{"/srv", "/home/ubuntu", "/var/www", "/var/wwwx/a", "/var/www/app", "/var/www/app/shared", "/var/www/app/current/public/sub"} {
+		if err := svc.checkRootPath(ctx, "", root); !errors.Is(err, ErrInvalidRoot) {
+			t.Fatalf("root %s: err = %v, want ErrInvalidRoot", root, err)
+		}
+	}
+	if err := svc.checkRootPath(ctx, "", "/var/www/other"); err != nil {
+		t.Fatalf("separate root: %v", err)
+	}
+	if err := svc.checkRootPath(ctx, deployed.ID, "/var/www/app/current"); err != nil {
+		t.Fatalf("own deploy dir: %v", err)
+	}
+}
+
+func TestDefaultPolicyFollowsWebServer(t *testing.T) {
+	nginx := NewService(nil, nil, "", "", "", "", false, PortRange{}, nil, nil, nil)
+	if policy := nginx.defaultPolicy("php"); policy.RateLimit == 0 {
+		t.Fatalf("nginx default policy should rate limit: %+v", policy)
+	}
+	caddy := NewService(nil, nil, "", "", "", "caddy", false, PortRange{}, nil, nil, nil)
+	if policy := caddy.defaultPolicy("php"); policy.RateLimit != 0 || policy.RateBurst != 0 || !policy.Gzip {
+		t.Fatalf("caddy default policy = %+v", policy)
+	}
+}
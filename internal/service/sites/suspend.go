@@ -0,0 +1,112 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+var (
+	ErrInvalidMaintenance = errors.New("invalid maintenance settings")
+	ErrSiteState          = errors.New("site state does not allow this action")
+)
+
+const (
+	maxMaintenanceAllowIPs  = 50
+	maxMaintenancePageBytes = 64 * 1024
+)
+
+// Suspend takes the site offline without removing its files or metadata.
+func (s *Service) Suspend(ctx context.Context, actorID, id string) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Suspended {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: site is already suspended", ErrSiteState)
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeSuspendSite, jobs.SitePayload{SiteID: site.ID, Domain: site.Domain})
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// Resume brings a suspended site, or one in maintenance, back online.
+func (s *Service) Resume(ctx context.Context, actorID, id string) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if !site.Suspended && site.Maintenance == nil {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: site is neither suspended nor in maintenance", ErrSiteState)
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeResumeSite, jobs.SitePayload{SiteID: site.ID, Domain: site.Domain})
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// EnableMaintenance serves a 503 maintenance page to everyone except the
+// allowed addresses. Calling it again replaces the allowlist and page.
+func (s *Service) EnableMaintenance(ctx context.Context, actorID, id string, allowIPs []string, page string) (store.Site, store.Job, error) {
+	allowIPs, err := normalizeAllowIPs(allowIPs)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if len(page) > maxMaintenancePageBytes {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: page larger than %d bytes", ErrInvalidMaintenance, maxMaintenancePageBytes)
+	}
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Suspended {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: resume the site first", ErrSiteState)
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeMaintenanceSite, jobs.SiteMaintenancePayload{
+		SiteID:   site.ID,
+		AllowIPs: allowIPs,
+		Page:     page,
+	})
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+func normalizeAllowIPs(raw []string) ([]string, error) {
+	if len(raw) > maxMaintenanceAllowIPs {
+		return nil, fmt.Errorf("%w: at most %d allow_ips", ErrInvalidMaintenance, maxMaintenanceAllowIPs)
+	}
+	return normalizeAddresses(raw, ErrInvalidMaintenance)
+}
+
+// normalizeAddresses canonicalises IPs and CIDRs and drops duplicates.
+// Invalid entries are reported wrapped in kind.
+func normalizeAddresses(raw []string, kind error) ([]string, error) {
+	out := make([]string, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, entry := range raw {
+		entry = strings.TrimSpace(entry)
+		if ip := net.ParseIP(entry); ip != nil {
+			entry = ip.String()
+		} else if _, network, err := net.ParseCIDR(entry); err == nil {
+			entry = network.String()
+		} else {
+			return nil, fmt.Errorf("%w: %q is not an IP or CIDR", kind, entry)
+		}
+		if _, dup := seen[entry]; dup {
+			continue
+		}
+		seen[entry] = struct{}{}
+		out = append(out, entry)
+	}
+	return out, nil
+}
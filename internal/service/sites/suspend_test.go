@@ -0,0 +1,22 @@
+package sites
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAllowIPs(t *testing.T) {
+	got, err := normalizeAllowIPs([]string{" 203.0.113.7 ", "10.1.2.3/8", "203.0.113.7", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	want := []string{"203.0.113.7", "10.0.0.0/8", "2001:db8::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("allow ips = %v, want %v", got, want)
+	}
+
+	if _, err := normalizeAllowIPs([]string{"10.0.0.0/8; deny all"}); !errors.Is(err, ErrInvalidMaintenance) {
+		t.Fatalf("expected invalid entry to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package sites
+
+import (
+	"context"
+	"strings"
+
+	"nusantara/internal/jobs"
+	sslsvc "nusantara/internal/ssl"
+	"nusantara/internal/store"
+)
+
+const stepEnableTLS = "enable_tls"
+
+// tlsSteps issues the certificate for every host name of the site and then
+// switches the vhost to HTTPS. The first step runs after dependsOn.
+func tlsSteps(site store.Site, email string, hsts bool, dependsOn ...string) ([]jobs.WorkflowStep, error) {
+	issue := sslsvc.IssuePayload{Domain: site.Domain, Aliases: site.Aliases, Email: strings.TrimSpace(email), Webroot: site.RootPath}
+	if err := issue.Validate(); err != nil {
+		return nil, err
+	}
+	certPath, keyPath := sslsvc.CertificatePaths(site.Domain)
+	return []jobs.WorkflowStep{
+		{
+			Name:      stepIssueSSL,
+			JobType:   store.JobTypeIssueSSL,
+			Payload:   issue,
+			DependsOn: dependsOn,
+		},
+		{
+			Name:    stepEnableTLS,
+			JobType: store.JobTypeEnableSiteTLS,
+			Payload: jobs.SiteTLSPayload{
+				SiteID:   site.ID,
+				Email:    issue.Email,
+				CertPath: certPath,
+				KeyPath:  keyPath,
+				HSTS:     hsts,
+			},
+			DependsOn: []string{stepIssueSSL},
+		},
+	}, nil
+}
+
+// EnableTLS issues a certificate for the site's host names and renders the
+// HTTPS server block once it exists.
+func (s *Service) EnableTLS(ctx context.Context, actorID, id, email string, hsts bool) (store.Site, jobs.Workflow, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	steps, err := tlsSteps(site, email, hsts)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	workflow, err := s.jobSvc.EnqueueWorkflow(ctx, actorID, "site_tls:"+site.Domain, steps)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	return site, workflow, nil
+}
+
+// DisableTLS drops the TLS state and reprovisions the site as plain HTTP.
+// The certificate itself is kept.
+func (s *Service) DisableTLS(ctx context.Context, actorID, id string) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.repo.UpdateSiteTLS(ctx, site.ID, nil); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if err := s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusProvisioning); err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeProvisionSite, provisionPayload(site))
+	if err != nil {
+		_ = s.repo.UpdateSiteTLS(ctx, site.ID, site.TLS)
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, site.Status)
+		return store.Site{}, store.Job{}, err
+	}
+	site, err = s.repo.GetSiteByID(ctx, site.ID)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// SiteByDomain finds the site whose primary domain is domain.
+func (s *Service) SiteByDomain(ctx context.Context, domain string) (store.Site, bool, error) {
+	domain = normalizeDomain(domain)
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return store.Site{}, false, err
+	}
+	for _, site := range sites {
+		if site.Domain == domain {
+			return site, true, nil
+		}
+	}
+	return store.Site{}, false, nil
+}
@@ -0,0 +1,156 @@
+package sites
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+)
+
+// UpdateSiteInput changes a site's settings. Nil fields keep their current
+// value. Changing the runtime drops the settings that belonged to the old
+// one: the upstream, PHP version, proxy and app, and the policy is reset to
+// the new runtime's default unless given.
+type UpdateSiteInput struct {
+	RootPath     *string
+	Runtime      *string
+	UpstreamHost *string
+	UpstreamPort *int
+	PHPVersion   *string
+	Proxy        *store.SiteProxy
+	Policy       *store.SitePolicy
+	App          *store.SiteApp
+	// CustomDirectives is kept across runtime changes.
+	CustomDirectives *string
+}
+
+// UpdateSite validates the new settings like CreateSite and queues a
+// reprovision. The settings are stored once the new vhost is live, so the
+// returned site still shows the current ones. Files are not moved when the
+// root path changes.
+func (s *Service) UpdateSite(ctx context.Context, actorID, id string, input UpdateSiteInput) (store.Site, store.Job, error) {
+	site, err := s.repo.GetSiteByID(ctx, id)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if site.Unmanaged {
+		return store.Site{}, store.Job{}, provision.ErrSiteUnmanaged
+	}
+	if site.Suspended || site.Status == store.SiteStatusDeleting {
+		return store.Site{}, store.Job{}, fmt.Errorf("%w: site is %s", ErrSiteState, site.Status)
+	}
+
+	merged := CreateSiteInput{
+		RootPath:     site.RootPath,
+		Runtime:      site.Runtime,
+		UpstreamHost: site.UpstreamHost,
+		UpstreamPort: site.UpstreamPort,
+		PHPVersion:   site.PHPVersion,
+		Proxy:        site.Proxy,
+		Policy:       site.Policy,
+		App:          site.App,
+	}
+	if input.Runtime != nil {
+		runtime := strings.ToLower(strings.TrimSpace(*input.Runtime))
+		if runtime != site.Runtime {
+			merged = CreateSiteInput{
+				RootPath: site.RootPath,
+				Runtime:  runtime,
+				Policy:   s.defaultPolicy(runtime),
+			}
+		}
+	}
+	if input.RootPath != nil {
+		merged.RootPath = *input.RootPath
+	}
+	if input.UpstreamHost != nil {
+		merged.UpstreamHost = *input.UpstreamHost
+	}
+	if input.UpstreamPort != nil {
+		merged.UpstreamPort = *input.UpstreamPort
+	}
+	if input.PHPVersion != nil {
+		merged.PHPVersion = *input.PHPVersion
+	}
+	if input.Proxy != nil {
+		merged.Proxy = input.Proxy
+	}
+	if input.Policy != nil {
+		merged.Policy = input.Policy
+	}
+	if input.App != nil {
+		merged.App = input.App
+	} else if merged.App != nil && merged.App.User != "" {
+		// Apps always run as the site user now; drop users stored earlier.
+		app := *merged.App
+		app.User = ""
+		merged.App = &app
+	}
+	directives := site.CustomDirectives
+	if input.CustomDirectives != nil {
+		directives = strings.TrimSpace(*input.CustomDirectives)
+		if err := provision.ValidateCustomDirectives(directives); err != nil {
+			return store.Site{}, store.Job{}, err
+		}
+	}
+
+	settings, err := siteSettings(site, merged)
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	if settings.RootPath != site.RootPath {
+		if err := s.checkRootPath(ctx, site.ID, settings.RootPath); err != nil {
+			return store.Site{}, store.Job{}, err
+		}
+	}
+	if settings.PHPVersion != "" && s.apply && s.php != nil {
+		if _, err := s.php.Resolve(settings.PHPVersion); err != nil {
+			return store.Site{}, store.Job{}, err
+		}
+	}
+	if _, proxied := upstreamRuntimes[settings.Runtime]; proxied {
+		if settings.UpstreamPort == 0 {
+			if settings.UpstreamPort, err = s.allocateUpstreamPort(ctx, site.ID, settings.Runtime, settings.UpstreamHost); err != nil {
+				return store.Site{}, store.Job{}, err
+			}
+		} else if err := s.checkUpstreamFree(ctx, site.ID, settings); err != nil {
+			return store.Site{}, store.Job{}, err
+		}
+	}
+
+	job, err := s.jobSvc.Enqueue(ctx, actorID, store.JobTypeReprovisionSite, jobs.SiteUpdatePayload{
+		SiteID:       site.ID,
+		RootPath:     settings.RootPath,
+		Runtime:      settings.Runtime,
+		UpstreamHost: settings.UpstreamHost,
+		UpstreamPort: settings.UpstreamPort,
+		PHPVersion:   settings.PHPVersion,
+		Proxy:        settings.Proxy,
+		Policy:       settings.Policy,
+		App:          settings.App,
+
+		CustomDirectives: directives,
+	})
+	if err != nil {
+		return store.Site{}, store.Job{}, err
+	}
+	return site, job, nil
+}
+
+// checkUpstreamFree reports early what the repository would reject when the
+// job stores the settings.
+func (s *Service) checkUpstreamFree(ctx context.Context, siteID string, settings store.Site) error {
+	sites, err := s.repo.ListSites(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, other := range sites {
+		if other.ID != siteID && store.UpstreamConflict(settings, other) {
+			return store.ErrUpstreamInUse
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package sites
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/php"
+	"nusantara/internal/provision"
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func TestUpdateSite(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	ctx := context.Background()
+
+	jobSvc := jobs.NewService(repo, log.New(io.Discard, "", 0), nil, nil)
+	jobSvc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = jobSvc.Stop(stopCtx)
+	}()
+	svc := NewService(repo, jobSvc, "", "", "", "", false, PortRange{Min: 3000, Max: 3001}, nil, nil, nil)
+
+	create := func(input CreateSiteInput) store.Site {
+		site, err := newSite("usr-1", input)
+		if err != nil {
+			t.Fatalf("new site %s: %v", input.Domain, err)
+		}
+		if site, err = svc.createSiteRecord(ctx, site); err != nil {
+			t.Fatalf("create site %s: %v", input.Domain, err)
+		}
+		return site
+	}
+	phpSite := create(CreateSiteInput{Domain: "php.example.com", RootPath: "/var/www/php", Runtime: "php", PHPVersion: "8.2"})
+	create(CreateSiteInput{Domain: "node.example.com", RootPath: "/var/www/node", Runtime: "node"})
+	proxySite := create(CreateSiteInput{Domain: "proxy.example.com", RootPath: "/var/www/proxy", Runtime: "proxy",
+		Proxy: &store.SiteProxy{Targets: []store.ProxyTarget{{URL: "http://10.0.0.5:8080"}}}})
+
+	payload := func(job store.Job) jobs.SiteUpdatePayload {
+		t.Helper()
+		if job.Type != store.JobTypeReprovisionSite {
+			t.Fatalf("job type = %s", job.Type)
+		}
+		var p jobs.SiteUpdatePayload
+		if err := json.Unmarshal([]byte(job.Payload), &p); err != nil {
+			t.Fatalf("decode payload: %v", err)
+		}
+		return p
+	}
+	str := func(v string) *string { return &v }
+
+	site, job, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{RootPath: str("/srv/php")})
+	if err != nil {
+		t.Fatalf("update root: %v", err)
+	}
+	if site.RootPath != "/var/www/php" {
+		t.Fatalf("settings must be stored by the job, got root %s", site.RootPath)
+	}
+	if p := payload(job); p.RootPath != "/srv/php" || p.Runtime != "php" || p.PHPVersion != "8.2" || p.Policy == nil {
+		t.Fatalf("unchanged settings not kept: %+v", p)
+	}
+
+	_, job, err = svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{Runtime: str("Node")})
+	if err != nil {
+		t.Fatalf("switch runtime: %v", err)
+	}
+	p := payload(job)
+	if p.Runtime != "node" || p.PHPVersion != "" || p.UpstreamHost != "127.0.0.1" || p.UpstreamPort != 3001 {
+		t.Fatalf("runtime switch payload = %+v", p)
+	}
+	if p.Policy == nil || p.Policy.FastCGICache != 0 {
+		t.Fatalf("policy not reset to the node default: %+v", p.Policy)
+	}
+
+	site, job, err = svc.SetCustomDirectives(ctx, "usr-1", phpSite.ID, " client_max_body_size 64m; ")
+	if err != nil {
+		t.Fatalf("set directives: %v", err)
+	}
+	if site.CustomDirectives != "" || payload(job).CustomDirectives != "client_max_body_size 64m;" {
+		t.Fatalf("directives stored before the job ran: site=%q payload=%+v", site.CustomDirectives, payload(job))
+	}
+	if _, _, err := svc.SetCustomDirectives(ctx, "usr-1", phpSite.ID, "access_log /etc/passwd;"); !errors.Is(err, provision.ErrInvalidDirectives) {
+		t.Fatalf("expected access_log to be rejected, got %v", err)
+	}
+
+	site, job, err = svc.SetProxy(ctx, "usr-1", proxySite.ID, store.SiteProxy{Targets: []store.ProxyTarget{{URL: "http://10.0.0.6:8080"}}})
+	if err != nil {
+		t.Fatalf("set proxy: %v", err)
+	}
+	if site.Proxy.Targets[0].URL != "http://10.0.0.5:8080" || payload(job).Proxy.Targets[0].URL != "http://10.0.0.6:8080" {
+		t.Fatalf("proxy stored before the job ran: site=%+v payload=%+v", site.Proxy, payload(job).Proxy)
+	}
+
+	port := 3000
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{Runtime: str("node"), UpstreamPort: &port}); !errors.Is(err, store.ErrUpstreamInUse) {
+		t.Fatalf("expected upstream conflict, got %v", err)
+	}
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{Runtime: str("cobol")}); !errors.Is(err, ErrInvalidRuntime) {
+		t.Fatalf("expected invalid runtime, got %v", err)
+	}
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{RootPath: str("relative/path")}); !errors.Is(err, ErrInvalidRoot) {
+		t.Fatalf("expected invalid root, got %v", err)
+	}
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{Runtime: str("static"), PHPVersion: str("8.3")}); !errors.Is(err, ErrInvalidPHP) {
+		t.Fatalf("expected php version to be rejected for static, got %v", err)
+	}
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{App: &store.SiteApp{Command: "node server.js"}}); !errors.Is(err, provision.ErrInvalidApp) {
+		t.Fatalf("expected app to be rejected for php, got %v", err)
+	}
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", "site-missing", UpdateSiteInput{}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	if err := repo.UpdateSiteSuspended(ctx, phpSite.ID, true); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	if _, _, err := svc.UpdateSite(ctx, "usr-1", phpSite.ID, UpdateSiteInput{RootPath: str("/srv/php")}); !errors.Is(err, ErrSiteState) {
+		t.Fatalf("expected suspended site to be rejected, got %v", err)
+	}
+}
+
+func TestPHPVersionIsPinnedAndStoredByTheJob(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	ctx := context.Background()
+
+	runDir := t.TempDir()
+	for _, name := range []string{"php8.2-fpm.sock", "php8.3-fpm.sock"} {
+		if err := os.WriteFile(filepath.Join(runDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobSvc := jobs.NewService(repo, log.New(io.Discard, "", 0), nil, nil)
+	jobSvc.Start(ctx)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = jobSvc.Stop(stopCtx)
+	}()
+	svc := NewService(repo, jobSvc, "", "", "", "", true, PortRange{}, php.NewDetector(runDir), nil, nil)
+
+	legacy := store.Site{ID: "site_legacy", Domain: "legacy.example.com", RootPath: "/var/www/legacy", Runtime: "php"}
+	if err := repo.CreateSite(ctx, legacy); err != nil {
+		t.Fatalf("create legacy site: %v", err)
+	}
+	if err := svc.BackfillSites(ctx); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	if got, _ := repo.GetSiteByID(ctx, legacy.ID); got.PHPVersion != "8.3" {
+		t.Fatalf("legacy site pinned to %q, want 8.3", got.PHPVersion)
+	}
+
+	site, err := newSite("usr-1", CreateSiteInput{Domain: "php.example.com", RootPath: "/var/www/php", Runtime: "php"})
+	if err != nil {
+		t.Fatalf("new site: %v", err)
+	}
+	if site, err = svc.createSiteRecord(ctx, site); err != nil || site.PHPVersion != "8.3" {
+		t.Fatalf("created site version = %q, err = %v", site.PHPVersion, err)
+	}
+
+	site, job, err := svc.SetPHPVersion(ctx, "usr-1", site.ID, "8.2")
+	if err != nil {
+		t.Fatalf("set php version: %v", err)
+	}
+	if site.PHPVersion != "8.3" || job.Type != store.JobTypeReprovisionSite {
+		t.Fatalf("version stored before the job ran: site=%q job=%s", site.PHPVersion, job.Type)
+	}
+}
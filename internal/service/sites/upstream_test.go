@@ -0,0 +1,85 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"nusantara/internal/store"
+	"nusantara/internal/store/filedb"
+)
+
+func TestCreateSiteRecordAllocatesUpstreamPort(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	svc := NewService(repo, nil, "", "", "", "", false, PortRange{Min: 3000, Max: 3001}, nil, nil, nil)
+	ctx := context.Background()
+
+	newNode := func(domain string, port int) store.Site {
+		site, err := newSite("usr-1", CreateSiteInput{Domain: domain, RootPath: "/var/www/" + domain, Runtime: "node", UpstreamPort: port})
+		if err != nil {
+			t.Fatalf("new site %s: %v", domain, err)
+		}
+		return site
+	}
+
+	first, err := svc.createSiteRecord(ctx, newNode("one.example.com", 0))
+	if err != nil || first.UpstreamHost != "127.0.0.1" || first.UpstreamPort != 3000 {
+		t.Fatalf("first site = %+v err=%v", first, err)
+	}
+	if _, err := svc.createSiteRecord(ctx, newNode("two.example.com", 3000)); !errors.Is(err, store.ErrUpstreamInUse) {
+		t.Fatalf("expected upstream conflict, got %v", err)
+	}
+	second, err := svc.createSiteRecord(ctx, newNode("two.example.com", 0))
+	if err != nil || second.UpstreamPort != 3001 {
+		t.Fatalf("second site = %+v err=%v", second, err)
+	}
+	if _, err := svc.createSiteRecord(ctx, newNode("three.example.com", 0)); !errors.Is(err, ErrNoUpstreamPort) {
+		t.Fatalf("expected exhausted range, got %v", err)
+	}
+}
+
+func TestCreateSiteRecordCountsLegacyAndLoopbackUpstreams(t *testing.T) {
+	repo, err := filedb.New(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("init repo: %v", err)
+	}
+	defer repo.Close()
+	svc := NewService(repo, nil, "", "", "", "", false, PortRange{Min: 3000, Max: 3001}, nil, nil, nil)
+	ctx := context.Background()
+
+	// Created before upstreams were stored: served on 127.0.0.1:3000.
+	legacy := store.Site{ID: "site_legacy", Domain: "legacy.example.com", RootPath: "/var/www/legacy", Runtime: "node"}
+	if err := repo.CreateSite(ctx, legacy); err != nil {
+		t.Fatalf("create legacy site: %v", err)
+	}
+
+	site, err := newSite("usr-1", CreateSiteInput{Domain: "one.example.com", RootPath: "/var/www/one", Runtime: "node", UpstreamHost: "localhost", UpstreamPort: 3000})
+	if err != nil {
+		t.Fatalf("new site: %v", err)
+	}
+	if _, err := svc.createSiteRecord(ctx, site); !errors.Is(err, store.ErrUpstreamInUse) {
+		t.Fatalf("localhost:3000 next to a legacy site: err = %v", err)
+	}
+	site.UpstreamPort = 0
+	created, err := svc.createSiteRecord(ctx, site)
+	if err != nil || created.UpstreamPort != 3001 {
+		t.Fatalf("allocated site = %+v err=%v", created, err)
+	}
+}
+
+func TestNewSiteValidatesUpstream(t *testing.T) {
+	if _, err := newSite("usr-1", CreateSiteInput{Domain: "a.example.com", RootPath: "/var/www/a", Runtime: "static", UpstreamPort: 3000}); !errors.Is(err, ErrInvalidUpstream) {
+		t.Fatalf("expected static upstream to be rejected, got %v", err)
+	}
+	if _, err := newSite("usr-1", CreateSiteInput{Domain: "a.example.com", RootPath: "/var/www/a", Runtime: "node", UpstreamPort: 70000}); !errors.Is(err, ErrInvalidUpstream) {
+		t.Fatalf("expected out of range port to be rejected, got %v", err)
+	}
+	if _, err := newSite("usr-1", CreateSiteInput{Domain: "a.example.com", RootPath: "/var/www/a", Runtime: "python", UpstreamHost: "bad host"}); !errors.Is(err, ErrInvalidUpstream) {
+		t.Fatalf("expected invalid host to be rejected, got %v", err)
+	}
+}
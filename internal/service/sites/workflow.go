@@ -0,0 +1,101 @@
+package sites
+
+import (
+	"context"
+
+	dbsvc "nusantara/internal/db"
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+const (
+	stepProvisionSite  = "provision_site"
+	stepIssueSSL       = "issue_ssl"
+	stepCreateDatabase = "create_database"
+	stepCreateDBUser   = "create_database_user"
+)
+
+type CreateSiteWorkflowInput struct {
+	Site CreateSiteInput
+	// SSLEmail enables certificate issuance and HTTPS once the site is
+	// provisioned; HSTS adds the Strict-Transport-Security header.
+	SSLEmail string
+	HSTS     bool
+	// Database is optional; the user step is added when Username is set.
+	Database *SiteDatabaseInput
+}
+
+type SiteDatabaseInput struct {
+	Name     string
+	Username string
+	Password string
+	Host     string
+}
+
+// CreateSiteWorkflow creates the site record and a workflow that provisions
+// it, then issues SSL and creates the database and user. The SSL step waits
+// for provisioning; database steps run independently of the site.
+func (s *Service) CreateSiteWorkflow(ctx context.Context, actorID string, input CreateSiteWorkflowInput) (store.Site, jobs.Workflow, error) {
+	if input.Site.Policy == nil {
+		input.Site.Policy = s.defaultPolicy(input.Site.Runtime)
+	}
+	site, err := newSite(actorID, input.Site)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+
+	steps := []jobs.WorkflowStep{{
+		Name:    stepProvisionSite,
+		JobType: store.JobTypeProvisionSite,
+		Payload: provisionPayload(site),
+	}}
+	if input.SSLEmail != "" {
+		tls, err := tlsSteps(site, input.SSLEmail, input.HSTS, stepProvisionSite)
+		if err != nil {
+			return store.Site{}, jobs.Workflow{}, err
+		}
+		steps = append(steps, tls...)
+	}
+	if db := input.Database; db != nil {
+		steps = append(steps, jobs.WorkflowStep{
+			Name:    stepCreateDatabase,
+			JobType: store.JobTypeCreateDatabase,
+			Payload: dbsvc.CreateDatabasePayload{Name: db.Name},
+		})
+		if db.Username != "" {
+			steps = append(steps, jobs.WorkflowStep{
+				Name:    stepCreateDBUser,
+				JobType: store.JobTypeCreateDBUser,
+				Payload: dbsvc.CreateUserInput{
+					Database: db.Name,
+					Username: db.Username,
+					Password: db.Password,
+					Host:     db.Host,
+				},
+				DependsOn: []string{stepCreateDatabase},
+			})
+		}
+	}
+	// Reject bad step input before the site record exists.
+	for _, step := range steps {
+		if v, ok := step.Payload.(jobs.Validator); ok {
+			if err := v.Validate(); err != nil {
+				return store.Site{}, jobs.Workflow{}, err
+			}
+		}
+	}
+
+	if err := s.checkRootPath(ctx, "", site.RootPath); err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	site, err = s.createSiteRecord(ctx, site)
+	if err != nil {
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	workflow, err := s.jobSvc.EnqueueWorkflow(ctx, actorID, "create_site:"+site.Domain, steps)
+	if err != nil {
+		_ = s.repo.UpdateSiteStatus(ctx, site.ID, store.SiteStatusFailed)
+		return store.Site{}, jobs.Workflow{}, err
+	}
+	return site, workflow, nil
+}
@@ -0,0 +1,50 @@
+package ssl
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"nusantara/internal/jobs"
+	"nusantara/internal/store"
+)
+
+type IssuePayload struct {
+	Domain  string   `json:"domain"`
+	Aliases []string `json:"aliases,omitempty"`
+	Email   string   `json:"email"`
+	// Webroot is the directory the site serves /.well-known/acme-challenge/
+	// from. Empty uses /var/www/<domain>/public.
+	Webroot string `json:"webroot,omitempty"`
+}
+
+func (p IssuePayload) Validate() error {
+	if !isValidDomain(strings.ToLower(strings.TrimSpace(p.Domain))) {
+		return ErrInvalidDomain
+	}
+	if !emailPattern.MatchString(strings.TrimSpace(p.Email)) {
+		return ErrInvalidEmail
+	}
+	for _, alias := range p.Aliases {
+		if !isValidDomain(strings.ToLower(strings.TrimSpace(alias))) {
+			return ErrInvalidDomain
+		}
+	}
+	if p.Webroot != "" && !filepath.IsAbs(p.Webroot) {
+		return ErrNoWebroot
+	}
+	return nil
+}
+
+// RegisterJobs exposes certificate issue and renewal as async jobs.
+func (s *Service) RegisterJobs(r jobs.Registry) error {
+	if err := r.Register(store.JobTypeIssueSSL, jobs.Typed(func(ctx context.Context, _ store.Job, p IssuePayload) error {
+		jobs.Logf(ctx, "issuing certificate for %s aliases=%v", p.Domain, p.Aliases)
+		return s.Issue(ctx, p.Webroot, p.Domain, p.Email, p.Aliases...)
+	})); err != nil {
+		return err
+	}
+	return r.Register(store.JobTypeRenewSSL, jobs.HandlerFunc(func(ctx context.Context, _ store.Job) error {
+		return s.Renew(ctx)
+	}))
+}
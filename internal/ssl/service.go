@@ -16,6 +16,10 @@ import (
 var (
 	ErrInvalidDomain = errors.New("invalid domain")
 	ErrInvalidEmail  = errors.New("invalid email")
+	// ErrNoWebroot is returned when there is no directory to answer the
+	// ACME challenge from. The panel never lets certbot edit the web server
+	// config, so webroot is the only supported method.
+	ErrNoWebroot = errors.New("no webroot to answer the acme challenge")
 )
 
 var (
@@ -26,11 +30,14 @@ var (
 type Service struct {
 	apply          bool
 	certbotCommand string
+	reloadCommand  string
 	timeout        time.Duration
 	logger         *log.Logger
 }
 
-func NewService(apply bool, certbotCommand string, timeout time.Duration, logger *log.Logger) *Service {
+// NewService builds the certbot wrapper. reloadCommand runs as the renewal
+// deploy hook so nginx picks up renewed certificates.
+func NewService(apply bool, certbotCommand, reloadCommand string, timeout time.Duration, logger *log.Logger) *Service {
 	if strings.TrimSpace(certbotCommand) == "" {
 		certbotCommand = "certbot"
 	}
@@ -40,12 +47,17 @@ func NewService(apply bool, certbotCommand string, timeout time.Duration, logger
 	return &Service{
 		apply:          apply,
 		certbotCommand: certbotCommand,
+		reloadCommand:  reloadCommand,
 		timeout:        timeout,
 		logger:         logger,
 	}
 }
 
-func (s *Service) Issue(ctx context.Context, domain, email string) error {
+// Issue obtains or expands the certificate named after domain so that it
+// covers domain plus aliases. It only runs certonly: the panel renders the
+// HTTPS server block itself, see CertificatePaths. The challenge is answered
+// from webroot; an empty webroot falls back to /var/www/<domain>/public.
+func (s *Service) Issue(ctx context.Context, webroot, domain, email string, aliases ...string) error {
 	domain = strings.ToLower(strings.TrimSpace(domain))
 	email = strings.TrimSpace(email)
 	if !isValidDomain(domain) {
@@ -54,9 +66,23 @@ func (s *Service) Issue(ctx context.Context, domain, email string) error {
 	if !emailPattern.MatchString(email) {
 		return ErrInvalidEmail
 	}
+	names := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		if !isValidDomain(alias) {
+			return ErrInvalidDomain
+		}
+		names = append(names, alias)
+	}
+	if webroot == "" {
+		webroot = defaultWebrootForDomain(domain)
+	}
+	if webroot == "" {
+		return ErrNoWebroot
+	}
 
 	if !s.apply {
-		s.logf("dry-run ssl issue domain=%s email=%s", domain, email)
+		s.logf("dry-run ssl issue domain=%s aliases=%v email=%s", domain, names, email)
 		return nil
 	}
 
@@ -65,24 +91,13 @@ func (s *Service) Issue(ctx context.Context, domain, email string) error {
 
 	_ = os.RemoveAll("/var/lib/letsencrypt/temp_checkpoint")
 
-	issueArgs := issueCommandArgs(domain, email)
+	issueArgs := issueCommandArgs(webroot, domain, email, names)
 	if err := runCommand(runCtx, s.certbotCommand, issueArgs...); err != nil {
-		if !certificateExists(domain) {
+		if !CertificateExists(domain) {
 			return fmt.Errorf("issue cert: %w", err)
 		}
 		s.logf("cert issue command returned non-zero, but certificate already exists domain=%s err=%v", domain, err)
 	}
-
-	installArgs := []string{
-		"install",
-		"--cert-name", domain,
-		"--nginx",
-		"--redirect",
-		"--non-interactive",
-	}
-	if err := runCommand(runCtx, s.certbotCommand, installArgs...); err != nil {
-		return fmt.Errorf("install cert: %w", err)
-	}
 	return nil
 }
 
@@ -95,7 +110,11 @@ func (s *Service) Renew(ctx context.Context) error {
 	runCtx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	if err := runCommand(runCtx, s.certbotCommand, "renew", "--non-interactive"); err != nil {
+	args := []string{"renew", "--non-interactive"}
+	if strings.TrimSpace(s.reloadCommand) != "" {
+		args = append(args, "--deploy-hook", s.reloadCommand)
+	}
+	if err := runCommand(runCtx, s.certbotCommand, args...); err != nil {
 		return fmt.Errorf("renew cert: %w", err)
 	}
 	return nil
@@ -138,20 +157,23 @@ func (s *Service) logf(format string, args ...any) {
 	}
 }
 
-func issueCommandArgs(domain, email string) []string {
+func issueCommandArgs(webroot, domain, email string, aliases []string) []string {
 	args := []string{
 		"certonly",
+		"--cert-name", domain,
+		"--expand",
 		"-d", domain,
+	}
+	for _, alias := range aliases {
+		args = append(args, "-d", alias)
+	}
+	args = append(args,
 		"--non-interactive",
 		"--agree-tos",
 		"-m", email,
-	}
-
-	webroot := defaultWebrootForDomain(domain)
-	if webroot == "" {
-		return append(args, "--nginx")
-	}
-	return append(args, "--webroot", "-w", webroot)
+		"--webroot", "-w", webroot,
+	)
+	return args
 }
 
 func defaultWebrootForDomain(domain string) string {
@@ -163,9 +185,20 @@ func defaultWebrootForDomain(domain string) string {
 	return root
 }
 
-func certificateExists(domain string) bool {
-	fullchain := filepath.Join("/etc/letsencrypt/live", domain, "fullchain.pem")
-	privkey := filepath.Join("/etc/letsencrypt/live", domain, "privkey.pem")
+// LiveDir is where certbot links the current certificate of each cert name.
+const LiveDir = "/etc/letsencrypt/live"
+
+// CertificatePaths returns the certificate chain and key paths certbot keeps
+// for the certificate named after domain.
+func CertificatePaths(domain string) (certPath, keyPath string) {
+	dir := filepath.Join(LiveDir, strings.ToLower(strings.TrimSpace(domain)))
+	return filepath.Join(dir, "fullchain.pem"), filepath.Join(dir, "privkey.pem")
+}
+
+// CertificateExists reports whether certbot holds a certificate and key for
+// the certificate named after domain.
+func CertificateExists(domain string) bool {
+	fullchain, privkey := CertificatePaths(domain)
 	if _, err := os.Stat(fullchain); err != nil {
 		return false
 	}
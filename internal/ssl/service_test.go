@@ -2,6 +2,7 @@ package ssl
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"strings"
@@ -10,29 +11,41 @@ import (
 )
 
 func TestIssueDryRun(t *testing.T) {
-	svc := NewService(false, "certbot", 30*time.Second, log.New(io.Discard, "", 0))
-	if err := svc.Issue(context.Background(), "example.com", "admin@example.com"); err != nil {
+	svc := NewService(false, "certbot", "", 30*time.Second, log.New(io.Discard, "", 0))
+	if err := svc.Issue(context.Background(), "/var/www/example.com", "example.com", "admin@example.com"); err != nil {
 		t.Fatalf("issue dry-run failed: %v", err)
 	}
 }
 
 func TestIssueValidation(t *testing.T) {
-	svc := NewService(false, "certbot", 30*time.Second, nil)
-	if err := svc.Issue(context.Background(), "bad domain", "admin@example.com"); err == nil {
+	svc := NewService(false, "certbot", "", 30*time.Second, nil)
+	if err := svc.Issue(context.Background(), "/var/www/example.com", "bad domain", "admin@example.com"); err == nil {
 		t.Fatalf("expected invalid domain error")
 	}
-	if err := svc.Issue(context.Background(), "example.com", "invalid-email"); err == nil {
+	if err := svc.Issue(context.Background(), "/var/www/example.com", "example.com", "invalid-email"); err == nil {
 		t.Fatalf("expected invalid email error")
 	}
 }
 
-func TestIssueCommandArgsFallbackToNginxWhenNoWebroot(t *testing.T) {
-	args := issueCommandArgs("unlikely-domain-for-test-1234567890.example", "admin@example.com")
+func TestIssueRequiresWebroot(t *testing.T) {
+	svc := NewService(false, "certbot", "", 30*time.Second, nil)
+	err := svc.Issue(context.Background(), "", "unlikely-domain-for-test-1234567890.example", "admin@example.com")
+	if !errors.Is(err, ErrNoWebroot) {
+		t.Fatalf("expected ErrNoWebroot without a webroot, got %v", err)
+	}
+	args := issueCommandArgs("/srv/site", "example.com", "admin@example.com", nil)
 	joined := strings.Join(args, " ")
-	if !strings.Contains(joined, "--nginx") {
-		t.Fatalf("expected --nginx fallback args, got: %s", joined)
+	if !strings.Contains(joined, "--webroot -w /srv/site") || strings.Contains(joined, "--nginx") {
+		t.Fatalf("expected webroot-only args, got: %s", joined)
 	}
-	if strings.Contains(joined, "--webroot") {
-		t.Fatalf("did not expect --webroot in fallback args, got: %s", joined)
+}
+
+func TestIssueCommandArgsIncludesAliases(t *testing.T) {
+	args := issueCommandArgs("/var/www/example.com", "example.com", "admin@example.com", []string{"www.example.com", "shop.example.com"})
+	joined := strings.Join(args, " ")
+	for _, want := range []string{"--cert-name example.com", "--expand", "-d example.com -d www.example.com -d shop.example.com"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q in args, got: %s", want, joined)
+		}
 	}
 }
@@ -18,15 +18,17 @@ import (
 const schemaVersion = 1
 
 type snapshot struct {
-	SchemaVersion int                      `json:"schema_version"`
-	Users         map[string]store.User    `json:"users"`
-	Sessions      map[string]store.Session `json:"sessions"`
-	Sites         map[string]store.Site    `json:"sites"`
-	Jobs          map[string]store.Job     `json:"jobs"`
-	AuditLogs     []store.AuditLog         `json:"audit_logs"`
-	AuditSequence int64                    `json:"audit_sequence"`
-	UsernameIndex map[string]string        `json:"username_index"`
-	DomainIndex   map[string]string        `json:"domain_index"`
+	SchemaVersion int                                `json:"schema_version"`
+	Users         map[string]store.User              `json:"users"`
+	Sessions      map[string]store.Session           `json:"sessions"`
+	Sites         map[string]store.Site              `json:"sites"`
+	Jobs          map[string]store.Job               `json:"jobs"`
+	Schedules     map[string]store.Schedule          `json:"schedules"`
+	Idempotency   map[string]store.IdempotencyRecord `json:"idempotency"`
+	AuditLogs     []store.AuditLog                   `json:"audit_logs"`
+	AuditSequence int64                              `json:"audit_sequence"`
+	UsernameIndex map[string]string                  `json:"username_index"`
+	DomainIndex   map[string]string                  `json:"domain_index"`
 }
 
 type Repository struct {
@@ -61,6 +63,8 @@ func newSnapshot() snapshot {
 		Sessions:      make(map[string]store.Session),
 		Sites:         make(map[string]store.Site),
 		Jobs:          make(map[string]store.Job),
+		Schedules:     make(map[string]store.Schedule),
+		Idempotency:   make(map[string]store.IdempotencyRecord),
 		AuditLogs:     make([]store.AuditLog, 0, 128),
 		UsernameIndex: make(map[string]string),
 		DomainIndex:   make(map[string]string),
@@ -95,6 +99,12 @@ func (r *Repository) load() error {
 	if snap.Jobs == nil {
 		snap.Jobs = make(map[string]store.Job)
 	}
+	if snap.Schedules == nil {
+		snap.Schedules = make(map[string]store.Schedule)
+	}
+	if snap.Idempotency == nil {
+		snap.Idempotency = make(map[string]store.IdempotencyRecord)
+	}
 	if snap.AuditLogs == nil {
 		snap.AuditLogs = make([]store.AuditLog, 0, 128)
 	}
@@ -118,7 +128,9 @@ func (r *Repository) rebuildIndexes() {
 
 	r.data.DomainIndex = make(map[string]string, len(r.data.Sites))
 	for id, site := range r.data.Sites {
-		r.data.DomainIndex[strings.ToLower(site.Domain)] = id
+		for _, name := range site.Hostnames() {
+			r.data.DomainIndex[strings.ToLower(name)] = id
+		}
 	}
 }
 
@@ -230,20 +242,59 @@ func (r *Repository) DeleteSessionByTokenHash(_ context.Context, tokenHash strin
 	return r.save()
 }
 
+func (r *Repository) DeleteExpiredSessions(_ context.Context, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for tokenHash, session := range r.data.Sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(r.data.Sessions, tokenHash)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, r.save()
+}
+
 func (r *Repository) CreateSite(_ context.Context, site store.Site) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	domainKey := strings.ToLower(site.Domain)
-	if _, exists := r.data.DomainIndex[domainKey]; exists {
+	if r.hostnameTaken(site.ID, site.Hostnames()) {
 		return store.ErrConflict
 	}
+	for _, other := range r.data.Sites {
+		if store.UpstreamConflict(site, other) {
+			return store.ErrUpstreamInUse
+		}
+	}
 
 	r.data.Sites[site.ID] = site
-	r.data.DomainIndex[domainKey] = site.ID
+	for _, name := range site.Hostnames() {
+		r.data.DomainIndex[strings.ToLower(name)] = site.ID
+	}
 	return r.save()
 }
 
+// hostnameTaken reports whether any of names is used by a site other than
+// siteID, or repeated within names.
+func (r *Repository) hostnameTaken(siteID string, names []string) bool {
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if _, dup := seen[key]; dup {
+			return true
+		}
+		seen[key] = struct{}{}
+		if owner, exists := r.data.DomainIndex[key]; exists && owner != siteID {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Repository) ListSites(_ context.Context, limit int) ([]store.Site, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -283,6 +334,195 @@ func (r *Repository) UpdateSiteStatus(_ context.Context, id, status string) erro
 	return r.save()
 }
 
+func (r *Repository) UpdateSitePHPVersion(_ context.Context, id, version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.PHPVersion = version
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSite(_ context.Context, site store.Site) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.data.Sites[site.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	for _, other := range r.data.Sites {
+		if other.ID != site.ID && store.UpstreamConflict(site, other) {
+			return store.ErrUpstreamInUse
+		}
+	}
+	site.Domain = current.Domain
+	site.Aliases = current.Aliases
+	site.CanonicalRedirect = current.CanonicalRedirect
+	site.CreatedBy = current.CreatedBy
+	site.CreatedAt = current.CreatedAt
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[site.ID] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteDomains(_ context.Context, id string, aliases []string, canonicalRedirect string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	updated := site
+	updated.Aliases = append([]string(nil), aliases...)
+	updated.CanonicalRedirect = canonicalRedirect
+	if r.hostnameTaken(id, updated.Hostnames()) {
+		return store.ErrConflict
+	}
+	for _, alias := range site.Aliases {
+		delete(r.data.DomainIndex, strings.ToLower(alias))
+	}
+	for _, alias := range updated.Aliases {
+		r.data.DomainIndex[strings.ToLower(alias)] = id
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = updated
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteTLS(_ context.Context, id string, tls *store.SiteTLS) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.TLS = tls
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteCustomDirectives(_ context.Context, id, directives string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.CustomDirectives = directives
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteMaintenance(_ context.Context, id string, maintenance *store.SiteMaintenance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Maintenance = maintenance
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteSuspended(_ context.Context, id string, suspended bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Suspended = suspended
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteUnmanaged(_ context.Context, id string, unmanaged bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Unmanaged = unmanaged
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteProxy(_ context.Context, id string, proxy *store.SiteProxy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Proxy = proxy
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteAccess(_ context.Context, id string, access *store.SiteAccess) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Access = access
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSitePolicy(_ context.Context, id string, policy *store.SitePolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Policy = policy
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteApp(_ context.Context, id string, app *store.SiteApp) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.App = app
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
+func (r *Repository) UpdateSiteDeploy(_ context.Context, id string, deploy *store.SiteDeploy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	site, ok := r.data.Sites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	site.Deploy = deploy
+	site.UpdatedAt = time.Now().UTC()
+	r.data.Sites[id] = site
+	return r.save()
+}
+
 func (r *Repository) DeleteSite(_ context.Context, id string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -290,7 +530,9 @@ func (r *Repository) DeleteSite(_ context.Context, id string) error {
 	if !ok {
 		return store.ErrNotFound
 	}
-	delete(r.data.DomainIndex, strings.ToLower(site.Domain))
+	for _, name := range site.Hostnames() {
+		delete(r.data.DomainIndex, strings.ToLower(name))
+	}
 	delete(r.data.Sites, id)
 	return r.save()
 }
@@ -328,6 +570,21 @@ func (r *Repository) GetJobByID(_ context.Context, id string) (store.Job, error)
 	return job, nil
 }
 
+func (r *Repository) ListJobsByParent(_ context.Context, parentID string) ([]store.Job, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	jobs := make([]store.Job, 0)
+	for _, job := range r.data.Jobs {
+		if job.ParentID == parentID {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
 func (r *Repository) UpdateJob(_ context.Context, id, status, errorMsg string, startedAt, finishedAt *time.Time) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -343,6 +600,106 @@ func (r *Repository) UpdateJob(_ context.Context, id, status, errorMsg string, s
 	return r.save()
 }
 
+func (r *Repository) UpdateJobPayload(_ context.Context, id, payload string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.data.Jobs[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	job.Payload = payload
+	r.data.Jobs[id] = job
+	return r.save()
+}
+
+func (r *Repository) DeleteJobsFinishedBefore(_ context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for id, job := range r.data.Jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(before) {
+			delete(r.data.Jobs, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, r.save()
+}
+
+func (r *Repository) CreateSchedule(_ context.Context, schedule store.Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.data.Schedules[schedule.ID]; exists {
+		return store.ErrConflict
+	}
+	r.data.Schedules[schedule.ID] = schedule
+	return r.save()
+}
+
+func (r *Repository) ListSchedules(_ context.Context) ([]store.Schedule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	items := make([]store.Schedule, 0, len(r.data.Schedules))
+	for _, schedule := range r.data.Schedules {
+		items = append(items, schedule)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.Before(items[j].CreatedAt)
+	})
+	return items, nil
+}
+
+func (r *Repository) GetScheduleByID(_ context.Context, id string) (store.Schedule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schedule, ok := r.data.Schedules[id]
+	if !ok {
+		return store.Schedule{}, store.ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (r *Repository) UpdateScheduleState(_ context.Context, id string, paused bool, nextRunAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schedule, ok := r.data.Schedules[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	schedule.Paused = paused
+	schedule.NextRunAt = nextRunAt
+	schedule.UpdatedAt = time.Now().UTC()
+	r.data.Schedules[id] = schedule
+	return r.save()
+}
+
+func (r *Repository) UpdateScheduleRun(_ context.Context, id, lastJobID string, lastRunAt, nextRunAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schedule, ok := r.data.Schedules[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	schedule.LastJobID = lastJobID
+	schedule.LastRunAt = &lastRunAt
+	schedule.NextRunAt = nextRunAt
+	schedule.UpdatedAt = time.Now().UTC()
+	r.data.Schedules[id] = schedule
+	return r.save()
+}
+
+func (r *Repository) DeleteSchedule(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.data.Schedules[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(r.data.Schedules, id)
+	return r.save()
+}
+
 func (r *Repository) CreateAuditLog(_ context.Context, logEntry store.AuditLog) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -352,6 +709,69 @@ func (r *Repository) CreateAuditLog(_ context.Context, logEntry store.AuditLog)
 	return r.save()
 }
 
+// CreateIdempotencyRecord returns store.ErrConflict while an unexpired record
+// with the same ID exists; an expired one is replaced.
+func (r *Repository) CreateIdempotencyRecord(_ context.Context, record store.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.data.Idempotency[record.ID]; ok && existing.ExpiresAt.After(record.CreatedAt) {
+		return store.ErrConflict
+	}
+	r.data.Idempotency[record.ID] = record
+	return r.save()
+}
+
+func (r *Repository) GetIdempotencyRecord(_ context.Context, id string) (store.IdempotencyRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	record, ok := r.data.Idempotency[id]
+	if !ok {
+		return store.IdempotencyRecord{}, store.ErrNotFound
+	}
+	return record, nil
+}
+
+func (r *Repository) CompleteIdempotencyRecord(_ context.Context, id string, statusCode int, contentType, body string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.data.Idempotency[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	record.Completed = true
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = body
+	r.data.Idempotency[id] = record
+	return r.save()
+}
+
+func (r *Repository) DeleteIdempotencyRecord(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.data.Idempotency[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(r.data.Idempotency, id)
+	return r.save()
+}
+
+func (r *Repository) DeleteExpiredIdempotencyRecords(_ context.Context, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for id, record := range r.data.Idempotency {
+		if !record.ExpiresAt.After(now) {
+			delete(r.data.Idempotency, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, r.save()
+}
+
 func (r *Repository) ListAuditLogs(_ context.Context, limit int) ([]store.AuditLog, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -3,6 +3,8 @@
 import (
 	"context"
 	"errors"
+	"net"
+	"strings"
 	"time"
 )
 
@@ -14,19 +16,50 @@ const (
 	SiteStatusActive       = "active"
 	SiteStatusFailed       = "failed"
 	SiteStatusDeleting     = "deleting"
+	SiteStatusSuspended    = "suspended"
+	SiteStatusMaintenance  = "maintenance"
 
 	JobStatusQueued  = "queued"
 	JobStatusRunning = "running"
 	JobStatusSuccess = "success"
 	JobStatusFailed  = "failed"
+	JobStatusWaiting = "waiting"
+	JobStatusSkipped = "skipped"
 
 	JobTypeProvisionSite   = "provision_site"
 	JobTypeDeprovisionSite = "deprovision_site"
+	JobTypeIssueSSL        = "ssl_issue"
+	JobTypeRenewSSL        = "ssl_renew"
+	JobTypeRunBackup       = "backup_run"
+	JobTypeCleanup         = "cleanup"
+	JobTypeCreateDatabase  = "db_create_database"
+	JobTypeCreateDBUser    = "db_create_user"
+	JobTypeWorkflow        = "workflow"
+	JobTypeEnableSiteTLS   = "site_tls_enable"
+	JobTypeSuspendSite     = "site_suspend"
+	JobTypeResumeSite      = "site_resume"
+	JobTypeMaintenanceSite = "site_maintenance"
+	JobTypeReconcileSites  = "sites_reconcile"
+	JobTypeReprovisionSite = "reprovision_site"
+	JobTypeDeploySite      = "deploy"
+	JobTypeRollbackSite    = "deploy_rollback"
+	JobTypeExtractArchive  = "site_extract_archive"
+
+	// CanonicalApex redirects www.<domain> to the domain; CanonicalWWW
+	// redirects the domain to www.<domain>.
+	CanonicalApex = "apex"
+	CanonicalWWW  = "www"
+
+	ScheduleMissedRunOnce = "run_once"
+	ScheduleMissedSkip    = "skip"
 )
 
 var (
 	ErrNotFound = errors.New("not found")
 	ErrConflict = errors.New("conflict")
+	// ErrUpstreamInUse is returned when another site already proxies to the
+	// same upstream host and port.
+	ErrUpstreamInUse = errors.New("upstream address already in use")
 )
 
 type User struct {
@@ -47,16 +80,231 @@ type Session struct {
 }
 
 type Site struct {
-	ID        string    `json:"id"`
-	Domain    string    `json:"domain"`
-	RootPath  string    `json:"root_path"`
-	Runtime   string    `json:"runtime"`
+	ID       string `json:"id"`
+	Domain   string `json:"domain"`
+	RootPath string `json:"root_path"`
+	Runtime  string `json:"runtime"`
+	// UpstreamHost/UpstreamPort are the backend address for proxied runtimes
+	// (node, python); empty for php and static sites.
+	UpstreamHost string `json:"upstream_host,omitempty"`
+	UpstreamPort int    `json:"upstream_port,omitempty"`
+	// Proxy configures the proxy runtime's upstream targets.
+	Proxy *SiteProxy `json:"proxy,omitempty"`
+	// App is the process the panel runs behind node and python sites; nil
+	// leaves the upstream to be started by hand.
+	App *SiteApp `json:"app,omitempty"`
+	// PHPVersion selects the PHP-FPM version for php sites; empty means the
+	// newest version installed on the host.
+	PHPVersion string `json:"php_version,omitempty"`
+	// Aliases are extra host names served by the site. Every name is unique
+	// across all sites, domains and aliases alike.
+	Aliases []string `json:"aliases,omitempty"`
+	// CanonicalRedirect is empty, CanonicalApex or CanonicalWWW.
+	CanonicalRedirect string `json:"canonical_redirect,omitempty"`
+	// CustomDirectives is an admin snippet placed inside the site's server
+	// block, validated before it is stored and rendered.
+	CustomDirectives string `json:"custom_directives,omitempty"`
+	// Maintenance is set while the site serves its maintenance page.
+	Maintenance *SiteMaintenance `json:"maintenance,omitempty"`
+	// Suspended keeps the site offline across provisioning: its vhost is
+	// rendered but not enabled until the site is resumed. Status alone
+	// cannot carry this, it reads provisioning while a job runs.
+	Suspended bool `json:"suspended,omitempty"`
+	// Access restricts who may reach the site.
+	Access *SiteAccess `json:"access,omitempty"`
+	// Policy holds rate limits and caching; nil renders none of them.
+	Policy *SitePolicy `json:"policy,omitempty"`
+	// Deploy links the site to a git branch deployed as releases.
+	Deploy *SiteDeploy `json:"deploy,omitempty"`
+	// Unmanaged sites were imported from a hand-written vhost; the panel
+	// does not render or remove their nginx config until they are adopted.
+	Unmanaged    bool   `json:"unmanaged,omitempty"`
+	ImportedFrom string `json:"imported_from,omitempty"`
+	// TLS is set once a certificate is installed; the vhost then serves
+	// HTTPS and redirects plain HTTP.
+	TLS       *SiteTLS  `json:"tls,omitempty"`
 	Status    string    `json:"status"`
 	CreatedBy string    `json:"created_by"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+type SiteTLS struct {
+	// Email is the ACME account used to re-issue when host names change.
+	Email     string    `json:"email"`
+	CertPath  string    `json:"cert_path"`
+	KeyPath   string    `json:"key_path"`
+	HSTS      bool      `json:"hsts"`
+	EnabledAt time.Time `json:"enabled_at"`
+}
+
+// SiteProxy configures a site that reverse-proxies to arbitrary upstreams.
+// Timeouts are in seconds; zero keeps the nginx default.
+type SiteProxy struct {
+	Targets []ProxyTarget `json:"targets"`
+	// Balance is empty (round robin), "least_conn" or "ip_hash".
+	Balance          string `json:"balance,omitempty"`
+	WebSocket        bool   `json:"websocket,omitempty"`
+	PreserveHost     bool   `json:"preserve_host,omitempty"`
+	DisableBuffering bool   `json:"disable_buffering,omitempty"`
+	ConnectTimeout   int    `json:"connect_timeout,omitempty"`
+	ReadTimeout      int    `json:"read_timeout,omitempty"`
+	SendTimeout      int    `json:"send_timeout,omitempty"`
+}
+
+// ProxyTarget is one upstream server: http://host:port, https://host:port
+// or unix:/path/to.sock. MaxFails and FailTimeout are nginx's passive
+// health check parameters.
+type ProxyTarget struct {
+	URL         string `json:"url"`
+	Weight      int    `json:"weight,omitempty"`
+	MaxFails    int    `json:"max_fails,omitempty"`
+	FailTimeout int    `json:"fail_timeout,omitempty"`
+	Backup      bool   `json:"backup,omitempty"`
+}
+
+// SiteApp describes the systemd unit running a node or python site.
+type SiteApp struct {
+	// Command is the ExecStart line, e.g. "/usr/bin/node server.js".
+	Command string `json:"command"`
+	// WorkingDir defaults to the site root.
+	WorkingDir string `json:"working_dir,omitempty"`
+	// EnvFile is an optional KEY=value file loaded into the environment.
+	EnvFile string `json:"env_file,omitempty"`
+	// User defaults to the site's own system user.
+	User string `json:"user,omitempty"`
+	// Restart is "on-failure" (default), "always" or "no".
+	Restart string `json:"restart,omitempty"`
+}
+
+// SiteDeploy links a site to a git branch. Releases are checked out under
+// Path/releases and Path/current points at the live one; the site root
+// becomes Path/current/WebDir on the first deploy.
+type SiteDeploy struct {
+	RepoURL string `json:"repo_url"`
+	Branch  string `json:"branch"`
+	Path    string `json:"path"`
+	WebDir  string `json:"web_dir,omitempty"`
+	// KeepReleases is how many releases besides the live one are kept for
+	// rollback.
+	KeepReleases int `json:"keep_releases"`
+	// BuildCommand runs in a new release before it goes live;
+	// PostDeployCommand runs once it is live.
+	BuildCommand      string `json:"build_command,omitempty"`
+	PostDeployCommand string `json:"post_deploy_command,omitempty"`
+	// PublicKey is the site's deploy key to register with the git host.
+	PublicKey string `json:"public_key"`
+	// Webhook reports whether pushes to Branch deploy the site. Its secret
+	// is kept next to the private deploy key, never in the site record.
+	Webhook  bool          `json:"webhook,omitempty"`
+	Current  string        `json:"current,omitempty"`
+	Releases []SiteRelease `json:"releases,omitempty"`
+}
+
+// SiteRelease is one deployed commit, newest last in SiteDeploy.Releases.
+type SiteRelease struct {
+	ID        string    `json:"id"`
+	Commit    string    `json:"commit"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SiteMaintenance struct {
+	// AllowIPs are addresses or CIDRs that still reach the site.
+	AllowIPs []string `json:"allow_ips,omitempty"`
+	// Page is custom HTML; empty uses the panel's default page.
+	Page      string    `json:"page,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// SiteAccess holds a site's basic auth and IP rules. Only user names are
+// kept here; their bcrypt hashes live in the site's htpasswd file.
+type SiteAccess struct {
+	Users []string `json:"users,omitempty"`
+	Realm string   `json:"realm,omitempty"`
+	// Allow and Deny are addresses or CIDRs; the most specific match wins
+	// and, once Allow is non-empty, unmatched clients are refused.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// Paths limits protection to these URI prefixes; empty protects the
+	// whole site.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// SitePolicy configures request limiting, compression and caching. Limits
+// apply per client address; zero disables a setting.
+type SitePolicy struct {
+	// RateLimit is requests per second; RateBurst absorbs short spikes.
+	RateLimit int  `json:"rate_limit,omitempty"`
+	RateBurst int  `json:"rate_burst,omitempty"`
+	ConnLimit int  `json:"conn_limit,omitempty"`
+	Gzip      bool `json:"gzip,omitempty"`
+	// Brotli needs the ngx_brotli module on the host.
+	Brotli bool `json:"brotli,omitempty"`
+	// StaticCache is the browser cache lifetime in seconds for
+	// StaticExtensions, or for a built-in asset list when that is empty.
+	StaticCache      int      `json:"static_cache,omitempty"`
+	StaticExtensions []string `json:"static_extensions,omitempty"`
+	// FastCGICache caches PHP responses for this many seconds (php only).
+	FastCGICache int `json:"fastcgi_cache,omitempty"`
+}
+
+// Hostnames returns the domain followed by the aliases.
+func (s Site) Hostnames() []string {
+	return append([]string{s.Domain}, s.Aliases...)
+}
+
+// legacyUpstreamPorts are used for sites created before the upstream was
+// stored on the site.
+var legacyUpstreamPorts = map[string]int{
+	"node":   3000,
+	"python": 8000,
+}
+
+// Upstream returns the address node and python sites are proxied to,
+// falling back to the defaults used before it was stored. ok is false for
+// runtimes without an upstream.
+func (s Site) Upstream() (host string, port int, ok bool) {
+	legacyPort, ok := legacyUpstreamPorts[s.Runtime]
+	if !ok {
+		return "", 0, false
+	}
+	host, port = s.UpstreamHost, s.UpstreamPort
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if port == 0 {
+		port = legacyPort
+	}
+	return host, port, true
+}
+
+// UpstreamConflict reports whether a and b proxy to the same address. Every
+// loopback name counts as one host, since localhost and 127.0.0.1 reach the
+// same listener.
+func UpstreamConflict(a, b Site) bool {
+	hostA, portA, okA := a.Upstream()
+	hostB, portB, okB := b.Upstream()
+	return okA && okB && portA == portB && sameUpstreamHost(hostA, hostB)
+}
+
+func sameUpstreamHost(a, b string) bool {
+	return upstreamHostKey(a) == upstreamHostKey(b)
+}
+
+func upstreamHostKey(host string) string {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if host == "localhost" {
+		return "loopback"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() {
+			return "loopback"
+		}
+		return ip.String()
+	}
+	return host
+}
+
 type Job struct {
 	ID          string     `json:"id"`
 	Type        string     `json:"type"`
@@ -67,6 +315,41 @@ type Job struct {
 	FinishedAt  *time.Time `json:"finished_at,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	TriggeredBy string     `json:"triggered_by"`
+	ParentID    string     `json:"parent_id,omitempty"`
+	Step        string     `json:"step,omitempty"`
+	DependsOn   []string   `json:"depends_on,omitempty"`
+}
+
+type Schedule struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	CronExpr     string     `json:"cron"`
+	JobType      string     `json:"job_type"`
+	Payload      string     `json:"payload"`
+	MissedPolicy string     `json:"missed_policy"`
+	Paused       bool       `json:"paused"`
+	NextRunAt    time.Time  `json:"next_run_at"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastJobID    string     `json:"last_job_id,omitempty"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// IdempotencyRecord keeps the first response to a mutating request so a retry
+// with the same Idempotency-Key can be replayed. ID is "<user_id>:<key>".
+type IdempotencyRecord struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	RequestHash string    `json:"request_hash"`
+	Completed   bool      `json:"completed"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        string    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
 
 type AuditLog struct {
@@ -91,17 +374,48 @@ type Repository interface {
 	CreateSession(ctx context.Context, session Session) error
 	GetSessionByTokenHash(ctx context.Context, tokenHash string) (Session, error)
 	DeleteSessionByTokenHash(ctx context.Context, tokenHash string) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error)
 
 	CreateSite(ctx context.Context, site Site) error
 	ListSites(ctx context.Context, limit int) ([]Site, error)
 	GetSiteByID(ctx context.Context, id string) (Site, error)
 	UpdateSiteStatus(ctx context.Context, id, status string) error
+	// UpdateSite replaces a site's settings. The domain, aliases, creator and
+	// creation time are kept; see UpdateSiteDomains.
+	UpdateSite(ctx context.Context, site Site) error
 	DeleteSite(ctx context.Context, id string) error
+	UpdateSitePHPVersion(ctx context.Context, id, version string) error
+	UpdateSiteDomains(ctx context.Context, id string, aliases []string, canonicalRedirect string) error
+	UpdateSiteTLS(ctx context.Context, id string, tls *SiteTLS) error
+	UpdateSiteCustomDirectives(ctx context.Context, id, directives string) error
+	UpdateSiteMaintenance(ctx context.Context, id string, maintenance *SiteMaintenance) error
+	UpdateSiteSuspended(ctx context.Context, id string, suspended bool) error
+	UpdateSiteUnmanaged(ctx context.Context, id string, unmanaged bool) error
+	UpdateSiteProxy(ctx context.Context, id string, proxy *SiteProxy) error
+	UpdateSiteAccess(ctx context.Context, id string, access *SiteAccess) error
+	UpdateSitePolicy(ctx context.Context, id string, policy *SitePolicy) error
+	UpdateSiteApp(ctx context.Context, id string, app *SiteApp) error
+	UpdateSiteDeploy(ctx context.Context, id string, deploy *SiteDeploy) error
 
 	CreateJob(ctx context.Context, job Job) error
 	ListJobs(ctx context.Context, limit int) ([]Job, error)
 	GetJobByID(ctx context.Context, id string) (Job, error)
+	ListJobsByParent(ctx context.Context, parentID string) ([]Job, error)
 	UpdateJob(ctx context.Context, id, status, errorMsg string, startedAt, finishedAt *time.Time) error
+	UpdateJobPayload(ctx context.Context, id, payload string) error
+	DeleteJobsFinishedBefore(ctx context.Context, before time.Time) (int, error)
+
+	CreateSchedule(ctx context.Context, schedule Schedule) error
+	ListSchedules(ctx context.Context) ([]Schedule, error)
+	GetScheduleByID(ctx context.Context, id string) (Schedule, error)
+	UpdateScheduleState(ctx context.Context, id string, paused bool, nextRunAt time.Time) error
+	UpdateScheduleRun(ctx context.Context, id, lastJobID string, lastRunAt, nextRunAt time.Time) error
+	DeleteSchedule(ctx context.Context, id string) error
+	CreateIdempotencyRecord(ctx context.Context, record IdempotencyRecord) error
+	GetIdempotencyRecord(ctx context.Context, id string) (IdempotencyRecord, error)
+	CompleteIdempotencyRecord(ctx context.Context, id string, statusCode int, contentType, body string) error
+	DeleteIdempotencyRecord(ctx context.Context, id string) error
+	DeleteExpiredIdempotencyRecords(ctx context.Context, now time.Time) (int, error)
 
 	CreateAuditLog(ctx context.Context, log AuditLog) error
 	ListAuditLogs(ctx context.Context, limit int) ([]AuditLog, error)
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"nusantara/internal/buildinfo"
+	"nusantara/internal/events"
 )
 
 var (
@@ -36,10 +37,16 @@ type Config struct {
 	Cooldown  int
 }
 
+const (
+	watchInterval = 2 * time.Second
+	watchTimeout  = 30 * time.Minute
+)
+
 type Service struct {
 	enabled bool
 	cfg     Config
 	logger  *log.Logger
+	events  *events.Bus
 }
 
 type StartResult struct {
@@ -78,7 +85,7 @@ type CheckResult struct {
 	LastCheckedAt   time.Time `json:"last_checked_at"`
 }
 
-func NewService(enabled bool, cfg Config, logger *log.Logger) *Service {
+func NewService(enabled bool, cfg Config, logger *log.Logger, bus *events.Bus) *Service {
 	if cfg.LogLines <= 0 {
 		cfg.LogLines = 80
 	}
@@ -87,6 +94,7 @@ func NewService(enabled bool, cfg Config, logger *log.Logger) *Service {
 		enabled: enabled,
 		cfg:     cfg,
 		logger:  logger,
+		events:  bus,
 	}
 }
 
@@ -160,13 +168,71 @@ func (s *Service) Start(ctx context.Context) (StartResult, error) {
 	}
 
 	s.logf("panel updater started unit=%s", s.unitServiceName())
-	return StartResult{
+	result := StartResult{
 		Unit:      s.unitServiceName(),
 		RepoURL:   s.cfg.RepoURL,
 		Branch:    s.cfg.Branch,
 		ScriptURL: s.cfg.ScriptURL,
 		StartedAt: time.Now().UTC(),
-	}, nil
+	}
+	s.events.Publish(events.TopicUpdater, "update.started", result)
+	if s.events != nil {
+		go s.watch()
+	}
+	return result, nil
+}
+
+// watch follows the updater unit after Start and publishes new journal lines
+// and the final unit state. It outlives the request that started the update.
+func (s *Service) watch() {
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	var seen []string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		st, err := s.Status(ctx)
+		if err != nil {
+			s.logf("panel updater watch failed err=%v", err)
+			return
+		}
+		fresh := newLogLines(seen, st.Logs)
+		for _, line := range fresh {
+			s.events.Publish(events.TopicUpdater, "update.log", map[string]string{
+				"unit": st.Unit,
+				"line": line,
+			})
+		}
+		seen = st.Logs
+		if st.Exists && !st.Running {
+			st.Logs = nil
+			s.events.Publish(events.TopicUpdater, "update.finished", st)
+			return
+		}
+	}
+}
+
+// newLogLines returns the lines of current that come after the overlap with
+// previous. Both are tail windows of the same journal.
+func newLogLines(previous, current []string) []string {
+	if len(previous) == 0 {
+		return current
+	}
+	last := previous[len(previous)-1]
+	for i := len(current) - 1; i >= 0; i-- {
+		if current[i] == last {
+			return current[i+1:]
+		}
+	}
+	return current
 }
 
 func (s *Service) Status(ctx context.Context) (Status, error) {